@@ -0,0 +1,140 @@
+/**************************************************************************************************/
+// File: blocks.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Data blocks of a cabinet folder and the stored and MSZIP decoders
+/**************************************************************************************************/
+package cab
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// blockReader: Reads the CFDATA blocks of one folder in order
+type blockReader struct {
+	folder *Folder
+	offset int64 // of the next block
+	n      int   // blocks read
+}
+
+// storedReader: Uncompressed folder
+type storedReader struct {
+	blocks *blockReader
+	buf    []byte
+}
+
+// mszipReader: MSZIP folder. Every block is a deflate stream using the previous 32K of output as
+// its dictionary.
+type mszipReader struct {
+	blocks *blockReader
+	window []byte
+	buf    []byte
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// next returns the compressed data of the next block and its uncompressed size. It returns io.EOF
+// after the last block.
+func (b *blockReader) next() ([]byte, int, error) {
+	if b.n >= b.folder.Blocks {
+		return nil, 0, io.EOF
+	}
+	c := b.folder.cab
+	header := make([]byte, 8+c.dataReserve)
+	if _, err := c.r.ReadAt(header, b.offset); err != nil {
+		return nil, 0, b.errorf("Unable to read block header: %s", err)
+	}
+	size := int(binary.LittleEndian.Uint16(header[4:]))
+	uncompressed := int(binary.LittleEndian.Uint16(header[6:]))
+	if uncompressed > maxBlockSize {
+		return nil, 0, b.errorf("Invalid uncompressed size %d", uncompressed)
+	}
+
+	data := make([]byte, size)
+	if _, err := c.r.ReadAt(data, b.offset+int64(len(header))); err != nil {
+		return nil, 0, b.errorf("Unable to read block: %s", err)
+	}
+	if stored := binary.LittleEndian.Uint32(header); c.Verify && stored != 0 {
+		// like cabextract, the reserved bytes are not part of the checksum
+		if sum := checksum(header[4:8], checksum(data, 0)); sum != stored {
+			return nil, 0, b.errorf("Checksum mismatch, stored %08x, computed %08x", stored, sum)
+		}
+	}
+	b.offset += int64(len(header) + size)
+	b.n++
+	return data, uncompressed, nil
+}
+
+// checksum is the CFDATA checksum: data XORed as little endian 32 bit words, with the trailing
+// bytes taken most significant first
+func checksum(data []byte, seed uint32) uint32 {
+	sum := seed
+	n := len(data) &^ 3
+	for i := 0; i < n; i += 4 {
+		sum ^= binary.LittleEndian.Uint32(data[i:])
+	}
+	var last uint32
+	for _, b := range data[n:] {
+		last = last<<8 | uint32(b)
+	}
+	return sum ^ last
+}
+
+func (b *blockReader) errorf(format string, v ...interface{}) error {
+	return fmt.Errorf("Folder %d, block %d: %s", b.folder.Index, b.n, fmt.Sprintf(format, v...))
+}
+
+func (s *storedReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		data, uncompressed, err := s.blocks.next()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) != uncompressed {
+			return 0, s.blocks.errorf("Stored block of %d bytes has uncompressed size %d", len(data), uncompressed)
+		}
+		s.buf = data
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (m *mszipReader) Read(p []byte) (int, error) {
+	for len(m.buf) == 0 {
+		data, uncompressed, err := m.blocks.next()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) < 2 || data[0] != 'C' || data[1] != 'K' {
+			return 0, m.blocks.errorf("Missing MSZIP signature")
+		}
+
+		out := make([]byte, uncompressed)
+		fr := flate.NewReaderDict(bytes.NewReader(data[2:]), m.window)
+		if _, err := io.ReadFull(fr, out); err != nil {
+			return 0, m.blocks.errorf("Unable to inflate: %s", err)
+		}
+
+		m.window = append(m.window, out...)
+		if len(m.window) > maxBlockSize {
+			m.window = m.window[len(m.window)-maxBlockSize:]
+		}
+		m.buf = out
+	}
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
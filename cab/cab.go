@@ -0,0 +1,427 @@
+/**************************************************************************************************/
+// File: cab.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Reader for Microsoft cabinet (MS-CAB) files
+/**************************************************************************************************/
+
+// Package cab reads Microsoft cabinet files such as wsusscn2.cab.
+//
+// Open lists the folders and files of a cabinet. File contents are decompressed on the fly,
+// stored, MSZIP and LZX folders are supported:
+//
+//	c, err := cab.Open("wsusscn2.cab")
+//	defer c.Close()
+//	err = c.Walk(func(f *cab.File, r io.Reader) error {
+//		fmt.Println(f.Name, f.Size)
+//		return nil
+//	})
+//
+// OpenCatalog reads the update metadata of wsusscn2.cab into wsusscn2 records.
+package cab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Compression types of a folder
+const (
+	CompressNone    = 0
+	CompressMSZIP   = 1
+	CompressQuantum = 2
+	CompressLZX     = 3
+)
+
+const (
+	flagPrevCabinet    = 0x0001
+	flagNextCabinet    = 0x0002
+	flagReservePresent = 0x0004
+
+	attribNameIsUTF = 0x80
+
+	maxBlockSize = 32768 // uncompressed bytes per data block
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Cabinet: Open cabinet file
+type Cabinet struct {
+	r       io.ReaderAt
+	closer  io.Closer
+	Size    int64 // cbCabinet, total size of the cabinet
+	Folders []*Folder
+	Files   []*File
+
+	SetID    uint16
+	Index    uint16 // iCabinet, number of this cabinet in its set
+	Reserved []byte // abReserve of the header, holds the Authenticode signature location when signed
+
+	Verify bool // check the checksum of every data block read
+
+	dataReserve int // cbCFData
+}
+
+// Folder: Compressed stream holding the data of one or more files
+type Folder struct {
+	Index       int
+	Offset      int64 // coffCabStart, offset of the first data block
+	Blocks      int   // cCFData
+	Compression uint16
+	Window      uint // LZX window bits
+
+	cab *Cabinet
+}
+
+// File: File stored in a folder
+type File struct {
+	Name       string
+	Size       uint32
+	Offset     uint32 // uoffFolderStart, offset within the uncompressed folder
+	Folder     int    // index into Cabinet.Folders
+	Modified   time.Time
+	Attributes uint16
+
+	cab *Cabinet
+}
+
+// cfheader: Fixed part of the cabinet header
+type cfheader struct {
+	Signature    [4]byte
+	Reserved1    uint32
+	CabinetSize  uint32
+	Reserved2    uint32
+	FilesOffset  uint32
+	Reserved3    uint32
+	VersionMinor uint8
+	VersionMajor uint8
+	Folders      uint16
+	Files        uint16
+	Flags        uint16
+	SetID        uint16
+	Index        uint16
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Open opens the cabinet file name
+func Open(name string) (*Cabinet, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	c.closer = f
+	return c, nil
+}
+
+// Close closes the file opened by Open
+func (c *Cabinet) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// NewReader reads the folder and file tables of the cabinet in r
+func NewReader(r io.ReaderAt) (*Cabinet, error) {
+	sr := io.NewSectionReader(r, 0, 1<<62)
+	var h cfheader
+	if err := binary.Read(sr, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("Unable to read cabinet header: %s", err)
+	}
+	if string(h.Signature[:]) != "MSCF" {
+		return nil, errors.New("Not a cabinet file")
+	}
+	if h.VersionMajor != 1 || h.VersionMinor != 3 {
+		return nil, fmt.Errorf("Unsupported cabinet version %d.%d", h.VersionMajor, h.VersionMinor)
+	}
+	if h.Flags&(flagPrevCabinet|flagNextCabinet) != 0 {
+		return nil, errors.New("Cabinets spanning several files are not supported")
+	}
+
+	c := &Cabinet{r: r, Size: int64(h.CabinetSize), SetID: h.SetID, Index: h.Index}
+	folderReserve := 0
+	if h.Flags&flagReservePresent != 0 {
+		var sizes struct {
+			Header uint16
+			Folder uint8
+			Data   uint8
+		}
+		if err := binary.Read(sr, binary.LittleEndian, &sizes); err != nil {
+			return nil, err
+		}
+		c.Reserved = make([]byte, sizes.Header)
+		if _, err := io.ReadFull(sr, c.Reserved); err != nil {
+			return nil, err
+		}
+		folderReserve, c.dataReserve = int(sizes.Folder), int(sizes.Data)
+	}
+
+	for i := 0; i < int(h.Folders); i++ {
+		var cf struct {
+			Offset      uint32
+			Blocks      uint16
+			Compression uint16
+		}
+		if err := binary.Read(sr, binary.LittleEndian, &cf); err != nil {
+			return nil, fmt.Errorf("Unable to read folder %d: %s", i, err)
+		}
+		if _, err := sr.Seek(int64(folderReserve), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		c.Folders = append(c.Folders, &Folder{
+			Index:       i,
+			Offset:      int64(cf.Offset),
+			Blocks:      int(cf.Blocks),
+			Compression: cf.Compression & 0x000f,
+			Window:      uint(cf.Compression>>8) & 0x1f,
+			cab:         c,
+		})
+	}
+
+	if _, err := sr.Seek(int64(h.FilesOffset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(h.Files); i++ {
+		var cf struct {
+			Size       uint32
+			Offset     uint32
+			Folder     uint16
+			Date       uint16
+			Time       uint16
+			Attributes uint16
+		}
+		if err := binary.Read(sr, binary.LittleEndian, &cf); err != nil {
+			return nil, fmt.Errorf("Unable to read file %d: %s", i, err)
+		}
+		name, err := readString(sr)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read file %d: %s", i, err)
+		}
+		if cf.Attributes&attribNameIsUTF == 0 {
+			name = latin1(name)
+		}
+		if int(cf.Folder) >= len(c.Folders) {
+			// 0xFFFD-0xFFFF continue a folder from or into another cabinet
+			return nil, fmt.Errorf("%s: File spans several cabinets", name)
+		}
+		c.Files = append(c.Files, &File{
+			Name:       name,
+			Size:       cf.Size,
+			Offset:     cf.Offset,
+			Folder:     int(cf.Folder),
+			Modified:   dosTime(cf.Date, cf.Time),
+			Attributes: cf.Attributes,
+			cab:        c,
+		})
+	}
+	return c, nil
+}
+
+// readString reads a NUL terminated string of at most 256 bytes
+func readString(r io.Reader) (string, error) {
+	var b [1]byte
+	var s []byte
+	for len(s) <= 256 {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(s), nil
+		}
+		s = append(s, b[0])
+	}
+	return "", errors.New("Name too long")
+}
+
+// latin1 converts a name stored in the cabinet's code page. Names of wsusscn2.cab are ASCII, other
+// bytes are read as ISO-8859-1.
+func latin1(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			r := make([]rune, len(s))
+			for j := 0; j < len(s); j++ {
+				r[j] = rune(s[j])
+			}
+			return string(r)
+		}
+	}
+	return s
+}
+
+// dosTime converts an MS-DOS date and time
+func dosTime(d uint16, t uint16) time.Time {
+	return time.Date(int(d>>9)+1980, time.Month(d>>5&0xf), int(d&0x1f), int(t>>11), int(t>>5&0x3f), int(t&0x1f)*2, 0, time.UTC)
+}
+
+// CompressionName describes the compression of the folder (Ex., "LZX:21")
+func (fo *Folder) CompressionName() string {
+	switch fo.Compression {
+	case CompressNone:
+		return "none"
+	case CompressMSZIP:
+		return "MSZIP"
+	case CompressQuantum:
+		return fmt.Sprintf("Quantum:%d", fo.Window)
+	case CompressLZX:
+		return fmt.Sprintf("LZX:%d", fo.Window)
+	}
+	return fmt.Sprintf("unknown:%d", fo.Compression)
+}
+
+// Compression describes the compression of the folder holding the file
+func (f *File) Compression() string {
+	return f.cab.Folders[f.Folder].CompressionName()
+}
+
+// Path returns the name with forward slashes. Names are stored with backslashes.
+func (f *File) Path() string {
+	return strings.Replace(f.Name, "\\", "/", -1)
+}
+
+// Open returns the contents of the file. Its folder is decompressed from the start, use
+// Cabinet.Walk to read many files.
+func (f *File) Open() (io.Reader, error) {
+	r, err := f.cab.Folders[f.Folder].open()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(f.Offset)); err != nil {
+		return nil, fmt.Errorf("%s: %s", f.Name, err)
+	}
+	return &fileReader{r: io.LimitReader(r, int64(f.Size)), file: f, left: int64(f.Size)}, nil
+}
+
+// ReadFile returns the contents of the file
+func (f *File) ReadFile() ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	b := bytes.NewBuffer(make([]byte, 0, f.Size))
+	_, err = io.Copy(b, r)
+	return b.Bytes(), err
+}
+
+// Find returns the file called name, compared case insensitively and with either slash, or nil
+func (c *Cabinet) Find(name string) *File {
+	name = strings.Replace(name, "\\", "/", -1)
+	for _, f := range c.Files {
+		if strings.EqualFold(f.Path(), name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for every file with a reader for its contents, in the order the files are stored.
+// Each folder is decompressed once. fn must not keep r after it returns, unread data is skipped.
+func (c *Cabinet) Walk(fn func(f *File, r io.Reader) error) error {
+	for _, folder := range c.Folders {
+		var files []*File
+		for _, f := range c.Files {
+			if f.Folder == folder.Index {
+				files = append(files, f)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Offset < files[j].Offset })
+
+		r, err := folder.open()
+		if err != nil {
+			return err
+		}
+		var pos int64
+		for _, f := range files {
+			if int64(f.Offset) < pos {
+				// overlapping entries, reopen to go back
+				if r, err = folder.open(); err != nil {
+					return err
+				}
+				pos = 0
+			}
+			if _, err := io.CopyN(ioutil.Discard, r, int64(f.Offset)-pos); err != nil {
+				return fmt.Errorf("%s: %s", f.Name, err)
+			}
+			fr := &fileReader{r: io.LimitReader(r, int64(f.Size)), file: f, left: int64(f.Size)}
+			if err := fn(f, fr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(ioutil.Discard, fr); err != nil {
+				return err
+			}
+			pos = int64(f.Offset) + int64(f.Size)
+		}
+	}
+	return nil
+}
+
+// fileReader: Reader over one file of a folder, failing if the folder ends early
+type fileReader struct {
+	r    io.Reader
+	file *File
+	left int64
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	fr.left -= int64(n)
+	if err == io.EOF && fr.left > 0 {
+		err = fmt.Errorf("%s: %s", fr.file.Name, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// open returns a reader over the uncompressed data of the folder
+func (fo *Folder) open() (io.Reader, error) {
+	blocks := &blockReader{folder: fo, offset: fo.Offset}
+	switch fo.Compression {
+	case CompressNone:
+		return &storedReader{blocks: blocks}, nil
+	case CompressMSZIP:
+		return &mszipReader{blocks: blocks}, nil
+	case CompressLZX:
+		if fo.Window < 15 || fo.Window > 21 {
+			return nil, fmt.Errorf("Folder %d: Invalid LZX window size %d", fo.Index, fo.Window)
+		}
+		return newLZXReader(blocks, fo.Window, fo.length()), nil
+	}
+	return nil, fmt.Errorf("Folder %d: Unsupported compression type %d", fo.Index, fo.Compression)
+}
+
+// length returns the uncompressed size of the folder, the end of its last file
+func (fo *Folder) length() int64 {
+	var n int64
+	for _, f := range fo.cab.Files {
+		if f.Folder == fo.Index && int64(f.Offset)+int64(f.Size) > n {
+			n = int64(f.Offset) + int64(f.Size)
+		}
+	}
+	return n
+}
@@ -0,0 +1,587 @@
+/**************************************************************************************************/
+// File: catalog.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Update metadata of wsusscn2.cab read into wsusscn2 records
+/**************************************************************************************************/
+package cab
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const catalogLanguage = "en" // localized properties read for titles and descriptions
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Catalog: Records read from wsusscn2.cab. The cab carries no CVE data.
+type Catalog struct {
+	CreationDate    string // of the package
+	Updates         []wsusscn2.Update
+	Supersedes      []wsusscn2.UpdateSupersede
+	Products        []wsusscn2.Product
+	ProductFamilies []wsusscn2.ProductFamily
+	Classifications []wsusscn2.Classification
+	Rules           []*UpdateRules // of the updates asked for with ReadCatalogRules
+
+	Logger *log.Logger // progress, nil for the standard logger
+}
+
+// revision: One update revision from package.xml and its core (c/), extended (x/) and
+// localized (l/) fragments
+type revision struct {
+	UpdateId        string
+	RevisionId      string
+	RevisionNumber  string
+	CreationDate    string
+	DefaultLanguage string
+	Categories      []categoryRef
+	SupersededBy    []string // revision ids
+	BundledBy       []string // revision ids
+	listed          bool     // found in package.xml
+
+	UpdateType       string
+	CategoryType     string
+	PublicationState string
+	IsPublic         string
+	IsBeta           string
+
+	Kb                string
+	MsrcSeverity      string
+	InstallBehavior   string
+	UninstallBehavior string
+
+	Title          string
+	Description    string
+	UninstallNotes string
+	MoreInfoUrl    string
+	SupportUrl     string
+}
+
+// categoryRef: Category of an update, by update id of the category
+type categoryRef struct {
+	Type string
+	Id   string
+}
+
+// catalogReader: State while walking the cab
+type catalogReader struct {
+	catalog   *Catalog
+	revisions map[string]*revision    // by revision id
+	order     []*revision             // package.xml order
+	rules     map[string]*UpdateRules // by lower case update id, of the updates asked for
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// OpenCatalog reads package.xml and the update fragments of the package cabs nested in the
+// wsusscn2.cab at name
+func OpenCatalog(name string) (*Catalog, error) {
+	c, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return ReadCatalog(c, nil)
+}
+
+// ReadCatalog reads the catalog from an open wsusscn2.cab. logger receives progress, nil for
+// the standard logger.
+func ReadCatalog(c *Cabinet, logger *log.Logger) (*Catalog, error) {
+	return ReadCatalogRules(c, logger, nil)
+}
+
+// ReadCatalogRules is ReadCatalog also reading the prerequisites and applicability rules of the
+// updates with updateUids into Catalog.Rules, in the same order. Unknown ids are left out.
+func ReadCatalogRules(c *Cabinet, logger *log.Logger, updateUids []string) (*Catalog, error) {
+	cr := &catalogReader{catalog: &Catalog{Logger: logger}, revisions: make(map[string]*revision), rules: make(map[string]*UpdateRules)}
+	for _, id := range updateUids {
+		cr.rules[strings.ToLower(id)] = &UpdateRules{UpdateId: id}
+	}
+	if err := cr.walk(c); err != nil {
+		return nil, err
+	}
+	if len(cr.order) == 0 {
+		return nil, fmt.Errorf("No package.xml found, not a wsusscn2.cab")
+	}
+	cr.build()
+	cr.buildRules(updateUids)
+	return cr.catalog, nil
+}
+
+func (cat *Catalog) logf(format string, v ...interface{}) {
+	if cat.Logger != nil {
+		cat.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// walk reads the files of c and of the cabs nested in it
+func (cr *catalogReader) walk(c *Cabinet) error {
+	return c.WalkNested(func(full string, f *File, r io.Reader) error {
+		name := f.Path()
+		switch {
+		case IsCab(name):
+			cr.catalog.logf("Reading %s", full)
+			return nil
+		case strings.EqualFold(path.Base(name), "package.xml"):
+			return cr.readPackage(r)
+		}
+
+		dir, id := path.Split(name)
+		if _, err := strconv.Atoi(id); err != nil {
+			return nil
+		}
+		switch strings.ToLower(dir) {
+		case "c/", "x/", "l/" + catalogLanguage + "/":
+			return cr.readFragment(cr.revision(id), dir[:1], r, full)
+		}
+		return nil
+	})
+}
+
+// revision returns the revision with id, creating it if needed
+func (cr *catalogReader) revision(id string) *revision {
+	rev, ok := cr.revisions[id]
+	if !ok {
+		rev = &revision{RevisionId: id}
+		cr.revisions[id] = rev
+	}
+	return rev
+}
+
+// attr returns the value of the attribute with local name
+func attr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// readPackage reads the update list of package.xml
+func (cr *catalogReader) readPackage(r io.Reader) error {
+	d := xml.NewDecoder(r)
+	var rev *revision
+	var parent string // relationship element within an Update
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("package.xml: %s", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "OfflineSyncPackage":
+				cr.catalog.CreationDate = attr(t, "CreationDate")
+			case "Update":
+				rev = cr.revision(attr(t, "RevisionId"))
+				rev.UpdateId = attr(t, "UpdateId")
+				rev.RevisionNumber = attr(t, "RevisionNumber")
+				rev.CreationDate = attr(t, "CreationDate")
+				rev.DefaultLanguage = attr(t, "DefaultLanguage")
+				rev.listed = true
+				cr.order = append(cr.order, rev)
+			case "SupersededBy", "BundledBy", "Prerequisites":
+				parent = t.Name.Local
+			case "Category":
+				if rev != nil {
+					rev.Categories = append(rev.Categories, categoryRef{Type: attr(t, "Type"), Id: attr(t, "Id")})
+				}
+			case "Revision":
+				if rev == nil {
+					break
+				}
+				switch parent {
+				case "SupersededBy":
+					rev.SupersededBy = append(rev.SupersededBy, attr(t, "Id"))
+				case "BundledBy":
+					rev.BundledBy = append(rev.BundledBy, attr(t, "Id"))
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "Update":
+				rev = nil
+			case "SupersededBy", "BundledBy", "Prerequisites":
+				parent = ""
+			}
+		}
+	}
+}
+
+// fragmentReader wraps a fragment of several top level elements in one root element
+func fragmentReader(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(b, []byte("<?xml")) {
+		if i := bytes.Index(b, []byte("?>")); i >= 0 {
+			b = b[i+2:]
+		}
+	}
+	return io.MultiReader(strings.NewReader("<fragment>"), bytes.NewReader(b), strings.NewReader("</fragment>")), nil
+}
+
+// readFragment reads the properties of rev from a core ("c"), extended ("x") or localized ("l")
+// fragment
+func (cr *catalogReader) readFragment(rev *revision, kind string, r io.Reader, name string) error {
+	fr, err := fragmentReader(r)
+	if err != nil {
+		return err
+	}
+	d := xml.NewDecoder(fr)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var text *string
+		switch kind + ":" + se.Name.Local {
+		case "c:UpdateIdentity":
+			if rev.UpdateId == "" {
+				rev.UpdateId = attr(se, "UpdateID")
+				rev.RevisionNumber = attr(se, "RevisionNumber")
+			}
+		case "c:Properties":
+			rev.UpdateType = attr(se, "UpdateType")
+			rev.PublicationState = attr(se, "PublicationState")
+			rev.IsPublic = attr(se, "IsPublic")
+			rev.IsBeta = attr(se, "IsBeta")
+			if rev.CreationDate == "" {
+				rev.CreationDate = attr(se, "CreationDate")
+			}
+		case "c:CategoryInformation":
+			rev.CategoryType = attr(se, "CategoryType")
+		case "c:Relationships", "c:ApplicabilityRules", "x:InstallableItem":
+			if err := cr.readRules(rev, d, se); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		case "x:ExtendedProperties":
+			rev.MsrcSeverity = attr(se, "MsrcSeverity")
+		case "x:InstallationBehavior":
+			rev.InstallBehavior = attr(se, "RebootBehavior")
+		case "x:UninstallationBehavior":
+			rev.UninstallBehavior = attr(se, "RebootBehavior")
+		case "x:KBArticleID":
+			text = &rev.Kb
+		case "x:SupportUrl":
+			if rev.SupportUrl == "" {
+				text = &rev.SupportUrl
+			}
+		case "l:Title":
+			text = &rev.Title
+		case "l:Description":
+			text = &rev.Description
+		case "l:UninstallNotes":
+			text = &rev.UninstallNotes
+		case "l:MoreInfoUrl":
+			text = &rev.MoreInfoUrl
+		case "l:SupportUrl":
+			text = &rev.SupportUrl
+		}
+		if text != nil {
+			if err := d.DecodeElement(text, &se); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			*text = strings.TrimSpace(*text)
+		}
+	}
+}
+
+// readRules reads the element se of the rules of rev if they were asked for, and skips it
+// otherwise
+func (cr *catalogReader) readRules(rev *revision, d *xml.Decoder, se xml.StartElement) error {
+	rules, ok := cr.rules[strings.ToLower(rev.UpdateId)]
+	if !ok {
+		return d.Skip()
+	}
+	rules.RevisionId = rev.RevisionId
+
+	switch se.Name.Local {
+	case "Relationships":
+		return decodeRelationships(d, rules)
+	case "ApplicabilityRules":
+		return decodeApplicability(d, &rules.IsInstalled, &rules.IsInstallable, &rules.IsSuperseded)
+	}
+	item, err := decodeItem(d, se)
+	if err != nil {
+		return err
+	}
+	rules.Items = append(rules.Items, item)
+	return nil
+}
+
+// buildRules fills in the revision and titles of the rules asked for and adds them to the
+// catalog
+func (cr *catalogReader) buildRules(updateUids []string) {
+	titles := make(map[string]string)
+	for _, rev := range cr.revisions {
+		titles[strings.ToLower(rev.UpdateId)] = rev.Title
+	}
+	title := func(refs []UpdateRef) {
+		for i := range refs {
+			refs[i].Title = titles[strings.ToLower(refs[i].UpdateId)]
+		}
+	}
+
+	for _, id := range updateUids {
+		rules := cr.rules[strings.ToLower(id)]
+		rev, ok := cr.revisions[rules.RevisionId]
+		if !ok {
+			continue
+		}
+		rules.UpdateId, rules.RevisionNumber = rev.UpdateId, rev.RevisionNumber
+		rules.UpdateType, rules.Title = rev.UpdateType, rev.Title
+		for _, p := range rules.Prerequisites {
+			title(p.Updates)
+		}
+		title(rules.BundledUpdates)
+		cr.catalog.Rules = append(cr.catalog.Rules, rules)
+	}
+}
+
+// archFromTitle guesses the architecture of an update from its title
+func archFromTitle(title string) string {
+	t := strings.ToLower(title)
+	switch {
+	case strings.Contains(t, "arm64"):
+		return "arm64"
+	case strings.Contains(t, "x64") || strings.Contains(t, "amd64") || strings.Contains(t, "64-bit"):
+		return "x64"
+	case strings.Contains(t, "itanium") || strings.Contains(t, "ia64"):
+		return "ia64"
+	case strings.Contains(t, "x86") || strings.Contains(t, "32-bit"):
+		return "x86"
+	}
+	return ""
+}
+
+// build turns the revisions into records
+func (cr *catalogReader) build() {
+	cat := cr.catalog
+
+	// categories by update id, typed by their own metadata or by how updates refer to them
+	categories := make(map[string]*revision)
+	types := make(map[string]string)
+	for _, rev := range cr.order {
+		for _, ref := range rev.Categories {
+			types[ref.Id] = ref.Type
+		}
+	}
+	for _, rev := range cr.order {
+		if rev.UpdateType == "Category" || types[rev.UpdateId] != "" {
+			if rev.CategoryType == "" {
+				rev.CategoryType = types[rev.UpdateId]
+			}
+			categories[rev.UpdateId] = rev
+		}
+	}
+
+	for _, rev := range categories {
+		switch rev.CategoryType {
+		case "Product":
+			cat.Products = append(cat.Products, wsusscn2.Product{ProductUid: rev.UpdateId, ProductRevision: rev.RevisionNumber, ProductTitle: rev.Title})
+		case "ProductFamily":
+			cat.ProductFamilies = append(cat.ProductFamilies, wsusscn2.ProductFamily{ProductFamilyUid: rev.UpdateId, ProductFamilyRevision: rev.RevisionNumber, ProductFamilyTitle: rev.Title})
+		case "UpdateClassification":
+			cat.Classifications = append(cat.Classifications, wsusscn2.Classification{ClassificationUid: rev.UpdateId, ClassificationRevision: rev.RevisionNumber, ClassificationTitle: rev.Title})
+		}
+	}
+	sort.Slice(cat.Products, func(i, j int) bool { return cat.Products[i].ProductTitle < cat.Products[j].ProductTitle })
+	sort.Slice(cat.ProductFamilies, func(i, j int) bool {
+		return cat.ProductFamilies[i].ProductFamilyTitle < cat.ProductFamilies[j].ProductFamilyTitle
+	})
+	sort.Slice(cat.Classifications, func(i, j int) bool {
+		return cat.Classifications[i].ClassificationTitle < cat.Classifications[j].ClassificationTitle
+	})
+
+	// first category of type t of rev, or of its products
+	category := func(rev *revision, t string) string {
+		for _, refs := range [][]categoryRef{rev.Categories, cr.productRefs(rev, categories)} {
+			for _, ref := range refs {
+				if c, ok := categories[ref.Id]; ok && c.CategoryType == t {
+					return c.Title
+				}
+			}
+		}
+		return ""
+	}
+
+	// reverse relationships, by revision id
+	supersedes := make(map[string][]string)
+	bundles := make(map[string][]string)
+	for _, rev := range cr.order {
+		for _, id := range rev.SupersededBy {
+			supersedes[id] = append(supersedes[id], rev.UpdateId)
+		}
+		for _, id := range rev.BundledBy {
+			bundles[id] = append(bundles[id], rev.UpdateId)
+		}
+	}
+
+	products := make(map[string][]string) // titles by revision id
+	for _, rev := range cr.order {
+		if categories[rev.UpdateId] != nil || rev.UpdateType == "Detectoid" {
+			continue
+		}
+		for _, ref := range rev.Categories {
+			if c, ok := categories[ref.Id]; ok && c.CategoryType == "Product" {
+				products[rev.RevisionId] = append(products[rev.RevisionId], c.Title)
+			}
+		}
+		if len(products[rev.RevisionId]) == 0 {
+			products[rev.RevisionId] = []string{""}
+		}
+
+		for _, product := range products[rev.RevisionId] {
+			cat.Updates = append(cat.Updates, wsusscn2.Update{
+				Bundles:             strings.Join(bundles[rev.RevisionId], ","),
+				ClassificationTitle: category(rev, "UpdateClassification"),
+				CompanyTitle:        category(rev, "Company"),
+				Description:         rev.Description,
+				InstallBehavior:     rev.InstallBehavior,
+				IsBeta:              boolString(rev.IsBeta),
+				IsBundled:           strconv.FormatBool(len(rev.BundledBy) > 0),
+				IsPublic:            boolString(rev.IsPublic),
+				IsSuperseded:        strconv.FormatBool(len(rev.SupersededBy) > 0),
+				Kb:                  rev.Kb,
+				Language:            rev.DefaultLanguage,
+				Arch:                archFromTitle(rev.Title),
+				MoreInfoUrl:         rev.MoreInfoUrl,
+				MsrcSeverity:        rev.MsrcSeverity,
+				ProductFamilyTitle:  category(rev, "ProductFamily"),
+				ProductTitle:        product,
+				PublicationState:    rev.PublicationState,
+				Supersedes:          strings.Join(supersedes[rev.RevisionId], ","),
+				SupportUrl:          rev.SupportUrl,
+				UninstallBehavior:   rev.UninstallBehavior,
+				UninstallNotes:      rev.UninstallNotes,
+				UpdateCreationDate:  rev.CreationDate,
+				UpdateRevision:      rev.RevisionNumber,
+				UpdateTitle:         rev.Title,
+				UpdateType:          rev.UpdateType,
+				UpdateUid:           rev.UpdateId,
+			})
+		}
+	}
+
+	// like the API, super_uid is the latest superseder, found by following the chain of
+	// supersessions to the updates nothing supersedes
+	for _, rev := range cr.order {
+		for _, id := range cr.latest(rev, products) {
+			super := cr.revisions[id]
+			for _, product := range products[rev.RevisionId] {
+				superProduct := products[id][0]
+				for _, p := range products[id] {
+					if p == product {
+						superProduct = p
+					}
+				}
+				cat.Supersedes = append(cat.Supersedes, wsusscn2.UpdateSupersede{
+					UpdateUid:          rev.UpdateId,
+					UpdateTitle:        rev.Title,
+					UpdateCreationDate: rev.CreationDate,
+					ProductTitle:       product,
+					IsSuperseded:       "true",
+					SuperUpdateUid:     super.UpdateId,
+					SuperTitle:         super.Title,
+					SuperCreationDate:  super.CreationDate,
+					SuperProductTitle:  superProduct,
+					SuperIsSuperseded:  "false",
+				})
+			}
+		}
+	}
+	cat.logf("Read %d updates, %d supersede records and %d products", len(cat.Updates), len(cat.Supersedes), len(cat.Products))
+}
+
+// latest returns the revision ids of the updates superseding rev, directly or through other
+// updates, that no update of the catalog supersedes. Superseders missing from package.xml or
+// without a product are skipped.
+func (cr *catalogReader) latest(rev *revision, products map[string][]string) []string {
+	superseders := func(r *revision) []*revision {
+		var revs []*revision
+		for _, id := range r.SupersededBy {
+			if super, ok := cr.revisions[id]; ok && super.listed && len(products[id]) > 0 {
+				revs = append(revs, super)
+			}
+		}
+		return revs
+	}
+
+	var latest []string
+	seen := map[string]bool{rev.RevisionId: true}
+	pending := superseders(rev)
+	for len(pending) > 0 {
+		r := pending[0]
+		pending = pending[1:]
+		if seen[r.RevisionId] {
+			continue
+		}
+		seen[r.RevisionId] = true
+		next := superseders(r)
+		if len(next) == 0 {
+			latest = append(latest, r.RevisionId)
+		}
+		pending = append(pending, next...)
+	}
+	return latest
+}
+
+// productRefs returns the categories of the products of rev
+func (cr *catalogReader) productRefs(rev *revision, categories map[string]*revision) []categoryRef {
+	var refs []categoryRef
+	for _, ref := range rev.Categories {
+		if c, ok := categories[ref.Id]; ok && c.CategoryType == "Product" {
+			refs = append(refs, c.Categories...)
+		}
+	}
+	return refs
+}
+
+// boolString normalizes an xml boolean, "" stays unknown
+func boolString(v string) string {
+	if b, err := strconv.ParseBool(v); err == nil {
+		return strconv.FormatBool(b)
+	}
+	return v
+}
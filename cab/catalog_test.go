@@ -0,0 +1,80 @@
+/**************************************************************************************************/
+// File: catalog_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the catalog read from testdata/wsusscn2.cab
+/**************************************************************************************************/
+package cab
+
+import (
+	"io/ioutil"
+	"log"
+	"sort"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// testCatalog reads testdata/wsusscn2.cab: KB4022725 (rev 200) is superseded by KB4025342
+// (rev 201), which is superseded by KB4034674 (rev 203)
+func testCatalog(t *testing.T) *Catalog {
+	c, err := Open("testdata/wsusscn2.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cat, err := ReadCatalog(c, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cat
+}
+
+func TestCatalogUpdates(t *testing.T) {
+	cat := testCatalog(t)
+	var kbs []string
+	for _, u := range cat.Updates {
+		kbs = append(kbs, u.Kb+" "+u.ProductTitle+" "+u.IsSuperseded+" "+u.Supersedes)
+	}
+	sort.Strings(kbs)
+	expected := []string{
+		"4022725 Windows 10 true ",
+		"4022725 Windows Server 2016 true ",
+		"4025342 Windows 10 false ",
+		"4025342 Windows 10 true 8b4e84f6-595f-41ed-854f-4ca886e317a5",
+		"4034674 Windows 10 false e2b3f4c1-1111-4a2b-9c3d-000000000201",
+	}
+	if len(kbs) != len(expected) {
+		t.Fatalf("Got updates %q, expected %q", kbs, expected)
+	}
+	for i := range kbs {
+		if kbs[i] != expected[i] {
+			t.Errorf("Update %d is %q, expected %q", i, kbs[i], expected[i])
+		}
+	}
+}
+
+func TestCatalogSupersedes(t *testing.T) {
+	// super_uid is the latest superseder, like the API, not the next update of the chain
+	cat := testCatalog(t)
+	expected := map[string]string{
+		"8b4e84f6-595f-41ed-854f-4ca886e317a5 Windows 10":          "e2b3f4c1-1111-4a2b-9c3d-000000000203",
+		"8b4e84f6-595f-41ed-854f-4ca886e317a5 Windows Server 2016": "e2b3f4c1-1111-4a2b-9c3d-000000000203",
+		"e2b3f4c1-1111-4a2b-9c3d-000000000201 Windows 10":          "e2b3f4c1-1111-4a2b-9c3d-000000000203",
+	}
+	if len(cat.Supersedes) != len(expected) {
+		t.Fatalf("Got %d supersede records, expected %d: %+v", len(cat.Supersedes), len(expected), cat.Supersedes)
+	}
+	for _, s := range cat.Supersedes {
+		key := s.UpdateUid + " " + s.ProductTitle
+		if s.SuperUpdateUid != expected[key] {
+			t.Errorf("%s: super_uid %s, expected %s", key, s.SuperUpdateUid, expected[key])
+		}
+		if s.SuperIsSuperseded != "false" || s.SuperProductTitle != "Windows 10" {
+			t.Errorf("%s: super_is_superseded %s, super_product_title %s", key, s.SuperIsSuperseded, s.SuperProductTitle)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/**************************************************************************************************/
+// File: extract.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Extraction of cabinets and the cabinets nested in them
+/**************************************************************************************************/
+package cab
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// ExtractOptions: Options for Extract
+type ExtractOptions struct {
+	Inner    bool                       // also extract nested .cab files, each into a directory named after it
+	Test     bool                       // decompress and verify checksums without writing anything
+	Progress func(name string, f *File) // called after each file, name includes the nested cabinets
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// IsCab reports if name has the .cab extension
+func IsCab(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".cab")
+}
+
+// WalkNested is Walk descending into nested .cab files. name is the path of the file prefixed by
+// the nested cabinets it is in (Ex., "package2.cab/c/200"). fn is called for a nested cabinet
+// before its files. The checksums of nested cabinets are verified if c.Verify is set.
+func (c *Cabinet) WalkNested(fn func(name string, f *File, r io.Reader) error) error {
+	return c.walkNested("", fn)
+}
+
+func (c *Cabinet) walkNested(parent string, fn func(name string, f *File, r io.Reader) error) error {
+	return c.Walk(func(f *File, r io.Reader) error {
+		name := parent + f.Path()
+		if !IsCab(name) {
+			return fn(name, f, r)
+		}
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if err := fn(name, f, bytes.NewReader(b)); err != nil {
+			return err
+		}
+		inner, err := NewReader(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		inner.Verify = c.Verify
+		return inner.walkNested(name+"/", fn)
+	})
+}
+
+// safePath returns name as a relative path that cannot leave the extraction directory. Names
+// with a ".." element are rejected.
+func safePath(name string) (string, error) {
+	slashed := strings.Replace(name, "\\", "/", -1)
+	for _, element := range strings.Split(slashed, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("Unsafe file name %q", name)
+		}
+	}
+	p := path.Clean("/" + slashed)[1:]
+	if p == "" {
+		return "", fmt.Errorf("Invalid file name %q", name)
+	}
+	return p, nil
+}
+
+// Extract writes every file of the cabinet below dir, verifying block checksums. Nested
+// cabinets are written as files, and with opts.Inner also extracted into a directory named after
+// them without the extension (package2.cab into package2/).
+func (c *Cabinet) Extract(dir string, opts ExtractOptions) error {
+	c.Verify = true
+	walk := c.Walk
+	if opts.Inner {
+		walk = func(fn func(f *File, r io.Reader) error) error {
+			return c.WalkNested(func(name string, f *File, r io.Reader) error {
+				return fn(&File{Name: name, Size: f.Size, Offset: f.Offset, Folder: f.Folder, Modified: f.Modified, Attributes: f.Attributes, cab: f.cab}, r)
+			})
+		}
+	}
+
+	return walk(func(f *File, r io.Reader) error {
+		name, err := safePath(f.Path())
+		if err != nil {
+			return err
+		}
+		if opts.Inner {
+			// files of package2.cab go to package2/
+			parts := strings.Split(name, "/")
+			for i := 0; i < len(parts)-1; i++ {
+				if IsCab(parts[i]) {
+					parts[i] = strings.TrimSuffix(parts[i], path.Ext(parts[i]))
+				}
+			}
+			name = strings.Join(parts, "/")
+		}
+
+		if opts.Test {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return err
+			}
+		} else if err := writeFile(filepath.Join(dir, filepath.FromSlash(name)), f, r); err != nil {
+			return err
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(name, f)
+		}
+		return nil
+	})
+}
+
+// writeFile writes the contents of f to target, creating its directory
+func writeFile(target string, f *File, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, f.Modified, f.Modified)
+}
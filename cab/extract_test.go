@@ -0,0 +1,214 @@
+/**************************************************************************************************/
+// File: extract_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the decoders and extraction of testdata/test.cab and wsusscn2.cab
+/**************************************************************************************************/
+package cab
+
+//go:generate go run ./testdata/gen testdata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// testFiles: SHA-256 of the files of testdata/test.cab. Its folders are MSZIP (a.txt, dir\b.xml,
+// c.bin), stored (stored.dat), LZX with a 32K window and verbatim, aligned and uncompressed
+// blocks spanning frames and E8 translation (l\en\12345, c\12345), and LZX with a 128K window
+// (package.xml).
+var testFiles = map[string]string{
+	"a.txt":       "16efd4d1f2197b7e02142a7daf2485932d4b60a417bc5e0d9236745f5799d62c",
+	"dir/b.xml":   "6ffd94c2227571b68b2f164293c3f1522d8f1c5bd21750aba091fce2de3fe843",
+	"c.bin":       "5dd0cff8bb9568971b84af8eab8a6f8f691ae749c100845af0d57f3880588951",
+	"stored.dat":  "6ac703e18c2398ffc12f808c37ca30a37c59d6328ac60458970e0e890105bcb6",
+	"l/en/12345":  "0f6396fe4485c4381e4154e1b86ed039b27b9c15aaa5f797f016f9f124e18ac5",
+	"c/12345":     "b14bec539d723527553a38d884eea5bbbad48335f98f5f02e0a64575a2697459",
+	"package.xml": "2c8c8421da16d4f8c3ec0deab15ff3b06f3d105f20b5da94f44f0ce9771158c9",
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func sha(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// extractFiles extracts c into a temporary directory and returns the contents of the files by
+// slash separated path
+func extractFiles(t *testing.T, c *Cabinet, opts ExtractOptions) map[string][]byte {
+	dir, err := ioutil.TempDir("", "cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := c.Extract(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(name)
+		rel, _ := filepath.Rel(dir, name)
+		files[filepath.ToSlash(rel)] = b
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFolders(t *testing.T) {
+	c, err := Open("testdata/test.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var compressions []string
+	for _, fo := range c.Folders {
+		compressions = append(compressions, fo.CompressionName())
+	}
+	if s := strings.Join(compressions, ","); s != "MSZIP,none,LZX:15,LZX:17" {
+		t.Errorf("Folders are %s", s)
+	}
+	if len(c.Files) != len(testFiles) {
+		t.Errorf("Got %d files, expected %d", len(c.Files), len(testFiles))
+	}
+	for _, f := range c.Files {
+		b, err := f.ReadFile()
+		if err != nil {
+			t.Fatalf("%s: %s", f.Name, err)
+		}
+		if sha(b) != testFiles[f.Path()] {
+			t.Errorf("%s (%s) differs", f.Path(), f.Compression())
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	c, err := Open("testdata/test.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	files := extractFiles(t, c, ExtractOptions{})
+	if len(files) != len(testFiles) {
+		t.Errorf("Extracted %d files, expected %d", len(files), len(testFiles))
+	}
+	for name, expected := range testFiles {
+		if b, ok := files[name]; !ok {
+			t.Errorf("%s not extracted", name)
+		} else if sha(b) != expected {
+			t.Errorf("%s differs", name)
+		}
+	}
+}
+
+func TestExtractNested(t *testing.T) {
+	c, err := Open("testdata/wsusscn2.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	files := extractFiles(t, c, ExtractOptions{Inner: true})
+	var names []string
+	for name := range files {
+		if !strings.HasPrefix(name, "package2/l/de/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	expected := "index.xml package.cab package/package.xml package2.cab " +
+		"package2/c/100 package2/c/101 package2/c/102 package2/c/103 package2/c/104 package2/c/105 package2/c/200 package2/c/201 package2/c/202 package2/c/203 " +
+		"package2/l/en/100 package2/l/en/101 package2/l/en/102 package2/l/en/103 package2/l/en/104 package2/l/en/105 package2/l/en/200 package2/l/en/201 package2/l/en/202 package2/l/en/203 " +
+		"package2/x/200 package2/x/201 package2/x/202 package2/x/203"
+	if s := strings.Join(names, " "); s != expected {
+		t.Fatalf("Extracted %s, expected %s", s, expected)
+	}
+
+	// the nested files are the files of the nested cabinets
+	inner, err := NewReader(bytes.NewReader(files["package.cab"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := inner.Find("package.xml").ReadFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, files["package/package.xml"]) || !bytes.HasPrefix(b, []byte("<?xml")) {
+		t.Errorf("package/package.xml differs from package.xml of package.cab")
+	}
+}
+
+func TestExtractChecksum(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/test.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Extract("", ExtractOptions{Test: true}); err != nil {
+		t.Fatalf("Test of the intact cabinet: %s", err)
+	}
+
+	// flip one byte of the data of the first block of the stored folder
+	fo := c.Folders[1]
+	b[fo.Offset+8+int64(c.dataReserve)+100] ^= 0xff
+	c, err = NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Extract("", ExtractOptions{Test: true})
+	if err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+		t.Errorf("Test of the corrupted cabinet returned %v, expected a checksum mismatch", err)
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"a.txt":         "a.txt",
+		"dir\\b.xml":    "dir/b.xml",
+		"/abs/c.bin":    "abs/c.bin",
+		"\\abs\\c.bin":  "abs/c.bin",
+		"./d/./e":       "d/e",
+		"f..g":          "f..g",
+		"..":            "",
+		"../a.txt":      "",
+		"..\\a.txt":     "",
+		"dir/../../etc": "",
+		"dir\\..\\x":    "",
+		"/":             "",
+	} {
+		p, err := safePath(name)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("safePath(%q) = %q, expected an error", name, p)
+			}
+		} else if err != nil || p != expected {
+			t.Errorf("safePath(%q) = %q, %v, expected %q", name, p, err, expected)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+/**************************************************************************************************/
+// File: lzx.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: LZX decoder for cabinet folders
+/**************************************************************************************************/
+package cab
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const (
+	lzxBlockVerbatim     = 1
+	lzxBlockAligned      = 2
+	lzxBlockUncompressed = 3
+
+	lzxNumChars      = 256
+	lzxMinMatch      = 2
+	lzxFrameSize     = 32768
+	lzxPretreeSize   = 20
+	lzxLengthSize    = 249
+	lzxAlignedSize   = 8
+	lzxMaxMainSize   = lzxNumChars + 50*8
+	lzxMaxPadding    = 16 // zero bytes read past the end of the input before giving up
+	lzxInvalidSymbol = 0xffff
+)
+
+// number of position slots for each window size from 2^15 to 2^21
+var lzxPositionSlots = [...]int{15: 30, 16: 32, 17: 34, 18: 36, 19: 38, 20: 42, 21: 50}
+
+var lzxExtraBits, lzxPositionBase [51]uint32
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// lzxInput: Compressed bytes of a folder, read across block boundaries
+type lzxInput struct {
+	blocks  *blockReader
+	buf     []byte
+	padding int
+	err     error
+}
+
+// huffTable: Canonical Huffman code decoded with one lookup of the longest code length
+type huffTable struct {
+	lens  []byte
+	bits  uint
+	table []uint16
+}
+
+// lzxReader: Decodes an LZX stream frame by frame
+type lzxReader struct {
+	in     *lzxInput
+	bitbuf uint64 // next bits, most significant first
+	nbits  uint
+
+	window     []byte
+	windowMask uint32
+	windowPosn uint32
+	framePosn  uint32
+	frame      uint32
+	length     int64 // uncompressed size of the folder
+	offset     int64 // bytes decoded
+
+	r0, r1, r2   uint32
+	mainElements int
+	headerRead   bool
+
+	blockType      int
+	blockLength    uint32
+	blockRemaining uint32
+
+	intelFilesize int32
+	intelCurpos   int32
+	intelStarted  bool
+
+	pretree, main, lengths, aligned huffTable
+
+	out []byte
+	err error
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func init() {
+	j := uint32(0)
+	for i := 0; i < len(lzxExtraBits); i += 2 {
+		lzxExtraBits[i] = j
+		if i+1 < len(lzxExtraBits) {
+			lzxExtraBits[i+1] = j
+		}
+		if i != 0 && j < 17 {
+			j++
+		}
+	}
+	j = 0
+	for i := range lzxPositionBase {
+		lzxPositionBase[i] = j
+		j += 1 << lzxExtraBits[i]
+	}
+}
+
+// readByte returns the next input byte. A few zero bytes are returned after the end of the
+// input, as the bit reader reads ahead.
+func (in *lzxInput) readByte() byte {
+	for len(in.buf) == 0 {
+		if in.err != nil {
+			return 0
+		}
+		data, _, err := in.blocks.next()
+		if err == io.EOF {
+			if in.padding++; in.padding > lzxMaxPadding {
+				in.err = errors.New("LZX data ends early")
+			}
+			return 0
+		}
+		if err != nil {
+			in.err = err
+			return 0
+		}
+		in.buf = data
+	}
+	b := in.buf[0]
+	in.buf = in.buf[1:]
+	return b
+}
+
+func newLZXReader(blocks *blockReader, windowBits uint, length int64) *lzxReader {
+	size := uint32(1) << windowBits
+	z := &lzxReader{
+		in:           &lzxInput{blocks: blocks},
+		window:       make([]byte, size),
+		windowMask:   size - 1,
+		length:       length,
+		r0:           1,
+		r1:           1,
+		r2:           1,
+		mainElements: lzxNumChars + lzxPositionSlots[windowBits]*8,
+	}
+	z.pretree.lens = make([]byte, lzxPretreeSize)
+	z.main.lens = make([]byte, lzxMaxMainSize)
+	z.lengths.lens = make([]byte, lzxLengthSize)
+	z.aligned.lens = make([]byte, lzxAlignedSize)
+	return z
+}
+
+func (z *lzxReader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		if z.offset >= z.length {
+			return 0, io.EOF
+		}
+		z.err = z.decodeFrame()
+		if z.err == nil {
+			z.err = z.in.err
+		}
+	}
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// ensure fills the bit buffer with at least n bits, 16 at a time
+func (z *lzxReader) ensure(n uint) {
+	for z.nbits < n {
+		lo := z.in.readByte()
+		hi := z.in.readByte()
+		z.bitbuf |= uint64(uint16(hi)<<8|uint16(lo)) << (64 - 16 - z.nbits)
+		z.nbits += 16
+	}
+}
+
+func (z *lzxReader) peek(n uint) uint32 {
+	return uint32(z.bitbuf >> (64 - n))
+}
+
+func (z *lzxReader) remove(n uint) {
+	z.bitbuf <<= n
+	z.nbits -= n
+}
+
+func (z *lzxReader) readBits(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	z.ensure(n)
+	v := z.peek(n)
+	z.remove(n)
+	return v
+}
+
+// readUint32 reads a little endian value from a byte aligned input
+func (z *lzxReader) readUint32() uint32 {
+	var b [4]byte
+	for i := range b {
+		b[i] = z.in.readByte()
+	}
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+// build makes the lookup table for the code lengths in t.lens. An incomplete code is accepted,
+// its unused entries fail when decoded.
+func (t *huffTable) build() error {
+	t.bits = 0
+	for _, l := range t.lens {
+		if uint(l) > t.bits {
+			t.bits = uint(l)
+		}
+	}
+	if t.bits > 16 {
+		return fmt.Errorf("Huffman code length %d too long", t.bits)
+	}
+	size := 1 << t.bits
+	if cap(t.table) < size {
+		t.table = make([]uint16, size)
+	}
+	t.table = t.table[:size]
+	for i := range t.table {
+		t.table[i] = lzxInvalidSymbol
+	}
+
+	code := 0
+	for l := uint(1); l <= t.bits; l++ {
+		for sym, sl := range t.lens {
+			if uint(sl) != l {
+				continue
+			}
+			start, end := code<<(t.bits-l), (code+1)<<(t.bits-l)
+			if end > size {
+				return errors.New("Invalid Huffman code")
+			}
+			for i := start; i < end; i++ {
+				t.table[i] = uint16(sym)
+			}
+			code++
+		}
+		code <<= 1
+	}
+	return nil
+}
+
+// decode reads one symbol of t
+func (z *lzxReader) decode(t *huffTable) (int, error) {
+	if t.bits == 0 {
+		return 0, errors.New("Symbol read from an empty Huffman code")
+	}
+	z.ensure(16)
+	sym := t.table[z.peek(t.bits)]
+	if sym == lzxInvalidSymbol {
+		return 0, errors.New("Invalid Huffman symbol")
+	}
+	z.remove(uint(t.lens[sym]))
+	return int(sym), nil
+}
+
+// readLengths reads the code lengths lens[first:last] through the pretree. The lengths are
+// coded as differences to those of the previous block.
+func (z *lzxReader) readLengths(lens []byte, first int, last int) error {
+	for i := range z.pretree.lens {
+		z.pretree.lens[i] = byte(z.readBits(4))
+	}
+	if err := z.pretree.build(); err != nil {
+		return err
+	}
+
+	for x := first; x < last; {
+		sym, err := z.decode(&z.pretree)
+		if err != nil {
+			return err
+		}
+		run, value := 1, -1
+		switch sym {
+		case 17:
+			run, value = int(z.readBits(4))+4, 0
+		case 18:
+			run, value = int(z.readBits(5))+20, 0
+		case 19:
+			run = int(z.readBits(1)) + 4
+			if sym, err = z.decode(&z.pretree); err != nil {
+				return err
+			}
+			fallthrough
+		default:
+			value = int(lens[x]) - sym
+			if value < 0 {
+				value += 17
+			}
+		}
+		if x+run > last {
+			return errors.New("Code length run past the end of the tree")
+		}
+		for ; run > 0; run-- {
+			lens[x] = byte(value)
+			x++
+		}
+	}
+	return nil
+}
+
+// readBlockHeader starts the next block
+func (z *lzxReader) readBlockHeader() error {
+	if z.blockType == lzxBlockUncompressed && z.blockLength&1 == 1 {
+		// uncompressed blocks are padded to an even length
+		z.in.readByte()
+	}
+
+	z.blockType = int(z.readBits(3))
+	hi := z.readBits(16)
+	lo := z.readBits(8)
+	z.blockLength = hi<<8 | lo
+	z.blockRemaining = z.blockLength
+
+	switch z.blockType {
+	case lzxBlockAligned:
+		for i := range z.aligned.lens {
+			z.aligned.lens[i] = byte(z.readBits(3))
+		}
+		if err := z.aligned.build(); err != nil {
+			return err
+		}
+		fallthrough
+	case lzxBlockVerbatim:
+		if err := z.readLengths(z.main.lens, 0, lzxNumChars); err != nil {
+			return err
+		}
+		if err := z.readLengths(z.main.lens, lzxNumChars, z.mainElements); err != nil {
+			return err
+		}
+		if err := z.main.build(); err != nil {
+			return err
+		}
+		if z.main.lens[0xe8] != 0 {
+			z.intelStarted = true
+		}
+		if err := z.readLengths(z.lengths.lens, 0, lzxLengthSize); err != nil {
+			return err
+		}
+		// an empty length tree is valid as long as no long match needs it
+		return z.lengths.build()
+	case lzxBlockUncompressed:
+		z.intelStarted = true
+		// align to the next 16 bit boundary, skipping a whole word if already aligned
+		if z.nbits == 0 {
+			z.ensure(16)
+		}
+		z.nbits, z.bitbuf = 0, 0
+		z.r0, z.r1, z.r2 = z.readUint32(), z.readUint32(), z.readUint32()
+		return nil
+	}
+	return fmt.Errorf("Invalid LZX block type %d", z.blockType)
+}
+
+// matchOffset reads the offset of a match from its position slot and updates the repeated
+// offsets
+func (z *lzxReader) matchOffset(slot uint32) (uint32, error) {
+	switch slot {
+	case 0:
+		return z.r0, nil
+	case 1:
+		z.r1, z.r0 = z.r0, z.r1
+		return z.r0, nil
+	case 2:
+		z.r2, z.r0 = z.r0, z.r2
+		return z.r0, nil
+	}
+
+	extra := lzxExtraBits[slot]
+	offset := lzxPositionBase[slot] - 2
+	if z.blockType == lzxBlockAligned && extra >= 3 {
+		offset += z.readBits(uint(extra-3)) << 3
+		aligned, err := z.decode(&z.aligned)
+		if err != nil {
+			return 0, err
+		}
+		offset += uint32(aligned)
+	} else {
+		offset += z.readBits(uint(extra))
+	}
+	z.r2, z.r1, z.r0 = z.r1, z.r0, offset
+	return offset, nil
+}
+
+// decodeRun decodes n bytes of a verbatim or aligned block into the window. The last match may
+// run past n, the overrun is returned.
+func (z *lzxReader) decodeRun(n int) (int, error) {
+	windowSize := uint32(len(z.window))
+	for n > 0 {
+		sym, err := z.decode(&z.main)
+		if err != nil {
+			return 0, err
+		}
+		if sym < lzxNumChars {
+			z.window[z.windowPosn] = byte(sym)
+			z.windowPosn++
+			n--
+			continue
+		}
+
+		sym -= lzxNumChars
+		length := uint32(sym & 7)
+		if length == 7 {
+			footer, err := z.decode(&z.lengths)
+			if err != nil {
+				return 0, err
+			}
+			length += uint32(footer)
+		}
+		length += lzxMinMatch
+
+		offset, err := z.matchOffset(uint32(sym >> 3))
+		if err != nil {
+			return 0, err
+		}
+		if offset == 0 || offset > windowSize {
+			return 0, fmt.Errorf("Invalid LZX match offset %d", offset)
+		}
+		if z.windowPosn+length > windowSize {
+			return 0, errors.New("LZX match runs past the end of the window")
+		}
+
+		src := (z.windowPosn - offset) & z.windowMask
+		for i := uint32(0); i < length; i++ {
+			z.window[z.windowPosn] = z.window[src]
+			z.windowPosn++
+			src = (src + 1) & z.windowMask
+		}
+		n -= int(length)
+	}
+	return -n, nil
+}
+
+// decodeFrame decodes the next frame of at most 32K into z.out
+func (z *lzxReader) decodeFrame() error {
+	if !z.headerRead {
+		// E8 call translation: one flag bit and the translation size
+		if z.readBits(1) == 1 {
+			hi := z.readBits(16)
+			lo := z.readBits(16)
+			z.intelFilesize = int32(hi<<16 | lo)
+		}
+		z.headerRead = true
+	}
+
+	frameSize := uint32(lzxFrameSize)
+	if left := z.length - z.offset; left < int64(frameSize) {
+		frameSize = uint32(left)
+	}
+
+	todo := int(z.framePosn + frameSize - z.windowPosn)
+	for todo > 0 {
+		if z.blockRemaining == 0 {
+			if err := z.readBlockHeader(); err != nil {
+				return err
+			}
+		}
+
+		run := int(z.blockRemaining)
+		if run > todo {
+			run = todo
+		}
+		todo -= run
+		z.blockRemaining -= uint32(run)
+
+		switch z.blockType {
+		case lzxBlockVerbatim, lzxBlockAligned:
+			overrun, err := z.decodeRun(run)
+			if err != nil {
+				return err
+			}
+			if uint32(overrun) > z.blockRemaining {
+				return errors.New("LZX match runs past the end of the block")
+			}
+			z.blockRemaining -= uint32(overrun)
+		case lzxBlockUncompressed:
+			if z.windowPosn+uint32(run) > uint32(len(z.window)) {
+				return errors.New("LZX uncompressed block runs past the end of the window")
+			}
+			for i := 0; i < run; i++ {
+				z.window[z.windowPosn] = z.in.readByte()
+				z.windowPosn++
+			}
+		}
+	}
+	if z.windowPosn-z.framePosn != frameSize {
+		return fmt.Errorf("LZX frame %d decoded to %d bytes instead of %d", z.frame, z.windowPosn-z.framePosn, frameSize)
+	}
+
+	// frames start on a 16 bit boundary
+	if z.nbits > 0 {
+		z.ensure(16)
+	}
+	if z.nbits&15 != 0 {
+		z.remove(z.nbits & 15)
+	}
+
+	z.out = append(z.out[:0], z.window[z.framePosn:z.framePosn+frameSize]...)
+	if z.intelStarted && z.intelFilesize != 0 && z.frame < 32768 && frameSize > 10 {
+		z.undoE8(z.out)
+	}
+	z.intelCurpos += int32(frameSize)
+
+	z.frame++
+	z.offset += int64(frameSize)
+	z.framePosn += frameSize
+	if z.framePosn == uint32(len(z.window)) {
+		z.framePosn = 0
+	}
+	if z.windowPosn == uint32(len(z.window)) {
+		z.windowPosn = 0
+	}
+	return nil
+}
+
+// undoE8 turns the absolute addresses of x86 CALL instructions written by the compressor back
+// into relative ones
+func (z *lzxReader) undoE8(data []byte) {
+	curpos := z.intelCurpos
+	for i := 0; i < len(data)-10; {
+		if data[i] != 0xe8 {
+			i++
+			curpos++
+			continue
+		}
+		abs := int32(binary.LittleEndian.Uint32(data[i+1:]))
+		if abs >= -curpos && abs < z.intelFilesize {
+			rel := abs + z.intelFilesize
+			if abs >= 0 {
+				rel = abs - curpos
+			}
+			binary.LittleEndian.PutUint32(data[i+1:], uint32(rel))
+		}
+		i += 5
+		curpos += 5
+	}
+}
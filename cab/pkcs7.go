@@ -0,0 +1,305 @@
+/**************************************************************************************************/
+// File: pkcs7.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: PKCS#7 SignedData as used by Authenticode and RFC 3161 timestamps
+/**************************************************************************************************/
+package cab
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	_ "crypto/sha1" //digest algorithms of signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidCounterSign   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSpcIndirect   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidRFC3161Stamp  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// contentInfo: ContentInfo of PKCS#7
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData: SignedData of PKCS#7. CRLs are not used.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// issuerAndSerial: Identifies the certificate of a signer
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// attribute: Authenticated or unauthenticated attribute of a signer
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// signerInfo: SignerInfo of PKCS#7
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerial           issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// digestInfo: Digest of the signed file within SpcIndirectDataContent
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// spcIndirectDataContent: Content signed by Authenticode
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+// tstInfo: RFC 3161 timestamp token content, the fields after genTime are not used
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint digestInfo
+	Serial         *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// hashOf returns the hash of a digest algorithm
+func hashOf(alg pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	switch {
+	case alg.Algorithm.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case alg.Algorithm.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case alg.Algorithm.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case alg.Algorithm.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("Unsupported digest algorithm %s", alg.Algorithm)
+}
+
+// hashName returns the name of h as used in reports (Ex., "SHA256")
+func hashName(h crypto.Hash) string {
+	switch h {
+	case crypto.SHA1:
+		return "SHA1"
+	case crypto.SHA256:
+		return "SHA256"
+	case crypto.SHA384:
+		return "SHA384"
+	case crypto.SHA512:
+		return "SHA512"
+	}
+	return h.String()
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	d := h.New()
+	d.Write(data)
+	return d.Sum(nil)
+}
+
+// parseSignedData reads a ContentInfo holding SignedData and its certificates
+func parseSignedData(der []byte) (*signedData, []*x509.Certificate, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, nil, fmt.Errorf("Invalid PKCS#7: %s", err)
+	} else if len(bytes.TrimRight(rest, "\x00")) > 0 {
+		return nil, nil, errors.New("Invalid PKCS#7: Trailing data")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("Invalid PKCS#7: Content type %s is not SignedData", ci.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("Invalid PKCS#7 SignedData: %s", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, nil, fmt.Errorf("Expected 1 signer, found %d", len(sd.SignerInfos))
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid certificate in PKCS#7: %s", err)
+	}
+	return &sd, certs, nil
+}
+
+// content returns the encapsulated content: the value of the SEQUENCE or OCTET STRING, which is
+// what the messageDigest attribute covers
+func (sd *signedData) content() ([]byte, error) {
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &v); err != nil {
+		return nil, fmt.Errorf("Invalid PKCS#7 content: %s", err)
+	}
+	return v.Bytes, nil
+}
+
+// attributes reads a [0] or [1] IMPLICIT SET OF Attribute
+func attributes(raw asn1.RawValue) ([]attribute, error) {
+	var attrs []attribute
+	rest := raw.Bytes
+	for len(rest) > 0 {
+		var a attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil {
+			return nil, fmt.Errorf("Invalid signer attribute: %s", err)
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs, nil
+}
+
+// attributeValue returns the first value of the attribute with oid, nil if absent
+func attributeValue(attrs []attribute, oid asn1.ObjectIdentifier) []byte {
+	for _, a := range attrs {
+		if a.Type.Equal(oid) {
+			var v asn1.RawValue
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &v); err == nil {
+				return v.FullBytes
+			}
+		}
+	}
+	return nil
+}
+
+// signerCertificate finds the certificate of si in certs
+func (si *signerInfo) signerCertificate(certs []*x509.Certificate) (*x509.Certificate, error) {
+	for _, c := range certs {
+		if c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 && bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("Certificate of signer with serial %X not found", si.IssuerAndSerial.Serial)
+}
+
+// signatureAlgorithm maps the digest of si and the key of cert to an x509 signature algorithm
+func signatureAlgorithm(h crypto.Hash, cert *x509.Certificate) (x509.SignatureAlgorithm, error) {
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		switch h {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA, nil
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1, nil
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("Unsupported signature with %s and a %T key", hashName(h), cert.PublicKey)
+}
+
+// verify checks that si signed content: the messageDigest attribute must match the digest of
+// content and the signature over the attributes must be valid for the signer's certificate.
+// It returns the certificate and the attributes.
+func (si *signerInfo) verify(content []byte, certs []*x509.Certificate) (*x509.Certificate, []attribute, error) {
+	cert, err := si.signerCertificate(certs)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := hashOf(si.DigestAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(si.AuthenticatedAttributes.Bytes) == 0 {
+		return nil, nil, errors.New("Signer has no authenticated attributes")
+	}
+	attrs, err := attributes(si.AuthenticatedAttributes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var md []byte
+	if _, err := asn1.Unmarshal(attributeValue(attrs, oidMessageDigest), &md); err != nil {
+		return nil, nil, errors.New("Signer has no message digest")
+	}
+	if !bytes.Equal(md, digest(h, content)) {
+		return nil, nil, errors.New("Message digest of the signer does not match the signed content")
+	}
+
+	// the signature covers the attributes encoded as SET OF rather than [0] IMPLICIT
+	signed := append([]byte(nil), si.AuthenticatedAttributes.FullBytes...)
+	signed[0] = 0x31
+	alg, err := signatureAlgorithm(h, cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cert.CheckSignature(alg, signed, si.EncryptedDigest); err != nil {
+		return nil, nil, fmt.Errorf("Invalid signature of %s: %s", cert.Subject.CommonName, err)
+	}
+	return cert, attrs, nil
+}
+
+// signingTime returns the signingTime attribute, zero if absent
+func signingTime(attrs []attribute) time.Time {
+	var t time.Time
+	if v := attributeValue(attrs, oidSigningTime); v != nil {
+		asn1.Unmarshal(v, &t)
+	}
+	return t
+}
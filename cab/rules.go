@@ -0,0 +1,472 @@
+/**************************************************************************************************/
+// File: rules.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Prerequisites and applicability rules of the updates in wsusscn2.cab
+/**************************************************************************************************/
+package cab
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// csidls: Folders of the Csidl attribute of file rules
+var csidls = map[string]string{
+	"36": "%windir%",
+	"37": "%windir%\\system32",
+	"38": "%ProgramFiles%",
+	"41": "%windir%\\SysWOW64",
+	"42": "%ProgramFiles(x86)%",
+	"43": "%CommonProgramFiles%",
+	"44": "%CommonProgramFiles(x86)%",
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// UpdateRules: Prerequisites and applicability rules of one update revision. Windows Update
+// offers an update if every prerequisite is met, IsInstallable is true and IsInstalled is false.
+type UpdateRules struct {
+	UpdateId       string
+	RevisionId     string
+	RevisionNumber string
+	UpdateType     string
+	Title          string
+
+	Prerequisites  []Prerequisite
+	BundledUpdates []UpdateRef
+
+	IsInstalled   *Rule // nil if the update has no such rule
+	IsInstallable *Rule
+	IsSuperseded  *Rule
+	Items         []InstallableItem // from the extended properties
+}
+
+// InstallableItem: Rules of one installable item of an update, usually one per file or package
+// it installs
+type InstallableItem struct {
+	Id            string
+	IsInstalled   *Rule
+	IsInstallable *Rule
+}
+
+// Prerequisite: One of Updates must be installed, or applicable if IsCategory (products and
+// classifications). Detectoids, like "x64-based systems", are prerequisites too.
+type Prerequisite struct {
+	Updates    []UpdateRef
+	IsCategory bool
+}
+
+// UpdateRef: Update referenced by id, Title is empty if the cab does not list it
+type UpdateRef struct {
+	UpdateId string
+	Title    string
+}
+
+// Rule: Node of an applicability rule tree. And, Or and Not combine Children, True and False
+// are constants, any other name is a check of the host such as RegDword, FileVersion or
+// WindowsVersion with its parameters in Attributes.
+type Rule struct {
+	Name       string // element name without namespace prefix
+	Attributes []xml.Attr
+	Children   []*Rule
+	Text       string // character data, rarely used
+}
+
+// RegistryCheck: Parameters of a registry rule (RegKeyExists, RegDword, RegSz, RegSzToVersion...)
+type RegistryCheck struct {
+	Key        string // Ex., HKEY_LOCAL_MACHINE
+	Subkey     string
+	Value      string // empty for the default value and key rules
+	Type       string // value type of RegValueExists
+	Comparison string // Ex., EqualTo, LessThan, Contains
+	Data       string
+	RegType32  bool // read the 32 bit view of the registry
+}
+
+// FileCheck: Parameters of a file rule (FileExists, FileVersion, FileSize, FileModified...)
+type FileCheck struct {
+	Csidl      string // special folder the path is relative to, see FullPath
+	Path       string
+	Comparison string
+	Version    string
+	Size       string
+	Created    string
+	Modified   string
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// ruleName strips the namespace prefix from an element name. The cab writes rules both as
+// "lar:And" and as "b.RegSz".
+func ruleName(name xml.Name) string {
+	if i := strings.LastIndex(name.Local, "."); i >= 0 {
+		return name.Local[i+1:]
+	}
+	return name.Local
+}
+
+// decodeRule reads the rule started by se and its children
+func decodeRule(d *xml.Decoder, se xml.StartElement) (*Rule, error) {
+	r := &Rule{Name: ruleName(se.Name)}
+	for _, a := range se.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+			continue
+		}
+		r.Attributes = append(r.Attributes, xml.Attr{Name: xml.Name{Local: a.Name.Local}, Value: a.Value})
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeRule(d, t)
+			if err != nil {
+				return nil, err
+			}
+			r.Children = append(r.Children, child)
+		case xml.CharData:
+			r.Text += string(t)
+		case xml.EndElement:
+			r.Text = strings.TrimSpace(r.Text)
+			return r, nil
+		}
+	}
+}
+
+// decodeRuleList reads the rules within se, several are combined with And. It returns nil if
+// se is empty.
+func decodeRuleList(d *xml.Decoder, se xml.StartElement) (*Rule, error) {
+	list, err := decodeRule(d, se)
+	if err != nil {
+		return nil, err
+	}
+	switch len(list.Children) {
+	case 0:
+		return nil, nil
+	case 1:
+		return list.Children[0], nil
+	}
+	return &Rule{Name: "And", Children: list.Children}, nil
+}
+
+// decodeApplicability reads an ApplicabilityRules element into the rule pointers
+func decodeApplicability(d *xml.Decoder, installed, installable, superseded **Rule) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var target **Rule
+			switch t.Name.Local {
+			case "IsInstalled":
+				target = installed
+			case "IsInstallable":
+				target = installable
+			case "IsSuperseded":
+				target = superseded
+			}
+			if target == nil {
+				// Metadata and unknown elements
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if *target, err = decodeRuleList(d, t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// decodeRelationships reads the prerequisites and bundled updates of a Relationships element
+func decodeRelationships(d *xml.Decoder, rules *UpdateRules) error {
+	var parent string       // Prerequisites or BundledUpdates
+	var group *Prerequisite // open AtLeastOne
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch t.Name.Local {
+			case "Prerequisites", "BundledUpdates":
+				parent = t.Name.Local
+			case "AtLeastOne":
+				isCategory, _ := strconv.ParseBool(attr(t, "IsCategory"))
+				group = &Prerequisite{IsCategory: isCategory}
+			case "UpdateIdentity":
+				ref := UpdateRef{UpdateId: attr(t, "UpdateID")}
+				switch {
+				case parent == "BundledUpdates":
+					rules.BundledUpdates = append(rules.BundledUpdates, ref)
+				case group != nil:
+					group.Updates = append(group.Updates, ref)
+				case parent == "Prerequisites":
+					rules.Prerequisites = append(rules.Prerequisites, Prerequisite{Updates: []UpdateRef{ref}})
+				}
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				return nil
+			}
+			depth--
+			switch t.Name.Local {
+			case "AtLeastOne":
+				if group != nil {
+					rules.Prerequisites = append(rules.Prerequisites, *group)
+					group = nil
+				}
+			case "Prerequisites", "BundledUpdates":
+				parent = ""
+			}
+		}
+	}
+}
+
+// decodeItem reads an InstallableItem of an extended fragment
+func decodeItem(d *xml.Decoder, se xml.StartElement) (InstallableItem, error) {
+	item := InstallableItem{Id: attr(se, "ID")}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return item, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "ApplicabilityRules" {
+				var superseded *Rule
+				err = decodeApplicability(d, &item.IsInstalled, &item.IsInstallable, &superseded)
+			} else {
+				err = d.Skip()
+			}
+			if err != nil {
+				return item, err
+			}
+		case xml.EndElement:
+			return item, nil
+		}
+	}
+}
+
+// Attr returns the value of the attribute name, empty if absent
+func (r *Rule) Attr(name string) string {
+	for _, a := range r.Attributes {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Registry returns the parameters of a registry rule, nil for any other rule
+func (r *Rule) Registry() *RegistryCheck {
+	if !strings.HasPrefix(r.Name, "Reg") {
+		return nil
+	}
+	regType32, _ := strconv.ParseBool(r.Attr("RegType32"))
+	return &RegistryCheck{
+		Key:        r.Attr("Key"),
+		Subkey:     r.Attr("Subkey"),
+		Value:      r.Attr("Value"),
+		Type:       r.Attr("Type"),
+		Comparison: r.Attr("Comparison"),
+		Data:       r.Attr("Data"),
+		RegType32:  regType32,
+	}
+}
+
+// File returns the parameters of a file rule, nil for any other rule
+func (r *Rule) File() *FileCheck {
+	if !strings.HasPrefix(r.Name, "File") {
+		return nil
+	}
+	return &FileCheck{
+		Csidl:      r.Attr("Csidl"),
+		Path:       r.Attr("Path"),
+		Comparison: r.Attr("Comparison"),
+		Version:    r.Attr("Version"),
+		Size:       r.Attr("Size"),
+		Created:    r.Attr("Created"),
+		Modified:   r.Attr("Modified"),
+	}
+}
+
+// FullPath returns the path of the key, with the value name if there is one
+func (rc *RegistryCheck) FullPath() string {
+	p := rc.Key
+	if rc.Subkey != "" {
+		p += "\\" + rc.Subkey
+	}
+	if rc.Value != "" {
+		p += "\\" + rc.Value
+	}
+	return p
+}
+
+// FullPath returns the path with the special folder spelled as an environment variable (Ex.,
+// "%windir%\system32\ntoskrnl.exe")
+func (fc *FileCheck) FullPath() string {
+	if fc.Csidl == "" {
+		return fc.Path
+	}
+	dir, ok := csidls[fc.Csidl]
+	if !ok {
+		dir = "<csidl " + fc.Csidl + ">"
+	}
+	return dir + "\\" + strings.TrimLeft(fc.Path, "\\")
+}
+
+// String describes the rule on one line, without its children
+func (r *Rule) String() string {
+	if rc := r.Registry(); rc != nil {
+		s := r.Name + " " + rc.FullPath()
+		if rc.Type != "" {
+			s += " " + rc.Type
+		}
+		if rc.Comparison != "" {
+			s += " " + rc.Comparison + " " + `"` + rc.Data + `"`
+		}
+		if rc.RegType32 {
+			s += " (32 bit)"
+		}
+		return s
+	}
+
+	if fc := r.File(); fc != nil {
+		s := r.Name + " " + fc.FullPath()
+		if fc.Comparison != "" {
+			for _, v := range []string{fc.Version, fc.Size, fc.Created, fc.Modified} {
+				if v != "" {
+					s += " " + fc.Comparison + " " + v
+					break
+				}
+			}
+		}
+		return s
+	}
+
+	s := r.Name
+	for _, a := range r.Attributes {
+		s += " " + a.Name.Local + `="` + a.Value + `"`
+	}
+	if r.Text != "" {
+		s += ` "` + r.Text + `"`
+	}
+	return s
+}
+
+// Format writes the rule tree, one rule per line with children indented below their parent
+func (r *Rule) Format(w io.Writer, indent string) error {
+	if _, err := fmt.Fprintf(w, "%s%s\n", indent, r); err != nil {
+		return err
+	}
+	for _, child := range r.Children {
+		if err := child.Format(w, indent+"  "); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatRef formats an update reference as "id (title)"
+func formatRef(ref UpdateRef) string {
+	if ref.Title == "" {
+		return ref.UpdateId
+	}
+	return ref.UpdateId + " (" + ref.Title + ")"
+}
+
+// Format writes the prerequisites, bundled updates and applicability rules
+func (u *UpdateRules) Format(w io.Writer) error {
+	var lines []string
+	add := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	if len(u.Prerequisites) > 0 {
+		add("Prerequisites (all of):")
+		for _, p := range u.Prerequisites {
+			if len(p.Updates) == 1 && !p.IsCategory {
+				add("  %s", formatRef(p.Updates[0]))
+				continue
+			}
+			kind := "update"
+			if p.IsCategory {
+				kind = "category"
+			}
+			add("  one %s of:", kind)
+			for _, ref := range p.Updates {
+				add("    %s", formatRef(ref))
+			}
+		}
+	}
+	if len(u.BundledUpdates) > 0 {
+		add("Bundled updates:")
+		for _, ref := range u.BundledUpdates {
+			add("  %s", formatRef(ref))
+		}
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+
+	if err := formatRules(w, "", u.IsInstalled, u.IsInstallable, u.IsSuperseded); err != nil {
+		return err
+	}
+	for _, item := range u.Items {
+		if _, err := fmt.Fprintf(w, "Installable item %s:\n", item.Id); err != nil {
+			return err
+		}
+		if err := formatRules(w, "  ", item.IsInstalled, item.IsInstallable, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatRules writes the IsInstalled, IsInstallable and IsSuperseded rules that are set
+func formatRules(w io.Writer, indent string, installed, installable, superseded *Rule) error {
+	for _, v := range []struct {
+		name string
+		rule *Rule
+	}{{"IsInstalled", installed}, {"IsInstallable", installable}, {"IsSuperseded", superseded}} {
+		if v.rule == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s%s:\n", indent, v.name); err != nil {
+			return err
+		}
+		if err := v.rule.Format(w, indent+"  "); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+/**************************************************************************************************/
+// File: signature.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Authenticode signature of a cabinet
+/**************************************************************************************************/
+package cab
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// ErrNotSigned is returned by Signature for a cabinet without an Authenticode signature
+var ErrNotSigned = errors.New("Cabinet is not signed")
+
+const (
+	signatureReserve = 20      // size of the header reserve of a signed cabinet
+	maxSignatureSize = 1 << 20 // sanity limit for the PKCS#7 blob
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Signature: Authenticode signature of a cabinet. The header reserve of a signed cabinet points
+// to a PKCS#7 SignedData appended after the cabinet data.
+type Signature struct {
+	Signer          *x509.Certificate     // certificate of the signer
+	Certificates    []*x509.Certificate   // every certificate in the signature
+	DigestAlgorithm string                // Ex., "SHA256"
+	Digest          []byte                // digest of the cabinet that was signed
+	Timestamp       time.Time             // signing time from the timestamp, zero if not timestamped
+	TimestampSigner *x509.Certificate     // certificate of the timestamp authority, nil if not timestamped
+	Chains          [][]*x509.Certificate // chains to a trusted root, set by Verify
+
+	cab         *Cabinet
+	hash        crypto.Hash
+	signer      *signerInfo
+	signedData  *signedData
+	timestampCA []*x509.Certificate // certificates of an RFC 3161 timestamp token
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Signature reads the Authenticode signature of the cabinet. It returns ErrNotSigned if there
+// is none. The signature is not verified, see Verify.
+func (c *Cabinet) Signature() (*Signature, error) {
+	if len(c.Reserved) < signatureReserve {
+		return nil, ErrNotSigned
+	}
+	offset := binary.LittleEndian.Uint32(c.Reserved[4:])
+	size := binary.LittleEndian.Uint32(c.Reserved[8:])
+	if offset == 0 || size == 0 {
+		return nil, ErrNotSigned
+	}
+	if size > maxSignatureSize {
+		return nil, fmt.Errorf("Signature of %d bytes is too large", size)
+	}
+	if int64(offset) < c.Size {
+		return nil, fmt.Errorf("Signature at %d overlaps the cabinet data", offset)
+	}
+
+	der := make([]byte, size)
+	if _, err := c.r.ReadAt(der, int64(offset)); err != nil {
+		return nil, fmt.Errorf("Unable to read signature: %s", err)
+	}
+	sd, certs, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirect) {
+		return nil, fmt.Errorf("Not an Authenticode signature, content type %s", sd.ContentInfo.ContentType)
+	}
+	var spc spcIndirectDataContent
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &spc); err != nil {
+		return nil, fmt.Errorf("Invalid Authenticode content: %s", err)
+	}
+	h, err := hashOf(spc.MessageDigest.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Signature{
+		Certificates:    certs,
+		DigestAlgorithm: hashName(h),
+		Digest:          spc.MessageDigest.Digest,
+		cab:             c,
+		hash:            h,
+		signer:          &sd.SignerInfos[0],
+		signedData:      sd,
+	}
+	if s.Signer, err = s.signer.signerCertificate(certs); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// digest computes the Authenticode digest of the cabinet: the header without its reserved
+// fields and reserve sizes, the last 4 bytes of the reserve, and everything after the reserve
+// up to the signature. Same as osslsigncode.
+func (c *Cabinet) digest(h crypto.Hash, end int64) ([]byte, error) {
+	header := make([]byte, 40+len(c.Reserved))
+	if _, err := c.r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	d := h.New()
+	d.Write(header[0:4])   // signature
+	d.Write(header[8:12])  // cbCabinet
+	d.Write(header[16:20]) // coffFiles
+	d.Write(header[24:36]) // version, cFolders, cFiles, flags, setID, iCabinet
+	d.Write(header[len(header)-4:])
+
+	if _, err := io.Copy(d, io.NewSectionReader(c.r, int64(len(header)), end-int64(len(header)))); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
+
+// Verify checks that the cabinet is unchanged since it was signed, that the signature and the
+// timestamp are valid, and that the signer chains to one of roots for code signing. A nil roots
+// uses the system roots. The signer certificate must be valid at the time of the timestamp, or
+// now if there is none.
+func (s *Signature) Verify(roots *x509.CertPool) error {
+	offset := int64(binary.LittleEndian.Uint32(s.cab.Reserved[4:]))
+	sum, err := s.cab.digest(s.hash, offset)
+	if err != nil {
+		return fmt.Errorf("Unable to compute cabinet digest: %s", err)
+	}
+	if !bytes.Equal(sum, s.Digest) {
+		return fmt.Errorf("Cabinet has been modified: %s digest is %X, signed %X", s.DigestAlgorithm, sum, s.Digest)
+	}
+
+	content, err := s.signedData.content()
+	if err != nil {
+		return err
+	}
+	if _, _, err := s.signer.verify(content, s.Certificates); err != nil {
+		return err
+	}
+
+	if err := s.verifyTimestamp(); err != nil {
+		return err
+	}
+
+	at := time.Now()
+	if !s.Timestamp.IsZero() {
+		at = s.Timestamp
+	}
+	s.Chains, err = s.Signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool(s.Certificates),
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return fmt.Errorf("Untrusted signer %s: %s", s.Signer.Subject.CommonName, err)
+	}
+
+	if s.TimestampSigner != nil {
+		_, err = s.TimestampSigner.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: pool(append(append([]*x509.Certificate(nil), s.Certificates...), s.timestampCA...)),
+			CurrentTime:   s.Timestamp,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		})
+		if err != nil {
+			return fmt.Errorf("Untrusted timestamp authority %s: %s", s.TimestampSigner.Subject.CommonName, err)
+		}
+	}
+	return nil
+}
+
+// verifyTimestamp checks the countersignature or RFC 3161 timestamp of the signer, if any, and
+// sets Timestamp and TimestampSigner. Both cover the encrypted digest of the signer.
+func (s *Signature) verifyTimestamp() error {
+	attrs, err := attributes(s.signer.UnauthenticatedAttributes)
+	if err != nil {
+		return err
+	}
+
+	if v := attributeValue(attrs, oidCounterSign); v != nil {
+		var si signerInfo
+		if _, err := asn1.Unmarshal(v, &si); err != nil {
+			return fmt.Errorf("Invalid countersignature: %s", err)
+		}
+		cert, cattrs, err := si.verify(s.signer.EncryptedDigest, s.Certificates)
+		if err != nil {
+			return fmt.Errorf("Invalid countersignature: %s", err)
+		}
+		s.Timestamp, s.TimestampSigner = signingTime(cattrs), cert
+		return nil
+	}
+
+	if v := attributeValue(attrs, oidRFC3161Stamp); v != nil {
+		sd, certs, err := parseSignedData(v)
+		if err != nil {
+			return fmt.Errorf("Invalid timestamp: %s", err)
+		}
+		if !sd.ContentInfo.ContentType.Equal(oidTSTInfo) {
+			return fmt.Errorf("Invalid timestamp, content type %s", sd.ContentInfo.ContentType)
+		}
+		content, err := sd.content()
+		if err != nil {
+			return err
+		}
+		cert, _, err := sd.SignerInfos[0].verify(content, certs)
+		if err != nil {
+			return fmt.Errorf("Invalid timestamp: %s", err)
+		}
+
+		var tst tstInfo
+		if _, err := asn1.Unmarshal(content, &tst); err != nil {
+			return fmt.Errorf("Invalid timestamp: %s", err)
+		}
+		h, err := hashOf(tst.MessageImprint.Algorithm)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tst.MessageImprint.Digest, digest(h, s.signer.EncryptedDigest)) {
+			return errors.New("Timestamp does not match the signature")
+		}
+		s.Timestamp, s.TimestampSigner, s.timestampCA = tst.GenTime, cert, certs
+	}
+	return nil
+}
+
+func pool(certs []*x509.Certificate) *x509.CertPool {
+	p := x509.NewCertPool()
+	for _, c := range certs {
+		p.AddCert(c)
+	}
+	return p
+}
@@ -0,0 +1,102 @@
+/**************************************************************************************************/
+// File: signature_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the Authenticode signature of testdata/signed.cab
+/**************************************************************************************************/
+package cab
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// testRoots reads the trust bundle name of testdata
+func testRoots(t *testing.T, name string) *x509.CertPool {
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(b) {
+		t.Fatalf("No certificate in %s", name)
+	}
+	return roots
+}
+
+// testSignature reads the signature of testdata/signed.cab, wsusscn2.cab signed with SHA-256
+// by a leaf of testdata/root.pem that expired in 2019, timestamped in 2018 by an RFC 3161
+// authority. tamper is called with the bytes of the cabinet first.
+func testSignature(t *testing.T, tamper func(b []byte)) *Signature {
+	b, err := ioutil.ReadFile("testdata/signed.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(b)
+	}
+	c, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := c.Signature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestSignatureValid(t *testing.T) {
+	sig := testSignature(t, nil)
+	if err := sig.Verify(testRoots(t, "root.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if sig.Signer.Subject.CommonName != "Microsoft Corporation" || sig.DigestAlgorithm != "SHA256" {
+		t.Errorf("Signed by %s with %s", sig.Signer.Subject.CommonName, sig.DigestAlgorithm)
+	}
+	if !sig.Timestamp.Equal(time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC)) || sig.TimestampSigner == nil {
+		t.Errorf("Timestamp %s", sig.Timestamp)
+	}
+	if len(sig.Chains) != 1 || len(sig.Chains[0]) != 3 || sig.Chains[0][2].Subject.CommonName != "Test Root" {
+		t.Errorf("Chains %v", sig.Chains)
+	}
+}
+
+func TestSignatureTampered(t *testing.T) {
+	// one byte of the file data, after the headers
+	sig := testSignature(t, func(b []byte) {
+		b[200] ^= 1
+	})
+	err := sig.Verify(testRoots(t, "root.pem"))
+	if err == nil || !strings.Contains(err.Error(), "Cabinet has been modified") {
+		t.Errorf("Verify of a modified cabinet returned %v, expected a digest mismatch", err)
+	}
+}
+
+func TestSignatureUntrusted(t *testing.T) {
+	sig := testSignature(t, nil)
+	err := sig.Verify(testRoots(t, "untrusted.pem"))
+	if err == nil || !strings.Contains(err.Error(), "Untrusted signer") {
+		t.Errorf("Verify with another root returned %v, expected an untrusted signer", err)
+	}
+}
+
+func TestNotSigned(t *testing.T) {
+	c, err := Open("testdata/wsusscn2.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Signature(); err != ErrNotSigned {
+		t.Errorf("Signature of an unsigned cabinet returned %v", err)
+	}
+}
@@ -0,0 +1,436 @@
+/**************************************************************************************************/
+// File: lzx.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: LZX encoder of the test folders, with fixed rather than optimal Huffman codes
+/**************************************************************************************************/
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const (
+	lzxBlockVerbatim     = 1
+	lzxBlockAligned      = 2
+	lzxBlockUncompressed = 3
+
+	lzxNumChars     = 256
+	lzxFrameSize    = 32768
+	lzxPretreeSize  = 20
+	lzxLengthSize   = 249
+	lzxAlignedSize  = 8
+	lzxMaxMatch     = 257
+	lzxSearchWindow = 3000 // farthest match offset tried
+)
+
+// lzxPositionSlots: Number of position slots of each window size in bits
+var lzxPositionSlots = map[uint]int{15: 30, 16: 32, 17: 34, 18: 36, 19: 38, 20: 42, 21: 50}
+
+// lzxExtraBits, lzxPositionBase: Footer bits and first offset of each position slot
+var lzxExtraBits, lzxPositionBase [51]uint32
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// bitWriter: LZX bitstream, 16-bit little-endian words filled from the most significant bit
+type bitWriter struct {
+	out   []byte
+	buf   uint32
+	nbits uint
+}
+
+// huffman: Canonical Huffman code of a set of code lengths
+type huffman struct {
+	codes   []uint32
+	lengths []byte
+}
+
+// blockSpec: One LZX block of a folder. mainLengths defaults to a complete code of equal lengths,
+// noMatch encodes literals only.
+type blockSpec struct {
+	typ         int
+	length      int
+	mainLengths []byte
+	noMatch     bool
+}
+
+// lzxEncoder: State of the encoder carried from block to block of a folder
+type lzxEncoder struct {
+	w          bitWriter
+	windowBits uint
+	mainSize   int
+	prevMain   []byte
+	prevLength []byte
+	r0, r1, r2 uint32
+	frameEnds  []int // compressed size at the end of each frame
+	pos        int
+	frameEnd   int
+	uncompSize int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func init() {
+	j := uint32(0)
+	for i := 0; i < len(lzxExtraBits); i += 2 {
+		lzxExtraBits[i] = j
+		if i+1 < len(lzxExtraBits) {
+			lzxExtraBits[i+1] = j
+		}
+		if i != 0 && j < 17 {
+			j++
+		}
+	}
+	j = 0
+	for i := range lzxPositionBase {
+		lzxPositionBase[i] = j
+		j += 1 << lzxExtraBits[i]
+	}
+}
+
+// put writes the n low bits of v
+func (w *bitWriter) put(v uint32, n uint) {
+	for n > 0 {
+		n--
+		w.buf = w.buf<<1 | (v>>n)&1
+		w.nbits++
+		if w.nbits == 16 {
+			w.out = append(w.out, byte(w.buf), byte(w.buf>>8))
+			w.buf, w.nbits = 0, 0
+		}
+	}
+}
+
+// align pads to the next 16-bit word
+func (w *bitWriter) align() {
+	for w.nbits != 0 {
+		w.put(0, 1)
+	}
+}
+
+// raw writes bytes at a word boundary
+func (w *bitWriter) raw(b ...byte) {
+	if w.nbits != 0 {
+		panic("Raw bytes at an unaligned position")
+	}
+	w.out = append(w.out, b...)
+}
+
+// completeLengths returns the code lengths of a complete code of n symbols, as equal as possible
+func completeLengths(n int) []byte {
+	l := 0
+	for 1<<uint(l+1) <= n {
+		l++
+	}
+	lengths := make([]byte, n)
+	short := 1<<uint(l+1) - n
+	if 1<<uint(l) == n {
+		short = n
+	}
+	for i := range lengths {
+		if i < short {
+			lengths[i] = byte(l)
+		} else {
+			lengths[i] = byte(l + 1)
+		}
+	}
+	return lengths
+}
+
+// newHuffman assigns the canonical codes of lengths
+func newHuffman(lengths []byte) huffman {
+	h := huffman{codes: make([]uint32, len(lengths)), lengths: lengths}
+	max := 0
+	for _, l := range lengths {
+		if int(l) > max {
+			max = int(l)
+		}
+	}
+	code := uint32(0)
+	for l := 1; l <= max; l++ {
+		for s, sl := range lengths {
+			if int(sl) == l {
+				h.codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// put writes the code of sym
+func (h huffman) put(w *bitWriter, sym int) {
+	if h.lengths[sym] == 0 {
+		panic(fmt.Sprintf("Symbol %d has no code", sym))
+	}
+	w.put(h.codes[sym], uint(h.lengths[sym]))
+}
+
+// writeLengths writes code lengths as deltas from prev through a pretree, with the zero runs
+// (17, 18) and same-delta runs (19) of the format
+func (e *lzxEncoder) writeLengths(lengths []byte, prev []byte) {
+	pretree := completeLengths(lzxPretreeSize)
+	pc := newHuffman(pretree)
+	for _, l := range pretree {
+		e.w.put(uint32(l), 4)
+	}
+	delta := func(x int) int {
+		return (int(prev[x]) - int(lengths[x]) + 17) % 17
+	}
+	for x := 0; x < len(lengths); {
+		if lengths[x] == 0 {
+			run := 0
+			for x+run < len(lengths) && lengths[x+run] == 0 {
+				run++
+			}
+			if run >= 20 {
+				if run > 51 {
+					run = 51
+				}
+				pc.put(&e.w, 18)
+				e.w.put(uint32(run-20), 5)
+				x += run
+				continue
+			}
+			if run >= 4 {
+				pc.put(&e.w, 17)
+				e.w.put(uint32(run-4), 4)
+				x += run
+				continue
+			}
+		}
+		// 19 applies the delta of the first length to the whole run
+		run := 0
+		for x+run < len(lengths) && lengths[x+run] == lengths[x] && prev[x+run] == prev[x] && run < 5 {
+			run++
+		}
+		if run >= 4 {
+			pc.put(&e.w, 19)
+			e.w.put(uint32(run-4), 1)
+			pc.put(&e.w, delta(x))
+			x += run
+			continue
+		}
+		pc.put(&e.w, delta(x))
+		x++
+	}
+}
+
+// nextFrame aligns the bitstream and records the end of a frame once pos reaches it
+func (e *lzxEncoder) nextFrame() {
+	if e.pos != e.frameEnd {
+		return
+	}
+	e.w.align()
+	e.frameEnds = append(e.frameEnds, len(e.w.out))
+	if e.frameEnd == e.uncompSize {
+		e.frameEnd = e.uncompSize + 1
+		return
+	}
+	e.frameEnd += lzxFrameSize
+	if e.frameEnd > e.uncompSize {
+		e.frameEnd = e.uncompSize
+	}
+}
+
+// encode compresses data as blocks, which must cover it. e8size is the translation size written
+// in the header, 0 for none; data must already be translated.
+func (e *lzxEncoder) encode(data []byte, blocks []blockSpec, e8size int32) []byte {
+	e.mainSize = lzxNumChars + lzxPositionSlots[e.windowBits]*8
+	e.prevMain = make([]byte, e.mainSize)
+	e.prevLength = make([]byte, lzxLengthSize)
+	e.r0, e.r1, e.r2 = 1, 1, 1
+	e.uncompSize = len(data)
+	e.frameEnd = lzxFrameSize
+	if e.frameEnd > len(data) {
+		e.frameEnd = len(data)
+	}
+
+	if e8size != 0 {
+		e.w.put(1, 1)
+		e.w.put(uint32(e8size)>>16, 16)
+		e.w.put(uint32(e8size)&0xffff, 16)
+	} else {
+		e.w.put(0, 1)
+	}
+	for _, b := range blocks {
+		e.w.put(uint32(b.typ), 3)
+		e.w.put(uint32(b.length>>8), 16)
+		e.w.put(uint32(b.length&0xff), 8)
+		if b.typ == lzxBlockUncompressed {
+			e.uncompressed(data, b)
+		} else {
+			e.compressed(data, b)
+		}
+	}
+	if e.pos != len(data) {
+		panic("Blocks do not cover the data")
+	}
+	return e.w.out
+}
+
+// uncompressed writes an uncompressed block: the repeated offsets, then the bytes, padded to
+// an even length
+func (e *lzxEncoder) uncompressed(data []byte, b blockSpec) {
+	if e.w.nbits == 0 {
+		e.w.put(0, 16)
+	}
+	e.w.align()
+	var r [12]byte
+	binary.LittleEndian.PutUint32(r[0:], e.r0)
+	binary.LittleEndian.PutUint32(r[4:], e.r1)
+	binary.LittleEndian.PutUint32(r[8:], e.r2)
+	e.w.raw(r[:]...)
+	for end := e.pos + b.length; e.pos < end; {
+		e.w.raw(data[e.pos])
+		e.pos++
+		e.nextFrame()
+	}
+	if b.length&1 == 1 {
+		e.w.raw(0)
+	}
+}
+
+// compressed writes a verbatim or aligned block with greedy matching. Matches do not cross
+// frames.
+func (e *lzxEncoder) compressed(data []byte, b blockSpec) {
+	var aligned huffman
+	if b.typ == lzxBlockAligned {
+		lengths := completeLengths(lzxAlignedSize)
+		for _, l := range lengths {
+			e.w.put(uint32(l), 3)
+		}
+		aligned = newHuffman(lengths)
+	}
+	mainLengths := b.mainLengths
+	if mainLengths == nil {
+		mainLengths = completeLengths(e.mainSize)
+	}
+	e.writeLengths(mainLengths[:lzxNumChars], e.prevMain[:lzxNumChars])
+	e.writeLengths(mainLengths[lzxNumChars:], e.prevMain[lzxNumChars:])
+	copy(e.prevMain, mainLengths)
+	lengthLengths := make([]byte, lzxLengthSize)
+	if !b.noMatch {
+		lengthLengths = completeLengths(lzxLengthSize)
+	}
+	e.writeLengths(lengthLengths, e.prevLength)
+	copy(e.prevLength, lengthLengths)
+	mainCode, lengthCode := newHuffman(mainLengths), newHuffman(lengthLengths)
+
+	window := 1 << e.windowBits
+	for end := e.pos + b.length; e.pos < end; {
+		limit := end
+		if e.frameEnd < limit {
+			limit = e.frameEnd
+		}
+		// the repeated offsets first, so they win ties
+		matchLen, matchOffset := 0, 0
+		if !b.noMatch {
+			offsets := []int{int(e.r0), int(e.r1), int(e.r2)}
+			for off := 1; off <= lzxSearchWindow && off <= e.pos && off < window-3; off++ {
+				offsets = append(offsets, off)
+			}
+			for _, off := range offsets {
+				if off <= 0 || off > e.pos {
+					continue
+				}
+				l := 0
+				for e.pos+l < limit && l < lzxMaxMatch && data[e.pos+l] == data[e.pos+l-off] {
+					l++
+				}
+				if l > matchLen {
+					matchLen, matchOffset = l, off
+				}
+			}
+		}
+		if matchLen < 3 {
+			mainCode.put(&e.w, int(data[e.pos]))
+			e.pos++
+			e.nextFrame()
+			continue
+		}
+
+		off := uint32(matchOffset)
+		var slot, formatted uint32
+		switch off {
+		case e.r0:
+			slot = 0
+		case e.r1:
+			slot = 1
+			e.r1, e.r0 = e.r0, e.r1
+		case e.r2:
+			slot = 2
+			e.r2, e.r0 = e.r0, e.r2
+		default:
+			formatted = off + 2
+			for slot = 3; !(lzxPositionBase[slot] <= formatted && formatted < lzxPositionBase[slot+1]); slot++ {
+			}
+			e.r2, e.r1, e.r0 = e.r1, e.r0, off
+		}
+		header := matchLen - 2
+		if header > 7 {
+			header = 7
+		}
+		mainCode.put(&e.w, lzxNumChars+int(slot)*8+header)
+		if matchLen-2 >= 7 {
+			lengthCode.put(&e.w, matchLen-2-7)
+		}
+		if slot >= 3 {
+			extra := lzxExtraBits[slot]
+			footer := formatted - lzxPositionBase[slot]
+			if b.typ == lzxBlockAligned && extra >= 3 {
+				e.w.put(footer>>3, uint(extra-3))
+				aligned.put(&e.w, int(footer&7))
+			} else {
+				e.w.put(footer, uint(extra))
+			}
+		}
+		e.pos += matchLen
+		e.nextFrame()
+	}
+}
+
+// e8Translate applies the E8 call translation the decoder reverses, frame by frame, to a copy
+// of data
+func e8Translate(data []byte, size int32) []byte {
+	out := append([]byte(nil), data...)
+	curpos := int32(0)
+	for f := 0; f < len(out); f += lzxFrameSize {
+		end := f + lzxFrameSize
+		if end > len(out) {
+			end = len(out)
+		}
+		frame := out[f:end]
+		pos := curpos
+		for i := 0; i < len(frame)-10; {
+			if frame[i] != 0xe8 {
+				i++
+				pos++
+				continue
+			}
+			rel := int32(binary.LittleEndian.Uint32(frame[i+1:]))
+			if rel >= -pos && rel < size-pos {
+				binary.LittleEndian.PutUint32(frame[i+1:], uint32(rel+pos))
+			} else if rel >= size-pos && rel < size {
+				binary.LittleEndian.PutUint32(frame[i+1:], uint32(rel-size))
+			}
+			i += 5
+			pos += 5
+		}
+		curpos += int32(len(frame))
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+/**************************************************************************************************/
+// File: main.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Generator of the cabinets of cab/testdata. Run from cab with go generate, or
+//              go run ./testdata/gen [-sign] testdata
+/**************************************************************************************************/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/hashauthority/wsusscn2cli/cab"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// main writes test.cab and wsusscn2.cab to the directory argument. They are the same bytes on
+// every run. With -sign it also writes signed.cab, wsusscn2.cab signed by a new chain under
+// root.pem, and untrusted.pem, another root. Those differ on every run, as the keys are new,
+// so they are only rewritten on request.
+func main() {
+	sign := flag.Bool("sign", false, "Also write signed.cab, root.pem and untrusted.pem")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: gen [-sign] dir")
+	}
+	dir := flag.Arg(0)
+
+	test, files := testCab()
+	write(dir, "test.cab", test)
+	verify(filepath.Join(dir, "test.cab"), files)
+	wsus := wsusCab()
+	write(dir, "wsusscn2.cab", wsus)
+	if *sign {
+		signed, root, untrusted := signCab(wsus)
+		write(dir, "signed.cab", signed)
+		write(dir, "root.pem", root)
+		write(dir, "untrusted.pem", untrusted)
+	}
+}
+
+func check(e error) {
+	if e != nil {
+		log.Fatalf("%s", e)
+	}
+}
+
+// write writes b to the file name of dir
+func write(dir string, name string, b []byte) {
+	check(ioutil.WriteFile(filepath.Join(dir, name), b, 0644))
+	log.Printf("Wrote %s, %d bytes", filepath.Join(dir, name), len(b))
+}
+
+// testCab returns test.cab and the content of its files. Its folders are MSZIP (a.txt,
+// dir\b.xml, c.bin), stored (stored.dat), LZX with a 32K window and verbatim, aligned and
+// uncompressed blocks spanning frames and E8 translation (l\en\12345, c\12345), and LZX with
+// a 128K window (package.xml).
+func testCab() ([]byte, map[string][]byte) {
+	var folders []folderSpec
+	var files []fileSpec
+	content := map[string][]byte{}
+	add := func(folder int, name string, b []byte, offset int) {
+		files = append(files, fileSpec{name, folder, offset, len(b)})
+		content[name] = b
+	}
+
+	// MSZIP, the second file spans two blocks
+	a, b, c := textData(1000, 1), textData(40000, 2), textData(5, 3)
+	folder := append(append(append([]byte(nil), a...), b...), c...)
+	folders = append(folders, folderSpec{compMszip, mszipBlocks(folder)})
+	add(0, "a.txt", a, 0)
+	add(0, "dir\\b.xml", b, len(a))
+	add(0, "c.bin", c, len(a)+len(b))
+
+	s := textData(4000, 4)
+	folders = append(folders, folderSpec{compNone, storedBlocks(s)})
+	add(1, "stored.dat", s, 0)
+
+	// LZX over 70000 bytes, more than twice the 32K window. The second block has literals only.
+	l := textData(70000, 5)
+	literals := make([]byte, lzxNumChars+lzxPositionSlots[15]*8)
+	for i := 0; i < lzxNumChars; i++ {
+		literals[i] = 8
+	}
+	blocks := []blockSpec{
+		{typ: lzxBlockVerbatim, length: 20000},
+		{typ: lzxBlockVerbatim, length: 3001, mainLengths: literals, noMatch: true},
+		{typ: lzxBlockAligned, length: 20000},
+		{typ: lzxBlockUncompressed, length: 777},
+		{typ: lzxBlockUncompressed, length: 1000},
+		{typ: lzxBlockAligned, length: 70000 - 20000 - 3001 - 20000 - 777 - 1000},
+	}
+	folders = append(folders, folderSpec{compLzx | 15<<8, lzxBlocks(l, 15, blocks, int32(len(l)))})
+	add(2, "l/en/12345", l[:30000], 0)
+	add(2, "c/12345", l[30000:], 30000)
+
+	m := textData(20000, 6)
+	folders = append(folders, folderSpec{compLzx | 17<<8, lzxBlocks(m, 17, []blockSpec{{typ: lzxBlockVerbatim, length: len(m)}}, 0)})
+	add(3, "package.xml", m, 0)
+
+	return writeCab(folders, files, []byte{1, 2, 3, 4, 5, 6, 7, 8}), content
+}
+
+// verify reads the cabinet path back with the cab package, both in order and file by file, and
+// stops unless every file has the expected content
+func verify(path string, content map[string][]byte) {
+	c, err := cab.Open(path)
+	check(err)
+	defer c.Close()
+	if len(c.Files) != len(content) {
+		log.Fatalf("%s has %d files, expected %d", path, len(c.Files), len(content))
+	}
+	check(c.Walk(func(f *cab.File, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b, content[f.Name]) {
+			return fmt.Errorf("Walk read %s of %s wrong", f.Name, path)
+		}
+		return nil
+	}))
+	for _, f := range c.Files {
+		b, err := f.ReadFile()
+		check(err)
+		if !bytes.Equal(b, content[f.Name]) {
+			log.Fatalf("ReadFile read %s of %s wrong", f.Name, path)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+/**************************************************************************************************/
+// File: sign.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Authenticode signature of signed.cab, SHA-256 with an RFC 3161 timestamp
+/**************************************************************************************************/
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+var sha256OID = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+var nullParam = asn1.RawValue{Tag: 5}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// algo, attr, ias, signerInfo, contentInfo, signedData: PKCS #7 structures marshaled with
+// encoding/asn1
+type algo struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+type attr struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+type ias struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+type signerInfo struct {
+	Version int
+	IAS     ias
+	Digest  algo
+	Auth    asn1.RawValue
+	Enc     algo
+	Sig     []byte
+	Unauth  asn1.RawValue `asn1:"optional"`
+}
+type contentInfo struct {
+	T asn1.ObjectIdentifier
+	C asn1.RawValue
+}
+
+type signedData struct {
+	Version int
+	Algs    []algo `asn1:"set"`
+	Content contentInfo
+	Certs   asn1.RawValue
+	Signers []signerInfo `asn1:"set"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// mkcert creates the certificate of tmpl with a new key, signed by parent and pkey, or
+// self-signed when parent is nil
+func mkcert(tmpl *x509.Certificate, parent *x509.Certificate, pkey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	check(err)
+	if parent == nil {
+		parent, pkey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, pkey)
+	check(err)
+	c, err := x509.ParseCertificate(der)
+	check(err)
+	return c, key
+}
+
+// wrap returns content as a ContentInfo of type t
+func wrap(t asn1.ObjectIdentifier, content []byte) contentInfo {
+	return contentInfo{t, asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: content}}
+}
+
+// raw returns the DER of v as a raw value
+func raw(v interface{}) asn1.RawValue {
+	b, err := asn1.Marshal(v)
+	check(err)
+	return asn1.RawValue{FullBytes: b}
+}
+
+// implicitSet returns attrs as an implicitly tagged set, and as the SET OF that is signed
+func implicitSet(tag int, attrs []attr) (asn1.RawValue, []byte) {
+	var body []byte
+	for _, a := range attrs {
+		b, err := asn1.Marshal(a)
+		check(err)
+		body = append(body, b...)
+	}
+	set, _ := asn1.Marshal(asn1.RawValue{Class: 0, Tag: 17, IsCompound: true, Bytes: body})
+	return asn1.RawValue{Class: 2, Tag: tag, IsCompound: true, Bytes: body}, set
+}
+
+// sign returns ContentInfo(SignedData) over content (the full DER of the encapsulated content)
+func sign(ctype asn1.ObjectIdentifier, content []byte, hashed []byte, extra []attr, cert *x509.Certificate, key *rsa.PrivateKey, certs []*x509.Certificate, unauth []attr) []byte {
+	md := sha256.Sum256(hashed)
+	attrs := append([]attr{
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, []asn1.RawValue{raw(ctype)}},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}, []asn1.RawValue{raw(md[:])}},
+	}, extra...)
+	auth, set := implicitSet(0, attrs)
+	h := sha256.Sum256(set)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	check(err)
+	si := signerInfo{Version: 1, IAS: ias{asn1.RawValue{FullBytes: cert.RawIssuer}, cert.SerialNumber}, Digest: algo{sha256OID, nullParam}, Auth: auth,
+		Enc: algo{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}, nullParam}, Sig: sig}
+	if unauth != nil {
+		si.Unauth, _ = implicitSet(1, unauth)
+	}
+	var cb []byte
+	for _, c := range certs {
+		cb = append(cb, c.Raw...)
+	}
+	s := signedData{1, []algo{{sha256OID, nullParam}}, wrap(ctype, content), asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: cb}, []signerInfo{si}}
+	b, err := asn1.Marshal(wrap(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, raw(s).FullBytes))
+	check(err)
+	return b
+}
+
+// signCab returns src signed by a "Microsoft Corporation" leaf that expired in 2019 under a new
+// chain, timestamped on 2018-10-09 by an RFC 3161 authority, the PEM of its root, and the PEM
+// of another root of the same name. The cabinet digest is computed here, apart from the cab
+// package, following osslsigncode.
+func signCab(src []byte) ([]byte, []byte, []byte) {
+	le := binary.LittleEndian
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	root, rootKey := mkcert(&x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test Root"}, NotBefore: t0, NotAfter: t0.AddDate(30, 0, 0), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	inter, interKey := mkcert(&x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "Test Code Signing PCA"}, NotBefore: t0, NotAfter: t0.AddDate(20, 0, 0), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, root, rootKey)
+	// leaf expired in 2019: only valid through the timestamp
+	leaf, leafKey := mkcert(&x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "Microsoft Corporation", Organization: []string{"Microsoft Corporation"}}, NotBefore: t0, NotAfter: t0.AddDate(2, 0, 0), KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}}, inter, interKey)
+	tsa, tsaKey := mkcert(&x509.Certificate{SerialNumber: big.NewInt(4), Subject: pkix.Name{CommonName: "Test Time-Stamp Service"}, NotBefore: t0, NotAfter: t0.AddDate(20, 0, 0), KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}}, root, rootKey)
+	untrusted, _ := mkcert(&x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test Root"}, NotBefore: t0, NotAfter: t0.AddDate(30, 0, 0), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+
+	// the 20 byte reserve of osslsigncode, for the offset and size of the signature
+	flags := le.Uint16(src[30:])
+	if flags&4 != 0 {
+		panic("Cabinet already has a reserved area")
+	}
+	cab := append([]byte(nil), src[:36]...)
+	cab = append(cab, 20, 0, 0, 0) // cbCFHeader=20, cbCFFolder=0, cbCFData=0
+	res := make([]byte, 20)
+	le.PutUint32(res[0:], 0x00100000)
+	cab = append(cab, res...)
+	cab = append(cab, src[36:]...)
+	le.PutUint16(cab[30:], flags|4)
+	le.PutUint32(cab[8:], le.Uint32(src[8:])+24)
+	le.PutUint32(cab[16:], le.Uint32(src[16:])+24)
+	nf := int(le.Uint16(cab[26:]))
+	for i := 0; i < nf; i++ {
+		o := 60 + 8*i
+		le.PutUint32(cab[o:], le.Uint32(cab[o:])+24)
+	}
+	sigpos := uint32(len(cab))
+	le.PutUint32(cab[44:], sigpos)
+
+	// digest per osslsigncode cab.c, written separately from the cab package
+	d := sha256.New()
+	d.Write(cab[0:4])
+	d.Write(cab[8:12])
+	d.Write(cab[16:20])
+	d.Write(cab[24:30])
+	d.Write(cab[30:32])
+	d.Write(cab[32:34])
+	d.Write(cab[34:36])
+	d.Write(cab[56:60])
+	idx := 60
+	for i := 0; i < nf; i++ {
+		d.Write(cab[idx : idx+8])
+		idx += 8
+	}
+	d.Write(cab[idx:sigpos])
+	sum := d.Sum(nil)
+
+	spcOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	type digestInfo struct {
+		A algo
+		D []byte
+	}
+	spc, _ := asn1.Marshal(struct {
+		Data asn1.RawValue
+		MD   digestInfo
+	}{raw(struct{ T asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 25}}), digestInfo{algo{sha256OID, nullParam}, sum}})
+	var spcv asn1.RawValue
+	asn1.Unmarshal(spc, &spcv)
+
+	// first sign to learn the encrypted digest, then timestamp it
+	unsigned := sign(spcOID, spc, spcv.Bytes, nil, leaf, leafKey, []*x509.Certificate{leaf, inter}, nil)
+	var outer struct {
+		T asn1.ObjectIdentifier
+		C asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	asn1.Unmarshal(unsigned, &outer)
+	var inner struct {
+		Version int
+		Algs    asn1.RawValue
+		Content asn1.RawValue
+		Certs   asn1.RawValue   `asn1:"optional,tag:0"`
+		Signers []asn1.RawValue `asn1:"set"`
+	}
+	_, err := asn1.Unmarshal(outer.C.Bytes, &inner)
+	check(err)
+	var si struct {
+		Version int
+		IAS     asn1.RawValue
+		Digest  asn1.RawValue
+		Auth    asn1.RawValue
+		Enc     asn1.RawValue
+		Sig     []byte
+	}
+	_, err = asn1.Unmarshal(inner.Signers[0].FullBytes, &si)
+	check(err)
+
+	imprint := sha256.Sum256(si.Sig)
+	stamp := time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC)
+	tst, _ := asn1.Marshal(struct {
+		V  int
+		P  asn1.ObjectIdentifier
+		MI digestInfo
+		S  *big.Int
+		GT time.Time `asn1:"generalized"`
+	}{1, asn1.ObjectIdentifier{1, 2, 3}, digestInfo{algo{sha256OID, nullParam}, imprint[:]}, big.NewInt(99), stamp})
+	tstOct, _ := asn1.Marshal(tst)
+	token := sign(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}, tstOct, tst,
+		[]attr{{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}, []asn1.RawValue{raw(stamp)}}}, tsa, tsaKey, []*x509.Certificate{tsa}, nil)
+
+	// sign again with the same signature (PKCS1v15 is deterministic) plus the timestamp
+	final := sign(spcOID, spc, spcv.Bytes, nil, leaf, leafKey, []*x509.Certificate{leaf, inter}, []attr{{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}, []asn1.RawValue{{FullBytes: token}}}})
+	for len(final)%8 != 0 {
+		final = append(final, 0)
+	}
+	le.PutUint32(cab[48:], uint32(len(final)))
+	cab = append(cab, final...)
+	return cab, pemCert(root), pemCert(untrusted)
+}
+
+// pemCert returns the PEM of c
+func pemCert(c *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+}
@@ -0,0 +1,227 @@
+/**************************************************************************************************/
+// File: writer.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Cabinet writer and the data blocks of stored, MSZIP and LZX folders
+/**************************************************************************************************/
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const (
+	compNone  = 0
+	compMszip = 1
+	compLzx   = 3 // the window size in bits goes in the high byte
+
+	blockSize   = 32768
+	headerSize  = 36
+	flagReserve = 0x0004
+	setId       = 0x1234
+	fileDate    = 0x4d49 // 2018-10-09
+	fileTime    = 0x6000 // 12:00
+	fileAttribs = 0x20   // archive
+	cabVersion  = 0x0103
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// dataBlock: Compressed data of a block and its uncompressed size
+type dataBlock struct {
+	comp   []byte
+	uncomp int
+}
+
+// folderSpec: Compression type and blocks of a folder
+type folderSpec struct {
+	comp   uint16
+	blocks []dataBlock
+}
+
+// fileSpec: File of a folder at offset in its uncompressed data
+type fileSpec struct {
+	name   string
+	folder int
+	offset int
+	size   int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// checksum returns the CFDATA checksum of data starting from seed
+func checksum(data []byte, seed uint32) uint32 {
+	c := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		c ^= binary.LittleEndian.Uint32(data[i*4:])
+	}
+	var ul uint32
+	rest := data[n*4:]
+	switch len(rest) {
+	case 3:
+		ul |= uint32(rest[0]) << 16
+		rest = rest[1:]
+		fallthrough
+	case 2:
+		ul |= uint32(rest[0]) << 8
+		rest = rest[1:]
+		fallthrough
+	case 1:
+		ul |= uint32(rest[0])
+	}
+	return c ^ ul
+}
+
+// writeCab returns a single cabinet of folders and files, with reserve as the per-cabinet
+// reserved area when it is not nil
+func writeCab(folders []folderSpec, files []fileSpec, reserve []byte) []byte {
+	le := binary.LittleEndian
+	fileEntries := 0
+	for _, f := range files {
+		fileEntries += 16 + len(f.name) + 1
+	}
+	size := headerSize
+	if reserve != nil {
+		size += 4 + len(reserve)
+	}
+	filesOffset := size + 8*len(folders)
+	offset := filesOffset + fileEntries
+
+	var data bytes.Buffer
+	var folderOffsets []int
+	for _, folder := range folders {
+		folderOffsets = append(folderOffsets, offset)
+		for _, b := range folder.blocks {
+			var h [8]byte
+			le.PutUint16(h[4:], uint16(len(b.comp)))
+			le.PutUint16(h[6:], uint16(b.uncomp))
+			le.PutUint32(h[0:], checksum(h[4:8], checksum(b.comp, 0)))
+			data.Write(h[:])
+			data.Write(b.comp)
+			offset += 8 + len(b.comp)
+		}
+	}
+
+	var cab bytes.Buffer
+	flags := uint16(0)
+	if reserve != nil {
+		flags |= flagReserve
+	}
+	cab.WriteString("MSCF")
+	binary.Write(&cab, le, []uint32{0, uint32(offset), 0, uint32(filesOffset), 0})
+	binary.Write(&cab, le, []uint16{cabVersion, uint16(len(folders)), uint16(len(files)), flags, setId, 0})
+	if reserve != nil {
+		binary.Write(&cab, le, uint16(len(reserve)))
+		cab.Write([]byte{0, 0})
+		cab.Write(reserve)
+	}
+	for i, folder := range folders {
+		binary.Write(&cab, le, uint32(folderOffsets[i]))
+		binary.Write(&cab, le, []uint16{uint16(len(folder.blocks)), folder.comp})
+	}
+	for _, f := range files {
+		binary.Write(&cab, le, []uint32{uint32(f.size), uint32(f.offset)})
+		binary.Write(&cab, le, []uint16{uint16(f.folder), fileDate, fileTime, fileAttribs})
+		cab.WriteString(f.name)
+		cab.WriteByte(0)
+	}
+	if cab.Len() != filesOffset+fileEntries {
+		panic(fmt.Sprintf("Headers of %d bytes, expected %d", cab.Len(), filesOffset+fileEntries))
+	}
+	cab.Write(data.Bytes())
+	return cab.Bytes()
+}
+
+// storedBlocks splits d into uncompressed blocks
+func storedBlocks(d []byte) []dataBlock {
+	var blocks []dataBlock
+	for i := 0; i < len(d); i += blockSize {
+		end := i + blockSize
+		if end > len(d) {
+			end = len(d)
+		}
+		blocks = append(blocks, dataBlock{d[i:end], end - i})
+	}
+	return blocks
+}
+
+// mszipBlocks compresses d as MSZIP blocks, each deflated with the previous 32K as dictionary
+func mszipBlocks(d []byte) []dataBlock {
+	var blocks []dataBlock
+	var dict []byte
+	for i := 0; i < len(d); i += blockSize {
+		end := i + blockSize
+		if end > len(d) {
+			end = len(d)
+		}
+		var b bytes.Buffer
+		b.WriteString("CK")
+		w, err := flate.NewWriterDict(&b, 6, dict)
+		check(err)
+		w.Write(d[i:end])
+		check(w.Close())
+		blocks = append(blocks, dataBlock{b.Bytes(), end - i})
+		dict = d[:end]
+		if len(dict) > blockSize {
+			dict = dict[len(dict)-blockSize:]
+		}
+	}
+	return blocks
+}
+
+// lzxBlocks compresses d as blocks with a window of windowBits, split into one data block per
+// frame. e8size is the E8 translation size, 0 for none.
+func lzxBlocks(d []byte, windowBits uint, blocks []blockSpec, e8size int32) []dataBlock {
+	in := d
+	if e8size != 0 {
+		in = e8Translate(d, e8size)
+	}
+	e := &lzxEncoder{windowBits: windowBits}
+	comp := e.encode(in, blocks, e8size)
+
+	var data []dataBlock
+	prev := 0
+	for i, end := range e.frameEnds {
+		uncomp := lzxFrameSize
+		if (i+1)*lzxFrameSize > len(d) {
+			uncomp = len(d) - i*lzxFrameSize
+		}
+		data = append(data, dataBlock{comp[prev:end], uncomp})
+		prev = end
+	}
+	if prev != len(comp) {
+		panic(fmt.Sprintf("%d compressed bytes after the last frame", len(comp)-prev))
+	}
+	return data
+}
+
+// textData returns n bytes of XML-like words and random bytes, including E8 calls, from seed
+func textData(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	words := []string{"update", "security", "windows", "<Update UpdateId=\"", "KB4025339", "\"/>\n", "x64", "cumulative", "\xe8\x10\x00\x00\x00", "\xe8\xf0\xff\xff\xff"}
+	var b bytes.Buffer
+	for b.Len() < n {
+		if r.Intn(10) == 0 {
+			b.WriteByte(byte(r.Intn(256)))
+		} else {
+			b.WriteString(words[r.Intn(len(words))])
+		}
+	}
+	return b.Bytes()[:n]
+}
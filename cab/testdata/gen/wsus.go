@@ -0,0 +1,137 @@
+/**************************************************************************************************/
+// File: wsus.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Catalog of wsusscn2.cab: package.xml and the update fragments of package2.cab
+/**************************************************************************************************/
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// wsusUpdate: Update of the catalog. rev is its revision id, core extra XML of its core fragment.
+type wsusUpdate struct {
+	uid, rev, date, typ, catType, title, kb, sev string
+	cats                                         [][2]string
+	supersededBy, bundledBy                      []string
+	core                                         string
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// wsusUpdates returns the categories, detectoid and updates of the catalog. KB4022725 (rev 200)
+// is superseded by KB4025342 (rev 201), which bundles rev 202 and is superseded by KB4034674.
+func wsusUpdates() []wsusUpdate {
+	return []wsusUpdate{
+		{uid: "56309036-4c77-4dd9-951a-99ee9c246a94", rev: "101", typ: "Category", catType: "Company", title: "Microsoft"},
+		{uid: "6964aab4-c5b5-43bd-a17d-ffb4346a8e1d", rev: "102", typ: "Category", catType: "ProductFamily", title: "Windows", cats: [][2]string{{"Company", "56309036-4c77-4dd9-951a-99ee9c246a94"}}},
+		{uid: "a3c2375d-0c8a-42f9-bce0-28333e198407", rev: "100", typ: "Category", catType: "Product", title: "Windows 10", cats: [][2]string{{"Company", "56309036-4c77-4dd9-951a-99ee9c246a94"}, {"ProductFamily", "6964aab4-c5b5-43bd-a17d-ffb4346a8e1d"}}},
+		{uid: "569e8e8f-c6cd-42c8-92a3-efbb20a0f6f5", rev: "105", typ: "Category", catType: "Product", title: "Windows Server 2016", cats: [][2]string{{"ProductFamily", "6964aab4-c5b5-43bd-a17d-ffb4346a8e1d"}}},
+		{uid: "0fa1201d-4330-4fa8-8ae9-b877473b6441", rev: "103", typ: "Category", catType: "UpdateClassification", title: "Security Updates"},
+		{uid: "59653007-e2e9-4f71-8525-2ff588527978", rev: "104", typ: "Detectoid", title: "x64-based systems", core: `<ApplicabilityRules><IsInstalled><b.Processor Architecture="9"/></IsInstalled></ApplicabilityRules>`},
+		{uid: "8b4e84f6-595f-41ed-854f-4ca886e317a5", rev: "200", date: "2017-06-13T17:00:00Z", typ: "Software", title: "2017-06 Cumulative Update for Windows 10 Version 1703 for x64-based Systems (KB4022725)", kb: "4022725", sev: "Critical",
+			cats: [][2]string{{"Product", "a3c2375d-0c8a-42f9-bce0-28333e198407"}, {"Product", "569e8e8f-c6cd-42c8-92a3-efbb20a0f6f5"}, {"UpdateClassification", "0fa1201d-4330-4fa8-8ae9-b877473b6441"}}, supersededBy: []string{"201"}},
+		{uid: "e2b3f4c1-1111-4a2b-9c3d-000000000201", rev: "201", date: "2017-07-11T17:00:00Z", typ: "Software", title: "2017-07 Cumulative Update for Windows 10 Version 1703 for x64-based Systems (KB4025342)", kb: "4025342", sev: "Critical",
+			cats: [][2]string{{"Product", "a3c2375d-0c8a-42f9-bce0-28333e198407"}, {"UpdateClassification", "0fa1201d-4330-4fa8-8ae9-b877473b6441"}}, supersededBy: []string{"203"},
+			core: `<Relationships><Prerequisites><UpdateIdentity UpdateID="59653007-e2e9-4f71-8525-2ff588527978" /><AtLeastOne IsCategory="true"><UpdateIdentity UpdateID="a3c2375d-0c8a-42f9-bce0-28333e198407" /><UpdateIdentity UpdateID="569e8e8f-c6cd-42c8-92a3-efbb20a0f6f5" /></AtLeastOne></Prerequisites><BundledUpdates><UpdateIdentity UpdateID="e2b3f4c1-1111-4a2b-9c3d-000000000202" RevisionNumber="201" /></BundledUpdates></Relationships>` +
+				`<ApplicabilityRules><IsInstalled><lar:And xmlns:lar="http://schemas.microsoft.com/msus/2002/12/LogicalApplicabilityRules"><b.FileVersion Csidl="37" Path="ntoskrnl.exe" Comparison="GreaterThanOrEqualTo" Version="10.0.15063.483" /><lar:Not><bar:RegKeyExists xmlns:bar="http://schemas.microsoft.com/msus/2002/12/BaseApplicabilityRules" Key="HKEY_LOCAL_MACHINE" Subkey="SOFTWARE\Test" RegType32="true" /></lar:Not></lar:And></IsInstalled><IsInstallable><lar:Or><b.WindowsVersion Comparison="EqualTo" MajorVersion="10" MinorVersion="0" BuildNumber="15063" /><b.WmiQuery Namespace="root\cimv2" WqlQuery="SELECT * FROM Win32_OperatingSystem" /><lar:True /></lar:Or></IsInstallable><Metadata><Foo /></Metadata></ApplicabilityRules>`},
+		{uid: "e2b3f4c1-1111-4a2b-9c3d-000000000202", rev: "202", date: "2017-07-11T17:00:00Z", typ: "Software", title: "Cumulative Update for Windows 10 Version 1703 (KB4025342) payload", kb: "4025342",
+			cats: [][2]string{{"Product", "a3c2375d-0c8a-42f9-bce0-28333e198407"}}, bundledBy: []string{"201"}},
+		{uid: "e2b3f4c1-1111-4a2b-9c3d-000000000203", rev: "203", date: "2017-08-08T17:00:00Z", typ: "Software", title: "2017-08 Cumulative Update for Windows 10 Version 1703 for x64-based Systems (KB4034674)", kb: "4034674", sev: "Critical",
+			cats: [][2]string{{"Product", "a3c2375d-0c8a-42f9-bce0-28333e198407"}, {"UpdateClassification", "0fa1201d-4330-4fa8-8ae9-b877473b6441"}}},
+	}
+}
+
+// wsusCab returns wsusscn2.cab: an MSZIP folder of package.cab, holding package.xml, of
+// package2.cab, holding the fragments, and of index.xml
+func wsusCab() []byte {
+	var pkg bytes.Buffer
+	pkg.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	pkg.WriteString(`<OfflineSyncPackage xmlns="http://schemas.microsoft.com/msus/2004/02/OfflineSync" MinimumClientVersion="5.8.0.2678" ProtocolVersion="1.0" PackageId="ca6c5e27-0000-0000-0000-000000000000" SourceId="cc56dd6f-0000-0000-0000-000000000000" CreationDate="2018-10-09T03:12:56Z" PackageVersion="1.1"><Updates>`)
+	frags := map[string][]byte{}
+	var names []string
+	addFrag := func(name, content string) {
+		names = append(names, name)
+		frags[name] = []byte(content)
+	}
+	for _, u := range wsusUpdates() {
+		date := u.date
+		if date == "" {
+			date = "2005-01-01T00:00:00Z"
+		}
+		fmt.Fprintf(&pkg, `<Update CreationDate="%s" DefaultLanguage="en" UpdateId="%s" RevisionNumber="201" RevisionId="%s" IsLeaf="true">`, date, u.uid, u.rev)
+		if len(u.cats) > 0 {
+			pkg.WriteString("<Categories>")
+			for _, c := range u.cats {
+				fmt.Fprintf(&pkg, `<Category Type="%s" Id="%s" />`, c[0], c[1])
+			}
+			pkg.WriteString("</Categories>")
+		}
+		if u.typ == "Software" {
+			pkg.WriteString(`<Prerequisites><UpdateId Id="59653007-e2e9-4f71-8525-2ff588527978" /></Prerequisites>`)
+		}
+		for _, rel := range []struct {
+			name string
+			ids  []string
+		}{{"SupersededBy", u.supersededBy}, {"BundledBy", u.bundledBy}} {
+			if len(rel.ids) == 0 {
+				continue
+			}
+			pkg.WriteString("<" + rel.name + ">")
+			for _, id := range rel.ids {
+				fmt.Fprintf(&pkg, `<Revision Id="%s" />`, id)
+			}
+			pkg.WriteString("</" + rel.name + ">")
+		}
+		pkg.WriteString("</Update>")
+
+		core := fmt.Sprintf(`<UpdateIdentity UpdateID="%s" RevisionNumber="201" /><Properties UpdateType="%s" PublicationState="Published" IsPublic="true" IsBeta="false" CreationDate="%s" />`, u.uid, u.typ, date)
+		if u.catType != "" {
+			core += fmt.Sprintf(`<HandlerSpecificData type="cat:Category"><CategoryInformation CategoryType="%s" ProhibitsSubcategories="false" /></HandlerSpecificData>`, u.catType)
+		}
+		if u.typ == "Software" && u.core == "" {
+			core += `<Relationships><Prerequisites><UpdateIdentity UpdateID="59653007-e2e9-4f71-8525-2ff588527978" /></Prerequisites></Relationships>`
+			core += `<ApplicabilityRules><IsInstalled><b.RegSz Key="HKEY_LOCAL_MACHINE" Subkey="SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion" Value="CurrentBuild" Comparison="EqualTo" Data="15063" /></IsInstalled></ApplicabilityRules>`
+		}
+		core += u.core
+		addFrag("c\\"+u.rev, core)
+		if u.typ == "Software" {
+			addFrag("x\\"+u.rev, fmt.Sprintf(`<ExtendedProperties DefaultPropertiesLanguage="en" Handler="http://schemas.microsoft.com/msus/2002/12/UpdateHandlers/WindowsPatch" MsrcSeverity="%s"><InstallationBehavior RebootBehavior="CanRequestReboot" /><UninstallationBehavior RebootBehavior="CanRequestReboot" /></ExtendedProperties><InstallableItem ID="a1b2"><ApplicabilityRules><IsInstalled><b.RegDword Key="HKEY_LOCAL_MACHINE" Subkey="SOFTWARE\x" Value="Installed" Comparison="EqualTo" Data="1" /></IsInstalled><IsInstallable><lar:True /></IsInstallable></ApplicabilityRules><ApplicabilityMetadata /></InstallableItem><KBArticleID>%s</KBArticleID><SupportUrl>http://support.microsoft.com</SupportUrl>`, u.sev, u.kb))
+		}
+		addFrag("l\\en\\"+u.rev, fmt.Sprintf(`<LocalizedProperties><Language>en</Language><Title>%s</Title><Description>Install this update &amp; restart.</Description><MoreInfoUrl>https://support.microsoft.com/help/%s</MoreInfoUrl></LocalizedProperties>`, u.title, u.kb))
+		addFrag("l\\de\\"+u.rev, `<LocalizedProperties><Language>de</Language><Title>Deutsch</Title></LocalizedProperties>`)
+	}
+	pkg.WriteString("</Updates></OfflineSyncPackage>")
+
+	// package.cab: LZX with package.xml
+	p := pkg.Bytes()
+	pkgCab := writeCab([]folderSpec{{compLzx | 16<<8, lzxBlocks(p, 16, []blockSpec{{typ: lzxBlockVerbatim, length: len(p)}}, 0)}}, []fileSpec{{"package.xml", 0, 0, len(p)}}, nil)
+
+	// package2.cab: LZX with fragments
+	var all []byte
+	var files []fileSpec
+	for _, n := range names {
+		files = append(files, fileSpec{n, 0, len(all), len(frags[n])})
+		all = append(all, frags[n]...)
+	}
+	frag := writeCab([]folderSpec{{compLzx | 16<<8, lzxBlocks(all, 16, []blockSpec{{typ: lzxBlockAligned, length: len(all)}}, 0)}}, files, nil)
+
+	index := []byte(`<?xml version="1.0"?><Cab><CABLIST><CAB NAME="package2.cab" RANGESTART="100" /></CABLIST></Cab>`)
+	outer := append(append(append([]byte(nil), pkgCab...), frag...), index...)
+	outerCab := writeCab([]folderSpec{{compMszip, mszipBlocks(outer)}}, []fileSpec{
+		{"package.cab", 0, 0, len(pkgCab)},
+		{"package2.cab", 0, len(pkgCab), len(frag)},
+		{"index.xml", 0, len(pkgCab) + len(frag), len(index)},
+	}, nil)
+	return outerCab
+}
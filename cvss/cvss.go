@@ -0,0 +1,539 @@
+/**************************************************************************************************/
+// File: cvss.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: CVSS v3 vectors and scores
+/**************************************************************************************************/
+
+// Package cvss parses CVSS v3.0 and v3.1 vector strings and computes their scores, to check the
+// scores of the API, to filter CVEs by metric and to score them for an environment:
+//
+//	v, err := cvss.Parse("CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C")
+//	fmt.Println(v.BaseScore(), v.TemporalScore()) // 9.8 8.8
+package cvss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NotDefined: Value of temporal and environmental metrics missing from a vector
+const NotDefined = "X"
+
+// Metrics: Base and temporal metrics in vector order, with their values
+var Metrics = []Metric{
+	{"AV", "attack_vector", true, []Value{{"N", "network"}, {"A", "adjacent_network"}, {"L", "local"}, {"P", "physical"}}},
+	{"AC", "attack_complexity", true, []Value{{"L", "low"}, {"H", "high"}}},
+	{"PR", "privileges_required", true, []Value{{"N", "none"}, {"L", "low"}, {"H", "high"}}},
+	{"UI", "user_interaction", true, []Value{{"N", "none"}, {"R", "required"}}},
+	{"S", "scope", true, []Value{{"U", "unchanged"}, {"C", "changed"}}},
+	{"C", "confidentiality", true, []Value{{"H", "high"}, {"L", "low"}, {"N", "none"}}},
+	{"I", "integrity", true, []Value{{"H", "high"}, {"L", "low"}, {"N", "none"}}},
+	{"A", "availability", true, []Value{{"H", "high"}, {"L", "low"}, {"N", "none"}}},
+	{"E", "exploit_code_maturity", false, []Value{{"X", "not_defined"}, {"H", "high"}, {"F", "functional"}, {"P", "proof_of_concept"}, {"U", "unproven"}}},
+	{"RL", "remediation_level", false, []Value{{"X", "not_defined"}, {"U", "unavailable"}, {"W", "workaround"}, {"T", "temporary_fix"}, {"O", "official_fix"}}},
+	{"RC", "report_confidence", false, []Value{{"X", "not_defined"}, {"C", "confirmed"}, {"R", "reasonable"}, {"U", "unknown"}}},
+}
+
+// requirementValues: Values of the security requirements
+var requirementValues = []Value{{"X", "not_defined"}, {"H", "high"}, {"M", "medium"}, {"L", "low"}}
+
+// EnvironmentalMetrics: Security requirements and modified base metrics in vector order. A
+// modified metric that is not defined takes the value of its base metric.
+var EnvironmentalMetrics = []Metric{
+	{"CR", "confidentiality_requirement", false, requirementValues},
+	{"IR", "integrity_requirement", false, requirementValues},
+	{"AR", "availability_requirement", false, requirementValues},
+	{"MAV", "modified_attack_vector", false, modifiedValues(Metrics[0])},
+	{"MAC", "modified_attack_complexity", false, modifiedValues(Metrics[1])},
+	{"MPR", "modified_privileges_required", false, modifiedValues(Metrics[2])},
+	{"MUI", "modified_user_interaction", false, modifiedValues(Metrics[3])},
+	{"MS", "modified_scope", false, modifiedValues(Metrics[4])},
+	{"MC", "modified_confidentiality", false, modifiedValues(Metrics[5])},
+	{"MI", "modified_integrity", false, modifiedValues(Metrics[6])},
+	{"MA", "modified_availability", false, modifiedValues(Metrics[7])},
+}
+
+// weights: Numeric values of the metrics of the CVSS v3.1 specification, section 7.4.
+// Privileges required depends on the scope and is in privilegeWeights.
+var weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	"E":  {"X": 1, "H": 1, "F": 0.97, "P": 0.94, "U": 0.91},
+	"RL": {"X": 1, "U": 1, "W": 0.97, "T": 0.96, "O": 0.95},
+	"RC": {"X": 1, "C": 1, "R": 0.96, "U": 0.92},
+	"CR": {"X": 1, "H": 1.5, "M": 1, "L": 0.5},
+	"IR": {"X": 1, "H": 1.5, "M": 1, "L": 0.5},
+	"AR": {"X": 1, "H": 1.5, "M": 1, "L": 0.5},
+}
+
+// privilegeWeights: Weight of privileges required by scope
+var privilegeWeights = map[string]map[string]float64{
+	"U": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"C": {"N": 0.85, "L": 0.68, "H": 0.5},
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Vector: Parsed CVSS v3 vector. Metrics hold the abbreviated value of the vector string
+// (Ex., AV "N" for network). Temporal and environmental metrics missing from the string are
+// NotDefined.
+type Vector struct {
+	Version string // "3.0" or "3.1"
+
+	AV string // attack vector
+	AC string // attack complexity
+	PR string // privileges required
+	UI string // user interaction
+	S  string // scope
+	C  string // confidentiality impact
+	I  string // integrity impact
+	A  string // availability impact
+
+	E  string // exploit code maturity
+	RL string // remediation level
+	RC string // report confidence
+
+	CR  string // confidentiality requirement
+	IR  string // integrity requirement
+	AR  string // availability requirement
+	MAV string // modified attack vector
+	MAC string // modified attack complexity
+	MPR string // modified privileges required
+	MUI string // modified user interaction
+	MS  string // modified scope
+	MC  string // modified confidentiality impact
+	MI  string // modified integrity impact
+	MA  string // modified availability impact
+}
+
+// Metric: One metric of a vector
+type Metric struct {
+	Abbr     string // Ex., "AV"
+	Name     string // Ex., "attack_vector"
+	Required bool   // base metrics must be in every vector
+	Values   []Value
+}
+
+// Value: One value of a metric
+type Value struct {
+	Abbr string // Ex., "N"
+	Name string // Ex., "network"
+}
+
+// Environment: Environmental metrics of an organization, by abbreviation (Ex., "CR" "H")
+type Environment map[string]string
+
+// Profile: Environments by product. The environment of a product is Default overridden by the
+// metrics set for the product.
+type Profile struct {
+	Default  Environment            `json:"default"`
+	Products map[string]Environment `json:"products"`
+}
+
+// Filter: Accepted values of metrics, by metric abbreviation. A vector matches if every metric
+// of the filter has one of the accepted values.
+type Filter map[string][]string
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Parse reads a vector string such as "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H". The
+// CVSS:3.x prefix may be missing, in which case the version is 3.0.
+func Parse(s string) (Vector, error) {
+	v := Vector{Version: "3.0"}
+	for _, m := range append(Metrics[8:], EnvironmentalMetrics...) {
+		*v.field(m.Abbr) = NotDefined
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return v, errors.New("Empty CVSS vector")
+	}
+
+	seen := make(map[string]bool)
+	for i, part := range strings.Split(s, "/") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			return v, fmt.Errorf("Invalid CVSS vector %s: %s is not metric:value", s, part)
+		}
+		name, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		if name == "CVSS" {
+			if i != 0 || (value != "3.0" && value != "3.1") {
+				return v, fmt.Errorf("Invalid CVSS vector %s: unsupported version %s", s, kv[1])
+			}
+			v.Version = value
+			continue
+		}
+		m, ok := metric(name)
+		if !ok {
+			// unknown metrics do not change the scores
+			continue
+		}
+		if seen[name] {
+			return v, fmt.Errorf("Invalid CVSS vector %s: %s is repeated", s, name)
+		}
+		seen[name] = true
+		val, ok := m.value(value)
+		if !ok {
+			return v, fmt.Errorf("Invalid CVSS vector %s: %s is not a value of %s", s, kv[1], name)
+		}
+		*v.field(name) = val.Abbr
+	}
+
+	for _, m := range Metrics {
+		if m.Required && !seen[m.Abbr] {
+			return v, fmt.Errorf("Invalid CVSS vector %s: %s is missing", s, m.Abbr)
+		}
+	}
+	return v, nil
+}
+
+// metric returns the metric with abbreviation abbr
+func metric(abbr string) (Metric, bool) {
+	for _, m := range append(Metrics, EnvironmentalMetrics...) {
+		if m.Abbr == abbr {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
+
+// value returns the value of m with abbreviation or name s, ignoring case, "-" and spaces
+func (m Metric) value(s string) (Value, bool) {
+	s = strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToLower(strings.TrimSpace(s)))
+	for _, v := range m.Values {
+		if s == strings.ToLower(v.Abbr) || s == v.Name || (v.Name == "adjacent_network" && s == "adjacent") {
+			return v, true
+		}
+	}
+	return Value{}, false
+}
+
+// field returns the field of v holding metric abbr, nil if there is none
+func (v *Vector) field(abbr string) *string {
+	switch abbr {
+	case "AV":
+		return &v.AV
+	case "AC":
+		return &v.AC
+	case "PR":
+		return &v.PR
+	case "UI":
+		return &v.UI
+	case "S":
+		return &v.S
+	case "C":
+		return &v.C
+	case "I":
+		return &v.I
+	case "A":
+		return &v.A
+	case "E":
+		return &v.E
+	case "RL":
+		return &v.RL
+	case "RC":
+		return &v.RC
+	case "CR":
+		return &v.CR
+	case "IR":
+		return &v.IR
+	case "AR":
+		return &v.AR
+	case "MAV":
+		return &v.MAV
+	case "MAC":
+		return &v.MAC
+	case "MPR":
+		return &v.MPR
+	case "MUI":
+		return &v.MUI
+	case "MS":
+		return &v.MS
+	case "MC":
+		return &v.MC
+	case "MI":
+		return &v.MI
+	case "MA":
+		return &v.MA
+	}
+	return nil
+}
+
+// Get returns the abbreviated value of metric abbr (Ex., Get("AV") is "N"), empty if unknown
+func (v Vector) Get(abbr string) string {
+	if f := v.field(strings.ToUpper(abbr)); f != nil {
+		return *f
+	}
+	return ""
+}
+
+// String returns the vector string, leaving out metrics that are not defined
+func (v Vector) String() string {
+	parts := []string{"CVSS:" + v.Version}
+	for _, m := range append(Metrics, EnvironmentalMetrics...) {
+		if value := v.Get(m.Abbr); value != "" && value != NotDefined {
+			parts = append(parts, m.Abbr+":"+value)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// BaseScore computes the base score
+func (v Vector) BaseScore() float64 {
+	iss := 1 - (1-weights["C"][v.C])*(1-weights["I"][v.I])*(1-weights["A"][v.A])
+	var impact float64
+	if v.S == "C" {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0
+	}
+	exploitability := 8.22 * weights["AV"][v.AV] * weights["AC"][v.AC] * privilegeWeights[scope(v.S)][v.PR] * weights["UI"][v.UI]
+	if v.S == "C" {
+		return v.roundup(math.Min(1.08*(impact+exploitability), 10))
+	}
+	return v.roundup(math.Min(impact+exploitability, 10))
+}
+
+// TemporalScore computes the temporal score, the base score if no temporal metric is defined
+func (v Vector) TemporalScore() float64 {
+	return v.roundup(v.BaseScore() * weights["E"][v.value("E")] * weights["RL"][v.value("RL")] * weights["RC"][v.value("RC")])
+}
+
+// EnvironmentalScore computes the environmental score of the CVSS v3.1 specification, section
+// 7.3, with the modified impact formula of CVSS v3.0 for v3.0 vectors. It is the temporal score
+// if no environmental metric is defined.
+func (v Vector) EnvironmentalScore() float64 {
+	ms := v.modified("S")
+	miss := math.Min(1-(1-weights["CR"][v.value("CR")]*weights["C"][v.modified("C")])*
+		(1-weights["IR"][v.value("IR")]*weights["I"][v.modified("I")])*
+		(1-weights["AR"][v.value("AR")]*weights["A"][v.modified("A")]), 0.915)
+	var impact float64
+	switch {
+	case ms != "C":
+		impact = 6.42 * miss
+	case v.Version == "3.0":
+		impact = 7.52*(miss-0.029) - 3.25*math.Pow(miss-0.02, 15)
+	default:
+		impact = 7.52*(miss-0.029) - 3.25*math.Pow(miss*0.9731-0.02, 13)
+	}
+	if impact <= 0 {
+		return 0
+	}
+	exploitability := 8.22 * weights["AV"][v.modified("AV")] * weights["AC"][v.modified("AC")] * privilegeWeights[scope(ms)][v.modified("PR")] * weights["UI"][v.modified("UI")]
+	score := impact + exploitability
+	if ms == "C" {
+		score *= 1.08
+	}
+	return v.roundup(v.roundup(math.Min(score, 10)) * weights["E"][v.value("E")] * weights["RL"][v.value("RL")] * weights["RC"][v.value("RC")])
+}
+
+// Environment returns v with the metrics of e, replacing those of the vector string
+func (v Vector) Environment(e Environment) Vector {
+	for abbr, value := range e {
+		if f := v.field(abbr); f != nil {
+			*f = value
+		}
+	}
+	return v
+}
+
+// value returns the value of a temporal or environmental metric, NotDefined if empty
+func (v Vector) value(abbr string) string {
+	if value := v.Get(abbr); value != "" {
+		return value
+	}
+	return NotDefined
+}
+
+// modified returns the value of the modified base metric of abbr, the base metric if not defined
+func (v Vector) modified(abbr string) string {
+	if value := v.value("M" + abbr); value != NotDefined {
+		return value
+	}
+	return v.Get(abbr)
+}
+
+// scope returns "C" for a changed scope s, "U" otherwise
+func scope(s string) string {
+	if s == "C" {
+		return "C"
+	}
+	return "U"
+}
+
+// modifiedValues returns the values of base metric m with NotDefined first
+func modifiedValues(m Metric) []Value {
+	return append([]Value{{NotDefined, "not_defined"}}, m.Values...)
+}
+
+// roundup rounds x up to one decimal. CVSS v3.1 avoids floating point errors, v3.0 does not.
+func (v Vector) roundup(x float64) float64 {
+	if v.Version == "3.0" {
+		return math.Ceil(x*10) / 10
+	}
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return (math.Floor(float64(i)/10000) + 1) / 10
+}
+
+// FormatScore returns a score as the API prints it (Ex., "9.8" or "10.0")
+func FormatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', 1, 64)
+}
+
+// Higher reports if the score string a is higher than b, or b is not a score. An empty or
+// invalid a is never higher, so sorting by Higher puts unscored CVEs last.
+func Higher(a, b string) bool {
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	return err != nil || x > y
+}
+
+// ReadProfile reads a JSON profile of environmental metrics. Metrics and values may be given by
+// name or abbreviation:
+//
+//	{
+//	  "default": {"confidentiality_requirement": "medium"},
+//	  "products": {"Windows Server 2016": {"CR": "H", "modified_attack_vector": "adjacent_network"}}
+//	}
+func ReadProfile(r io.Reader) (Profile, error) {
+	var raw Profile
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return raw, fmt.Errorf("Invalid environmental profile: %s", err)
+	}
+	p := Profile{Products: make(map[string]Environment)}
+	var err error
+	if p.Default, err = NewEnvironment(raw.Default); err != nil {
+		return p, err
+	}
+	for product, e := range raw.Products {
+		if p.Products[strings.ToLower(product)], err = NewEnvironment(e); err != nil {
+			return p, fmt.Errorf("%s: %s", product, err)
+		}
+	}
+	return p, nil
+}
+
+// NewEnvironment returns the environment of metrics by name or abbreviation, by abbreviation
+func NewEnvironment(metrics map[string]string) (Environment, error) {
+	e := make(Environment)
+	for name, s := range metrics {
+		m, ok := environmentalMetric(name)
+		if !ok {
+			return e, fmt.Errorf("Unknown CVSS environmental metric %s", name)
+		}
+		v, ok := m.value(s)
+		if !ok {
+			return e, fmt.Errorf("Unknown %s %s. Expected: %s", m.Name, s, strings.Join(m.names(), ", "))
+		}
+		e[m.Abbr] = v.Abbr
+	}
+	return e, nil
+}
+
+// environmentalMetric returns the environmental metric with name or abbreviation s
+func environmentalMetric(s string) (Metric, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, m := range EnvironmentalMetrics {
+		if s == m.Name || s == strings.ToLower(m.Abbr) {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
+
+// Environment returns the environment of product, matched ignoring case
+func (p Profile) Environment(product string) Environment {
+	e := make(Environment)
+	for abbr, value := range p.Default {
+		e[abbr] = value
+	}
+	for abbr, value := range p.Products[strings.ToLower(strings.TrimSpace(product))] {
+		e[abbr] = value
+	}
+	return e
+}
+
+// names returns the names of the values of m
+func (m Metric) names() []string {
+	names := make([]string, 0, len(m.Values))
+	for _, v := range m.Values {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// NewFilter returns an empty filter
+func NewFilter() Filter {
+	return make(Filter)
+}
+
+// Add accepts the values of metric for the filter. metric and values may be given by name or
+// abbreviation (Ex., "attack_vector" "network" or "AV" "N").
+func (f Filter) Add(metric string, values ...string) error {
+	m, ok := metricByName(metric)
+	if !ok {
+		return fmt.Errorf("Unknown CVSS metric %s", metric)
+	}
+	for _, s := range values {
+		v, ok := m.value(s)
+		if !ok {
+			return fmt.Errorf("Unknown %s %s. Expected: %s", m.Name, s, strings.Join(m.names(), ", "))
+		}
+		f[m.Abbr] = append(f[m.Abbr], v.Abbr)
+	}
+	return nil
+}
+
+// metricByName returns the metric with name or abbreviation s
+func metricByName(s string) (Metric, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, m := range Metrics {
+		if s == m.Name || s == strings.ToLower(m.Abbr) {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
+
+// Match reports if v has one of the accepted values of every metric of f
+func (f Filter) Match(v Vector) bool {
+	for abbr, values := range f {
+		ok := false
+		for _, value := range values {
+			ok = ok || v.value(abbr) == value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
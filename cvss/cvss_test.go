@@ -0,0 +1,192 @@
+/**************************************************************************************************/
+// File: cvss_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the CVSS v3.0 and v3.1 scores against the FIRST calculators
+/**************************************************************************************************/
+package cvss
+
+import (
+	"strings"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// scoreTests: Vectors with their base, temporal and environmental scores from the FIRST CVSS
+// v3.0 and v3.1 calculators
+var scoreTests = []struct {
+	vector                        string
+	base, temporal, environmental string
+}{
+	// base, unchanged and changed scope
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "9.8", "9.8", "9.8"},
+	{"CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "9.8", "9.8", "9.8"},
+	{"AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "9.8", "9.8", "9.8"},
+	{"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", "7.8", "7.8", "7.8"},
+	{"CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N", "5.9", "5.9", "5.9"},
+	{"CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", "1.6", "1.6", "1.6"},
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", "0.0", "0.0", "0.0"},
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", "10.0", "10.0", "10.0"},
+	{"CVSS:3.0/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", "6.1", "6.1", "6.1"},
+	{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:L/I:L/A:N", "6.4", "6.4", "6.4"},
+
+	// temporal
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:U/RL:O/RC:C", "9.8", "8.5", "8.5"},
+	{"CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C", "9.8", "8.8", "8.8"},
+	{"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H/E:U/RL:O/RC:C", "8.8", "7.7", "7.7"},
+	{"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H/E:U/RL:O/RC:C", "7.8", "6.8", "6.8"},
+
+	// 10.0 * 0.92 is 9.200000000000001: v3.0 rounds it up, v3.1 does not
+	{"CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H/RC:U", "10.0", "9.3", "9.3"},
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H/RC:U", "10.0", "9.2", "9.2"},
+
+	// environmental, the modified impact of a changed scope differs between v3.0 and v3.1
+	{"CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/CR:H/IR:H/AR:H/MS:C", "9.8", "9.8", "10.0"},
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/CR:H/IR:H/AR:H/MS:C", "9.8", "9.8", "10.0"},
+	{"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:N/CR:H/IR:M/MS:C", "8.1", "8.1", "9.9"},
+	{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:N/CR:H/IR:M/MS:C", "8.1", "8.1", "10.0"},
+	{"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:L/A:L/CR:H/IR:H/AR:H/MAV:A/MS:C", "7.6", "7.6", "9.0"},
+	{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:L/A:L/CR:H/IR:H/AR:H/MAV:A/MS:C", "7.6", "7.6", "9.1"},
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H/MS:U", "10.0", "10.0", "9.8"},
+	{"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:C/C:H/I:H/A:H/CR:L/IR:L/AR:L/MPR:H/MS:U", "9.9", "9.9", "5.3"},
+	{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H/MC:N/MI:N/MA:N", "8.8", "8.8", "0.0"},
+	{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:U/RL:O/RC:C/CR:L/IR:L/AR:L/MAV:L", "9.8", "8.5", "5.8"},
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func TestScores(t *testing.T) {
+	for _, test := range scoreTests {
+		v, err := Parse(test.vector)
+		if err != nil {
+			t.Errorf("%s: %s", test.vector, err)
+			continue
+		}
+		base, temporal, environmental := FormatScore(v.BaseScore()), FormatScore(v.TemporalScore()), FormatScore(v.EnvironmentalScore())
+		if base != test.base || temporal != test.temporal || environmental != test.environmental {
+			t.Errorf("%s scores %s/%s/%s, expected %s/%s/%s", test.vector,
+				base, temporal, environmental, test.base, test.temporal, test.environmental)
+		}
+	}
+}
+
+func TestRoundup(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		x       float64
+		score   string
+	}{
+		{"3.1", 4.000000000000001, "4.0"},
+		{"3.0", 4.000000000000001, "4.1"},
+		{"3.1", 4.00001, "4.1"},
+		{"3.1", 4.02, "4.1"},
+		{"3.1", 4.0, "4.0"},
+		{"3.0", 4.0, "4.0"},
+		{"3.1", 9.95, "10.0"},
+	} {
+		v := Vector{Version: test.version}
+		if s := FormatScore(v.roundup(test.x)); s != test.score {
+			t.Errorf("v%s roundup(%v) = %s, expected %s", test.version, test.x, s, test.score)
+		}
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	// a profile by name gives the same scores as the metrics in the vector string
+	p, err := ReadProfile(strings.NewReader(`{
+		"default": {"confidentiality_requirement": "high", "IR": "H", "AR": "high"},
+		"products": {"Windows 10": {"modified_scope": "changed"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Parse("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for product, expected := range map[string]string{"windows 10": "10.0", "Windows Server 2016": "9.8"} {
+		e := v.Environment(p.Environment(product))
+		if s := FormatScore(e.EnvironmentalScore()); s != expected {
+			t.Errorf("%s: environmental score %s, expected %s", product, s, expected)
+		}
+	}
+	if s := v.Environment(p.Environment("Windows 10")).String(); !strings.Contains(s, "/CR:H/IR:H/AR:H/") || !strings.HasSuffix(s, "/MS:C") {
+		t.Errorf("Vector with the environment is %s", s)
+	}
+	if s := FormatScore(v.EnvironmentalScore()); s != "9.8" {
+		t.Errorf("Environment changed the vector, environmental score %s", s)
+	}
+
+	if _, err := NewEnvironment(map[string]string{"modified_scope": "sideways"}); err == nil {
+		t.Errorf("NewEnvironment accepted an unknown value")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"CVSS:2.0/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"AV:N/CVSS:3.1/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/A:L",
+		"CVSS:3.1/AV:Z/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) returned no error", s)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := NewFilter()
+	if err := f.Add("attack_vector", "network", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("S", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("AV", "remote"); err == nil {
+		t.Errorf("Add accepted an unknown value")
+	}
+	for s, expected := range map[string]bool{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": true,
+		"CVSS:3.1/AV:A/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": true,
+		"CVSS:3.1/AV:L/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": false,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": false,
+	} {
+		v, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Match(v) != expected {
+			t.Errorf("Match(%s) = %v, expected %v", s, !expected, expected)
+		}
+	}
+}
+
+func TestHigher(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"9.8", "7.5", true},
+		{"7.5", "9.8", false},
+		{"10.0", "9.8", true},
+		{"9.8", "9.8", false},
+		{"5.0", "", true},
+		{"", "5.0", false},
+		{"", "", false},
+	} {
+		if Higher(test.a, test.b) != test.expected {
+			t.Errorf("Higher(%q, %q) = %v, expected %v", test.a, test.b, !test.expected, test.expected)
+		}
+	}
+}
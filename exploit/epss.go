@@ -0,0 +1,127 @@
+/**************************************************************************************************/
+// File: epss.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: FIRST Exploit Prediction Scoring System scores
+/**************************************************************************************************/
+package exploit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// EPSS: Daily EPSS scores, epss_scores-YYYY-MM-DD.csv(.gz) of https://www.first.org/epss/data_stats
+type EPSS struct {
+	ModelVersion string // from the #model_version comment of the file, if any
+	ScoreDate    string
+
+	scores map[string]Score
+}
+
+// Score: EPSS score of one CVE
+type Score struct {
+	Epss       float64 // probability of exploitation in the next 30 days
+	Percentile float64 // share of CVEs with the same or a lower score
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// ReadEPSS reads EPSS scores as CSV with cve, epss and percentile columns, gzip compressed or
+// not. Lines starting with # are comments, except the model_version and score_date of the first.
+func ReadEPSS(r io.Reader) (*EPSS, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid EPSS file: %s", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	e := &EPSS{scores: make(map[string]Score)}
+	if line, err := br.Peek(1); err == nil && line[0] == '#' {
+		comment, _ := br.ReadString('\n')
+		for _, field := range strings.Split(strings.TrimSpace(strings.TrimPrefix(comment, "#")), ",") {
+			kv := strings.SplitN(field, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "model_version":
+				e.ModelVersion = kv[1]
+			case "score_date":
+				e.ScoreDate = kv[1]
+			}
+		}
+	}
+
+	cr := csv.NewReader(br)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid EPSS file: %s", err)
+	}
+	cveCol, epssCol, percentileCol := -1, -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "cve":
+			cveCol = i
+		case "epss":
+			epssCol = i
+		case "percentile":
+			percentileCol = i
+		}
+	}
+	if cveCol < 0 || epssCol < 0 {
+		return nil, fmt.Errorf("Invalid EPSS file: expected cve and epss columns, found %s", strings.Join(header, ","))
+	}
+
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid EPSS file: %s", err)
+		}
+		if len(row) <= cveCol || len(row) <= epssCol {
+			continue
+		}
+		var s Score
+		if s.Epss, err = strconv.ParseFloat(strings.TrimSpace(row[epssCol]), 64); err != nil {
+			return nil, fmt.Errorf("Invalid EPSS score %s of %s", row[epssCol], row[cveCol])
+		}
+		if percentileCol >= 0 && percentileCol < len(row) {
+			s.Percentile, _ = strconv.ParseFloat(strings.TrimSpace(row[percentileCol]), 64)
+		}
+		e.scores[strings.ToUpper(strings.TrimSpace(row[cveCol]))] = s
+	}
+	return e, nil
+}
+
+// Lookup returns the score of cve
+func (e *EPSS) Lookup(cve string) (Score, bool) {
+	s, ok := e.scores[strings.ToUpper(strings.TrimSpace(cve))]
+	return s, ok
+}
+
+// Len returns the number of CVEs scored
+func (e *EPSS) Len() int {
+	return len(e.scores)
+}
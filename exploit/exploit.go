@@ -0,0 +1,99 @@
+/**************************************************************************************************/
+// File: exploit.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Exploitation data of CVEs from the CISA KEV catalog and EPSS scores
+/**************************************************************************************************/
+
+// Package exploit reads the CISA Known Exploited Vulnerabilities catalog and the FIRST EPSS
+// scores from local files, so CVEs and updates can be prioritized by exploitation offline:
+//
+//	kev, err := exploit.ReadKEV(f)
+//	d := exploit.Data{KEV: kev}
+//	x := d.Lookup("CVE-2018-8174")
+//	fmt.Println(x.InKev, x.KevDueDate)
+package exploit
+
+import (
+	"strconv"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Data: Exploitation data to join on CVE. Either source may be nil.
+type Data struct {
+	KEV  *KEV
+	EPSS *EPSS
+}
+
+// Exploitation: What is known of the exploitation of one or more CVEs, output columns of the
+// list commands. Empty if unknown.
+type Exploitation struct {
+	InKev          bool   `json:"in_kev"`
+	KevDueDate     string `json:"kev_due_date"`    // earliest due date of the CVEs in the catalog
+	Epss           string `json:"epss"`            // highest probability of exploitation
+	EpssPercentile string `json:"epss_percentile"` // highest percentile
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Lookup returns the exploitation of cves: in KEV if any of them is, with the earliest due date,
+// and the highest EPSS score and percentile
+func (d Data) Lookup(cves ...string) Exploitation {
+	var x Exploitation
+	for _, cve := range cves {
+		cve = strings.ToUpper(strings.TrimSpace(cve))
+		if d.KEV != nil {
+			if v, ok := d.KEV.Lookup(cve); ok {
+				x.InKev = true
+				if x.KevDueDate == "" || (v.DueDate != "" && v.DueDate < x.KevDueDate) {
+					x.KevDueDate = v.DueDate
+				}
+			}
+		}
+		if d.EPSS != nil {
+			if s, ok := d.EPSS.Lookup(cve); ok {
+				if higher(s.Epss, x.Epss) {
+					x.Epss = formatProbability(s.Epss)
+				}
+				if higher(s.Percentile, x.EpssPercentile) {
+					x.EpssPercentile = formatProbability(s.Percentile)
+				}
+			}
+		}
+	}
+	return x
+}
+
+// Match reports if x is in KEV, when kevOnly is set, and has an EPSS score of at least minEpss,
+// when minEpss is above 0
+func (x Exploitation) Match(kevOnly bool, minEpss float64) bool {
+	if kevOnly && !x.InKev {
+		return false
+	}
+	if minEpss > 0 {
+		epss, err := strconv.ParseFloat(x.Epss, 64)
+		if err != nil || epss < minEpss {
+			return false
+		}
+	}
+	return true
+}
+
+// higher reports if probability p is higher than the formatted probability s, or s is empty
+func higher(p float64, s string) bool {
+	q, err := strconv.ParseFloat(s, 64)
+	return err != nil || p > q
+}
+
+// formatProbability returns p as EPSS files print it (Ex., "0.97565")
+func formatProbability(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64)
+}
@@ -0,0 +1,145 @@
+/**************************************************************************************************/
+// File: exploit_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of reading the KEV catalog and EPSS scores and of joining them on CVE
+/**************************************************************************************************/
+package exploit
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func readData(t *testing.T) Data {
+	f, err := os.Open("testdata/known_exploited_vulnerabilities.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	kev, err := ReadKEV(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := os.Open("testdata/epss_scores-2023-10-09.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	epss, err := ReadEPSS(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Data{KEV: kev, EPSS: epss}
+}
+
+func TestReadEPSS(t *testing.T) {
+	d := readData(t)
+	if d.EPSS.ModelVersion != "v2023.03.01" || d.EPSS.ScoreDate != "2023-10-09T00:00:00+0000" {
+		t.Errorf("Model version %q, score date %q", d.EPSS.ModelVersion, d.EPSS.ScoreDate)
+	}
+	if d.EPSS.Len() != 5 {
+		t.Errorf("Read %d scores, expected 5", d.EPSS.Len())
+	}
+	if s, ok := d.EPSS.Lookup("CVE-2018-8174"); !ok || s.Epss != 0.97356 || s.Percentile != 0.99885 {
+		t.Errorf("CVE-2018-8174 scored %v, %v", s, ok)
+	}
+
+	// uncompressed, without the comment line and with other columns
+	e, err := ReadEPSS(strings.NewReader("percentile,cve,epss\n0.5,CVE-2018-8174,0.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := e.Lookup("CVE-2018-8174"); !ok || s.Epss != 0.1 || s.Percentile != 0.5 || e.ModelVersion != "" {
+		t.Errorf("CVE-2018-8174 scored %v, %v", s, ok)
+	}
+
+	for _, s := range []string{"", "cve,score\nCVE-2018-8174,0.1\n", "cve,epss\nCVE-2018-8174,high\n"} {
+		if _, err := ReadEPSS(strings.NewReader(s)); err == nil {
+			t.Errorf("ReadEPSS(%q) returned no error", s)
+		}
+	}
+}
+
+func TestReadKEV(t *testing.T) {
+	d := readData(t)
+	if d.KEV.CatalogVersion != "2023.10.09" || d.KEV.Len() != 3 {
+		t.Errorf("Catalog %s of %d CVEs", d.KEV.CatalogVersion, d.KEV.Len())
+	}
+	if v, ok := d.KEV.Lookup("CVE-2018-8174"); !ok || v.DueDate != "2022-08-25" || v.KnownRansomwareCampaignUse != "Known" {
+		t.Errorf("CVE-2018-8174 is %v, %v", v, ok)
+	}
+
+	for _, s := range []string{"", "{}", `{"vulnerabilities": "none"}`} {
+		if _, err := ReadKEV(strings.NewReader(s)); err == nil {
+			t.Errorf("ReadKEV(%q) returned no error", s)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	d := readData(t)
+	for _, test := range []struct {
+		cves     []string
+		expected Exploitation
+	}{
+		// the files and the lookups mix upper and lower case
+		{[]string{"CVE-2018-8174"}, Exploitation{true, "2022-08-25", "0.97356", "0.99885"}},
+		{[]string{"cve-2018-8174"}, Exploitation{true, "2022-08-25", "0.97356", "0.99885"}},
+		{[]string{"CVE-2018-8453"}, Exploitation{true, "2022-07-28", "0.00512", "0.75613"}},
+		{[]string{" cve-2018-8453 "}, Exploitation{true, "2022-07-28", "0.00512", "0.75613"}},
+		{[]string{"cve-2017-11882"}, Exploitation{false, "", "0.9755", "0.99987"}},
+		{[]string{"CVE-2018-1000"}, Exploitation{}},
+
+		// earliest due date, highest score and percentile
+		{[]string{"CVE-2018-8174", "cve-2018-8453", "CVE-2017-11882"}, Exploitation{true, "2022-07-28", "0.9755", "0.99987"}},
+		{[]string{"CVE-2018-1000", "CVE-2018-8440", "CVE-2018-8174"}, Exploitation{true, "2022-04-05", "0.97356", "0.99885"}},
+	} {
+		if x := d.Lookup(test.cves...); x != test.expected {
+			t.Errorf("Lookup(%s) = %+v, expected %+v", strings.Join(test.cves, ", "), x, test.expected)
+		}
+	}
+
+	// either source may be missing
+	if x := (Data{EPSS: d.EPSS}).Lookup("CVE-2018-8174"); x.InKev || x.Epss != "0.97356" {
+		t.Errorf("Lookup without KEV = %+v", x)
+	}
+	if x := (Data{KEV: d.KEV}).Lookup("CVE-2018-8174"); !x.InKev || x.Epss != "" {
+		t.Errorf("Lookup without EPSS = %+v", x)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	d := readData(t)
+	for _, test := range []struct {
+		cve      string
+		kevOnly  bool
+		minEpss  float64
+		expected bool
+	}{
+		{"CVE-2018-8174", false, 0, true},
+		{"CVE-2018-1000", false, 0, true},
+		{"CVE-2018-8174", true, 0, true},
+		{"cve-2018-8453", true, 0, true},
+		{"CVE-2017-11882", true, 0, false},
+		{"CVE-2018-1000", true, 0, false},
+		{"CVE-2017-11882", false, 0.9, true},
+		{"CVE-2018-8453", false, 0.9, false},
+		{"CVE-2018-8440", false, 0.04, true},
+		{"CVE-2018-1000", false, 0.01, false},
+		{"CVE-2018-8174", true, 0.9, true},
+		{"CVE-2018-8453", true, 0.9, false},
+		{"CVE-2017-11882", true, 0.9, false},
+	} {
+		if d.Lookup(test.cve).Match(test.kevOnly, test.minEpss) != test.expected {
+			t.Errorf("%s Match(%v, %v) = %v, expected %v", test.cve, test.kevOnly, test.minEpss, !test.expected, test.expected)
+		}
+	}
+}
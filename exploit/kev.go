@@ -0,0 +1,78 @@
+/**************************************************************************************************/
+// File: kev.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: CISA Known Exploited Vulnerabilities catalog
+/**************************************************************************************************/
+package exploit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// KEV: Known Exploited Vulnerabilities catalog, known_exploited_vulnerabilities.json of
+// https://www.cisa.gov/known-exploited-vulnerabilities-catalog
+type KEV struct {
+	CatalogVersion  string          `json:"catalogVersion"`
+	DateReleased    string          `json:"dateReleased"`
+	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+
+	cves map[string]int // index of Vulnerabilities by CVE
+}
+
+// Vulnerability: One CVE of the KEV catalog
+type Vulnerability struct {
+	CveID                      string `json:"cveID"`
+	VendorProject              string `json:"vendorProject"`
+	Product                    string `json:"product"`
+	VulnerabilityName          string `json:"vulnerabilityName"`
+	DateAdded                  string `json:"dateAdded"`
+	ShortDescription           string `json:"shortDescription"`
+	RequiredAction             string `json:"requiredAction"`
+	DueDate                    string `json:"dueDate"`
+	KnownRansomwareCampaignUse string `json:"knownRansomwareCampaignUse"`
+	Notes                      string `json:"notes"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// ReadKEV reads the JSON KEV catalog
+func ReadKEV(r io.Reader) (*KEV, error) {
+	var k KEV
+	if err := json.NewDecoder(r).Decode(&k); err != nil {
+		return nil, fmt.Errorf("Invalid KEV catalog: %s", err)
+	}
+	if k.Vulnerabilities == nil {
+		return nil, fmt.Errorf("Invalid KEV catalog: no vulnerabilities")
+	}
+	k.cves = make(map[string]int, len(k.Vulnerabilities))
+	for i, v := range k.Vulnerabilities {
+		k.cves[strings.ToUpper(strings.TrimSpace(v.CveID))] = i
+	}
+	return &k, nil
+}
+
+// Lookup returns the catalog entry of cve
+func (k *KEV) Lookup(cve string) (Vulnerability, bool) {
+	i, ok := k.cves[strings.ToUpper(strings.TrimSpace(cve))]
+	if !ok {
+		return Vulnerability{}, false
+	}
+	return k.Vulnerabilities[i], true
+}
+
+// Len returns the number of CVEs of the catalog
+func (k *KEV) Len() int {
+	return len(k.cves)
+}
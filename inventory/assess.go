@@ -0,0 +1,285 @@
+/**************************************************************************************************/
+// File: assess.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Offline assessment of the updates a host is missing
+/**************************************************************************************************/
+package inventory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// severityRank: Order of MSRC severities, most severe first
+var severityRank = map[string]int{
+	"critical":  0,
+	"important": 1,
+	"moderate":  2,
+	"low":       3,
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Finding: Update a host is missing
+type Finding struct {
+	Hostname            string `json:"hostname"`
+	Kb                  string `json:"kb"`
+	UpdateUid           string `json:"update_uid"`
+	UpdateTitle         string `json:"update_title"`
+	ProductTitle        string `json:"product_title"`
+	ClassificationTitle string `json:"classification_title"`
+	MsrcSeverity        string `json:"msrc_severity"`
+	UpdateCreationDate  string `json:"update_creation_date"`
+	Arch                string `json:"arch"`
+	CveCount            int    `json:"cve_count"`
+	MaxCvssv3BaseScore  string `json:"max_cvssv3_base_score"`
+	Cves                string `json:"cves"` // comma separated
+}
+
+// Assessor: Assesses hosts against the updates, supersedence and CVEs of a source. Data is
+// fetched once per product and reused for every host of that product.
+type Assessor struct {
+	Source   wsusscn2.Source
+	Parallel int // pages fetched concurrently
+
+	products map[string]*productData // by lower case product title
+	cves     map[string][]wsusscn2.Cve
+}
+
+// productData: Updates of one product and what supersedes them
+type productData struct {
+	updates      []wsusscn2.Update
+	supersededBy map[string][]string // update uid to the uids superseding it
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewAssessor returns an assessor reading from src
+func NewAssessor(src wsusscn2.Source) *Assessor {
+	return &Assessor{Source: src, products: make(map[string]*productData), cves: make(map[string][]wsusscn2.Cve)}
+}
+
+// product returns the updates and supersedence of the product with title
+func (a *Assessor) product(ctx context.Context, title string) (*productData, error) {
+	key := strings.ToLower(title)
+	if p, ok := a.products[key]; ok {
+		return p, nil
+	}
+
+	p := &productData{supersededBy: make(map[string][]string)}
+	filter := wsusscn2.UpdateFilter{
+		ProductTitle: []string{title},
+		Page:         wsusscn2.Page{RecordLimit: math.MaxInt32, Parallel: a.Parallel},
+	}
+	it := a.Source.Updates(ctx, filter)
+	for it.Next() {
+		p.updates = append(p.updates, it.Update())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sit := a.Source.Supersedes(ctx, filter)
+	for sit.Next() {
+		s := sit.Supersede()
+		p.supersededBy[s.UpdateUid] = append(p.supersededBy[s.UpdateUid], s.SuperUpdateUid)
+	}
+	if err := sit.Err(); err != nil {
+		return nil, err
+	}
+
+	a.products[key] = p
+	return p, nil
+}
+
+// applicable returns the updates of p that apply to the version and architecture of h, leaving
+// out beta and bundled updates. Updates without an architecture in their title are x86 if the
+// same KB also has an x64 or arm64 update.
+func (p *productData) applicable(h Host) []wsusscn2.Update {
+	archs := make(map[string]map[string]bool) // kb to the architectures it has updates for
+	for _, u := range p.updates {
+		if archs[u.Kb] == nil {
+			archs[u.Kb] = make(map[string]bool)
+		}
+		archs[u.Kb][u.Arch] = true
+	}
+
+	var result []wsusscn2.Update
+	for _, u := range p.updates {
+		// bundled updates are installed through their bundle
+		if beta, _ := strconv.ParseBool(u.IsBeta); beta {
+			continue
+		}
+		if bundled, _ := strconv.ParseBool(u.IsBundled); bundled {
+			continue
+		}
+		if h.Arch != "" {
+			if u.Arch == "" && archs[u.Kb][h.Arch] {
+				continue
+			}
+			if u.Arch != "" && u.Arch != h.Arch {
+				continue
+			}
+		}
+		if h.Version != "" {
+			if v := wsusscn2.TitleVersion(u.UpdateTitle); v != "" && !strings.EqualFold(v, h.Version) {
+				continue
+			}
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// covered reports if an update superseding uid, directly or through others, is in ok
+func (p *productData) covered(uid string, ok func(uid string) bool) bool {
+	seen := map[string]bool{uid: true}
+	queue := []string{uid}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, super := range p.supersededBy[id] {
+			if seen[super] {
+				continue
+			}
+			if ok(super) {
+				return true
+			}
+			seen[super] = true
+			queue = append(queue, super)
+		}
+	}
+	return false
+}
+
+// Assess returns the updates h is missing: those that apply to its product, version and
+// architecture, whose KB is not installed, and that are not superseded by an installed update
+// or by another update that applies. Findings are sorted by severity, then newest first.
+//
+// Installed updates are only known by KB. h.UBR is not used: update records carry no build
+// revision, so the cumulative update a revision comes from is unknown. A host at 15063.483
+// without KB4025342 in InstalledKbs is reported as missing it.
+func (a *Assessor) Assess(ctx context.Context, h Host) ([]Finding, error) {
+	p, err := a.product(ctx, h.Product)
+	if err != nil {
+		return nil, err
+	}
+
+	// an installed superseder need not apply, it may be bundled or of another architecture
+	kbs := make(map[string]string) // update uid to KB, of every update of the product
+	for _, u := range p.updates {
+		kbs[u.UpdateUid] = u.Kb
+	}
+	candidates := p.applicable(h)
+	byUid := make(map[string]bool)
+	for _, u := range candidates {
+		byUid[u.UpdateUid] = true
+	}
+	installed := func(uid string) bool {
+		kb, ok := kbs[uid]
+		return ok && h.HasKb(kb)
+	}
+	applies := func(uid string) bool {
+		return byUid[uid]
+	}
+
+	var missing []wsusscn2.Update
+	for _, u := range candidates {
+		if h.HasKb(u.Kb) || p.covered(u.UpdateUid, installed) {
+			continue
+		}
+		// the superseding update is reported instead
+		if p.covered(u.UpdateUid, applies) {
+			continue
+		}
+		missing = append(missing, u)
+	}
+
+	if err := a.loadCves(ctx, h.Product, missing); err != nil {
+		return nil, err
+	}
+
+	findings := make([]Finding, 0, len(missing))
+	for _, u := range missing {
+		f := Finding{
+			Hostname:            h.Hostname,
+			Kb:                  u.Kb,
+			UpdateUid:           u.UpdateUid,
+			UpdateTitle:         u.UpdateTitle,
+			ProductTitle:        u.ProductTitle,
+			ClassificationTitle: u.ClassificationTitle,
+			MsrcSeverity:        u.MsrcSeverity,
+			UpdateCreationDate:  u.UpdateCreationDate,
+			Arch:                u.Arch,
+		}
+		var ids []string
+		max := -1.0
+		for _, c := range a.cves[u.UpdateUid] {
+			ids = append(ids, c.Cve)
+			if score, err := strconv.ParseFloat(c.Cvssv3BaseScore, 64); err == nil && score > max {
+				max = score
+				f.MaxCvssv3BaseScore = c.Cvssv3BaseScore
+			}
+		}
+		f.CveCount, f.Cves = len(ids), strings.Join(ids, ",")
+		findings = append(findings, f)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		ri, rj := rank(findings[i].MsrcSeverity), rank(findings[j].MsrcSeverity)
+		if ri != rj {
+			return ri < rj
+		}
+		return findings[i].UpdateCreationDate > findings[j].UpdateCreationDate
+	})
+	return findings, nil
+}
+
+// rank returns the position of an MSRC severity, unknown severities last
+func rank(severity string) int {
+	if r, ok := severityRank[strings.ToLower(severity)]; ok {
+		return r
+	}
+	return len(severityRank)
+}
+
+// loadCves fetches the CVEs of the updates not fetched yet, in batches
+func (a *Assessor) loadCves(ctx context.Context, product string, updates []wsusscn2.Update) error {
+	var uids []string
+	for _, u := range updates {
+		if _, ok := a.cves[u.UpdateUid]; !ok {
+			uids = append(uids, u.UpdateUid)
+			a.cves[u.UpdateUid] = nil
+		}
+	}
+
+	return wsusscn2.Batches(uids, func(batch []string) error {
+		seen := make(map[string]bool)
+		it := a.Source.Cves(ctx, wsusscn2.CveFilter{UpdateUid: batch, ProductTitle: []string{product}, Page: wsusscn2.Page{RecordLimit: math.MaxInt32}})
+		for it.Next() {
+			c := it.Cve()
+			if !seen[c.UpdateUid+c.Cve] {
+				seen[c.UpdateUid+c.Cve] = true
+				a.cves[c.UpdateUid] = append(a.cves[c.UpdateUid], c)
+			}
+		}
+		return it.Err()
+	})
+}
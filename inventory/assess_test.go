@@ -0,0 +1,275 @@
+/**************************************************************************************************/
+// File: assess_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of assessing the missing updates of a host against a fake source
+/**************************************************************************************************/
+package inventory
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// fakeSource: Source of fixed records, filtered by product and update uid
+type fakeSource struct {
+	wsusscn2.Source
+	updates    []wsusscn2.Update
+	supersedes []wsusscn2.UpdateSupersede
+	cves       []wsusscn2.Cve
+	requests   int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// match reports if a record of product and uid passes the product and uid filters
+func match(products []string, uids []string, product string, uid string) bool {
+	ok := len(products) == 0
+	for _, p := range products {
+		ok = ok || strings.EqualFold(p, product)
+	}
+	if len(uids) == 0 {
+		return ok
+	}
+	for _, u := range uids {
+		if strings.EqualFold(u, uid) {
+			return ok
+		}
+	}
+	return false
+}
+
+// bounds returns the slice bounds of the page at offset of n records
+func bounds(n int, limit int, offset int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	if offset+limit > n {
+		return offset, n
+	}
+	return offset, offset + limit
+}
+
+func (s *fakeSource) Updates(ctx context.Context, f wsusscn2.UpdateFilter) *wsusscn2.UpdateIterator {
+	s.requests++
+	var records []wsusscn2.Update
+	for _, u := range s.updates {
+		if match(f.ProductTitle, f.UpdateUid, u.ProductTitle, u.UpdateUid) {
+			records = append(records, u)
+		}
+	}
+	return wsusscn2.NewUpdateIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.Update, error) {
+		start, end := bounds(len(records), limit, offset)
+		return records[start:end], nil
+	})
+}
+
+func (s *fakeSource) Supersedes(ctx context.Context, f wsusscn2.UpdateFilter) *wsusscn2.SupersedeIterator {
+	s.requests++
+	var records []wsusscn2.UpdateSupersede
+	for _, r := range s.supersedes {
+		if match(f.ProductTitle, f.UpdateUid, r.ProductTitle, r.UpdateUid) {
+			records = append(records, r)
+		}
+	}
+	return wsusscn2.NewSupersedeIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.UpdateSupersede, error) {
+		start, end := bounds(len(records), limit, offset)
+		return records[start:end], nil
+	})
+}
+
+func (s *fakeSource) Cves(ctx context.Context, f wsusscn2.CveFilter) *wsusscn2.CveIterator {
+	s.requests++
+	var records []wsusscn2.Cve
+	for _, c := range s.cves {
+		if match(f.ProductTitle, f.UpdateUid, c.ProductTitle, c.UpdateUid) {
+			records = append(records, c)
+		}
+	}
+	return wsusscn2.NewCveIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.Cve, error) {
+		start, end := bounds(len(records), limit, offset)
+		return records[start:end], nil
+	})
+}
+
+// testSource returns a source of Windows 10 updates:
+//
+//	kb 4025342 (1703 x64, x86 without arch) <- 4034674 <- 4038788, all 1703 x64
+//	kb 4041676 (1709 x64) superseding nothing
+//	kb 4022405 (arm64 and without arch), no other update of the KB
+func testSource() *fakeSource {
+	const product = "Windows 10"
+	update := func(uid string, kb string, title string, arch string, created string) wsusscn2.Update {
+		return wsusscn2.Update{UpdateUid: uid, Kb: kb, UpdateTitle: title, ProductTitle: product, Arch: arch,
+			MsrcSeverity: "Critical", UpdateCreationDate: created, IsBeta: "false", IsBundled: "false"}
+	}
+	supersede := func(uid string, super string) wsusscn2.UpdateSupersede {
+		return wsusscn2.UpdateSupersede{UpdateUid: uid, ProductTitle: product, SuperUpdateUid: super, SuperProductTitle: product}
+	}
+	s := &fakeSource{
+		updates: []wsusscn2.Update{
+			update("a64", "4025342", "Cumulative Update for Windows 10 Version 1703 for x64-based Systems (KB4025342)", "x64", "2017-07-11"),
+			update("a86", "4025342", "Cumulative Update for Windows 10 Version 1703 (KB4025342)", "", "2017-07-11"),
+			update("b64", "4034674", "Cumulative Update for Windows 10 Version 1703 for x64-based Systems (KB4034674)", "x64", "2017-08-08"),
+			update("c64", "4038788", "Cumulative Update for Windows 10 Version 1703 for x64-based Systems (KB4038788)", "x64", "2017-09-12"),
+			update("d64", "4041676", "Cumulative Update for Windows 10 Version 1709 for x64-based Systems (KB4041676)", "x64", "2017-10-17"),
+			update("earm", "4022405", "Security Update for Windows 10 for ARM64-based Systems (KB4022405)", "arm64", "2017-06-13"),
+			update("e", "4022405", "Security Update for Windows 10 (KB4022405)", "", "2017-06-13"),
+			update("beta", "4099999", "Cumulative Update for Windows 10 Version 1703 (KB4099999)", "", "2017-09-01"),
+		},
+		supersedes: []wsusscn2.UpdateSupersede{supersede("a64", "b64"), supersede("b64", "c64"), supersede("a64", "c64")},
+		cves: []wsusscn2.Cve{
+			{UpdateUid: "c64", ProductTitle: product, Cve: "CVE-2017-8759", Cvssv3BaseScore: "7.8"},
+			{UpdateUid: "c64", ProductTitle: product, Cve: "CVE-2017-11882", Cvssv3BaseScore: "8.8"},
+			{UpdateUid: "c64", ProductTitle: product, Cve: "CVE-2017-8759", Cvssv3BaseScore: "7.8"},
+		},
+	}
+	s.updates[2].MsrcSeverity = "Important"
+	s.updates[7].IsBeta = "true"
+	return s
+}
+
+// missingUids returns the update uids of the findings of h
+func missingUids(t *testing.T, a *Assessor, h Host) []string {
+	findings, err := a.Assess(context.Background(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := []string{}
+	for _, f := range findings {
+		uids = append(uids, f.UpdateUid)
+	}
+	return uids
+}
+
+func TestApplicable(t *testing.T) {
+	src := testSource()
+	a := NewAssessor(src)
+	p, err := a.product(context.Background(), "windows 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		arch, version string
+		expected      []string
+	}{
+		// an update without an arch is left out for the archs its KB has updates of
+		{"x64", "1703", []string{"a64", "b64", "c64", "e"}},
+		{"x86", "1703", []string{"a86", "e"}},
+		{"arm64", "1703", []string{"a86", "earm"}},
+		// the title version must match, titles without one apply to every version
+		{"x64", "1709", []string{"d64", "e"}},
+		{"x64", "", []string{"a64", "b64", "c64", "d64", "e"}},
+		{"", "1703", []string{"a64", "a86", "b64", "c64", "earm", "e"}},
+	} {
+		var uids []string
+		for _, u := range p.applicable(Host{Arch: test.arch, Version: test.version}) {
+			uids = append(uids, u.UpdateUid)
+		}
+		if !reflect.DeepEqual(uids, test.expected) {
+			t.Errorf("%s %s: applicable %v, expected %v", test.arch, test.version, uids, test.expected)
+		}
+	}
+}
+
+func TestCovered(t *testing.T) {
+	p := &productData{supersededBy: map[string][]string{"a": {"b"}, "b": {"c", "a"}, "c": {"b"}}}
+	for _, test := range []struct {
+		uid, ok  string
+		expected bool
+	}{
+		{"a", "b", true},
+		{"a", "c", true}, // through b
+		{"c", "a", true}, // cycles end
+		{"a", "a", false},
+		{"a", "d", false},
+		{"d", "a", false},
+	} {
+		ok := func(uid string) bool { return uid == test.ok }
+		if p.covered(test.uid, ok) != test.expected {
+			t.Errorf("%s covered by %s = %v, expected %v", test.uid, test.ok, !test.expected, test.expected)
+		}
+	}
+}
+
+func TestAssess(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		arch      string
+		installed []string
+		expected  []string
+	}{
+		// the chain a64 <- b64 <- c64 applies, only its end is reported
+		{"chain", "x64", nil, []string{"c64", "e"}},
+		{"installed", "x64", []string{"4025342", "4022405"}, []string{"c64"}},
+		{"installed superseder", "x64", []string{"4034674"}, []string{"c64", "e"}},
+		{"up to date", "x64", []string{"4038788", "4022405"}, []string{}},
+		// nothing supersedes the x86 update of a
+		{"x86", "x86", nil, []string{"a86", "e"}},
+		{"x86 installed x64 superseder", "x86", []string{"4034674"}, []string{"a86", "e"}},
+	} {
+		a := NewAssessor(testSource())
+		h := Host{Hostname: "pc1", Product: "Windows 10", Version: "1703", Arch: test.arch, InstalledKbs: test.installed}
+		if uids := missingUids(t, a, h); !reflect.DeepEqual(uids, test.expected) {
+			t.Errorf("%s: missing %v, expected %v", test.name, uids, test.expected)
+		}
+	}
+}
+
+func TestAssessInstalledNotApplicable(t *testing.T) {
+	// the superseder is installed through a bundle, so it does not apply itself
+	src := testSource()
+	src.updates[3].IsBundled = "true"
+	a := NewAssessor(src)
+	h := Host{Hostname: "pc1", Product: "Windows 10", Version: "1703", Arch: "x64", InstalledKbs: []string{"4038788", "4022405"}}
+	if uids := missingUids(t, a, h); len(uids) != 0 {
+		t.Errorf("Missing %v, expected none with the bundled superseder installed", uids)
+	}
+	h.InstalledKbs = []string{"4022405"}
+	if uids := missingUids(t, a, h); !reflect.DeepEqual(uids, []string{"b64"}) {
+		t.Errorf("Missing %v, expected b64", uids)
+	}
+}
+
+func TestAssessFindings(t *testing.T) {
+	src := testSource()
+	a := NewAssessor(src)
+	h := Host{Hostname: "pc1", Product: "Windows 10", Version: "1703", Arch: "x64"}
+	findings, err := a.Assess(context.Background(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Finding{
+		// critical first, then newest first
+		{Hostname: "pc1", Kb: "4038788", UpdateUid: "c64", MsrcSeverity: "Critical", UpdateCreationDate: "2017-09-12", Arch: "x64",
+			CveCount: 2, MaxCvssv3BaseScore: "8.8", Cves: "CVE-2017-8759,CVE-2017-11882"},
+		{Hostname: "pc1", Kb: "4022405", UpdateUid: "e", MsrcSeverity: "Critical", UpdateCreationDate: "2017-06-13"},
+	}
+	for i := range findings {
+		findings[i].UpdateTitle, findings[i].ProductTitle = "", ""
+	}
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("Findings\n%+v\nexpected\n%+v", findings, expected)
+	}
+
+	// the product and the CVEs are fetched once
+	requests := src.requests
+	if _, err := a.Assess(context.Background(), Host{Hostname: "pc2", Product: "WINDOWS 10", Version: "1703", Arch: "x64"}); err != nil {
+		t.Fatal(err)
+	}
+	if src.requests != requests {
+		t.Errorf("Second host made %d requests, expected none", src.requests-requests)
+	}
+}
@@ -0,0 +1,466 @@
+/**************************************************************************************************/
+// File: import.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Import host inventory from systeminfo, Get-HotFix, wmic qfe and osquery output
+/**************************************************************************************************/
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Formats: Inventory formats of Import
+var Formats = []string{"json", "systeminfo", "hotfix", "qfe", "osquery"}
+
+// hostColumns: Columns naming the host in Get-HotFix and wmic qfe CSV, in order of preference
+var hostColumns = []string{"csname", "pscomputername", "source", "node"}
+
+// productTitles: Product titles of WSUS that differ from the OS name
+var productTitles = map[string]string{
+	"windows server 2022": "Microsoft Server operating system-21H2",
+	"windows server 2025": "Microsoft Server operating system-24H2",
+}
+
+var (
+	productPattern = regexp.MustCompile(`(?i)\bwindows\s+(server\s+)?(\d{4}|\d{1,2}(?:\.\d)?|xp|vista)(\s+r2)?\b`)
+	hotfixPattern  = regexp.MustCompile(`(?i)\bKB\d{5,8}\b`)
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// hostSet: Hosts by hostname, in order of first appearance
+type hostSet struct {
+	hosts  []*Host
+	byName map[string]*Host
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Import reads hosts in format, one of Formats, or detects the format if it is "" or "auto".
+// UTF-16 as written by PowerShell and wmic is understood. Hosts are normalized, but unlike
+// ReadHosts they may lack a product: Get-HotFix and wmic qfe only list KBs, Merge them with the
+// systeminfo or osquery os_version of the same hosts.
+func Import(r io.Reader, format string) ([]Host, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := decodeText(data)
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("Inventory is empty")
+	}
+	if format == "" || format == "auto" {
+		format = detectFormat(text)
+	}
+
+	var hosts []Host
+	switch strings.ToLower(format) {
+	case "json":
+		hosts, err = decodeHosts(strings.NewReader(text))
+	case "systeminfo":
+		hosts, err = parseSystemInfo(text)
+	case "hotfix", "qfe":
+		hosts, err = parseHotFixCsv(text)
+	case "osquery":
+		hosts, err = parseOsquery(text)
+	default:
+		return nil, fmt.Errorf("Unknown inventory format %s, expected auto or one of %s", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range hosts {
+		hosts[i].Normalize()
+	}
+	return hosts, nil
+}
+
+// decodeText returns data as a string, decoding UTF-16 with or without a byte order mark and
+// dropping a UTF-8 byte order mark. Line endings become \n; wmic writes \r\r\n.
+func decodeText(data []byte) string {
+	var text string
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		text = decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		text = decodeUTF16(data[2:], true)
+	case len(data) >= 4 && data[0] != 0 && data[1] == 0 && data[3] == 0:
+		text = decodeUTF16(data, false)
+	default:
+		text = strings.TrimPrefix(string(data), "\xef\xbb\xbf")
+	}
+	return strings.NewReplacer("\r\r\n", "\n", "\r\n", "\n", "\r", "\n").Replace(text)
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// detectFormat guesses the format of an inventory from its content
+func detectFormat(text string) string {
+	t := strings.TrimSpace(text)
+	lower := strings.ToLower(t)
+	switch {
+	case strings.HasPrefix(t, "{") || strings.HasPrefix(t, "["):
+		for _, key := range []string{`"hotfix_id"`, `"hostidentifier"`, `"platform"`, `"diffresults"`} {
+			if strings.Contains(lower, key) {
+				return "osquery"
+			}
+		}
+		return "json"
+	case strings.Contains(lower, "hotfixid"):
+		if strings.HasPrefix(lower, "node,") {
+			return "qfe"
+		}
+		return "hotfix"
+	}
+	return "systeminfo"
+}
+
+// parseSystemInfo reads the output of systeminfo, as a list (the default) or as CSV (/fo csv).
+// Several hosts may follow each other. Only English field names are understood.
+func parseSystemInfo(text string) ([]Host, error) {
+	var records []map[string]string
+	if strings.HasPrefix(strings.TrimSpace(text), `"`) {
+		r := csv.NewReader(strings.NewReader(text))
+		r.FieldsPerRecord = -1
+		rows, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid systeminfo CSV: %s", err)
+		}
+		for i := 1; i < len(rows); i++ {
+			if len(rows[i]) == len(rows[0]) && rows[i][0] == rows[0][0] {
+				continue // header of the next host
+			}
+			record := make(map[string]string)
+			for j, name := range rows[0] {
+				if j < len(rows[i]) {
+					record[strings.ToLower(name)] = rows[i][j]
+				}
+			}
+			records = append(records, record)
+		}
+	} else {
+		var record map[string]string
+		key := ""
+		s := bufio.NewScanner(strings.NewReader(text))
+		for s.Scan() {
+			line := s.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if line[0] == ' ' || line[0] == '\t' {
+				// continuation of a multi-line value such as Hotfix(s)
+				if record != nil && key != "" {
+					record[key] += "\n" + strings.TrimSpace(line)
+				}
+				continue
+			}
+			i := strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+			key = strings.ToLower(strings.TrimSpace(line[:i]))
+			if key == "host name" {
+				record = make(map[string]string)
+				records = append(records, record)
+			}
+			if record != nil {
+				record[key] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	if len(records) == 0 {
+		return nil, errors.New("No systeminfo found, expected a \"Host Name:\" line")
+	}
+
+	hosts := make([]Host, 0, len(records))
+	for _, record := range records {
+		h := Host{
+			Hostname:     record["host name"],
+			Arch:         record["system type"],
+			InstalledKbs: hotfixPattern.FindAllString(record["hotfix(s)"], -1),
+		}
+		// OS Version: 10.0.19045 N/A Build 19045
+		if fields := strings.Fields(record["os version"]); len(fields) > 0 {
+			h.Build = fields[0]
+		}
+		h.Normalize()
+		h.Product = ProductTitle(record["os name"], h.Build)
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// parseHotFixCsv reads the output of Get-HotFix | ConvertTo-Csv or wmic qfe list /format:csv
+func parseHotFixCsv(text string) ([]Host, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.Comment = '#' // #TYPE line of ConvertTo-Csv
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid hotfix CSV: %s", err)
+	}
+
+	kbColumn, hostColumn := -1, -1
+	hostRank := len(hostColumns)
+	header := 0
+	for ; header < len(rows) && kbColumn < 0; header++ {
+		for i, name := range rows[header] {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "hotfixid" {
+				kbColumn = i
+			}
+			for rank, h := range hostColumns {
+				if name == h && rank < hostRank {
+					hostColumn, hostRank = i, rank
+				}
+			}
+		}
+	}
+	if kbColumn < 0 {
+		return nil, errors.New("No HotFixID column found")
+	}
+
+	set := newHostSet()
+	for _, row := range rows[header:] {
+		if kbColumn >= len(row) {
+			continue
+		}
+		name := ""
+		if hostColumn >= 0 && hostColumn < len(row) {
+			name = row[hostColumn]
+		}
+		h := set.get(name)
+		h.InstalledKbs = append(h.InstalledKbs, row[kbColumn])
+	}
+	return set.list(), nil
+}
+
+// parseOsquery reads rows of the osquery patches and os_version tables (also system_info for
+// the hostname), as printed by osqueryi --json or logged by osqueryd: one result per line with
+// the row in "columns", a "snapshot" of rows or "diffResults". Removed rows are ignored.
+func parseOsquery(text string) ([]Host, error) {
+	set := newHostSet()
+	d := json.NewDecoder(strings.NewReader(text))
+	d.UseNumber()
+	for {
+		var v interface{}
+		err := d.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid osquery JSON: %s", err)
+		}
+		if err := addOsquery(set, v, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// osqueryi prints no hostIdentifier: os_version rows without a name are of the host named
+	// by the patches or system_info rows
+	hosts := set.list()
+	if len(hosts) == 2 && (hosts[0].Hostname == "" || hosts[1].Hostname == "") {
+		name := hosts[0].Hostname + hosts[1].Hostname
+		hosts[0].Hostname, hosts[1].Hostname = name, name
+		return Merge(hosts), nil
+	}
+	return hosts, nil
+}
+
+// addOsquery adds the rows of an osquery result v to set. Rows without a hostname belong to
+// hostIdentifier.
+func addOsquery(set *hostSet, v interface{}, hostIdentifier string) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, row := range v {
+			if err := addOsquery(set, row, hostIdentifier); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if id, ok := v["hostIdentifier"]; ok {
+			hostIdentifier = fmt.Sprint(id)
+		}
+		if action, _ := v["action"].(string); action == "removed" {
+			return nil
+		}
+		if columns, ok := v["columns"]; ok {
+			return addOsquery(set, columns, hostIdentifier)
+		}
+		if snapshot, ok := v["snapshot"]; ok {
+			return addOsquery(set, snapshot, hostIdentifier)
+		}
+		if diff, ok := v["diffResults"].(map[string]interface{}); ok {
+			return addOsquery(set, diff["added"], hostIdentifier)
+		}
+		addOsqueryRow(set, v, hostIdentifier)
+	case nil:
+		// diffResults without added rows
+	default:
+		return fmt.Errorf("Unexpected osquery result %v", v)
+	}
+	return nil
+}
+
+// addOsqueryRow adds one row of patches, os_version or system_info
+func addOsqueryRow(set *hostSet, row map[string]interface{}, hostIdentifier string) {
+	column := func(name string) string {
+		if v, ok := row[name]; ok && v != nil {
+			return strings.TrimSpace(fmt.Sprint(v))
+		}
+		return ""
+	}
+
+	name := hostIdentifier
+	if name == "" {
+		name = column("hostname")
+	}
+	if name == "" {
+		name = column("csname")
+	}
+	h := set.get(name)
+
+	switch {
+	case column("hotfix_id") != "":
+		h.InstalledKbs = append(h.InstalledKbs, column("hotfix_id"))
+	case column("platform") != "" || column("build") != "":
+		// os_version: name "Microsoft Windows 10 Pro", version "10.0.19045", revision the UBR
+		build := column("version")
+		if build == "" {
+			build = column("build")
+		}
+		h.Build = build
+		if rev := column("revision"); rev != "" && rev != "0" {
+			h.UBR = rev
+		}
+		if arch := column("arch"); arch != "" {
+			h.Arch = arch
+		}
+		h.Normalize()
+		h.Product = ProductTitle(column("name"), h.Build)
+	case column("cpu_type") != "":
+		// system_info
+		if h.Arch == "" {
+			h.Arch = NormalizeArch(column("cpu_type"))
+		}
+	}
+}
+
+// ProductTitle maps the name of an OS as reported by Windows (Ex., "Microsoft Windows 10 Pro" or
+// "Microsoft Windows Server 2012 R2 Standard") to the product title of its updates ("Windows 10",
+// "Windows Server 2012 R2"). Windows 11 hosts that report themselves as Windows 10 are recognized
+// by their build. Names that are not Windows are returned as they are.
+func ProductTitle(name, build string) string {
+	name = strings.NewReplacer("®", " ", "™", " ", "(R)", " ", "(TM)", " ").Replace(name)
+	m := productPattern.FindStringSubmatch(name)
+	if m == nil {
+		return strings.Join(strings.Fields(name), " ")
+	}
+
+	version := m[2]
+	switch strings.ToLower(version) {
+	case "xp":
+		version = "XP"
+	case "vista":
+		version = "Vista"
+	}
+	title := "Windows " + version
+	if m[1] != "" {
+		title = "Windows Server " + version
+	}
+	if m[3] != "" {
+		title += " R2"
+	}
+
+	if n, err := strconv.Atoi(build); title == "Windows 10" && err == nil && n >= 22000 {
+		title = "Windows 11"
+	}
+	if t, ok := productTitles[strings.ToLower(title)]; ok {
+		title = t
+	}
+	return title
+}
+
+// Merge combines the hosts with the same hostname, compared without case, into one: installed
+// KBs are joined and empty fields are filled in from later hosts. Hosts are kept in order of
+// first appearance.
+func Merge(hosts []Host) []Host {
+	set := newHostSet()
+	for _, h := range hosts {
+		m := set.get(h.Hostname)
+		for _, f := range []struct{ to, from *string }{
+			{&m.Product, &h.Product},
+			{&m.Version, &h.Version},
+			{&m.Build, &h.Build},
+			{&m.UBR, &h.UBR},
+			{&m.Arch, &h.Arch},
+		} {
+			if *f.to == "" {
+				*f.to = *f.from
+			}
+		}
+		m.InstalledKbs = append(m.InstalledKbs, h.InstalledKbs...)
+	}
+	return set.list()
+}
+
+func newHostSet() *hostSet {
+	return &hostSet{byName: make(map[string]*Host)}
+}
+
+// get returns the host named name, added if new
+func (s *hostSet) get(name string) *Host {
+	name = strings.TrimSpace(name)
+	key := strings.ToLower(name)
+	if h, ok := s.byName[key]; ok {
+		return h
+	}
+	h := &Host{Hostname: name}
+	s.hosts = append(s.hosts, h)
+	s.byName[key] = h
+	return h
+}
+
+// list returns the hosts of s, normalized
+func (s *hostSet) list() []Host {
+	hosts := make([]Host, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		h.Normalize()
+		hosts = append(hosts, *h)
+	}
+	return hosts
+}
@@ -0,0 +1,176 @@
+/**************************************************************************************************/
+// File: import_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of importing the output of the inventory collectors in testdata
+/**************************************************************************************************/
+package inventory
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func TestDecodeText(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{"utf-8", "Node,HotFixID\r\nPC1,KB4462917\r\n"},
+		{"utf-8 bom", "\xef\xbb\xbfNode,HotFixID\nPC1,KB4462917\n"},
+		{"utf-16 le bom", "\xff\xfeN\x00o\x00d\x00e\x00,\x00H\x00o\x00t\x00F\x00i\x00x\x00I\x00D\x00\r\x00\n\x00P\x00C\x001\x00,\x00K\x00B\x004\x004\x006\x002\x009\x001\x007\x00\r\x00\n\x00"},
+		{"utf-16 le", "N\x00o\x00d\x00e\x00,\x00H\x00o\x00t\x00F\x00i\x00x\x00I\x00D\x00\n\x00P\x00C\x001\x00,\x00K\x00B\x004\x004\x006\x002\x009\x001\x007\x00\n\x00"},
+		{"utf-16 be bom", "\xfe\xff\x00N\x00o\x00d\x00e\x00,\x00H\x00o\x00t\x00F\x00i\x00x\x00I\x00D\x00\n\x00P\x00C\x001\x00,\x00K\x00B\x004\x004\x006\x002\x009\x001\x007\x00\n"},
+		{"wmic", "\r\r\nNode,HotFixID\r\r\nPC1,KB4462917\r\r\n"},
+		{"mac", "Node,HotFixID\rPC1,KB4462917\r"},
+	} {
+		text := strings.TrimPrefix(decodeText([]byte(test.data)), "\n")
+		if text != "Node,HotFixID\nPC1,KB4462917\n" {
+			t.Errorf("%s decoded as %q", test.name, text)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	web1 := Host{Hostname: "WEB1", Product: "Windows Server 2016", Version: "1607", Build: "14393", Arch: "x64",
+		InstalledKbs: []string{"3192137", "4049065", "4462917"}}
+	for _, test := range []struct {
+		file, format string
+		expected     []Host
+	}{
+		{"systeminfo.txt", "systeminfo", []Host{
+			web1,
+			{Hostname: "PC7", Product: "Windows 10", Version: "22H2", Build: "19045", Arch: "x64", InstalledKbs: []string{"5030211", "5029709"}},
+		}},
+		{"systeminfo.csv", "systeminfo", []Host{
+			{Hostname: "DB1", Product: "Windows Server 2012 R2", Build: "9600", Arch: "x64", InstalledKbs: []string{"2919355", "4462926"}},
+			{Hostname: "KIOSK2", Product: "Windows 7", Build: "7601", Arch: "x86", InstalledKbs: []string{"4462923"}},
+		}},
+		// ConvertTo-Csv starts with a #TYPE line, PSComputerName and CSName both name the host
+		{"hotfix.csv", "hotfix", []Host{
+			{Hostname: "WEB1", InstalledKbs: []string{"4049065", "4462917"}},
+			{Hostname: "DB1", InstalledKbs: []string{"2919355", "4462926"}},
+		}},
+		{"qfe.csv", "qfe", []Host{
+			{Hostname: "WEB1", InstalledKbs: []string{"3192137", "4462917"}},
+			{Hostname: "PC7", InstalledKbs: []string{"5030211"}},
+		}},
+		// the os_version rows of osqueryi name no host, they are of the host of the patches
+		{"osqueryi.json", "osquery", []Host{
+			{Hostname: "WEB1", Product: "Windows Server 2016", Version: "1607", Build: "14393", UBR: "2515", Arch: "x64",
+				InstalledKbs: []string{"4049065", "4462917"}},
+		}},
+		// removed rows are ignored, Windows 11 reports itself as Windows 10
+		{"osqueryd.log", "osquery", []Host{
+			{Hostname: "db1", Product: "Microsoft Server operating system-21H2", Version: "21H2", Build: "20348", UBR: "2031", Arch: "x64",
+				InstalledKbs: []string{"5031364", "5030216"}},
+			{Hostname: "app1", Product: "Windows 11", Version: "23H2", Build: "22631", Arch: "x64", InstalledKbs: []string{"5030219", "5031354"}},
+		}},
+	} {
+		data, err := ioutil.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format := detectFormat(decodeText(data)); format != test.format {
+			t.Errorf("%s detected as %s, expected %s", test.file, format, test.format)
+		}
+		for _, format := range []string{"auto", test.format} {
+			hosts, err := Import(strings.NewReader(string(data)), format)
+			if err != nil {
+				t.Errorf("%s as %s: %s", test.file, format, err)
+				continue
+			}
+			if !reflect.DeepEqual(hosts, test.expected) {
+				t.Errorf("%s as %s:\n%+v\nexpected\n%+v", test.file, format, hosts, test.expected)
+			}
+		}
+	}
+}
+
+func TestImportOsqueryMerge(t *testing.T) {
+	patches := `[{"csname": "PC1", "hotfix_id": "KB4462917"}]` + "\n"
+	nameless := `[{"name": "Microsoft Windows 10 Pro", "platform": "windows", "version": "10.0.19045", "arch": "64-bit"}]` + "\n"
+	for _, test := range []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{"patches and nameless os_version", patches + nameless, []string{"PC1"}},
+		{"nameless os_version first", nameless + patches, []string{"PC1"}},
+		// two named hosts and a nameless one are not merged, it is unclear whose it is
+		{"two named hosts", patches + `[{"csname": "PC2", "hotfix_id": "KB4462918"}]` + "\n" + nameless, []string{"PC1", "PC2", ""}},
+		{"nameless only", nameless, []string{""}},
+	} {
+		hosts, err := Import(strings.NewReader(test.text), "osquery")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, h := range hosts {
+			names = append(names, h.Hostname)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s: hosts %q, expected %q", test.name, names, test.expected)
+		}
+		if test.expected[0] == "PC1" && len(hosts) == 1 {
+			if h := hosts[0]; h.Product != "Windows 10" || h.Build != "19045" || !reflect.DeepEqual(h.InstalledKbs, []string{"4462917"}) {
+				t.Errorf("%s: merged into %+v", test.name, h)
+			}
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, test := range []struct {
+		text, format string
+	}{
+		{"", "auto"},
+		{" \r\n", "auto"},
+		{"Node,HotFixID\nPC1,KB4462917\n", "wsus"},
+		{"Node,Description\nPC1,Security Update\n", "qfe"},
+		{"OS Name: Microsoft Windows 10 Pro\n", "systeminfo"},
+		{`{"hotfix_id": "KB4462917"`, "osquery"},
+		{`"text"`, "osquery"},
+	} {
+		if _, err := Import(strings.NewReader(test.text), test.format); err == nil {
+			t.Errorf("Import(%q, %s) returned no error", test.text, test.format)
+		}
+	}
+}
+
+func TestProductTitle(t *testing.T) {
+	for _, test := range []struct {
+		name, build, expected string
+	}{
+		{"Microsoft Windows 10 Pro", "19045", "Windows 10"},
+		{"Microsoft Windows 10 Enterprise", "", "Windows 10"},
+		// Windows 11 hosts that report themselves as Windows 10
+		{"Microsoft Windows 10 Pro", "22000", "Windows 11"},
+		{"Microsoft Windows 10 Enterprise", "26100", "Windows 11"},
+		{"Microsoft Windows 11 Pro", "22631", "Windows 11"},
+		{"Microsoft Windows 7 Enterprise", "7601", "Windows 7"},
+		{"Microsoft Windows 8.1 Pro", "9600", "Windows 8.1"},
+		{"Microsoft Windows XP Professional", "2600", "Windows XP"},
+		{"Microsoft® Windows Vista™ Business", "6002", "Windows Vista"},
+		{"Microsoft Windows Server 2016 Standard", "14393", "Windows Server 2016"},
+		{"Microsoft Windows Server 2019 Datacenter", "17763", "Windows Server 2019"},
+		{"Microsoft Windows Server 2012 R2 Standard", "9600", "Windows Server 2012 R2"},
+		{"Microsoft(R) Windows(R) Server 2008 R2 Enterprise", "7601", "Windows Server 2008 R2"},
+		{"Microsoft Windows Server 2008 Standard", "6002", "Windows Server 2008"},
+		// servers released after the WSUS product titles stopped following the OS name
+		{"Microsoft Windows Server 2022 Standard", "20348", "Microsoft Server operating system-21H2"},
+		{"Microsoft Windows Server 2025 Datacenter", "26100", "Microsoft Server operating system-24H2"},
+		{"Red Hat  Enterprise Linux", "", "Red Hat Enterprise Linux"},
+	} {
+		if title := ProductTitle(test.name, test.build); title != test.expected {
+			t.Errorf("ProductTitle(%q, %q) = %q, expected %q", test.name, test.build, title, test.expected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/**************************************************************************************************/
+// File: inventory.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Host inventory snapshots used to assess missing updates
+/**************************************************************************************************/
+
+// Package inventory holds what is known about a Windows host, its OS product, build and
+// installed KBs, and assesses which updates it is missing without running Windows Update:
+//
+//	hosts, err := inventory.ReadHosts(f)
+//	a := inventory.NewAssessor(client)
+//	findings, err := a.Assess(ctx, hosts[0])
+package inventory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// buildVersions: Marketing version of Windows 10, Windows 11 and Windows Server builds, as used
+// in update titles ("... for Windows 10 Version 1703 ...")
+var buildVersions = map[string]string{
+	"10240": "1507",
+	"10586": "1511",
+	"14393": "1607",
+	"15063": "1703",
+	"16299": "1709",
+	"17134": "1803",
+	"17763": "1809",
+	"18362": "1903",
+	"18363": "1909",
+	"19041": "2004",
+	"19042": "20H2",
+	"19043": "21H1",
+	"19044": "21H2",
+	"19045": "22H2",
+	"20348": "21H2",
+	"22000": "21H2",
+	"22621": "22H2",
+	"22631": "23H2",
+	"26100": "24H2",
+}
+
+var kbPattern = regexp.MustCompile(`(?i)^(?:KB)?\s*(\d{5,8})$`)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Host: Inventory snapshot of one Windows host
+type Host struct {
+	Hostname     string   `json:"hostname"`
+	Product      string   `json:"product"`       // product title as in listproduct (Ex., "Windows Server 2016")
+	Version      string   `json:"version"`       // Ex., "1703" or "22H2", derived from Build if empty
+	Build        string   `json:"build"`         // Ex., "15063"
+	UBR          string   `json:"ubr"`           // update build revision (Ex., "483" of 15063.483), reported only, see Assess
+	Arch         string   `json:"arch"`          // x64, x86, arm64 or ia64
+	InstalledKbs []string `json:"installed_kbs"` // Ex., "KB4025342", kept as "4025342"
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// ReadHosts reads hosts as JSON: one object, an array of objects, or one object per line.
+// Every host is normalized and must have a product.
+func ReadHosts(r io.Reader) ([]Host, error) {
+	hosts, err := decodeHosts(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hosts {
+		hosts[i].Normalize()
+		if hosts[i].Product == "" {
+			return nil, fmt.Errorf("Host %d (%s) has no product", i+1, hosts[i].Hostname)
+		}
+	}
+	return hosts, nil
+}
+
+// decodeHosts reads hosts as JSON without normalizing them
+func decodeHosts(r io.Reader) ([]Host, error) {
+	br := bufio.NewReader(r)
+	d := json.NewDecoder(br)
+
+	var hosts []Host
+	first, err := peek(br)
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		if err := d.Decode(&hosts); err != nil {
+			return nil, fmt.Errorf("Invalid inventory: %s", err)
+		}
+		return hosts, nil
+	}
+	for {
+		var h Host
+		err := d.Decode(&h)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid inventory: %s", err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// peek returns the first byte of br that is not white space without consuming it. A UTF-8 byte
+// order mark is dropped.
+func peek(br *bufio.Reader) (byte, error) {
+	if b, err := br.Peek(3); err == nil && string(b) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return 0, errors.New("Inventory is empty")
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// NormalizeKb returns the number of a KB given as "KB4025342" or "4025342", empty if kb is not
+// a KB
+func NormalizeKb(kb string) string {
+	m := kbPattern.FindStringSubmatch(strings.TrimSpace(kb))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// NormalizeArch maps the architecture names of the different collectors to the arch column of
+// listupdate: x64, x86, arm64 or ia64
+func NormalizeArch(arch string) string {
+	a := strings.ToLower(strings.TrimSpace(arch))
+	switch {
+	case a == "":
+		return ""
+	case strings.Contains(a, "arm64") || strings.Contains(a, "aarch64") || strings.Contains(a, "arm 64"):
+		return "arm64"
+	case strings.Contains(a, "ia64") || strings.Contains(a, "itanium"):
+		return "ia64"
+	case strings.Contains(a, "64"):
+		// x64, amd64, x86_64, 64-bit, x64-based PC
+		return "x64"
+	case strings.Contains(a, "86") || strings.Contains(a, "32") || a == "i386":
+		return "x86"
+	}
+	return a
+}
+
+// Normalize cleans up the fields of h: KB numbers without the prefix and duplicates, the arch
+// as in listupdate, a build given as "10.0.15063", "6.3.9600" or "15063.483" split into Build
+// and UBR, and Version derived from Build
+func (h *Host) Normalize() {
+	h.Hostname = strings.TrimSpace(h.Hostname)
+	h.Product = strings.TrimSpace(h.Product)
+	h.Arch = NormalizeArch(h.Arch)
+
+	build := strings.TrimSpace(h.Build)
+	parts := strings.Split(build, ".")
+	if len(parts) >= 3 {
+		// major.minor.build: 10.0.15063, 10.0.15063.483 or 6.3.9600
+		parts = parts[2:]
+	}
+	if len(parts) >= 1 {
+		h.Build = parts[0]
+	}
+	if len(parts) >= 2 && h.UBR == "" {
+		h.UBR = parts[1]
+	}
+	h.UBR = strings.TrimSpace(h.UBR)
+	if h.Version == "" {
+		h.Version = buildVersions[h.Build]
+	}
+
+	seen := make(map[string]bool)
+	var kbs []string
+	for _, v := range h.InstalledKbs {
+		kb := NormalizeKb(v)
+		if kb != "" && !seen[kb] {
+			seen[kb] = true
+			kbs = append(kbs, kb)
+		}
+	}
+	h.InstalledKbs = kbs
+}
+
+// HasKb reports if the KB number kb is installed
+func (h *Host) HasKb(kb string) bool {
+	kb = NormalizeKb(kb)
+	for _, v := range h.InstalledKbs {
+		if v == kb {
+			return true
+		}
+	}
+	return false
+}
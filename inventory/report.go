@@ -0,0 +1,304 @@
+/**************************************************************************************************/
+// File: report.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Fleet compliance report summarizing the findings of many hosts
+/**************************************************************************************************/
+package inventory
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashauthority/wsusscn2cli/cvss"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// reportTemplate: Self-contained HTML page of a Report, no external style sheets or scripts
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Segoe UI, Helvetica, Arial, sans-serif; font-size: 14px; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+td.n { text-align: right; }
+.summary td { border: none; padding: 2px 16px 2px 0; }
+.critical { background: #f8d0d0; }
+.important { background: #fbe3c4; }
+.moderate { background: #fdf5c0; }
+.ok { background: #d8f0d8; }
+.hosts { max-width: 40em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table class="summary">
+<tr><td>Generated</td><td>{{.Generated.Format "2006-01-02 15:04 MST"}}</td></tr>
+<tr><td>Hosts</td><td>{{len .Hosts}}</td></tr>
+<tr><td>Compliant hosts</td><td>{{.Compliant}}</td></tr>
+<tr><td>Missing updates</td><td>{{len .Findings}}</td></tr>
+<tr><td>Distinct KBs missing</td><td>{{len .Kbs}}</td></tr>
+</table>
+
+<h2>Hosts</h2>
+<table>
+<tr><th>Host</th><th>Product</th><th>Version</th><th>Build</th><th>Missing</th><th>Critical</th><th>Important</th><th>Oldest missing</th><th>Age (days)</th><th>CVEs</th><th>Max CVSS v3</th></tr>
+{{range .Hosts}}<tr{{if eq .Missing 0}} class="ok"{{else if .MissingCritical}} class="critical"{{else if .MissingImportant}} class="important"{{end}}><td>{{.Hostname}}</td><td>{{.Product}}</td><td>{{.Version}}</td><td>{{.Build}}{{if .UBR}}.{{.UBR}}{{end}}</td><td class="n">{{.Missing}}</td><td class="n">{{.MissingCritical}}</td><td class="n">{{.MissingImportant}}</td><td>{{.OldestMissing}}</td><td class="n">{{if .OldestMissing}}{{.OldestMissingDays}}{{end}}</td><td class="n">{{.CvesExposed}}</td><td class="n">{{.MaxCvssv3BaseScore}}</td></tr>
+{{end}}</table>
+
+<h2>KBs</h2>
+<table>
+<tr><th>KB</th><th>Title</th><th>Severity</th><th>Released</th><th>Age (days)</th><th>Hosts missing</th><th>CVEs</th><th>Max CVSS v3</th><th>Hosts</th></tr>
+{{range .Kbs}}<tr class="{{lower .MsrcSeverity}}"><td>{{if .Kb}}KB{{.Kb}}{{else}}{{.UpdateUid}}{{end}}</td><td>{{.UpdateTitle}}</td><td>{{.MsrcSeverity}}</td><td>{{.UpdateCreationDate}}</td><td class="n">{{.AgeDays}}</td><td class="n">{{.HostsMissing}}</td><td class="n">{{.CveCount}}</td><td class="n">{{.MaxCvssv3BaseScore}}</td><td class="hosts">{{.Hosts}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// HostSummary: Compliance of one host, a row of the per-host report
+type HostSummary struct {
+	Hostname           string `json:"hostname"`
+	Product            string `json:"product"`
+	Version            string `json:"version"`
+	Build              string `json:"build"`
+	UBR                string `json:"ubr"`
+	Missing            int    `json:"missing"`
+	MissingCritical    int    `json:"missing_critical"`
+	MissingImportant   int    `json:"missing_important"`
+	OldestMissing      string `json:"oldest_missing"`      // creation date of the oldest missing update
+	OldestMissingDays  int    `json:"oldest_missing_days"` // its age in days
+	CvesExposed        int    `json:"cves_exposed"`        // distinct CVEs of the missing updates
+	MaxCvssv3BaseScore string `json:"max_cvssv3_base_score"`
+}
+
+// KbSummary: One KB missing on the fleet, a row of the per-KB report. An update without a KB has
+// a row of its own.
+type KbSummary struct {
+	Kb                 string `json:"kb"`
+	UpdateUid          string `json:"update_uid"` // of an update without a KB
+	UpdateTitle        string `json:"update_title"`
+	MsrcSeverity       string `json:"msrc_severity"`
+	UpdateCreationDate string `json:"update_creation_date"`
+	AgeDays            int    `json:"age_days"`
+	HostsMissing       int    `json:"hosts_missing"`
+	Hosts              string `json:"hosts"` // comma separated
+	CveCount           int    `json:"cve_count"`
+	MaxCvssv3BaseScore string `json:"max_cvssv3_base_score"`
+	Cves               string `json:"cves"` // comma separated
+}
+
+// Report: Compliance of a fleet, built by adding the findings of every host
+type Report struct {
+	Title     string
+	Generated time.Time
+	Hosts     []HostSummary
+	Kbs       []KbSummary
+	Findings  []Finding
+
+	kbs map[string]*kbData // by KB, or by update uid of updates without one
+}
+
+// kbData: What is known of a KB while the report is built
+type kbData struct {
+	summary KbSummary
+	hosts   []string
+	cves    []string
+	seen    map[string]bool // hosts and CVEs already counted
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewReport returns an empty report generated at now. Ages are counted up to now.
+func NewReport(title string, now time.Time) *Report {
+	return &Report{Title: title, Generated: now, kbs: make(map[string]*kbData)}
+}
+
+// Add adds host h and the findings of its assessment to the report
+func (r *Report) Add(h Host, findings []Finding) {
+	s := HostSummary{
+		Hostname: h.Hostname,
+		Product:  h.Product,
+		Version:  h.Version,
+		Build:    h.Build,
+		UBR:      h.UBR,
+		Missing:  len(findings),
+	}
+	cves := make(map[string]bool)
+	for _, f := range findings {
+		switch rank(f.MsrcSeverity) {
+		case severityRank["critical"]:
+			s.MissingCritical++
+		case severityRank["important"]:
+			s.MissingImportant++
+		}
+		if created := date(f.UpdateCreationDate); created != "" && (s.OldestMissing == "" || created < s.OldestMissing) {
+			s.OldestMissing = created
+			s.OldestMissingDays = r.age(f.UpdateCreationDate)
+		}
+		for _, c := range splitList(f.Cves) {
+			cves[c] = true
+		}
+		if cvss.Higher(f.MaxCvssv3BaseScore, s.MaxCvssv3BaseScore) {
+			s.MaxCvssv3BaseScore = f.MaxCvssv3BaseScore
+		}
+		r.addKb(f)
+	}
+	s.CvesExposed = len(cves)
+
+	r.Hosts = append(r.Hosts, s)
+	r.Findings = append(r.Findings, findings...)
+}
+
+// addKb counts finding f for its KB, or for its update if it has no KB
+func (r *Report) addKb(f Finding) {
+	key, uid := f.Kb, ""
+	if key == "" {
+		uid = strings.ToLower(f.UpdateUid)
+		key = "uid:" + uid
+	}
+	k, ok := r.kbs[key]
+	if !ok {
+		k = &kbData{
+			summary: KbSummary{
+				Kb:                 f.Kb,
+				UpdateUid:          uid,
+				UpdateTitle:        f.UpdateTitle,
+				MsrcSeverity:       f.MsrcSeverity,
+				UpdateCreationDate: date(f.UpdateCreationDate),
+				AgeDays:            r.age(f.UpdateCreationDate),
+			},
+			seen: make(map[string]bool),
+		}
+		r.kbs[key] = k
+	}
+	// a KB has an update per architecture, the most severe one wins
+	if rank(f.MsrcSeverity) < rank(k.summary.MsrcSeverity) {
+		k.summary.MsrcSeverity = f.MsrcSeverity
+	}
+	if !k.seen["host:"+f.Hostname] {
+		k.seen["host:"+f.Hostname] = true
+		k.hosts = append(k.hosts, f.Hostname)
+	}
+	for _, c := range splitList(f.Cves) {
+		if !k.seen["cve:"+c] {
+			k.seen["cve:"+c] = true
+			k.cves = append(k.cves, c)
+		}
+	}
+	if cvss.Higher(f.MaxCvssv3BaseScore, k.summary.MaxCvssv3BaseScore) {
+		k.summary.MaxCvssv3BaseScore = f.MaxCvssv3BaseScore
+	}
+}
+
+// Finish sorts the report: hosts with the most critical, then important, then any missing
+// updates first, and KBs by severity, then by the number of hosts missing them
+func (r *Report) Finish() {
+	r.Kbs = r.Kbs[:0]
+	for _, k := range r.kbs {
+		s := k.summary
+		sort.Strings(k.hosts)
+		sort.Strings(k.cves)
+		s.HostsMissing, s.Hosts = len(k.hosts), strings.Join(k.hosts, ",")
+		s.CveCount, s.Cves = len(k.cves), strings.Join(k.cves, ",")
+		r.Kbs = append(r.Kbs, s)
+	}
+
+	sort.SliceStable(r.Hosts, func(i, j int) bool {
+		a, b := r.Hosts[i], r.Hosts[j]
+		if a.MissingCritical != b.MissingCritical {
+			return a.MissingCritical > b.MissingCritical
+		}
+		if a.MissingImportant != b.MissingImportant {
+			return a.MissingImportant > b.MissingImportant
+		}
+		if a.Missing != b.Missing {
+			return a.Missing > b.Missing
+		}
+		return strings.ToLower(a.Hostname) < strings.ToLower(b.Hostname)
+	})
+	sort.Slice(r.Kbs, func(i, j int) bool {
+		a, b := r.Kbs[i], r.Kbs[j]
+		if ra, rb := rank(a.MsrcSeverity), rank(b.MsrcSeverity); ra != rb {
+			return ra < rb
+		}
+		if a.HostsMissing != b.HostsMissing {
+			return a.HostsMissing > b.HostsMissing
+		}
+		if a.Kb != b.Kb {
+			return a.Kb < b.Kb
+		}
+		return a.UpdateUid < b.UpdateUid
+	})
+}
+
+// Compliant returns the number of hosts missing no update
+func (r *Report) Compliant() int {
+	n := 0
+	for _, h := range r.Hosts {
+		if h.Missing == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteHTML writes the report as a self-contained HTML page. Call Finish first.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// age returns the days from an update creation date to the time of the report, 0 if unknown
+func (r *Report) age(created string) int {
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", date(created)); err != nil {
+			return 0
+		}
+	}
+	if days := int(r.Generated.Sub(t).Hours() / 24); days > 0 {
+		return days
+	}
+	return 0
+}
+
+// date returns the date part of a timestamp such as 2018-01-10T17:00:00Z
+func date(timestamp string) string {
+	if i := strings.Index(timestamp, "T"); i >= 0 {
+		return timestamp[:i]
+	}
+	return timestamp
+}
+
+// splitList splits a comma separated list, dropping empty values
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
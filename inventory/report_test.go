@@ -0,0 +1,100 @@
+/**************************************************************************************************/
+// File: report_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the fleet compliance report
+/**************************************************************************************************/
+package inventory
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func TestReport(t *testing.T) {
+	r := NewReport("Fleet", time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC))
+	r.Add(Host{Hostname: "web1", Product: "Windows Server 2016"}, []Finding{
+		{Hostname: "web1", Kb: "4462917", UpdateUid: "a-x64", MsrcSeverity: "Critical", UpdateCreationDate: "2018-10-09T17:00:00Z", MaxCvssv3BaseScore: "7.5", Cves: "CVE-2018-8453,CVE-2018-8423"},
+		{Hostname: "web1", Kb: "4457131", UpdateUid: "b-x64", MsrcSeverity: "Important", UpdateCreationDate: "2018-09-11", MaxCvssv3BaseScore: "8.8", Cves: "CVE-2018-8440"},
+		{Hostname: "web1", UpdateUid: "C-NOKB", MsrcSeverity: "Moderate", UpdateCreationDate: "2018-08-14"},
+	})
+	r.Add(Host{Hostname: "db1", Product: "Windows Server 2016"}, []Finding{
+		// the x86 update of the same KB, less severe
+		{Hostname: "db1", Kb: "4462917", UpdateUid: "a-x86", MsrcSeverity: "Important", UpdateCreationDate: "2018-10-09T17:00:00Z", MaxCvssv3BaseScore: "9.8", Cves: "CVE-2018-8453, CVE-2018-8174"},
+		{Hostname: "db1", UpdateUid: "d-nokb", MsrcSeverity: "Moderate", UpdateCreationDate: "2018-07-10"},
+		{Hostname: "db1", Kb: "4462917", UpdateUid: "a-x86", MsrcSeverity: "Important", UpdateCreationDate: "2018-10-09T17:00:00Z"},
+	})
+	r.Add(Host{Hostname: "app1", Product: "Windows 10"}, []Finding{
+		{Hostname: "app1", UpdateUid: "c-nokb", MsrcSeverity: "Moderate", UpdateCreationDate: "2018-08-14"},
+	})
+	r.Add(Host{Hostname: "Ok1", Product: "Windows 10"}, nil)
+	r.Finish()
+
+	// hosts by missing critical, important, any, then by name
+	var hosts []string
+	for _, h := range r.Hosts {
+		hosts = append(hosts, h.Hostname)
+	}
+	if expected := []string{"web1", "db1", "app1", "Ok1"}; !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Hosts %v, expected %v", hosts, expected)
+	}
+	web1 := HostSummary{Hostname: "web1", Product: "Windows Server 2016", Missing: 3, MissingCritical: 1, MissingImportant: 1,
+		OldestMissing: "2018-08-14", OldestMissingDays: 56, CvesExposed: 3, MaxCvssv3BaseScore: "8.8"}
+	if r.Hosts[0] != web1 {
+		t.Errorf("Host %+v, expected %+v", r.Hosts[0], web1)
+	}
+	if h := r.Hosts[1]; h.Missing != 3 || h.MissingImportant != 2 || h.OldestMissingDays != 91 || h.CvesExposed != 2 {
+		t.Errorf("Host %+v", h)
+	}
+	if r.Compliant() != 1 || len(r.Findings) != 7 {
+		t.Errorf("%d compliant hosts and %d findings, expected 1 and 7", r.Compliant(), len(r.Findings))
+	}
+
+	// KBs by severity, then hosts missing, and updates without a KB each on their own row
+	expected := []KbSummary{
+		{Kb: "4462917", UpdateCreationDate: "2018-10-09", MsrcSeverity: "Critical", HostsMissing: 2, Hosts: "db1,web1",
+			CveCount: 3, Cves: "CVE-2018-8174,CVE-2018-8423,CVE-2018-8453", MaxCvssv3BaseScore: "9.8"},
+		{Kb: "4457131", UpdateCreationDate: "2018-09-11", AgeDays: 28, MsrcSeverity: "Important", HostsMissing: 1, Hosts: "web1",
+			CveCount: 1, Cves: "CVE-2018-8440", MaxCvssv3BaseScore: "8.8"},
+		{UpdateUid: "c-nokb", UpdateCreationDate: "2018-08-14", AgeDays: 56, MsrcSeverity: "Moderate", HostsMissing: 2, Hosts: "app1,web1"},
+		{UpdateUid: "d-nokb", UpdateCreationDate: "2018-07-10", AgeDays: 91, MsrcSeverity: "Moderate", HostsMissing: 1, Hosts: "db1"},
+	}
+	if !reflect.DeepEqual(r.Kbs, expected) {
+		t.Errorf("KBs\n%+v\nexpected\n%+v", r.Kbs, expected)
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteHTML(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"<td>KB4462917</td>", "<td>c-nokb</td>", "<td>Generated</td><td>2018-10-09 12:00 UTC</td>"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("HTML has no %s", s)
+		}
+	}
+}
+
+func TestReportAge(t *testing.T) {
+	r := NewReport("", time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC))
+	for created, expected := range map[string]int{
+		"2018-10-08T12:00:00Z": 1,
+		"2018-10-08T13:00:00Z": 0,
+		"2018-10-09T17:00:00Z": 0, // released later the same day
+		"2018-01-09":           273,
+		"2017-10-09T00:00:00":  365,
+		"":                     0,
+		"unknown":              0,
+	} {
+		if days := r.age(created); days != expected {
+			t.Errorf("Age of %q is %d days, expected %d", created, days, expected)
+		}
+	}
+}
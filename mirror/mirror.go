@@ -0,0 +1,289 @@
+/**************************************************************************************************/
+// File: mirror.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Local SQLite copy of the wsusscn2.cab API records
+/**************************************************************************************************/
+
+// Package mirror keeps a local SQLite copy of the wsusscn2.cab API records.
+//
+// A mirror is filled with Sync and then answers the same filters as the API, so the list
+// commands can run offline:
+//
+//	db, err := mirror.Create("wsusscn2cli.db")
+//	stats, err := db.Sync(ctx, client, mirror.SyncOptions{})
+//	it := db.Updates(ctx, wsusscn2.UpdateFilter{Kb: []string{"4025339"}})
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// readOnlyDriver: SQLite driver of the mirrors opened with Open. mode=ro does not cover ATTACH,
+// which would let a query create and write other database files, so its connections refuse it.
+const readOnlyDriver = "sqlite3_mirror_ro"
+
+const (
+	updatesTable         = "updates"
+	cvesTable            = "cves"
+	supersedeTable       = "supersede"
+	productsTable        = "products"
+	productFamiliesTable = "product_families"
+	classificationsTable = "classifications"
+)
+
+var tables = []table{
+	{updatesTable, wsusscn2.Update{}, "update_uid, product_title", []string{"kb", "update_creation_date"}},
+	{cvesTable, wsusscn2.Cve{}, "cve, update_uid, product_title", []string{"update_uid", "kb"}},
+	{supersedeTable, wsusscn2.UpdateSupersede{}, "update_uid, product_title, super_uid, super_product_title", []string{"super_uid"}},
+	{productsTable, wsusscn2.Product{}, "product_uid", nil},
+	{productFamiliesTable, wsusscn2.ProductFamily{}, "product_family_uid", nil},
+	{classificationsTable, wsusscn2.Classification{}, "classification_uid", nil},
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// DB: Open mirror database. DB implements wsusscn2.Source and is safe for concurrent use.
+type DB struct {
+	db     *sql.DB
+	Logger *log.Logger // sync progress, nil for the standard logger
+}
+
+// table: Mirror table holding one record type. Columns are the json tags of the record.
+type table struct {
+	name    string
+	record  interface{}
+	key     string // primary key columns
+	indexes []string
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func init() {
+	sql.Register(readOnlyDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			conn.RegisterAuthorizer(func(action int, arg1 string, arg2 string, arg3 string) int {
+				if action == sqlite3.SQLITE_ATTACH {
+					return sqlite3.SQLITE_DENY
+				}
+				return sqlite3.SQLITE_OK
+			})
+			return nil
+		},
+	})
+}
+
+// Open opens an existing mirror at path read-only. The mirror must have completed a sync.
+func Open(path string) (*DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("Unable to open mirror %s, run sync first: %s", path, err)
+	}
+	db, err := sql.Open(readOnlyDriver, "file:"+path+"?mode=ro&_query_only=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	m := &DB{db: db}
+	synced, err := m.State(stateSyncedAt)
+	if err == nil && synced == "" {
+		err = errors.New("no sync completed")
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to open mirror %s, run sync first: %s", path, err)
+	}
+	return m, nil
+}
+
+// Create opens the mirror at path for writing, creating the file and its tables if needed
+func Create(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	m := &DB{db: db}
+	if err := m.createSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to open mirror %s: %s", path, err)
+	}
+	return m, nil
+}
+
+// NewMemory returns an empty mirror held in memory, to be filled with Load
+func NewMemory() (*DB, error) {
+	db, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		return nil, err
+	}
+	// every connection would open its own empty database
+	db.SetMaxOpenConns(1)
+	m := &DB{db: db}
+	if err := m.createSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load adds records, a slice of one of the wsusscn2 record types, to the mirror
+func (m *DB) Load(ctx context.Context, records interface{}) error {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("mirror: Load of %s", v.Type())
+	}
+	for _, t := range tables {
+		if reflect.TypeOf(t.record) != v.Type().Elem() {
+			continue
+		}
+		return m.replace(ctx, t.name, false, func(stmt *sql.Stmt) error {
+			for i := 0; i < v.Len(); i++ {
+				if err := insert(ctx, stmt, v.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return fmt.Errorf("mirror: No table for %s", v.Type())
+}
+
+// Close closes the database
+func (m *DB) Close() error {
+	return m.db.Close()
+}
+
+func (m *DB) logf(format string, v ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+func (m *DB) createSchema() error {
+	stmts := []string{"CREATE TABLE IF NOT EXISTS sync_state (name TEXT PRIMARY KEY, value TEXT)"}
+	for _, t := range tables {
+		var defs []string
+		for _, c := range columns(t.record) {
+			defs = append(defs, c+" TEXT")
+		}
+		stmts = append(stmts, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s, PRIMARY KEY (%s))", t.name, strings.Join(defs, ", "), t.key))
+		for _, c := range t.indexes {
+			stmts = append(stmts, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s ON %s (%s)", t.name, c, t.name, c))
+		}
+	}
+	for _, s := range stmts {
+		if _, err := m.db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columns returns the column names of a record struct, its json tags in field order
+func columns(record interface{}) []string {
+	t := reflect.TypeOf(record)
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return names
+}
+
+// fields returns pointers to the fields of the struct record points to, for Scan
+func fields(record interface{}) []interface{} {
+	v := reflect.ValueOf(record).Elem()
+	ptrs := make([]interface{}, v.NumField())
+	for i := range ptrs {
+		ptrs[i] = v.Field(i).Addr().Interface()
+	}
+	return ptrs
+}
+
+// selectColumns returns "SELECT <columns of record> FROM name"
+func selectColumns(name string, record interface{}) string {
+	return "SELECT " + strings.Join(columns(record), ", ") + " FROM " + name
+}
+
+// queryRecords runs query and appends every row to the slice records points to
+func (m *DB) queryRecords(ctx context.Context, records interface{}, query string, args ...interface{}) error {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	slice := reflect.ValueOf(records).Elem()
+	for rows.Next() {
+		record := reflect.New(slice.Type().Elem())
+		if err := rows.Scan(fields(record.Interface())...); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, record.Elem()))
+	}
+	return rows.Err()
+}
+
+// insertStmt prepares an upsert of records of t within tx
+func insertStmt(ctx context.Context, tx *sql.Tx, t table) (*sql.Stmt, error) {
+	cols := columns(t.record)
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	return tx.PrepareContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)", t.name, strings.Join(cols, ", "), marks))
+}
+
+// insert runs stmt with the fields of record
+func insert(ctx context.Context, stmt *sql.Stmt, record interface{}) error {
+	v := reflect.ValueOf(record)
+	values := make([]interface{}, v.NumField())
+	for i := range values {
+		values[i] = v.Field(i).Interface()
+	}
+	_, err := stmt.ExecContext(ctx, values...)
+	return err
+}
+
+// tableNamed returns the mirror table called name
+func tableNamed(name string) table {
+	for _, t := range tables {
+		if t.name == name {
+			return t
+		}
+	}
+	panic("mirror: unknown table " + name)
+}
+
+// State returns a value recorded by Sync (Ex., "update_creation_date", "synced_at"), or "" if
+// it was never set
+func (m *DB) State(name string) (string, error) {
+	var value string
+	err := m.db.QueryRow("SELECT value FROM sync_state WHERE name = ?", name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (m *DB) setState(ctx context.Context, name string, value string) error {
+	_, err := m.db.ExecContext(ctx, "INSERT OR REPLACE INTO sync_state (name, value) VALUES (?, ?)", name, value)
+	return err
+}
@@ -0,0 +1,154 @@
+/**************************************************************************************************/
+// File: mirror_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Fake source and temporary mirrors shared by the mirror tests
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// fakeSource: Source of fixed records. Updates honors UpdateCreationDateAfter and Cves UpdateUid,
+// the filters Sync uses, and both record them.
+type fakeSource struct {
+	updates    []wsusscn2.Update
+	supersedes []wsusscn2.UpdateSupersede
+	cves       []wsusscn2.Cve
+	cveErr     error // error of Cves
+
+	since   []string   // UpdateCreationDateAfter of each Updates call
+	cveUids [][]string // UpdateUid of each Cves call
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// bounds returns the slice bounds of the page at offset of n records
+func bounds(n int, limit int, offset int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	if offset+limit > n {
+		return offset, n
+	}
+	return offset, offset + limit
+}
+
+func (s *fakeSource) ListClassifications(ctx context.Context) ([]wsusscn2.Classification, error) {
+	return []wsusscn2.Classification{{ClassificationUid: "0fa1201d", ClassificationTitle: "Security Updates"}}, nil
+}
+
+func (s *fakeSource) ListProducts(ctx context.Context) ([]wsusscn2.Product, error) {
+	return []wsusscn2.Product{{ProductUid: "a3c2375d", ProductTitle: "Windows 10"}, {ProductUid: "569e8e8f", ProductTitle: "Windows Server 2016"}}, nil
+}
+
+func (s *fakeSource) ListProductFamilies(ctx context.Context) ([]wsusscn2.ProductFamily, error) {
+	return []wsusscn2.ProductFamily{{ProductFamilyUid: "6964aab4", ProductFamilyTitle: "Windows"}}, nil
+}
+
+func (s *fakeSource) Updates(ctx context.Context, f wsusscn2.UpdateFilter) *wsusscn2.UpdateIterator {
+	s.since = append(s.since, f.UpdateCreationDateAfter)
+	var records []wsusscn2.Update
+	for _, u := range s.updates {
+		if f.UpdateCreationDateAfter == "" || u.UpdateCreationDate[:10] > f.UpdateCreationDateAfter {
+			records = append(records, u)
+		}
+	}
+	return wsusscn2.NewUpdateIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.Update, error) {
+		start, end := bounds(len(records), limit, offset)
+		return records[start:end], nil
+	})
+}
+
+func (s *fakeSource) Supersedes(ctx context.Context, f wsusscn2.UpdateFilter) *wsusscn2.SupersedeIterator {
+	records := s.supersedes
+	return wsusscn2.NewSupersedeIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.UpdateSupersede, error) {
+		start, end := bounds(len(records), limit, offset)
+		return records[start:end], nil
+	})
+}
+
+func (s *fakeSource) Cves(ctx context.Context, f wsusscn2.CveFilter) *wsusscn2.CveIterator {
+	s.cveUids = append(s.cveUids, f.UpdateUid)
+	if s.cveErr != nil {
+		return wsusscn2.ErrCveIterator(s.cveErr)
+	}
+	var records []wsusscn2.Cve
+	for _, c := range s.cves {
+		if len(f.UpdateUid) == 0 || strings.Contains(" "+strings.Join(f.UpdateUid, " ")+" ", " "+c.UpdateUid+" ") {
+			records = append(records, c)
+		}
+	}
+	return wsusscn2.NewCveIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.Cve, error) {
+		start, end := bounds(len(records), limit, offset)
+		return records[start:end], nil
+	})
+}
+
+// tempMirror creates an empty mirror in a temporary directory and returns it with its path.
+// cleanup closes it and removes the directory.
+func tempMirror(t *testing.T) (m *DB, path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "wsusscn2cli.db")
+	m, err = Create(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	m.Logger = log.New(ioutil.Discard, "", 0)
+	return m, path, func() {
+		m.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// count returns the number of rows of table name
+func count(t *testing.T, m *DB, name string) int {
+	var n int
+	if err := m.db.QueryRow("SELECT count(*) FROM " + name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestOpen(t *testing.T) {
+	m, path, cleanup := tempMirror(t)
+	defer cleanup()
+
+	if _, err := Open(path + ".missing"); err == nil || !strings.Contains(err.Error(), "run sync first") {
+		t.Errorf("Open of a missing file: %v", err)
+	}
+	// the tables exist, but no sync completed
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "run sync first") {
+		t.Errorf("Open before a sync: %v", err)
+	}
+
+	if _, err := m.Sync(context.Background(), &fakeSource{}, SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open after a sync: %s", err)
+	}
+	db.Close()
+}
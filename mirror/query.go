@@ -0,0 +1,253 @@
+/**************************************************************************************************/
+// File: query.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: wsusscn2 filters answered from the mirror tables
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// where: Conditions of a WHERE clause and their arguments, ANDed together
+type where struct {
+	conds []string
+	args  []interface{}
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+var _ wsusscn2.Source = (*DB)(nil)
+
+// String returns the clause including the WHERE keyword, or "" without conditions
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// page returns the arguments followed by limit and offset, for a query ending in LIMIT ? OFFSET ?
+func (w *where) page(limit int, offset int) []interface{} {
+	return append(append([]interface{}(nil), w.args...), limit, offset)
+}
+
+// in matches col against any of values, ignoring case
+func (w *where) in(col string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	w.conds = append(w.conds, fmt.Sprintf("%s COLLATE NOCASE IN (%s)", col, marks))
+	for _, v := range values {
+		w.args = append(w.args, v)
+	}
+}
+
+// like matches col containing any of values
+func (w *where) like(col string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	var conds []string
+	for _, v := range values {
+		conds = append(conds, col+" LIKE ?")
+		w.args = append(w.args, "%"+v+"%")
+	}
+	w.conds = append(w.conds, "("+strings.Join(conds, " OR ")+")")
+}
+
+// boolean matches col against a 0/1, t/f, true/false value
+func (w *where) boolean(col string, value string) error {
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("Unable to parse %s. Expected: 0/1, t/f, true/false. Found %s", col, value)
+	}
+	if b {
+		w.conds = append(w.conds, "lower("+col+") IN ('1', 't', 'true')")
+	} else {
+		w.conds = append(w.conds, "lower("+col+") IN ('0', 'f', 'false')")
+	}
+	return nil
+}
+
+// date compares the date part of col with value using op
+func (w *where) date(col string, op string, value string) {
+	if value == "" {
+		return
+	}
+	if strings.ToLower(value) == "today" {
+		value = time.Now().Format("2006-01-02")
+	}
+	w.conds = append(w.conds, fmt.Sprintf("substr(%s, 1, 10) %s ?", col, op))
+	w.args = append(w.args, value)
+}
+
+// score matches col against a score or a range of scores (Ex., 7.1-10.0)
+func (w *where) score(col string, value string) error {
+	if value == "" {
+		return nil
+	}
+	low, high := value, value
+	if i := strings.Index(value, "-"); i > 0 {
+		low, high = value[:i], value[i+1:]
+	}
+	lo, err1 := strconv.ParseFloat(strings.TrimSpace(low), 64)
+	hi, err2 := strconv.ParseFloat(strings.TrimSpace(high), 64)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("Unable to parse %s. Expected: score or range (Ex., 7.1-10.0). Found %s", col, value)
+	}
+	w.conds = append(w.conds, fmt.Sprintf("CAST(%s AS REAL) BETWEEN ? AND ?", col))
+	w.args = append(w.args, lo, hi)
+	return nil
+}
+
+// updateWhere translates f to conditions on the updates table
+func updateWhere(f wsusscn2.UpdateFilter) (*where, error) {
+	if _, err := f.Values(); err != nil {
+		return nil, err
+	}
+	w := &where{}
+	w.in("product_title", f.ProductTitle)
+	w.in("update_uid", f.UpdateUid)
+	w.like("update_title", f.UpdateTitle)
+	w.in("kb", f.Kb)
+	w.in("update_type", f.UpdateType)
+	w.in("product_family_title", f.ProductFamilyTitle)
+	w.in("classification_title", f.ClassificationTitle)
+	w.in("msrc_severity", f.MsrcSeverity)
+	w.in("arch", f.Arch)
+	for _, b := range []struct{ col, value string }{
+		{"is_superseded", f.IsSuperseded},
+		{"is_bundled", f.IsBundled},
+		{"is_public", f.IsPublic},
+		{"is_beta", f.IsBeta},
+	} {
+		if err := w.boolean(b.col, b.value); err != nil {
+			return nil, err
+		}
+	}
+	w.date("update_creation_date", ">", f.UpdateCreationDateAfter)
+	w.date("update_creation_date", "<", f.UpdateCreationDateBefore)
+	w.date("update_creation_date", "=", f.UpdateCreationDateOn)
+	return w, nil
+}
+
+// cveWhere translates f to conditions on the cves table
+func cveWhere(f wsusscn2.CveFilter) (*where, error) {
+	w := &where{}
+	w.in("cve", f.Cve)
+	w.in("product_title", f.ProductTitle)
+	w.in("update_uid", f.UpdateUid)
+	w.like("update_title", f.UpdateTitle)
+	w.in("kb", f.Kb)
+	w.in("product_family_title", f.ProductFamilyTitle)
+	w.in("classification_title", f.ClassificationTitle)
+	w.in("msrc_severity", f.MsrcSeverity)
+	w.in("arch", f.Arch)
+	if err := w.boolean("is_superseded", f.IsSuperseded); err != nil {
+		return nil, err
+	}
+	if err := w.boolean("is_in_file", f.IsInFile); err != nil {
+		return nil, err
+	}
+	if err := w.score("cvssv3_base_score", f.Cvssv3BaseScore); err != nil {
+		return nil, err
+	}
+	if err := w.score("cvssv3_temporal_score", f.Cvssv3TemporalScore); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListClassifications returns every classification in the mirror
+func (m *DB) ListClassifications(ctx context.Context) ([]wsusscn2.Classification, error) {
+	var records []wsusscn2.Classification
+	err := m.queryRecords(ctx, &records, selectColumns(classificationsTable, wsusscn2.Classification{})+" ORDER BY classification_title")
+	return records, err
+}
+
+// ListProducts returns every product in the mirror
+func (m *DB) ListProducts(ctx context.Context) ([]wsusscn2.Product, error) {
+	var records []wsusscn2.Product
+	err := m.queryRecords(ctx, &records, selectColumns(productsTable, wsusscn2.Product{})+" ORDER BY product_title")
+	return records, err
+}
+
+// ListProductFamilies returns every product family in the mirror
+func (m *DB) ListProductFamilies(ctx context.Context) ([]wsusscn2.ProductFamily, error) {
+	var records []wsusscn2.ProductFamily
+	err := m.queryRecords(ctx, &records, selectColumns(productFamiliesTable, wsusscn2.ProductFamily{})+" ORDER BY product_family_title")
+	return records, err
+}
+
+// Updates returns an iterator over the updates matching f, newest first
+func (m *DB) Updates(ctx context.Context, f wsusscn2.UpdateFilter) *wsusscn2.UpdateIterator {
+	w, err := updateWhere(f)
+	if err != nil {
+		return wsusscn2.ErrUpdateIterator(err)
+	}
+	query := selectColumns(updatesTable, wsusscn2.Update{}) + w.String() +
+		" ORDER BY update_creation_date DESC, update_uid, product_title LIMIT ? OFFSET ?"
+
+	return wsusscn2.NewUpdateIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.Update, error) {
+		var records []wsusscn2.Update
+		err := m.queryRecords(ctx, &records, query, w.page(limit, offset)...)
+		return records, err
+	})
+}
+
+// Supersedes returns an iterator over the supersede records of the updates matching f
+func (m *DB) Supersedes(ctx context.Context, f wsusscn2.UpdateFilter) *wsusscn2.SupersedeIterator {
+	w, err := updateWhere(f)
+	if err != nil {
+		return wsusscn2.ErrSupersedeIterator(err)
+	}
+	query := selectColumns(supersedeTable, wsusscn2.UpdateSupersede{})
+	if len(w.conds) > 0 {
+		// the filters may use any update field, look them up on the superseded update
+		query += " WHERE EXISTS (SELECT 1 FROM updates" + w.String() +
+			" AND updates.update_uid = supersede.update_uid AND updates.product_title = supersede.product_title)"
+	}
+	query += " ORDER BY update_creation_date DESC, update_uid, product_title, super_uid, super_product_title LIMIT ? OFFSET ?"
+
+	return wsusscn2.NewSupersedeIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.UpdateSupersede, error) {
+		var records []wsusscn2.UpdateSupersede
+		err := m.queryRecords(ctx, &records, query, w.page(limit, offset)...)
+		return records, err
+	})
+}
+
+// Cves returns an iterator over the cve records matching f
+func (m *DB) Cves(ctx context.Context, f wsusscn2.CveFilter) *wsusscn2.CveIterator {
+	w, err := cveWhere(f)
+	if err != nil {
+		return wsusscn2.ErrCveIterator(err)
+	}
+	query := selectColumns(cvesTable, wsusscn2.Cve{}) + w.String() +
+		" ORDER BY cve DESC, update_uid, product_title LIMIT ? OFFSET ?"
+
+	return wsusscn2.NewCveIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]wsusscn2.Cve, error) {
+		var records []wsusscn2.Cve
+		err := m.queryRecords(ctx, &records, query, w.page(limit, offset)...)
+		return records, err
+	})
+}
@@ -0,0 +1,163 @@
+/**************************************************************************************************/
+// File: query_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of answering the wsusscn2 filters from the mirror tables
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// loadMirror returns a mirror in memory holding records, slices of the wsusscn2 record types
+func loadMirror(t *testing.T, records ...interface{}) *DB {
+	m, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := m.Load(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestUpdateWhere(t *testing.T) {
+	for _, test := range []struct {
+		filter wsusscn2.UpdateFilter
+		where  string
+		args   []interface{}
+	}{
+		{wsusscn2.UpdateFilter{}, "", nil},
+		{
+			wsusscn2.UpdateFilter{ProductTitle: []string{"Windows 10", "Windows 8.1"}, Kb: []string{"4025342"}},
+			" WHERE product_title COLLATE NOCASE IN (?, ?) AND kb COLLATE NOCASE IN (?)",
+			[]interface{}{"Windows 10", "Windows 8.1", "4025342"},
+		},
+		{
+			wsusscn2.UpdateFilter{UpdateTitle: []string{"Cumulative", "Servicing Stack"}, Arch: []string{"x64"}},
+			" WHERE (update_title LIKE ? OR update_title LIKE ?) AND arch COLLATE NOCASE IN (?)",
+			[]interface{}{"%Cumulative%", "%Servicing Stack%", "x64"},
+		},
+		{
+			wsusscn2.UpdateFilter{IsSuperseded: "0", IsBeta: "true"},
+			" WHERE lower(is_superseded) IN ('0', 'f', 'false') AND lower(is_beta) IN ('1', 't', 'true')",
+			nil,
+		},
+		{
+			wsusscn2.UpdateFilter{UpdateCreationDateAfter: "2018-01-01", UpdateCreationDateBefore: "2018-02-01", UpdateCreationDateOn: "2018-01-09"},
+			" WHERE substr(update_creation_date, 1, 10) > ? AND substr(update_creation_date, 1, 10) < ? AND substr(update_creation_date, 1, 10) = ?",
+			[]interface{}{"2018-01-01", "2018-02-01", "2018-01-09"},
+		},
+	} {
+		w, err := updateWhere(test.filter)
+		if err != nil {
+			t.Errorf("%+v: %s", test.filter, err)
+			continue
+		}
+		if w.String() != test.where || !reflect.DeepEqual(w.args, test.args) {
+			t.Errorf("%+v translated to %q %v, expected %q %v", test.filter, w.String(), w.args, test.where, test.args)
+		}
+	}
+
+	for _, f := range []wsusscn2.UpdateFilter{{IsSuperseded: "maybe"}, {UpdateCreationDateAfter: "01/01/2018"}} {
+		if _, err := updateWhere(f); err == nil {
+			t.Errorf("%+v translated without an error", f)
+		}
+	}
+}
+
+func TestCveWhere(t *testing.T) {
+	w, err := cveWhere(wsusscn2.CveFilter{Cve: []string{"CVE-2018-8174"}, IsInFile: "t", Cvssv3BaseScore: "7.1-10.0", Cvssv3TemporalScore: "7.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	where := " WHERE cve COLLATE NOCASE IN (?) AND lower(is_in_file) IN ('1', 't', 'true')" +
+		" AND CAST(cvssv3_base_score AS REAL) BETWEEN ? AND ? AND CAST(cvssv3_temporal_score AS REAL) BETWEEN ? AND ?"
+	args := []interface{}{"CVE-2018-8174", 7.1, 10.0, 7.5, 7.5}
+	if w.String() != where || !reflect.DeepEqual(w.args, args) {
+		t.Errorf("Translated to %q %v, expected %q %v", w.String(), w.args, where, args)
+	}
+
+	for _, f := range []wsusscn2.CveFilter{{Cvssv3BaseScore: "high"}, {Cvssv3BaseScore: "7.0-"}, {IsSuperseded: "yes"}} {
+		if _, err := cveWhere(f); err == nil {
+			t.Errorf("%+v translated without an error", f)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	ctx := context.Background()
+	m := loadMirror(t,
+		[]wsusscn2.Update{
+			{UpdateUid: "a", Kb: "4025342", ProductTitle: "Windows 10", UpdateTitle: "2017-07 Cumulative Update for Windows 10 Version 1703", Arch: "x64", IsSuperseded: "true", UpdateCreationDate: "2017-07-11T17:00:00Z"},
+			{UpdateUid: "b", Kb: "4034674", ProductTitle: "Windows 10", UpdateTitle: "2017-08 Cumulative Update for Windows 10 Version 1703", Arch: "x86", IsSuperseded: "0", UpdateCreationDate: "2017-08-08T17:00:00Z"},
+			{UpdateUid: "c", Kb: "4025339", ProductTitle: "Windows Server 2016", UpdateTitle: "2017-07 Cumulative Update for Windows Server 2016", Arch: "x64", IsSuperseded: "False", UpdateCreationDate: "2017-07-11T17:00:00Z"},
+		},
+		[]wsusscn2.UpdateSupersede{
+			{UpdateUid: "a", ProductTitle: "Windows 10", IsSuperseded: "true", SuperUpdateUid: "b", SuperProductTitle: "Windows 10"},
+			{UpdateUid: "c", ProductTitle: "Windows Server 2016", IsSuperseded: "true", SuperUpdateUid: "d", SuperProductTitle: "Windows Server 2016"},
+		},
+		[]wsusscn2.Cve{
+			{Cve: "CVE-2017-8589", UpdateUid: "a", ProductTitle: "Windows 10", Cvssv3BaseScore: "8.8"},
+			{Cve: "CVE-2017-8620", UpdateUid: "b", ProductTitle: "Windows 10", Cvssv3BaseScore: "8.1"},
+			{Cve: "CVE-2017-8463", UpdateUid: "c", ProductTitle: "Windows Server 2016", Cvssv3BaseScore: "5.5"},
+		},
+	)
+	defer m.Close()
+
+	for _, test := range []struct {
+		filter   wsusscn2.UpdateFilter
+		expected []string
+	}{
+		// newest first
+		{wsusscn2.UpdateFilter{}, []string{"b", "a", "c"}},
+		{wsusscn2.UpdateFilter{ProductTitle: []string{"windows 10"}}, []string{"b", "a"}},
+		{wsusscn2.UpdateFilter{UpdateTitle: []string{"server"}}, []string{"c"}},
+		{wsusscn2.UpdateFilter{Kb: []string{"4025342", "4025339"}, Arch: []string{"X64"}}, []string{"a", "c"}},
+		{wsusscn2.UpdateFilter{IsSuperseded: "false"}, []string{"b", "c"}},
+		{wsusscn2.UpdateFilter{IsSuperseded: "1"}, []string{"a"}},
+		{wsusscn2.UpdateFilter{UpdateCreationDateAfter: "2017-07-11"}, []string{"b"}},
+		{wsusscn2.UpdateFilter{UpdateCreationDateBefore: "2017-08-08"}, []string{"a", "c"}},
+		{wsusscn2.UpdateFilter{UpdateCreationDateOn: "2017-07-11"}, []string{"a", "c"}},
+	} {
+		if uids := syncUids(t, m, test.filter); !reflect.DeepEqual(uids, test.expected) {
+			t.Errorf("%+v matched %v, expected %v", test.filter, uids, test.expected)
+		}
+	}
+
+	// the filters of supersede records apply to the superseded update
+	it := m.Supersedes(ctx, wsusscn2.UpdateFilter{Arch: []string{"x64"}, ProductTitle: []string{"Windows 10"}})
+	var supers []string
+	for it.Next() {
+		supers = append(supers, it.Supersede().SuperUpdateUid)
+	}
+	if it.Err() != nil || !reflect.DeepEqual(supers, []string{"b"}) {
+		t.Errorf("Supersede records %v, %v, expected the one of a", supers, it.Err())
+	}
+
+	cit := m.Cves(ctx, wsusscn2.CveFilter{Cvssv3BaseScore: "8.0-10.0"})
+	var cves []string
+	for cit.Next() {
+		cves = append(cves, cit.Cve().Cve)
+	}
+	if cit.Err() != nil || !reflect.DeepEqual(cves, []string{"CVE-2017-8620", "CVE-2017-8589"}) {
+		t.Errorf("CVEs scored 8.0 to 10.0 %v, %v", cves, cit.Err())
+	}
+
+	// a filter that does not translate stops the iterator
+	if it := m.Updates(ctx, wsusscn2.UpdateFilter{IsBeta: "maybe"}); it.Next() || it.Err() == nil {
+		t.Errorf("Invalid filter returned no error")
+	}
+}
@@ -0,0 +1,115 @@
+/**************************************************************************************************/
+// File: sql.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Ad-hoc SQL queries against the mirror tables
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Rows: Result of Query with every value formatted as a string
+type Rows struct {
+	rows    *sql.Rows
+	columns []string
+	scan    []interface{}
+	values  []string
+	err     error
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Query runs an SQL query against the mirror. The tables are updates, cves, supersede,
+// products, product_families and classifications, with one column per json field of the
+// matching wsusscn2 record. A mirror opened with Open is read-only, so statements that write
+// or ATTACH other databases fail.
+func (m *DB) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	r := &Rows{rows: rows, columns: columns, scan: make([]interface{}, len(columns)), values: make([]string, len(columns))}
+	for i := range r.scan {
+		r.scan[i] = new(interface{})
+	}
+	return r, nil
+}
+
+// Columns returns the column names of the result
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Next advances to the next row. It returns false when there are no more rows or an error
+// occurred, check Err afterwards.
+func (r *Rows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	if r.err = r.rows.Scan(r.scan...); r.err != nil {
+		return false
+	}
+	for i, v := range r.scan {
+		r.values[i] = formatValue(*(v.(*interface{})))
+	}
+	return true
+}
+
+// Values returns the current row. The returned slice is reused by the next call.
+func (r *Rows) Values() []string {
+	return r.values
+}
+
+// Err returns the error that stopped the iteration, if any
+func (r *Rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close releases the result
+func (r *Rows) Close() error {
+	return r.rows.Close()
+}
+
+// formatValue formats a value returned by the driver, NULL as ""
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
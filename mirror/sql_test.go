@@ -0,0 +1,111 @@
+/**************************************************************************************************/
+// File: sql_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of ad-hoc SQL queries against a mirror opened read-only
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// readOnlyMirror returns a synced mirror of one update opened with Open, and its path
+func readOnlyMirror(t *testing.T) (*DB, string, func()) {
+	m, path, cleanup := tempMirror(t)
+	src := &fakeSource{updates: []wsusscn2.Update{{UpdateUid: "u1", Kb: "4025342", ProductTitle: "Windows 10", UpdateCreationDate: "2017-07-11T17:00:00Z"}}}
+	if _, err := m.Sync(context.Background(), src, SyncOptions{}); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return db, path, func() {
+		db.Close()
+		cleanup()
+	}
+}
+
+// queryAll runs query and returns its rows
+func queryAll(m *DB, query string) ([][]string, error) {
+	rows, err := m.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values [][]string
+	for rows.Next() {
+		values = append(values, append([]string(nil), rows.Values()...))
+	}
+	return values, rows.Err()
+}
+
+func TestQuery(t *testing.T) {
+	m, _, cleanup := readOnlyMirror(t)
+	defer cleanup()
+
+	rows, err := m.Query(context.Background(), "SELECT kb, count(*) AS n, 2.5 AS score, NULL AS none, x'4b42' AS blob FROM updates GROUP BY kb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if columns := rows.Columns(); !reflect.DeepEqual(columns, []string{"kb", "n", "score", "none", "blob"}) {
+		t.Errorf("Columns %v", columns)
+	}
+	if !rows.Next() {
+		t.Fatalf("No row: %v", rows.Err())
+	}
+	// every type is formatted as a string, NULL as ""
+	if values := rows.Values(); !reflect.DeepEqual(values, []string{"4025342", "1", "2.5", "", "KB"}) {
+		t.Errorf("Values %q", values)
+	}
+	if rows.Next() || rows.Err() != nil {
+		t.Errorf("More than one row or %v", rows.Err())
+	}
+
+	if _, err := m.Query(context.Background(), "SELECT * FROM nothing"); err == nil {
+		t.Errorf("Query of an unknown table returned no error")
+	}
+}
+
+func TestQueryReadOnly(t *testing.T) {
+	m, path, cleanup := readOnlyMirror(t)
+	defer cleanup()
+	other := filepath.Join(filepath.Dir(path), "other.db")
+
+	for _, query := range []string{
+		"INSERT INTO updates (update_uid, product_title) VALUES ('u2', 'Windows 10')",
+		"UPDATE updates SET kb = '0'",
+		"DELETE FROM updates",
+		"DROP TABLE updates",
+		"CREATE TABLE notes (note TEXT)",
+		"SELECT 1; DROP TABLE updates",
+		"ATTACH DATABASE '" + other + "' AS other",
+		"ATTACH DATABASE ':memory:' AS scratch",
+	} {
+		if _, err := queryAll(m, query); err == nil {
+			t.Errorf("%s returned no error", query)
+		}
+	}
+
+	if rows, err := queryAll(m, "SELECT update_uid, kb FROM updates"); err != nil || !reflect.DeepEqual(rows, [][]string{{"u1", "4025342"}}) {
+		t.Errorf("Updates %v, %v after the writes", rows, err)
+	}
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Errorf("ATTACH created %s", other)
+	}
+}
@@ -0,0 +1,231 @@
+/**************************************************************************************************/
+// File: sync.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Full and incremental sync of the mirror from a wsusscn2 source
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const (
+	stateUpdateDate = "update_creation_date" // newest update_creation_date in the mirror
+	stateSyncedAt   = "synced_at"            // time of the last completed sync
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// SyncOptions: Options for Sync
+type SyncOptions struct {
+	Full     bool // pull every update and cve even if the mirror was synced before
+	Limit    int  // records per request, 0 for wsusscn2.DefaultLimit
+	Parallel int  // pages fetched concurrently
+}
+
+// SyncStats: Records written by one Sync
+type SyncStats struct {
+	Since           string // update_creation_date_after used, "" for a full sync
+	Updates         int
+	Cves            int
+	Supersedes      int
+	Products        int
+	ProductFamilies int
+	Classifications int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Sync copies the records of src into the mirror. The first sync and a Full sync pull every
+// update and cve. Later syncs pull the updates created since the newest one in the mirror (less
+// a day, as the date filter is exclusive) and the cves of those updates. Supersedence and the
+// catalogs are always pulled in full. Each table is written in one transaction, so an
+// interrupted sync leaves the previous data and high-water mark in place.
+func (m *DB) Sync(ctx context.Context, src wsusscn2.Source, opts SyncOptions) (SyncStats, error) {
+	var stats SyncStats
+	page := wsusscn2.Page{Limit: opts.Limit, RecordLimit: math.MaxInt32, Parallel: opts.Parallel}
+
+	if !opts.Full {
+		hwm, err := m.State(stateUpdateDate)
+		if err != nil {
+			return stats, err
+		}
+		if len(hwm) >= 10 {
+			if t, err := time.Parse("2006-01-02", hwm[:10]); err == nil {
+				stats.Since = t.AddDate(0, 0, -1).Format("2006-01-02")
+			}
+		}
+	}
+	if stats.Since == "" {
+		m.logf("Sync: pulling all updates")
+	} else {
+		m.logf("Sync: pulling updates created after %s", stats.Since)
+	}
+
+	// updates
+	var uids []string
+	err := m.replace(ctx, updatesTable, opts.Full, func(stmt *sql.Stmt) error {
+		it := src.Updates(ctx, wsusscn2.UpdateFilter{UpdateCreationDateAfter: stats.Since, Page: page})
+		seen := make(map[string]bool)
+		for it.Next() {
+			u := it.Update()
+			if err := insert(ctx, stmt, u); err != nil {
+				return err
+			}
+			if !seen[u.UpdateUid] {
+				seen[u.UpdateUid] = true
+				uids = append(uids, u.UpdateUid)
+			}
+			stats.Updates++
+		}
+		return it.Err()
+	})
+	if err != nil {
+		return stats, err
+	}
+	m.logf("Sync: %d updates", stats.Updates)
+
+	// cves, of the new updates only when incremental
+	err = m.replace(ctx, cvesTable, opts.Full, func(stmt *sql.Stmt) error {
+		pull := func(f wsusscn2.CveFilter) error {
+			it := src.Cves(ctx, f)
+			for it.Next() {
+				if err := insert(ctx, stmt, it.Cve()); err != nil {
+					return err
+				}
+				stats.Cves++
+			}
+			return it.Err()
+		}
+		if stats.Since == "" {
+			return pull(wsusscn2.CveFilter{Page: page})
+		}
+		return wsusscn2.Batches(uids, func(batch []string) error {
+			return pull(wsusscn2.CveFilter{UpdateUid: batch, Page: page})
+		})
+	})
+	if err != nil {
+		return stats, err
+	}
+	m.logf("Sync: %d cves", stats.Cves)
+
+	// supersedence changes for old updates too, so it is always pulled in full
+	err = m.replace(ctx, supersedeTable, true, func(stmt *sql.Stmt) error {
+		it := src.Supersedes(ctx, wsusscn2.UpdateFilter{Page: page})
+		for it.Next() {
+			if err := insert(ctx, stmt, it.Supersede()); err != nil {
+				return err
+			}
+			stats.Supersedes++
+		}
+		return it.Err()
+	})
+	if err != nil {
+		return stats, err
+	}
+	m.logf("Sync: %d supersede records", stats.Supersedes)
+
+	// catalogs
+	if stats.Products, err = m.replaceList(ctx, productsTable, func() (interface{}, error) { return src.ListProducts(ctx) }); err != nil {
+		return stats, err
+	}
+	if stats.ProductFamilies, err = m.replaceList(ctx, productFamiliesTable, func() (interface{}, error) { return src.ListProductFamilies(ctx) }); err != nil {
+		return stats, err
+	}
+	if stats.Classifications, err = m.replaceList(ctx, classificationsTable, func() (interface{}, error) { return src.ListClassifications(ctx) }); err != nil {
+		return stats, err
+	}
+	m.logf("Sync: %d products, %d product families, %d classifications", stats.Products, stats.ProductFamilies, stats.Classifications)
+
+	if err := m.markSuperseded(ctx); err != nil {
+		return stats, err
+	}
+
+	var hwm sql.NullString
+	if err := m.db.QueryRowContext(ctx, "SELECT max(update_creation_date) FROM updates").Scan(&hwm); err != nil {
+		return stats, err
+	}
+	if hwm.Valid {
+		if err := m.setState(ctx, stateUpdateDate, hwm.String); err != nil {
+			return stats, err
+		}
+	}
+	return stats, m.setState(ctx, stateSyncedAt, time.Now().UTC().Format(time.RFC3339))
+}
+
+// replace writes records into table name in one transaction. fill inserts them with stmt. With
+// clear the table is emptied first.
+func (m *DB) replace(ctx context.Context, name string, clear bool, fill func(stmt *sql.Stmt) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if clear {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+name); err != nil {
+			return err
+		}
+	}
+	stmt, err := insertStmt(ctx, tx, tableNamed(name))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if err := fill(stmt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceList replaces the contents of table name with the slice returned by list
+func (m *DB) replaceList(ctx context.Context, name string, list func() (interface{}, error)) (int, error) {
+	records, err := list()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = m.replace(ctx, name, true, func(stmt *sql.Stmt) error {
+		v := reflect.ValueOf(records)
+		for n = 0; n < v.Len(); n++ {
+			if err := insert(ctx, stmt, v.Index(n).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// markSuperseded carries the supersedence of updates pulled by earlier syncs over to their
+// update and cve records, which an incremental sync does not pull again
+func (m *DB) markSuperseded(ctx context.Context) error {
+	for _, name := range []string{updatesTable, cvesTable} {
+		_, err := m.db.ExecContext(ctx, "UPDATE "+name+" SET is_superseded = "+
+			"(SELECT s.is_superseded FROM supersede s WHERE s.update_uid = "+name+".update_uid AND s.product_title = "+name+".product_title LIMIT 1) "+
+			"WHERE EXISTS (SELECT 1 FROM supersede s WHERE s.update_uid = "+name+".update_uid AND s.product_title = "+name+".product_title)")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/**************************************************************************************************/
+// File: sync_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of full and incremental syncs into a temporary SQLite file
+/**************************************************************************************************/
+package mirror
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// syncUids returns the update uids of the updates in m matching f
+func syncUids(t *testing.T, m *DB, f wsusscn2.UpdateFilter) []string {
+	var uids []string
+	it := m.Updates(context.Background(), f)
+	for it.Next() {
+		uids = append(uids, it.Update().UpdateUid)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return uids
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	m, _, cleanup := tempMirror(t)
+	defer cleanup()
+
+	update := func(uid string, kb string, created string) wsusscn2.Update {
+		return wsusscn2.Update{UpdateUid: uid, Kb: kb, ProductTitle: "Windows 10", UpdateCreationDate: created, IsSuperseded: "false"}
+	}
+	cve := func(id string, uid string) wsusscn2.Cve {
+		return wsusscn2.Cve{Cve: id, UpdateUid: uid, ProductTitle: "Windows 10", IsSuperseded: "false"}
+	}
+	src := &fakeSource{
+		updates: []wsusscn2.Update{update("u1", "4056892", "2018-01-03T18:00:00Z"), update("u2", "4074588", "2018-02-13T18:00:00Z")},
+		cves:    []wsusscn2.Cve{cve("CVE-2018-0743", "u1"), cve("CVE-2018-0825", "u2")},
+	}
+
+	// the first sync pulls everything
+	stats, err := m.Sync(ctx, src, SyncOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SyncStats{Updates: 2, Cves: 2, Products: 2, ProductFamilies: 1, Classifications: 1}
+	if stats != expected {
+		t.Errorf("First sync %+v, expected %+v", stats, expected)
+	}
+	if hwm, _ := m.State(stateUpdateDate); hwm != "2018-02-13T18:00:00Z" {
+		t.Errorf("High-water mark %q after the first sync", hwm)
+	}
+	synced, _ := m.State(stateSyncedAt)
+	if synced == "" {
+		t.Errorf("synced_at not set")
+	}
+
+	// a new update superseding u1, which is not pulled again
+	src.updates = append(src.updates, update("u3", "4088776", "2018-03-13T17:00:00Z"))
+	src.cves = append(src.cves, cve("CVE-2018-0886", "u3"))
+	src.supersedes = []wsusscn2.UpdateSupersede{{UpdateUid: "u1", ProductTitle: "Windows 10", IsSuperseded: "true", SuperUpdateUid: "u3", SuperProductTitle: "Windows 10"}}
+
+	stats, err = m.Sync(ctx, src, SyncOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the date filter is exclusive, so the updates of the newest day are pulled again
+	expected = SyncStats{Since: "2018-02-12", Updates: 2, Cves: 2, Supersedes: 1, Products: 2, ProductFamilies: 1, Classifications: 1}
+	if stats != expected {
+		t.Errorf("Incremental sync %+v, expected %+v", stats, expected)
+	}
+	if !reflect.DeepEqual(src.since, []string{"", "2018-02-12"}) {
+		t.Errorf("update_creation_date_after of the syncs %q", src.since)
+	}
+	if uids := src.cveUids[len(src.cveUids)-1]; !reflect.DeepEqual(uids, []string{"u2", "u3"}) {
+		t.Errorf("CVEs pulled for %v, expected the new updates", uids)
+	}
+	if hwm, _ := m.State(stateUpdateDate); hwm != "2018-03-13T17:00:00Z" {
+		t.Errorf("High-water mark %q after the incremental sync", hwm)
+	}
+
+	// pulled again without duplicates
+	for name, expected := range map[string]int{updatesTable: 3, cvesTable: 3, supersedeTable: 1, productsTable: 2} {
+		if n := count(t, m, name); n != expected {
+			t.Errorf("%d rows in %s, expected %d", n, name, expected)
+		}
+	}
+
+	// the supersedence of u1 is carried over to its update and cve
+	if uids := syncUids(t, m, wsusscn2.UpdateFilter{IsSuperseded: "true"}); !reflect.DeepEqual(uids, []string{"u1"}) {
+		t.Errorf("Superseded updates %v, expected u1", uids)
+	}
+	it := m.Cves(ctx, wsusscn2.CveFilter{IsSuperseded: "true"})
+	var cves []string
+	for it.Next() {
+		cves = append(cves, it.Cve().Cve)
+	}
+	if it.Err() != nil || !reflect.DeepEqual(cves, []string{"CVE-2018-0743"}) {
+		t.Errorf("Superseded CVEs %v, %v, expected CVE-2018-0743", cves, it.Err())
+	}
+
+	// a full sync drops what the source no longer has
+	src.updates = src.updates[1:]
+	if stats, err = m.Sync(ctx, src, SyncOptions{Full: true}); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Since != "" || src.since[len(src.since)-1] != "" {
+		t.Errorf("Full sync pulled updates after %q", src.since[len(src.since)-1])
+	}
+	if uids := syncUids(t, m, wsusscn2.UpdateFilter{}); !reflect.DeepEqual(uids, []string{"u3", "u2"}) {
+		t.Errorf("Updates %v after a full sync, expected u3, u2", uids)
+	}
+}
+
+func TestSyncInterrupted(t *testing.T) {
+	ctx := context.Background()
+	m, _, cleanup := tempMirror(t)
+	defer cleanup()
+
+	src := &fakeSource{updates: []wsusscn2.Update{{UpdateUid: "u1", ProductTitle: "Windows 10", UpdateCreationDate: "2018-01-03T18:00:00Z"}}}
+	if _, err := m.Sync(ctx, src, SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	synced, _ := m.State(stateSyncedAt)
+
+	// a sync failing on the CVEs keeps the previous high-water mark and sync time
+	src.updates = append(src.updates, wsusscn2.Update{UpdateUid: "u2", ProductTitle: "Windows 10", UpdateCreationDate: "2018-02-13T18:00:00Z"})
+	src.cveErr = errors.New("503 Service Unavailable")
+	if _, err := m.Sync(ctx, src, SyncOptions{}); err != src.cveErr {
+		t.Fatalf("Sync returned %v, expected %v", err, src.cveErr)
+	}
+	if hwm, _ := m.State(stateUpdateDate); hwm != "2018-01-03T18:00:00Z" {
+		t.Errorf("High-water mark %q after a failed sync", hwm)
+	}
+	if s, _ := m.State(stateSyncedAt); s != synced {
+		t.Errorf("synced_at %q after a failed sync, expected %q", s, synced)
+	}
+}
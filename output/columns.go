@@ -0,0 +1,158 @@
+/**************************************************************************************************/
+// File: columns.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Column selection for any record struct, driven by its json tags
+/**************************************************************************************************/
+package output
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Selector: Selected columns of one record type. Every exported field with a json tag is a
+// column named by the tag and titled by the field name. Fields of embedded structs are
+// included as if they were declared on the outer struct.
+type Selector struct {
+	recordType reflect.Type
+	columns    []Column
+	fields     [][]int // field index of each column
+	values     []string
+	typed      []interface{}
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// structColumns lists the columns of struct type t with their field indexes
+func structColumns(t reflect.Type, parent []int) ([]Column, [][]int) {
+	var columns []Column
+	var fields [][]int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		index := append(append([]int(nil), parent...), i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			c, fi := structColumns(f.Type, index)
+			columns = append(columns, c...)
+			fields = append(fields, fi...)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, Column{Name: name, Title: f.Name})
+		fields = append(fields, index)
+	}
+	return columns, fields
+}
+
+// NewSelector returns a selector for the struct type of record. spec is a comma separated list
+// of column names, an empty spec selects every column. Unknown names are an error.
+func NewSelector(record interface{}, spec string) (*Selector, error) {
+	t := reflect.TypeOf(record)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Columns of %s cannot be selected", t)
+	}
+
+	all, allFields := structColumns(t, nil)
+	s := &Selector{recordType: t}
+
+	if strings.TrimSpace(spec) == "" {
+		s.columns, s.fields = all, allFields
+		s.values = make([]string, len(s.columns))
+		s.typed = make([]interface{}, len(s.columns))
+		return s, nil
+	}
+
+	byName := make(map[string]int)
+	for i, c := range all {
+		byName[c.Name] = i
+	}
+
+	var unknown []string
+	for _, v := range strings.Split(spec, ",") {
+		name := strings.ToLower(strings.TrimSpace(v))
+		if name == "" {
+			continue
+		}
+		i, ok := byName[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		s.columns = append(s.columns, all[i])
+		s.fields = append(s.fields, allFields[i])
+	}
+
+	if len(unknown) > 0 {
+		names := make([]string, len(all))
+		for i, c := range all {
+			names[i] = c.Name
+		}
+		return nil, fmt.Errorf("Unknown column(s): %s. Available columns: %s", strings.Join(unknown, ", "), strings.Join(names, ", "))
+	}
+	if len(s.columns) == 0 {
+		return nil, fmt.Errorf("No columns selected")
+	}
+
+	s.values = make([]string, len(s.columns))
+	s.typed = make([]interface{}, len(s.columns))
+	return s, nil
+}
+
+// Columns returns the selected columns in output order
+func (s *Selector) Columns() []Column {
+	return s.columns
+}
+
+// Values returns the selected column values of record as text, which must be of the selector's
+// type. The returned slice is reused by the next call.
+func (s *Selector) Values(record interface{}) []string {
+	v := s.record(record)
+	for i, index := range s.fields {
+		f := v.FieldByIndex(index)
+		if f.Kind() == reflect.String {
+			s.values[i] = f.String()
+		} else {
+			s.values[i] = fmt.Sprint(f.Interface())
+		}
+	}
+	return s.values
+}
+
+// Fields returns the selected column values of record with their types (Ex., int and bool
+// fields stay numbers and booleans). The returned slice is reused by the next call.
+func (s *Selector) Fields(record interface{}) []interface{} {
+	v := s.record(record)
+	for i, index := range s.fields {
+		s.typed[i] = v.FieldByIndex(index).Interface()
+	}
+	return s.typed
+}
+
+// record returns the struct value of record, which must be of the selector's type
+func (s *Selector) record(record interface{}) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if v.Type() != s.recordType {
+		panic(fmt.Sprintf("output: Selector for %s used with %s", s.recordType, v.Type()))
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+/**************************************************************************************************/
+// File: csv.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: RFC 4180 CSV writer
+/**************************************************************************************************/
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// QuotePolicy: When CSV fields are enclosed in double quotes
+type QuotePolicy int
+
+const (
+	QuoteAll     QuotePolicy = iota // quote every field (default, matches earlier versions)
+	QuoteMinimal                    // quote only fields containing the delimiter, quotes or line breaks
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// CSVOptions: Formatting options of a CSV writer
+type CSVOptions struct {
+	Delimiter rune        // field separator, defaults to ','
+	NoHeader  bool        // do not write the header row
+	Quote     QuotePolicy // quoting policy
+	CRLF      bool        // end lines with \r\n (RFC 4180) instead of \n
+}
+
+// csvWriter: Writer producing RFC 4180 CSV
+type csvWriter struct {
+	w    *bufio.Writer
+	opts CSVOptions
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// ParseQuotePolicy parses "all" or "minimal"
+func ParseQuotePolicy(s string) (QuotePolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "all":
+		return QuoteAll, nil
+	case "minimal":
+		return QuoteMinimal, nil
+	}
+	return QuoteAll, fmt.Errorf("Unknown quote policy %q. Expected: all, minimal", s)
+}
+
+// ParseDelimiter parses a single character delimiter. "tab" and "\t" select a tab.
+func ParseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return ',', nil
+	case "tab", `\t`, "\t":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+		return 0, fmt.Errorf("Invalid delimiter %q. Expected a single character other than a quote or line break", s)
+	}
+	return r[0], nil
+}
+
+// NewCSVWriter returns a Writer producing CSV on w
+func NewCSVWriter(w io.Writer, opts CSVOptions) Writer {
+	if opts.Delimiter == 0 {
+		opts.Delimiter = ','
+	}
+	return &csvWriter{w: bufio.NewWriter(w), opts: opts}
+}
+
+func (c *csvWriter) WriteHeader(columns []Column) error {
+	if c.opts.NoHeader {
+		return nil
+	}
+	titles := make([]string, len(columns))
+	for i, col := range columns {
+		titles[i] = col.Title
+	}
+	return c.WriteRow(titles)
+}
+
+func (c *csvWriter) WriteRow(values []string) error {
+	for i, v := range values {
+		if i > 0 {
+			if _, err := c.w.WriteRune(c.opts.Delimiter); err != nil {
+				return err
+			}
+		}
+		if err := c.writeField(v); err != nil {
+			return err
+		}
+	}
+	if c.opts.CRLF {
+		_, err := c.w.WriteString("\r\n")
+		return err
+	}
+	return c.w.WriteByte('\n')
+}
+
+func (c *csvWriter) Flush() error {
+	return c.w.Flush()
+}
+
+// Abort flushes the rows, CSV has no end to leave out
+func (c *csvWriter) Abort() error {
+	return c.w.Flush()
+}
+
+// needsQuotes reports if field must be quoted to be read back unchanged
+func (c *csvWriter) needsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if strings.ContainsRune(field, c.opts.Delimiter) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+	// leading spaces are trimmed by some readers
+	return field[0] == ' ' || field[0] == '\t'
+}
+
+// writeField writes one field, doubling embedded quotes when quoted
+func (c *csvWriter) writeField(field string) error {
+	if c.opts.Quote == QuoteMinimal && !c.needsQuotes(field) {
+		_, err := c.w.WriteString(field)
+		return err
+	}
+	if err := c.w.WriteByte('"'); err != nil {
+		return err
+	}
+	if _, err := c.w.WriteString(strings.Replace(field, `"`, `""`, -1)); err != nil {
+		return err
+	}
+	return c.w.WriteByte('"')
+}
@@ -0,0 +1,121 @@
+/**************************************************************************************************/
+// File: json.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: JSON array and newline delimited JSON writers
+/**************************************************************************************************/
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// jsonWriter: Writer producing one JSON object per row, keyed by column name. Rows are either
+// elements of one JSON array or, for NDJSON, separate lines.
+type jsonWriter struct {
+	w       *bufio.Writer
+	ndjson  bool
+	keys    [][]byte // encoded column names
+	rows    int
+	started bool
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewJSONWriter returns a Writer producing a JSON array of objects on w
+func NewJSONWriter(w io.Writer) Writer {
+	return &jsonWriter{w: bufio.NewWriter(w)}
+}
+
+// NewNDJSONWriter returns a Writer producing one JSON object per line on w
+func NewNDJSONWriter(w io.Writer) Writer {
+	return &jsonWriter{w: bufio.NewWriter(w), ndjson: true}
+}
+
+func (j *jsonWriter) WriteHeader(columns []Column) error {
+	j.keys = make([][]byte, len(columns))
+	for i, col := range columns {
+		key, err := json.Marshal(col.Name)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	if !j.ndjson {
+		j.started = true
+		_, err := j.w.WriteString("[")
+		return err
+	}
+	return nil
+}
+
+// WriteRow writes values as JSON strings, for rows without types such as query results
+func (j *jsonWriter) WriteRow(values []string) error {
+	typed := make([]interface{}, len(values))
+	for i, v := range values {
+		typed[i] = v
+	}
+	return j.WriteValues(typed)
+}
+
+// WriteValues writes values with their JSON types: numbers, booleans or strings
+func (j *jsonWriter) WriteValues(values []interface{}) error {
+	if !j.ndjson && j.rows > 0 {
+		if _, err := j.w.WriteString(","); err != nil {
+			return err
+		}
+	}
+	if !j.ndjson {
+		if _, err := j.w.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	j.rows++
+
+	j.w.WriteByte('{')
+	for i, v := range values {
+		if i >= len(j.keys) {
+			break
+		}
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[i])
+		j.w.WriteByte(':')
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		j.w.Write(value)
+	}
+	_, err := j.w.WriteString("}")
+	if err == nil && j.ndjson {
+		err = j.w.WriteByte('\n')
+	}
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	if j.started {
+		j.started = false
+		if _, err := j.w.WriteString("\n]\n"); err != nil {
+			return err
+		}
+	}
+	return j.w.Flush()
+}
+
+// Abort flushes the objects written so far without closing the array
+func (j *jsonWriter) Abort() error {
+	return j.w.Flush()
+}
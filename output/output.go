@@ -0,0 +1,43 @@
+/**************************************************************************************************/
+// File: output.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Writers shared by every command that prints records
+/**************************************************************************************************/
+
+// Package output writes command results as rows of named columns.
+package output
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Column: One output column. Name is the json tag of the field (Ex., update_uid), Title is
+// used as the CSV header (Ex., UpdateUid).
+type Column struct {
+	Name  string
+	Title string
+}
+
+// Writer: Writes one stream of rows. WriteHeader is called once before the first row and Flush
+// once after the last. Use a Stream to have this enforced.
+type Writer interface {
+	WriteHeader(columns []Column) error
+	WriteRow(values []string) error
+	Flush() error
+}
+
+// TypedWriter: Writer that keeps the types of values, such as numbers and booleans in JSON.
+// A Stream passes it the field values of records instead of their text.
+type TypedWriter interface {
+	Writer
+	WriteValues(values []interface{}) error
+}
+
+// Aborter: Writer that can flush the rows written so far without ending the output (Ex., without
+// the closing bracket of a JSON array), so a stream stopped by an error is not taken for complete
+type Aborter interface {
+	Writer
+	Abort() error
+}
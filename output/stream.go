@@ -0,0 +1,91 @@
+/**************************************************************************************************/
+// File: stream.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Record streams writing their header exactly once
+/**************************************************************************************************/
+package output
+
+import (
+	"errors"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Stream: Writes records of one type through a Writer. The header is written exactly once, before
+// the first record, or by Close when no record was written. Records may arrive from any number
+// of pages without repeating the header.
+type Stream struct {
+	w       Writer
+	s       *Selector
+	header  bool
+	closed  bool
+	records int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewStream returns a stream writing the columns selected by s to w
+func NewStream(w Writer, s *Selector) *Stream {
+	return &Stream{w: w, s: s}
+}
+
+func (st *Stream) writeHeader() error {
+	if st.header {
+		return nil
+	}
+	st.header = true
+	return st.w.WriteHeader(st.s.Columns())
+}
+
+// Write writes one record, which must be of the selector's type
+func (st *Stream) Write(record interface{}) error {
+	if st.closed {
+		return errors.New("output: Write on closed stream")
+	}
+	if err := st.writeHeader(); err != nil {
+		return err
+	}
+	st.records++
+	if tw, ok := st.w.(TypedWriter); ok {
+		return tw.WriteValues(st.s.Fields(record))
+	}
+	return st.w.WriteRow(st.s.Values(record))
+}
+
+// Records returns the number of records written so far
+func (st *Stream) Records() int {
+	return st.records
+}
+
+// Abort flushes the records written so far without ending the output, for a stream stopped by
+// an error. Nothing is written if the writer is not an Aborter. The stream is closed.
+func (st *Stream) Abort() error {
+	if st.closed {
+		return nil
+	}
+	st.closed = true
+	if a, ok := st.w.(Aborter); ok {
+		return a.Abort()
+	}
+	return nil
+}
+
+// Close writes the header if nothing was written yet and flushes the writer. Closing twice is a
+// no-op.
+func (st *Stream) Close() error {
+	if st.closed {
+		return nil
+	}
+	st.closed = true
+	if err := st.writeHeader(); err != nil {
+		return err
+	}
+	return st.w.Flush()
+}
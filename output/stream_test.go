@@ -0,0 +1,141 @@
+/**************************************************************************************************/
+// File: stream_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of record streams and writers
+/**************************************************************************************************/
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+type testRecord struct {
+	Kb        string `json:"kb"`
+	Depth     int    `json:"depth"`
+	IsCurrent bool   `json:"is_current"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// stream writes n records to a stream over w and closes it
+func stream(t *testing.T, w Writer, n int) {
+	s, err := NewSelector(testRecord{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewStream(w, s)
+	for i := 0; i < n; i++ {
+		if err := st.Write(testRecord{Kb: fmt.Sprint(400000 + i), Depth: i, IsCurrent: i == n-1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamCSV(t *testing.T) {
+	var b bytes.Buffer
+	stream(t, NewCSVWriter(&b, CSVOptions{}), 3)
+	expected := "\"Kb\",\"Depth\",\"IsCurrent\"\n" +
+		"\"400000\",\"0\",\"false\"\n" +
+		"\"400001\",\"1\",\"false\"\n" +
+		"\"400002\",\"2\",\"true\"\n"
+	if b.String() != expected {
+		t.Errorf("Got %q, expected %q", b.String(), expected)
+	}
+
+	b.Reset()
+	stream(t, NewCSVWriter(&b, CSVOptions{}), 0)
+	if b.String() != "\"Kb\",\"Depth\",\"IsCurrent\"\n" {
+		t.Errorf("Header of no records is %q", b.String())
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	var b bytes.Buffer
+	stream(t, NewJSONWriter(&b), 3)
+	expected := "[\n" +
+		"{\"kb\":\"400000\",\"depth\":0,\"is_current\":false},\n" +
+		"{\"kb\":\"400001\",\"depth\":1,\"is_current\":false},\n" +
+		"{\"kb\":\"400002\",\"depth\":2,\"is_current\":true}\n" +
+		"]\n"
+	if b.String() != expected {
+		t.Errorf("Got %q, expected %q", b.String(), expected)
+	}
+	var records []testRecord
+	if err := json.Unmarshal(b.Bytes(), &records); err != nil || len(records) != 3 || records[2].Depth != 2 || !records[2].IsCurrent {
+		t.Errorf("Unmarshal %+v: %v", records, err)
+	}
+
+	b.Reset()
+	stream(t, NewJSONWriter(&b), 0)
+	if b.String() != "[\n]\n" {
+		t.Errorf("Array of no records is %q", b.String())
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	var b bytes.Buffer
+	stream(t, NewNDJSONWriter(&b), 2)
+	expected := "{\"kb\":\"400000\",\"depth\":0,\"is_current\":false}\n" +
+		"{\"kb\":\"400001\",\"depth\":1,\"is_current\":true}\n"
+	if b.String() != expected {
+		t.Errorf("Got %q, expected %q", b.String(), expected)
+	}
+}
+
+func TestJSONWriteRow(t *testing.T) {
+	// rows without types, such as query results, stay strings
+	var b bytes.Buffer
+	w := NewNDJSONWriter(&b)
+	w.WriteHeader([]Column{{Name: "n"}})
+	w.WriteRow([]string{"1"})
+	w.Flush()
+	if strings.TrimSpace(b.String()) != "{\"n\":\"1\"}" {
+		t.Errorf("Got %q", b.String())
+	}
+}
+
+func TestStreamAbort(t *testing.T) {
+	s, err := NewSelector(testRecord{}, "kb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		w        func(b *bytes.Buffer) Writer
+		expected string
+	}{
+		{func(b *bytes.Buffer) Writer { return NewCSVWriter(b, CSVOptions{}) }, "\"Kb\"\n\"400000\"\n\"400001\"\n"},
+		{func(b *bytes.Buffer) Writer { return NewJSONWriter(b) }, "[\n{\"kb\":\"400000\"},\n{\"kb\":\"400001\"}"},
+		{func(b *bytes.Buffer) Writer { return NewNDJSONWriter(b) }, "{\"kb\":\"400000\"}\n{\"kb\":\"400001\"}\n"},
+	} {
+		// the records written before the error are kept, the JSON array is left open
+		var b bytes.Buffer
+		st := NewStream(tc.w(&b), s)
+		st.Write(testRecord{Kb: "400000"})
+		st.Write(testRecord{Kb: "400001"})
+		if err := st.Abort(); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Close(); err != nil || b.String() != tc.expected {
+			t.Errorf("Got %q and %v, expected %q", b.String(), err, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/**************************************************************************************************/
+// File: audit.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Consistency checks of supersedence data
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Checks of Audit
+const (
+	CheckNonTerminalLatest = "non_terminal_latest"           // the latest superseding update of a supersede record is superseded itself
+	CheckStaleSuperFlag    = "stale_super_is_superseded"     // super_is_superseded disagrees with whether the latest superseding update is superseded
+	CheckDanglingSuper     = "dangling_super_uid"            // super_uid of a supersede record is not an update
+	CheckDanglingLink      = "dangling_link"                 // supersedes or bundles of an update lists an unknown update
+	CheckCrossProduct      = "cross_product"                 // an update supersedes an update of another product only
+	CheckMissingSuperseder = "superseded_without_superseder" // is_superseded is true, but nothing supersedes the update
+	CheckStaleFlag         = "stale_is_superseded"           // is_superseded is false, but the update is superseded
+	CheckCycle             = "cycle"                         // updates supersede each other in a cycle
+)
+
+// AuditChecks: Every check of Audit
+var AuditChecks = []string{
+	CheckNonTerminalLatest,
+	CheckStaleSuperFlag,
+	CheckDanglingSuper,
+	CheckDanglingLink,
+	CheckCrossProduct,
+	CheckMissingSuperseder,
+	CheckStaleFlag,
+	CheckCycle,
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Anomaly: Inconsistency found by Audit, about an update and the related update of the check
+type Anomaly struct {
+	Check               string `json:"check"`
+	UpdateUid           string `json:"update_uid"`
+	Kb                  string `json:"kb"`
+	UpdateTitle         string `json:"update_title"`
+	ProductTitle        string `json:"product_title"`
+	RelatedUid          string `json:"related_uid"`
+	RelatedKb           string `json:"related_kb"`
+	RelatedProductTitle string `json:"related_product_title"`
+	Detail              string `json:"detail"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Audit cross-checks the is_superseded flags of the updates against the supersedence links
+// and the supersede records, and reports dangling links, "latest" superseding updates that are
+// superseded themselves, supersedence across products and cycles. Links to updates outside of
+// what was loaded show up as dangling; Fetch them first.
+func (g *Graph) Audit() []Anomaly {
+	var anomalies []Anomaly
+	add := func(check string, uid string, product string, related string, relatedProduct string, detail string) {
+		a := Anomaly{Check: check, UpdateUid: uid, ProductTitle: product, RelatedUid: related, RelatedProductTitle: relatedProduct, Detail: detail}
+		if u := g.updates[uid]; u != nil {
+			a.Kb, a.UpdateTitle = u.Kb, u.UpdateTitle
+			if a.ProductTitle == "" {
+				a.ProductTitle = strings.Join(g.products[uid], ", ")
+			}
+		}
+		if u := g.updates[related]; u != nil {
+			a.RelatedKb = u.Kb
+			if a.RelatedProductTitle == "" {
+				a.RelatedProductTitle = strings.Join(g.products[related], ", ")
+			}
+		}
+		anomalies = append(anomalies, a)
+	}
+
+	// supersede records
+	seen := make(map[string]bool)
+	current := make(map[string]string) // KBs of the current replacements, by uid
+	for _, r := range g.records {
+		uid, super := strings.ToLower(r.UpdateUid), strings.ToLower(r.SuperUpdateUid)
+		key := uid + " " + super + " " + r.ProductTitle
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if g.updates[super] == nil {
+			add(CheckDanglingSuper, uid, r.ProductTitle, super, r.SuperProductTitle, "super_uid is not an update")
+			continue
+		}
+		flag, err := strconv.ParseBool(r.SuperIsSuperseded)
+		switch {
+		case len(g.superseders(super)) > 0:
+			if _, ok := current[super]; !ok {
+				current[super] = g.kbList(g.current(super))
+			}
+			check := CheckNonTerminalLatest
+			if err == nil && !flag {
+				check = CheckStaleSuperFlag
+			}
+			add(check, uid, r.ProductTitle, super, r.SuperProductTitle, "latest is superseded, current: "+current[super])
+		case err == nil && flag:
+			add(CheckStaleSuperFlag, uid, r.ProductTitle, super, r.SuperProductTitle, "super_is_superseded is true, but no update supersedes the latest")
+		}
+	}
+
+	// links
+	for _, l := range g.Links() {
+		if g.updates[l.From] == nil || g.updates[l.To] == nil {
+			add(CheckDanglingLink, l.From, "", l.To, "", fmt.Sprintf("%s an update that is not found", l.Kind))
+			continue
+		}
+		if l.Kind == LinkSupersedes && !shareAny(g.products[l.From], g.products[l.To]) {
+			add(CheckCrossProduct, l.To, "", l.From, "", "superseded by an update of another product")
+		}
+	}
+
+	// flags
+	for _, uid := range g.Nodes() {
+		u := g.updates[uid]
+		if u == nil {
+			continue
+		}
+		flag, err := strconv.ParseBool(u.IsSuperseded)
+		if err != nil {
+			continue
+		}
+		superseders := g.superseders(uid)
+		if flag && len(superseders) == 0 {
+			add(CheckMissingSuperseder, uid, "", "", "", "is_superseded is true, but no update supersedes it")
+		}
+		if !flag && len(superseders) > 0 {
+			add(CheckStaleFlag, uid, "", superseders[0], "", "is_superseded is false, but superseded by "+g.kbList(superseders))
+		}
+	}
+
+	// cycles
+	_, cycles := g.walk(g.Nodes(), g.superseders)
+	for _, c := range uniqueCycles(cycles) {
+		add(CheckCycle, c[0], "", c[1], "", "cycle "+strings.Replace(g.kbList(c), ",", " -> ", -1))
+	}
+
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if a.Check != b.Check {
+			return checkRank(a.Check) < checkRank(b.Check)
+		}
+		if a.ProductTitle != b.ProductTitle {
+			return a.ProductTitle < b.ProductTitle
+		}
+		return a.Kb < b.Kb
+	})
+	return anomalies
+}
+
+// current returns the updates superseding uid, directly or through others, that nothing
+// supersedes
+func (g *Graph) current(uid string) []string {
+	var uids []string
+	for _, step := range g.Chain(uid).Steps {
+		if step.Depth > 0 && step.Current {
+			uids = append(uids, step.UpdateUid)
+		}
+	}
+	return uids
+}
+
+// kbList returns the KBs of uids, or the uid of updates without one, comma separated
+func (g *Graph) kbList(uids []string) string {
+	names := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if u := g.updates[uid]; u != nil && u.Kb != "" {
+			names = append(names, "KB"+u.Kb)
+		} else {
+			names = append(names, uid)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// checkRank returns the position of check in AuditChecks
+func checkRank(check string) int {
+	for i, c := range AuditChecks {
+		if c == check {
+			return i
+		}
+	}
+	return len(AuditChecks)
+}
+
+// shareAny reports if a and b have a value in common, or either is empty
+func shareAny(a []string, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, v := range a {
+		if contains(b, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+/**************************************************************************************************/
+// File: client.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: HTTP client for the wsusscn2.cab API
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// DefaultApiUrl: Endpoint of the hosted wsusscn2.cab API
+const DefaultApiUrl = "https://api.wsusscn2.cab:443"
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Client: wsusscn2.cab API client. The zero value is not usable, create one with NewClient. A
+// Client is safe for concurrent use once configured.
+type Client struct {
+	ApiUrl     string        // base url, Ex. https://api.wsusscn2.cab:443
+	ApiKey     string        // key used for basic authentication
+	HttpClient *http.Client  // client used for every request
+	Debug      bool          // dump requests and responses to Logger
+	Logger     *log.Logger   // defaults to the standard logger
+	MaxRetries int           // retries for 429, 5xx and network errors
+	RetryWait  time.Duration // wait before the first retry, doubled for every retry
+
+	limiter *rateLimiter // set by SetRateLimit
+	quota   quotaTracker // last quota reported by the service
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewClient returns a client for the API at apiUrl
+func NewClient(apiUrl string, apiKey string) *Client {
+	return &Client{
+		ApiUrl:     strings.TrimRight(apiUrl, "/"),
+		ApiKey:     apiKey,
+		HttpClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: DefaultMaxRetries,
+		RetryWait:  DefaultRetryWait,
+	}
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// newRequest creates an authenticated GET request for path with query q
+func (c *Client) newRequest(ctx context.Context, path string, q url.Values) (*http.Request, error) {
+	req, err := http.NewRequest("GET", c.ApiUrl+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth("u", c.ApiKey)
+	if q != nil {
+		req.URL.RawQuery = q.Encode()
+	}
+	return req, nil
+}
+
+// getJson requests path and decodes the JSON response into target. Requests failing with 429,
+// 5xx or a network error are retried up to MaxRetries times.
+func (c *Client) getJson(ctx context.Context, path string, q url.Values, target interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := c.tryGetJson(ctx, path, q, target)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := c.backoff(attempt, err)
+		c.logf("%s. Retrying in %s (%d/%d)", err, wait.Round(time.Millisecond), attempt+1, c.MaxRetries)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// tryGetJson makes a single attempt of getJson
+func (c *Client) tryGetJson(ctx context.Context, path string, q url.Values, target interface{}) error {
+	req, err := c.newRequest(ctx, path, q)
+	if err != nil {
+		return err
+	}
+
+	c.logf("GET %s", req.URL.String())
+
+	if c.Debug {
+		requestDump, err := httputil.DumpRequest(req, true)
+		if err != nil {
+			c.logf("%s", err)
+		}
+		c.logf("%s", requestDump)
+	}
+
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	r, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	c.updateQuota(r.Header)
+
+	if c.Debug {
+		responseDump, err := httputil.DumpResponse(r, true)
+		if err != nil {
+			c.logf("%s", err)
+		}
+		c.logf("%s", responseDump)
+	}
+
+	if r.StatusCode != http.StatusOK {
+		se := &statusError{
+			StatusCode: r.StatusCode,
+			RetryAfter: parseRetryAfter(r.Header.Get("Retry-After"), time.Now()),
+		}
+		switch {
+		case r.StatusCode == http.StatusUnauthorized:
+			se.Message = "Unauthorized request to service"
+		case r.StatusCode == http.StatusForbidden:
+			se.Message = "Forbidden request to service"
+		case r.StatusCode == http.StatusNotFound:
+			se.Message = "Resource not found (404) received from service"
+		case r.StatusCode == http.StatusTooManyRequests:
+			se.Message = "Too many requests (429) received from service"
+		default:
+			se.Message = fmt.Sprintf("Unknown HTTP error (%d) received from service", r.StatusCode)
+		}
+		return se
+	}
+
+	return json.NewDecoder(r.Body).Decode(target)
+}
+
+// ListClassifications returns all classifications
+func (c *Client) ListClassifications(ctx context.Context) ([]Classification, error) {
+	var classification []Classification
+	err := c.getJson(ctx, "/classification", nil, &classification)
+	return classification, err
+}
+
+// ListProducts returns all products
+func (c *Client) ListProducts(ctx context.Context) ([]Product, error) {
+	var product []Product
+	err := c.getJson(ctx, "/product", nil, &product)
+	return product, err
+}
+
+// ListProductFamilies returns all product families
+func (c *Client) ListProductFamilies(ctx context.Context) ([]ProductFamily, error) {
+	var productFamily []ProductFamily
+	err := c.getJson(ctx, "/productfamily", nil, &productFamily)
+	return productFamily, err
+}
+
+// Updates returns an iterator over the updates matching f
+func (c *Client) Updates(ctx context.Context, f UpdateFilter) *UpdateIterator {
+	q, err := f.Values()
+	if err != nil {
+		return ErrUpdateIterator(err)
+	}
+	return &UpdateIterator{p: newPager(ctx, f.Page, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		var page []Update
+		err := c.getJson(ctx, "/update", pageValues(q, limit, offset), &page)
+		return page, len(page), err
+	})}
+}
+
+// Supersedes returns an iterator over the latest superseding update for each update matching f
+func (c *Client) Supersedes(ctx context.Context, f UpdateFilter) *SupersedeIterator {
+	q, err := f.Values()
+	if err != nil {
+		return ErrSupersedeIterator(err)
+	}
+	return &SupersedeIterator{p: newPager(ctx, f.Page, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		var page []UpdateSupersede
+		err := c.getJson(ctx, "/supersede", pageValues(q, limit, offset), &page)
+		return page, len(page), err
+	})}
+}
+
+// Cves returns an iterator over the CVE records matching f
+func (c *Client) Cves(ctx context.Context, f CveFilter) *CveIterator {
+	q, err := f.Values()
+	if err != nil {
+		return ErrCveIterator(err)
+	}
+	return &CveIterator{p: newPager(ctx, f.Page, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		var page []Cve
+		err := c.getJson(ctx, "/cve", pageValues(q, limit, offset), &page)
+		return page, len(page), err
+	})}
+}
+
+// ListUpdates returns the updates matching f. Use Updates to stream large result sets.
+func (c *Client) ListUpdates(ctx context.Context, f UpdateFilter) ([]Update, error) {
+	var updates []Update
+	it := c.Updates(ctx, f)
+	for it.Next() {
+		updates = append(updates, it.Update())
+	}
+	return updates, it.Err()
+}
+
+// ListSupersede returns the latest superseding update for each update matching f. Use
+// Supersedes to stream large result sets.
+func (c *Client) ListSupersede(ctx context.Context, f UpdateFilter) ([]UpdateSupersede, error) {
+	var supersedes []UpdateSupersede
+	it := c.Supersedes(ctx, f)
+	for it.Next() {
+		supersedes = append(supersedes, it.Supersede())
+	}
+	return supersedes, it.Err()
+}
+
+// ListCves returns the CVE records matching f. Use Cves to stream large result sets.
+func (c *Client) ListCves(ctx context.Context, f CveFilter) ([]Cve, error) {
+	var cves []Cve
+	it := c.Cves(ctx, f)
+	for it.Next() {
+		cves = append(cves, it.Cve())
+	}
+	return cves, it.Err()
+}
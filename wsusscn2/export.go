@@ -0,0 +1,280 @@
+/**************************************************************************************************/
+// File: export.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Graphviz DOT, GraphML and Mermaid export of a supersedence graph
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// GraphFormats: Formats of Graph.Write
+var GraphFormats = []string{"dot", "graphml", "mermaid"}
+
+// severityColors: Fill color of nodes by MSRC severity
+var severityColors = map[string]string{
+	"critical":  "#f8d0d0",
+	"important": "#fbe3c4",
+	"moderate":  "#fdf5c0",
+	"low":       "#e0ecf8",
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// graphML: GraphML document, with a label key yEd can map to node labels
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	Id   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	Id          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Subgraph returns the graph of the updates with uids and the links between them. Referenced
+// updates that are not in g are kept as nodes without details.
+func (g *Graph) Subgraph(uids []string) *Graph {
+	sub := NewGraph()
+	keep := make(map[string]bool)
+	for _, uid := range uids {
+		uid = strings.ToLower(uid)
+		keep[uid] = true
+		if u, ok := g.updates[uid]; ok {
+			sub.updates[uid] = u
+			sub.products[uid] = g.products[uid]
+		}
+	}
+	for l := range g.links {
+		if keep[l.From] && keep[l.To] {
+			sub.link(l)
+		}
+	}
+	return sub
+}
+
+// Nodes returns the uids of the updates of the graph and of those its links refer to, sorted by
+// creation date
+func (g *Graph) Nodes() []string {
+	seen := make(map[string]bool)
+	var uids []string
+	add := func(uid string) {
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	for uid := range g.updates {
+		add(uid)
+	}
+	for l := range g.links {
+		add(l.From)
+		add(l.To)
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		if ci, cj := g.created(uids[i]), g.created(uids[j]); ci != cj {
+			return ci < cj
+		}
+		return uids[i] < uids[j]
+	})
+	return uids
+}
+
+// Write writes the graph in format, one of GraphFormats
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch strings.ToLower(format) {
+	case "dot":
+		return g.WriteDOT(w)
+	case "graphml":
+		return g.WriteGraphML(w)
+	case "mermaid":
+		return g.WriteMermaid(w)
+	}
+	return fmt.Errorf("Unknown graph format %s. Expected: %s", format, strings.Join(GraphFormats, ", "))
+}
+
+// WriteDOT writes the graph for Graphviz. Older updates are on the left, nodes are filled by
+// severity, bundle links are dashed and updates that are not in the graph are dotted.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph supersedence {")
+	fmt.Fprintln(bw, "\trankdir=RL;")
+	fmt.Fprintln(bw, "\tnode [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\", fontname=\"Helvetica\", fontsize=10];")
+	fmt.Fprintln(bw, "\tedge [fontname=\"Helvetica\", fontsize=8];")
+	for _, uid := range g.Nodes() {
+		attrs := fmt.Sprintf("label=%s", dotQuote(strings.Join(g.label(uid), "\n")))
+		if u, ok := g.updates[uid]; !ok {
+			attrs += ", style=\"rounded,dotted\""
+		} else if color, ok := severityColors[strings.ToLower(u.MsrcSeverity)]; ok {
+			attrs += fmt.Sprintf(", fillcolor=%s", dotQuote(color))
+		}
+		fmt.Fprintf(bw, "\t%s [%s];\n", dotQuote(uid), attrs)
+	}
+	for _, l := range g.Links() {
+		attrs := fmt.Sprintf("label=%s", dotQuote(l.Kind))
+		if l.Kind == LinkBundles {
+			attrs += ", style=dashed"
+		}
+		fmt.Fprintf(bw, "\t%s -> %s [%s];\n", dotQuote(l.From), dotQuote(l.To), attrs)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteGraphML writes the graph as GraphML for yEd and other graph editors. Nodes carry the KB,
+// title, creation date, severity and products, and a label combining them. Edges carry their kind.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{Id: "label", For: "node", Name: "label", Type: "string"},
+			{Id: "kb", For: "node", Name: "kb", Type: "string"},
+			{Id: "title", For: "node", Name: "title", Type: "string"},
+			{Id: "date", For: "node", Name: "update_creation_date", Type: "string"},
+			{Id: "severity", For: "node", Name: "msrc_severity", Type: "string"},
+			{Id: "product", For: "node", Name: "product_title", Type: "string"},
+			{Id: "kind", For: "edge", Name: "kind", Type: "string"},
+		},
+		Graph: graphMLGraph{Id: "supersedence", EdgeDefault: "directed"},
+	}
+	for _, uid := range g.Nodes() {
+		n := graphMLNode{Id: uid, Data: []graphMLData{{Key: "label", Value: strings.Join(g.label(uid), "\n")}}}
+		if u, ok := g.updates[uid]; ok {
+			n.Data = append(n.Data,
+				graphMLData{Key: "kb", Value: u.Kb},
+				graphMLData{Key: "title", Value: u.UpdateTitle},
+				graphMLData{Key: "date", Value: u.UpdateCreationDate},
+				graphMLData{Key: "severity", Value: u.MsrcSeverity},
+				graphMLData{Key: "product", Value: strings.Join(g.products[uid], ", ")},
+			)
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+	for _, l := range g.Links() {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: l.From, Target: l.To, Data: []graphMLData{{Key: "kind", Value: l.Kind}}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart for Markdown documents. Bundle links are
+// dotted and nodes are styled by severity.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "flowchart RL")
+	ids := make(map[string]string)
+	for i, uid := range g.Nodes() {
+		ids[uid] = fmt.Sprintf("u%d", i+1)
+		class := "missing"
+		if u, ok := g.updates[uid]; ok {
+			class = strings.ToLower(u.MsrcSeverity)
+			if _, ok := severityColors[class]; !ok {
+				class = ""
+			}
+		}
+		fmt.Fprintf(bw, "\t%s[\"%s\"]", ids[uid], mermaidEscape(strings.Join(g.label(uid), "<br/>")))
+		if class != "" {
+			fmt.Fprintf(bw, ":::%s", class)
+		}
+		fmt.Fprintln(bw)
+	}
+	for _, l := range g.Links() {
+		arrow := "-->"
+		if l.Kind == LinkBundles {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(bw, "\t%s %s|%s| %s\n", ids[l.From], arrow, l.Kind, ids[l.To])
+	}
+	for _, severity := range []string{"critical", "important", "moderate", "low"} {
+		fmt.Fprintf(bw, "\tclassDef %s fill:%s\n", severity, severityColors[severity])
+	}
+	fmt.Fprintln(bw, "\tclassDef missing stroke-dasharray:3")
+	return bw.Flush()
+}
+
+// label returns the lines of the label of a node: KB, title, creation date and severity
+func (g *Graph) label(uid string) []string {
+	u, ok := g.updates[uid]
+	if !ok {
+		return []string{uid, "(not found)"}
+	}
+	var lines []string
+	if u.Kb != "" {
+		lines = append(lines, "KB"+u.Kb)
+	}
+	lines = append(lines, u.UpdateTitle)
+	last := u.UpdateCreationDate
+	if i := strings.Index(last, "T"); i >= 0 {
+		last = last[:i]
+	}
+	if u.MsrcSeverity != "" {
+		last += " " + u.MsrcSeverity
+	}
+	return append(lines, strings.TrimSpace(last))
+}
+
+// dotQuote returns s as a quoted DOT string
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// mermaidEscape escapes the quotes of a Mermaid label
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;").Replace(s)
+}
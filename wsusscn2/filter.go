@@ -0,0 +1,210 @@
+/**************************************************************************************************/
+// File: filter.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Query filters for the list endpoints
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const (
+	DefaultLimit       = 1000  // records per page
+	DefaultOffset      = 0     // records to skip
+	DefaultRecordLimit = 20000 // max records per list call
+	UidBatch           = 50    // update uids per request filtered by UpdateUid
+
+	dateLayout = "2006-01-02"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Page: Paging options shared by the paged list endpoints. Zero values use the defaults.
+type Page struct {
+	Limit       int // number of records per page
+	Offset      int // number of records to skip
+	RecordLimit int // max number of records to return
+	Parallel    int // number of pages fetched concurrently, records are still returned in order
+}
+
+// UpdateFilter: Filters for /update and /supersede. Multiple values for one field are ORed
+// together, fields are ANDed together.
+type UpdateFilter struct {
+	ProductTitle        []string
+	UpdateUid           []string
+	UpdateTitle         []string
+	Kb                  []string
+	UpdateType          []string
+	ProductFamilyTitle  []string
+	ClassificationTitle []string
+	MsrcSeverity        []string
+	Arch                []string
+
+	IsSuperseded string
+	IsBundled    string
+	IsPublic     string
+	IsBeta       string
+
+	UpdateCreationDateAfter  string // YYYY-MM-DD (exclusive)
+	UpdateCreationDateBefore string // YYYY-MM-DD (exclusive)
+	UpdateCreationDateOn     string // YYYY-MM-DD or "today"
+
+	Page
+}
+
+// CveFilter: Filters for /cve
+type CveFilter struct {
+	Cve                 []string
+	ProductTitle        []string
+	UpdateUid           []string
+	UpdateTitle         []string
+	Kb                  []string
+	ProductFamilyTitle  []string
+	ClassificationTitle []string
+	MsrcSeverity        []string
+	Arch                []string
+
+	Cvssv3BaseScore     string // score or range (Ex., 7.1-10.0)
+	Cvssv3TemporalScore string // score or range (Ex., 7.1-10.0)
+	IsSuperseded        string
+	IsInFile            string
+
+	Page
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// normalize fills in defaults for unset paging options
+func (p Page) normalize() Page {
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Offset <= 0 {
+		p.Offset = DefaultOffset
+	}
+	if p.RecordLimit <= 0 {
+		p.RecordLimit = DefaultRecordLimit
+	}
+	if p.RecordLimit < p.Limit {
+		p.Limit = p.RecordLimit
+	}
+	return p
+}
+
+// Batches calls fn with uids split into batches of at most UidBatch, the number of uids a
+// request filtered by UpdateUid takes. It stops at the first error.
+func Batches(uids []string, fn func(batch []string) error) error {
+	for start := 0; start < len(uids); start += UidBatch {
+		end := start + UidBatch
+		if end > len(uids) {
+			end = len(uids)
+		}
+		if err := fn(uids[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addAll(q url.Values, key string, values []string) {
+	for _, v := range values {
+		q.Add(key, v)
+	}
+}
+
+func addIfSet(q url.Values, key string, value string) {
+	if value != "" {
+		q.Add(key, value)
+	}
+}
+
+func checkDate(name string, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := time.Parse(dateLayout, value); err != nil {
+		return fmt.Errorf("Unable to parse %s. Expected: YYYY-MM-DD. Found %s", name, value)
+	}
+	return nil
+}
+
+// Values validates the filter and returns it as query parameters (without paging)
+func (f UpdateFilter) Values() (url.Values, error) {
+	if err := checkDate("update_creation_date_after", f.UpdateCreationDateAfter); err != nil {
+		return nil, err
+	}
+	if err := checkDate("update_creation_date_before", f.UpdateCreationDateBefore); err != nil {
+		return nil, err
+	}
+	if strings.ToLower(f.UpdateCreationDateOn) != "today" {
+		if err := checkDate("update_creation_date_on", f.UpdateCreationDateOn); err != nil {
+			return nil, err
+		}
+	}
+
+	q := url.Values{}
+	addAll(q, "product_title", f.ProductTitle)
+	addAll(q, "uid", f.UpdateUid)
+	addAll(q, "title", f.UpdateTitle)
+	addAll(q, "kb", f.Kb)
+	addAll(q, "type", f.UpdateType)
+	addAll(q, "product_family_title", f.ProductFamilyTitle)
+	addAll(q, "classification_title", f.ClassificationTitle)
+	addAll(q, "msrc_severity", f.MsrcSeverity)
+	addAll(q, "arch", f.Arch)
+	addIfSet(q, "is_superseded", f.IsSuperseded)
+	addIfSet(q, "is_bundled", f.IsBundled)
+	addIfSet(q, "is_public", f.IsPublic)
+	addIfSet(q, "is_beta", f.IsBeta)
+	addIfSet(q, "update_creation_date_after", f.UpdateCreationDateAfter)
+	addIfSet(q, "update_creation_date_before", f.UpdateCreationDateBefore)
+	addIfSet(q, "update_creation_date_on", f.UpdateCreationDateOn)
+	return q, nil
+}
+
+// Values returns the filter as query parameters (without paging)
+func (f CveFilter) Values() (url.Values, error) {
+	q := url.Values{}
+	addAll(q, "cve", f.Cve)
+	addAll(q, "product_title", f.ProductTitle)
+	addAll(q, "uid", f.UpdateUid)
+	addAll(q, "title", f.UpdateTitle)
+	addAll(q, "kb", f.Kb)
+	addAll(q, "product_family_title", f.ProductFamilyTitle)
+	addAll(q, "classification_title", f.ClassificationTitle)
+	addAll(q, "msrc_severity", f.MsrcSeverity)
+	addAll(q, "arch", f.Arch)
+	addIfSet(q, "is_in_file", f.IsInFile)
+	addIfSet(q, "is_superseded", f.IsSuperseded)
+	addIfSet(q, "cvssv3_base_score", f.Cvssv3BaseScore)
+	addIfSet(q, "cvssv3_temporal_score", f.Cvssv3TemporalScore)
+	return q, nil
+}
+
+// pageValues copies q and adds limit and offset
+func pageValues(q url.Values, limit int, offset int) url.Values {
+	pq := url.Values{}
+	for k, v := range q {
+		pq[k] = append([]string(nil), v...)
+	}
+	pq.Set("limit", strconv.Itoa(limit))
+	pq.Set("offset", strconv.Itoa(offset))
+	return pq
+}
@@ -0,0 +1,471 @@
+/**************************************************************************************************/
+// File: graph.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: In-memory graph of supersedence and bundling between updates
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Kinds of Link
+const (
+	LinkSupersedes = "supersedes"
+	LinkBundles    = "bundles"
+)
+
+// uidSeparators: Anything but the characters of an update uid separates the uids of the
+// Supersedes and Bundles fields: commas, semicolons, white space, brackets and quotes
+var uidSeparators = regexp.MustCompile(`[^0-9A-Za-z-]+`)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Graph: Directed graph of supersedence and bundling between updates, built from the Supersedes
+// and Bundles fields of updates. Updates listed for several products are one node. Supersede
+// records name the latest superseding update, not the next one, so they are kept apart from the
+// links and only followed for updates with no known direct superseder.
+type Graph struct {
+	updates  map[string]*Update  // first record of each update, by uid
+	products map[string][]string // product titles of each update
+
+	supersedes   map[string][]string // uid to the uids it supersedes
+	supersededBy map[string][]string
+	bundles      map[string][]string // uid to the uids bundled into it
+	bundledBy    map[string][]string
+	links        map[Link]bool
+	latest       map[string][]string // uid to the latest uids superseding it, from supersede records
+	latestOf     map[string][]string // reverse of latest
+	records      []UpdateSupersede   // supersede records as added, for Audit
+}
+
+// Link: Edge of a Graph. From supersedes or bundles To.
+type Link struct {
+	From string
+	To   string
+	Kind string // LinkSupersedes or LinkBundles
+}
+
+// ChainStep: Update in the supersedence chain of another one
+type ChainStep struct {
+	UpdateUid    string
+	Depth        int      // longest distance in supersedence steps from the start, negative for superseded updates
+	Supersedes   []string // uids in the chain this update supersedes directly
+	SupersededBy []string // uids in the chain superseding this update directly
+	Current      bool     // not superseded by anything
+	Missing      bool     // referenced, but not in the graph
+}
+
+// Chain: Supersedence chain of updates, from the oldest update they replace to their current
+// replacements
+type Chain struct {
+	Steps  []ChainStep // by Depth, then creation date
+	Cycles [][]string  // supersedence cycles met, each as uids from and back to the same update
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewGraph returns an empty graph
+func NewGraph() *Graph {
+	return &Graph{
+		updates:      make(map[string]*Update),
+		products:     make(map[string][]string),
+		supersedes:   make(map[string][]string),
+		supersededBy: make(map[string][]string),
+		bundles:      make(map[string][]string),
+		bundledBy:    make(map[string][]string),
+		links:        make(map[Link]bool),
+		latest:       make(map[string][]string),
+		latestOf:     make(map[string][]string),
+	}
+}
+
+// LoadGraph reads the updates and supersede records matching f from src into a new graph
+func LoadGraph(ctx context.Context, src Source, f UpdateFilter) (*Graph, error) {
+	g := NewGraph()
+	it := src.Updates(ctx, f)
+	for it.Next() {
+		g.Add(it.Update())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sit := src.Supersedes(ctx, f)
+	for sit.Next() {
+		g.AddSupersede(sit.Supersede())
+	}
+	if err := sit.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Fetch reads the updates with uids and their supersede records from src into the graph, for
+// links to updates outside of what was loaded, UidBatch uids per request. It returns the number
+// of updates added.
+func (g *Graph) Fetch(ctx context.Context, src Source, uids []string) (int, error) {
+	before := len(g.updates)
+	err := Batches(uids, func(batch []string) error {
+		f := UpdateFilter{UpdateUid: batch, Page: Page{RecordLimit: math.MaxInt32}}
+		it := src.Updates(ctx, f)
+		for it.Next() {
+			g.Add(it.Update())
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+
+		sit := src.Supersedes(ctx, f)
+		for sit.Next() {
+			g.AddSupersede(sit.Supersede())
+		}
+		return sit.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(g.updates) - before, nil
+}
+
+// ParseUids splits the Supersedes or Bundles field of an update into uids. Commas, semicolons,
+// white space, brackets and quotes are all accepted as separators.
+func ParseUids(field string) []string {
+	var uids []string
+	for _, uid := range uidSeparators.Split(field, -1) {
+		if uid != "" {
+			uids = append(uids, strings.ToLower(uid))
+		}
+	}
+	return uids
+}
+
+// Add adds update u and the updates it supersedes and bundles to the graph
+func (g *Graph) Add(u Update) {
+	uid := strings.ToLower(u.UpdateUid)
+	if uid == "" {
+		return
+	}
+	if _, ok := g.updates[uid]; !ok {
+		g.updates[uid] = &u
+	}
+	if u.ProductTitle != "" && !contains(g.products[uid], u.ProductTitle) {
+		g.products[uid] = append(g.products[uid], u.ProductTitle)
+	}
+	for _, old := range ParseUids(u.Supersedes) {
+		g.link(Link{From: uid, To: old, Kind: LinkSupersedes})
+	}
+	for _, part := range ParseUids(u.Bundles) {
+		g.link(Link{From: uid, To: part, Kind: LinkBundles})
+	}
+}
+
+// AddSupersede adds a supersede record. Its super_uid is the latest update superseding the
+// update, possibly through others, so it is not added as a link: a chain A, B, C would get a
+// shortcut from A to C.
+func (g *Graph) AddSupersede(s UpdateSupersede) {
+	if s.UpdateUid == "" || s.SuperUpdateUid == "" {
+		return
+	}
+	g.records = append(g.records, s)
+	uid, super := strings.ToLower(s.UpdateUid), strings.ToLower(s.SuperUpdateUid)
+	if uid != super && !contains(g.latest[uid], super) {
+		g.latest[uid] = append(g.latest[uid], super)
+		g.latestOf[super] = append(g.latestOf[super], uid)
+	}
+}
+
+func (g *Graph) link(l Link) {
+	if l.From == l.To || g.links[l] {
+		return
+	}
+	g.links[l] = true
+	if l.Kind == LinkBundles {
+		g.bundles[l.From] = append(g.bundles[l.From], l.To)
+		g.bundledBy[l.To] = append(g.bundledBy[l.To], l.From)
+	} else {
+		g.supersedes[l.From] = append(g.supersedes[l.From], l.To)
+		g.supersededBy[l.To] = append(g.supersededBy[l.To], l.From)
+	}
+}
+
+// Update returns the update with uid, false if it is not in the graph
+func (g *Graph) Update(uid string) (Update, bool) {
+	u, ok := g.updates[strings.ToLower(uid)]
+	if !ok {
+		return Update{}, false
+	}
+	return *u, true
+}
+
+// Products returns the product titles of the update with uid
+func (g *Graph) Products(uid string) []string {
+	return g.products[strings.ToLower(uid)]
+}
+
+// Len returns the number of updates in the graph
+func (g *Graph) Len() int {
+	return len(g.updates)
+}
+
+// Kb returns the uids of the updates of KB kb, sorted
+func (g *Graph) Kb(kb string) []string {
+	kb = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(kb)), "KB")
+	var uids []string
+	for uid, u := range g.updates {
+		if u.Kb == kb {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+// Supersedes returns the uids of the updates uid supersedes directly
+func (g *Graph) Supersedes(uid string) []string {
+	return g.supersedes[strings.ToLower(uid)]
+}
+
+// SupersededBy returns the uids of the updates superseding uid directly
+func (g *Graph) SupersededBy(uid string) []string {
+	return g.supersededBy[strings.ToLower(uid)]
+}
+
+// Latest returns the uids of the latest updates superseding uid according to the supersede
+// records
+func (g *Graph) Latest(uid string) []string {
+	return g.latest[strings.ToLower(uid)]
+}
+
+// superseders returns the updates superseding uid directly or, if none is known, the latest
+// updates superseding it
+func (g *Graph) superseders(uid string) []string {
+	if direct := g.supersededBy[uid]; len(direct) > 0 {
+		return direct
+	}
+	return g.latest[uid]
+}
+
+// predecessors is the reverse of superseders: the updates uid supersedes directly, and those it
+// is the latest superseder of that have no known direct superseder
+func (g *Graph) predecessors(uid string) []string {
+	result := g.supersedes[uid]
+	for _, old := range g.latestOf[uid] {
+		if len(g.supersededBy[old]) == 0 && !contains(result, old) {
+			result = append(result[:len(result):len(result)], old)
+		}
+	}
+	return result
+}
+
+// Bundles returns the uids of the updates bundled into uid
+func (g *Graph) Bundles(uid string) []string {
+	return g.bundles[strings.ToLower(uid)]
+}
+
+// BundledBy returns the uids of the updates uid is bundled into
+func (g *Graph) BundledBy(uid string) []string {
+	return g.bundledBy[strings.ToLower(uid)]
+}
+
+// Links returns every link of the graph, sorted
+func (g *Graph) Links() []Link {
+	links := make([]Link, 0, len(g.links))
+	for l := range g.links {
+		links = append(links, l)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		if links[i].Kind != links[j].Kind {
+			return links[i].Kind < links[j].Kind
+		}
+		return links[i].To < links[j].To
+	})
+	return links
+}
+
+// Broken returns the links to or from updates that are not in the graph
+func (g *Graph) Broken() []Link {
+	var broken []Link
+	for _, l := range g.Links() {
+		if g.updates[l.From] == nil || g.updates[l.To] == nil {
+			broken = append(broken, l)
+		}
+	}
+	return broken
+}
+
+// Chain returns the supersedence chain of the updates with uids: every update they supersede,
+// directly or through others, down to the oldest, and every update superseding them up to the
+// current replacements. Branches are followed and cycles are reported instead of followed. The
+// latest superseding update of a supersede record stands in for a missing direct superseder.
+func (g *Graph) Chain(uids ...string) Chain {
+	var chain Chain
+	starts := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		starts = append(starts, strings.ToLower(uid))
+	}
+
+	newer, cycles := g.walk(starts, g.superseders)
+	chain.Cycles = append(chain.Cycles, cycles...)
+	older, cycles := g.walk(starts, g.predecessors)
+	chain.Cycles = append(chain.Cycles, cycles...)
+
+	depth := newer
+	for uid, d := range older {
+		if _, ok := depth[uid]; !ok {
+			depth[uid] = -d
+		}
+	}
+	inChain := func(list []string) []string {
+		var result []string
+		for _, uid := range list {
+			if _, ok := depth[uid]; ok {
+				result = append(result, uid)
+			}
+		}
+		return result
+	}
+
+	for uid, d := range depth {
+		chain.Steps = append(chain.Steps, ChainStep{
+			UpdateUid:    uid,
+			Depth:        d,
+			Supersedes:   inChain(g.predecessors(uid)),
+			SupersededBy: inChain(g.superseders(uid)),
+			Current:      len(g.superseders(uid)) == 0,
+			Missing:      g.updates[uid] == nil,
+		})
+	}
+	sort.Slice(chain.Steps, func(i, j int) bool {
+		a, b := chain.Steps[i], chain.Steps[j]
+		if a.Depth != b.Depth {
+			return a.Depth < b.Depth
+		}
+		if ca, cb := g.created(a.UpdateUid), g.created(b.UpdateUid); ca != cb {
+			return ca < cb
+		}
+		return a.UpdateUid < b.UpdateUid
+	})
+	chain.Cycles = uniqueCycles(chain.Cycles)
+	return chain
+}
+
+// walk follows next from starts and returns the longest distance of every update reached, 0 for
+// the starts, and the cycles found. Links closing a cycle are left out of the distances.
+func (g *Graph) walk(starts []string, next func(uid string) []string) (map[string]int, [][]string) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	closing := make(map[Link]bool)
+	var order, stack []string
+	var cycles [][]string
+
+	var visit func(uid string)
+	visit = func(uid string) {
+		state[uid] = visiting
+		stack = append(stack, uid)
+		for _, n := range next(uid) {
+			switch state[n] {
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == n {
+						cycles = append(cycles, append(append([]string(nil), stack[i:]...), n))
+						break
+					}
+				}
+				closing[Link{From: uid, To: n}] = true
+			case 0:
+				visit(n)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[uid] = done
+		order = append(order, uid)
+	}
+	for _, uid := range starts {
+		if state[uid] == 0 {
+			visit(uid)
+		}
+	}
+
+	// reverse post order visits every update before those it leads to
+	depth := make(map[string]int)
+	for _, uid := range starts {
+		depth[uid] = 0
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		uid := order[i]
+		d, ok := depth[uid]
+		if !ok {
+			continue
+		}
+		for _, n := range next(uid) {
+			if closing[Link{From: uid, To: n}] {
+				continue
+			}
+			if nd, ok := depth[n]; !ok || nd < d+1 {
+				depth[n] = d + 1
+			}
+		}
+	}
+	return depth, cycles
+}
+
+// created returns the creation date of uid, empty if not in the graph
+func (g *Graph) created(uid string) string {
+	if u := g.updates[uid]; u != nil {
+		return u.UpdateCreationDate
+	}
+	return ""
+}
+
+// uniqueCycles drops cycles that are rotations of another one
+func uniqueCycles(cycles [][]string) [][]string {
+	seen := make(map[string]bool)
+	var unique [][]string
+	for _, c := range cycles {
+		ring := c[:len(c)-1]
+		min := 0
+		for i := range ring {
+			if ring[i] < ring[min] {
+				min = i
+			}
+		}
+		key := strings.Join(append(append([]string(nil), ring[min:]...), ring[:min]...), ",")
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, c)
+		}
+	}
+	return unique
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
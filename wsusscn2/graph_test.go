@@ -0,0 +1,208 @@
+/**************************************************************************************************/
+// File: graph_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the supersedence graph
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// batchSource: Source of updates u0, u1... where each update supersedes the one before. It
+// records the number of uids of each request.
+type batchSource struct {
+	Source
+	total    int
+	requests []int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func (s *batchSource) Updates(ctx context.Context, f UpdateFilter) *UpdateIterator {
+	s.requests = append(s.requests, len(f.UpdateUid))
+	var updates []Update
+	for _, uid := range f.UpdateUid {
+		var i int
+		if _, err := fmt.Sscanf(uid, "u%d", &i); err == nil && i < s.total {
+			u := Update{UpdateUid: uid}
+			if i > 0 {
+				u.Supersedes = fmt.Sprintf("u%d", i-1)
+			}
+			updates = append(updates, u)
+		}
+	}
+	return NewUpdateIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]Update, error) {
+		if offset >= len(updates) {
+			return nil, nil
+		}
+		if offset+limit > len(updates) {
+			limit = len(updates) - offset
+		}
+		return updates[offset : offset+limit], nil
+	})
+}
+
+func (s *batchSource) Supersedes(ctx context.Context, f UpdateFilter) *SupersedeIterator {
+	s.requests = append(s.requests, len(f.UpdateUid))
+	return NewSupersedeIterator(ctx, f.Page, func(ctx context.Context, limit int, offset int) ([]UpdateSupersede, error) {
+		return nil, nil
+	})
+}
+
+// testChain returns a graph of the chain a, b, c: b supersedes a and c supersedes b directly,
+// and the supersede records of a and b name c as their latest superseding update
+func testChain() *Graph {
+	g := NewGraph()
+	g.Add(Update{UpdateUid: "a", Kb: "1", ProductTitle: "Windows 10", UpdateCreationDate: "2017-06-13", IsSuperseded: "true"})
+	g.Add(Update{UpdateUid: "b", Kb: "2", ProductTitle: "Windows 10", UpdateCreationDate: "2017-07-11", IsSuperseded: "true", Supersedes: "a"})
+	g.Add(Update{UpdateUid: "c", Kb: "3", ProductTitle: "Windows 10", UpdateCreationDate: "2017-08-08", IsSuperseded: "false", Supersedes: "b"})
+	for _, uid := range []string{"a", "b"} {
+		g.AddSupersede(UpdateSupersede{UpdateUid: uid, ProductTitle: "Windows 10", IsSuperseded: "true", SuperUpdateUid: "c", SuperProductTitle: "Windows 10", SuperIsSuperseded: "false"})
+	}
+	return g
+}
+
+func TestChainLinks(t *testing.T) {
+	// the latest superseding update of a record is not a link, so there is no shortcut from c to a
+	g := testChain()
+	expected := []Link{{From: "b", To: "a", Kind: LinkSupersedes}, {From: "c", To: "b", Kind: LinkSupersedes}}
+	if links := g.Links(); !reflect.DeepEqual(links, expected) {
+		t.Errorf("Links %v, expected %v", links, expected)
+	}
+	if s := g.SupersededBy("a"); !reflect.DeepEqual(s, []string{"b"}) {
+		t.Errorf("a superseded by %v, expected b", s)
+	}
+	if l := g.Latest("a"); !reflect.DeepEqual(l, []string{"c"}) {
+		t.Errorf("Latest of a %v, expected c", l)
+	}
+
+	var b bytes.Buffer
+	if err := g.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	if edges := strings.Count(b.String(), "->"); edges != 2 {
+		t.Errorf("DOT has %d edges, expected 2:\n%s", edges, b.String())
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := testChain().Chain("a")
+	expected := []ChainStep{
+		{UpdateUid: "a", Depth: 0, SupersededBy: []string{"b"}},
+		{UpdateUid: "b", Depth: 1, Supersedes: []string{"a"}, SupersededBy: []string{"c"}},
+		{UpdateUid: "c", Depth: 2, Supersedes: []string{"b"}, Current: true},
+	}
+	if !reflect.DeepEqual(chain.Steps, expected) {
+		t.Errorf("Steps %+v, expected %+v", chain.Steps, expected)
+	}
+	if len(chain.Cycles) > 0 {
+		t.Errorf("Cycles %v", chain.Cycles)
+	}
+
+	chain = testChain().Chain("c")
+	if len(chain.Steps) != 3 || chain.Steps[0].UpdateUid != "a" || chain.Steps[0].Depth != -2 {
+		t.Errorf("Steps %+v, expected a at depth -2", chain.Steps)
+	}
+}
+
+func TestChainLatestOnly(t *testing.T) {
+	// without the direct supersedence, the latest superseding update of a record stands in
+	g := NewGraph()
+	g.Add(Update{UpdateUid: "a", Kb: "1", IsSuperseded: "true"})
+	g.Add(Update{UpdateUid: "c", Kb: "3", IsSuperseded: "false"})
+	g.AddSupersede(UpdateSupersede{UpdateUid: "a", IsSuperseded: "true", SuperUpdateUid: "c", SuperIsSuperseded: "false"})
+
+	if len(g.Links()) != 0 {
+		t.Errorf("Links %v, expected none", g.Links())
+	}
+	if current := g.current("a"); !reflect.DeepEqual(current, []string{"c"}) {
+		t.Errorf("Current of a %v, expected c", current)
+	}
+	if steps := g.Chain("c").Steps; len(steps) != 2 || steps[0].UpdateUid != "a" || steps[0].Depth != -1 {
+		t.Errorf("Steps %+v, expected a at depth -1", steps)
+	}
+	if anomalies := g.Audit(); len(anomalies) != 0 {
+		t.Errorf("Anomalies %+v", anomalies)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	g := testChain()
+	if anomalies := g.Audit(); len(anomalies) != 0 {
+		t.Errorf("Anomalies %+v", anomalies)
+	}
+
+	// a record naming b as the latest, while c supersedes b
+	g.AddSupersede(UpdateSupersede{UpdateUid: "a", ProductTitle: "Windows 10", IsSuperseded: "true", SuperUpdateUid: "b", SuperIsSuperseded: "true"})
+	anomalies := g.Audit()
+	if len(anomalies) != 1 || anomalies[0].Check != CheckNonTerminalLatest || anomalies[0].RelatedKb != "2" || !strings.Contains(anomalies[0].Detail, "KB3") {
+		t.Errorf("Anomalies %+v, expected b as non terminal latest", anomalies)
+	}
+}
+
+func TestGraphFetch(t *testing.T) {
+	src := &batchSource{total: 120}
+	var uids []string
+	for i := 0; i < 130; i++ {
+		uids = append(uids, fmt.Sprintf("u%d", i))
+	}
+	g := NewGraph()
+	added, err := g.Fetch(context.Background(), src, uids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 120 {
+		t.Errorf("Added %d updates, expected 120", added)
+	}
+	// updates and supersedes of each batch of UidBatch uids
+	expected := []int{UidBatch, UidBatch, UidBatch, UidBatch, 30, 30}
+	if !reflect.DeepEqual(src.requests, expected) {
+		t.Errorf("Requests of %v uids, expected %v", src.requests, expected)
+	}
+	if s := g.SupersededBy("u48"); !reflect.DeepEqual(s, []string{"u49"}) {
+		t.Errorf("u48 superseded by %v across batches, expected u49", s)
+	}
+
+	src.requests = nil
+	if added, err := g.Fetch(context.Background(), src, nil); added != 0 || err != nil || len(src.requests) != 0 {
+		t.Errorf("Fetch of no uids added %d, %v with %d requests", added, err, len(src.requests))
+	}
+}
+
+func TestAuditStaleSuperFlag(t *testing.T) {
+	for _, test := range []struct {
+		super string // latest superseding update of a
+		flag  string // super_is_superseded
+		check string
+	}{
+		{"b", "true", CheckNonTerminalLatest},
+		{"b", "false", CheckStaleSuperFlag},
+		{"c", "true", CheckStaleSuperFlag}, // nothing supersedes c
+		{"c", "false", ""},
+	} {
+		g := testChain()
+		g.AddSupersede(UpdateSupersede{UpdateUid: "a", ProductTitle: "Windows Server 2016", IsSuperseded: "true", SuperUpdateUid: test.super, SuperIsSuperseded: test.flag})
+		var checks []string
+		for _, a := range g.Audit() {
+			checks = append(checks, a.Check)
+		}
+		if test.check == "" && len(checks) != 0 || test.check != "" && !reflect.DeepEqual(checks, []string{test.check}) {
+			t.Errorf("Latest %s with super_is_superseded %s reported as %v, expected %q", test.super, test.flag, checks, test.check)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/**************************************************************************************************/
+// File: iterator.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Streaming iterators over the paged list endpoints
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// pageFunc fetches at most limit records starting at offset. It returns the records as a slice
+// and the number of records in it.
+type pageFunc func(ctx context.Context, limit int, offset int) (interface{}, int, error)
+
+// pageResult: One fetched page of a parallel pager
+type pageResult struct {
+	records interface{}
+	n       int
+	limit   int
+	err     error
+}
+
+// pager walks the pages of one list request. Only one page is held in memory at a time, or
+// Page.Parallel pages when fetching concurrently.
+type pager struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	fetch     pageFunc
+	page      Page
+	recordCnt int
+	offset    int
+	done      bool
+	err       error
+
+	pending    []chan pageResult // in-flight pages in offset order
+	launched   int               // records requested by launched pages
+	stopLaunch bool              // a short page marked the end
+}
+
+// UpdateIterator: Streams update records, fetching the next page when the current one is used up
+//
+//	it := c.Updates(ctx, filter)
+//	for it.Next() {
+//		u := it.Update()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type UpdateIterator struct {
+	p    *pager
+	page []Update
+	i    int
+}
+
+// CveIterator: Streams cve records, see UpdateIterator
+type CveIterator struct {
+	p    *pager
+	page []Cve
+	i    int
+}
+
+// SupersedeIterator: Streams supersede records, see UpdateIterator
+type SupersedeIterator struct {
+	p    *pager
+	page []UpdateSupersede
+	i    int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func newPager(ctx context.Context, page Page, fetch pageFunc) *pager {
+	page = page.normalize()
+	ctx, cancel := context.WithCancel(ctx)
+	return &pager{
+		ctx:    ctx,
+		cancel: cancel,
+		fetch:  fetch,
+		page:   page,
+		offset: page.Offset,
+	}
+}
+
+// finish stops the pager, cancels in-flight requests and records err
+func (p *pager) finish(err error) {
+	p.done = true
+	if p.err == nil {
+		p.err = err
+	}
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.pending = nil
+}
+
+// failedPager returns a pager that yields no records and reports err
+func failedPager(err error) *pager {
+	return &pager{done: true, err: err, page: Page{}.normalize()}
+}
+
+// next returns the next non-empty page. It returns false once the record limit was reached,
+// a short page marked the end, the context was cancelled or an error occurred.
+func (p *pager) next() (interface{}, bool) {
+	if p.page.Parallel > 1 {
+		return p.nextParallel()
+	}
+
+	if p.done || p.recordCnt >= p.page.RecordLimit {
+		p.finish(nil)
+		return nil, false
+	}
+
+	if err := p.ctx.Err(); err != nil {
+		p.finish(err)
+		return nil, false
+	}
+
+	limit := p.page.Limit
+	if remaining := p.page.RecordLimit - p.recordCnt; remaining < limit {
+		limit = remaining
+	}
+
+	records, curRecordCnt, err := p.fetch(p.ctx, limit, p.offset)
+	if err != nil {
+		p.finish(p.fetchErr(err))
+		return nil, false
+	}
+
+	p.recordCnt += curRecordCnt
+	p.offset += curRecordCnt
+
+	if curRecordCnt < limit {
+		p.finish(nil)
+	}
+	if curRecordCnt == 0 {
+		return nil, false
+	}
+	return records, true
+}
+
+// fetchErr returns the error of the context once it is done, since a failed fetch then only
+// wraps it (Ex., in a *url.Error), and err otherwise
+func (p *pager) fetchErr(err error) error {
+	if ctxErr := p.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// launch starts fetching the next offset window in the background
+func (p *pager) launch() {
+	limit := p.page.Limit
+	if remaining := p.page.RecordLimit - p.launched; remaining < limit {
+		limit = remaining
+	}
+	offset := p.offset + p.launched
+	p.launched += limit
+
+	result := make(chan pageResult, 1)
+	p.pending = append(p.pending, result)
+	go func() {
+		records, n, err := p.fetch(p.ctx, limit, offset)
+		result <- pageResult{records: records, n: n, limit: limit, err: err}
+	}()
+}
+
+// nextParallel keeps up to Page.Parallel pages in flight and returns them in offset order.
+// Every window is assumed to be full until a short page marks the end.
+func (p *pager) nextParallel() (interface{}, bool) {
+	for !p.done {
+		if err := p.ctx.Err(); err != nil {
+			p.finish(err)
+			break
+		}
+		for !p.stopLaunch && len(p.pending) < p.page.Parallel && p.launched < p.page.RecordLimit {
+			p.launch()
+		}
+		if len(p.pending) == 0 {
+			p.finish(nil)
+			break
+		}
+
+		res := <-p.pending[0]
+		p.pending = p.pending[1:]
+		if res.err != nil {
+			p.finish(p.fetchErr(res.err))
+			break
+		}
+
+		p.recordCnt += res.n
+		if res.n < res.limit {
+			p.stopLaunch = true
+			p.finish(nil)
+		}
+		if res.n > 0 {
+			return res.records, true
+		}
+	}
+	return nil, false
+}
+
+// Next advances to the next update. It returns false when there are no more records or an
+// error occurred, check Err afterwards.
+func (it *UpdateIterator) Next() bool {
+	for it.i >= len(it.page) {
+		records, ok := it.p.next()
+		if !ok {
+			it.page, it.i = nil, 0
+			return false
+		}
+		it.page, it.i = records.([]Update), 0
+	}
+	it.i++
+	return true
+}
+
+// Update returns the current record
+func (it *UpdateIterator) Update() Update {
+	return it.page[it.i-1]
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *UpdateIterator) Err() error {
+	return it.p.err
+}
+
+// Next advances to the next cve, see UpdateIterator.Next
+func (it *CveIterator) Next() bool {
+	for it.i >= len(it.page) {
+		records, ok := it.p.next()
+		if !ok {
+			it.page, it.i = nil, 0
+			return false
+		}
+		it.page, it.i = records.([]Cve), 0
+	}
+	it.i++
+	return true
+}
+
+// Cve returns the current record
+func (it *CveIterator) Cve() Cve {
+	return it.page[it.i-1]
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *CveIterator) Err() error {
+	return it.p.err
+}
+
+// Next advances to the next supersede record, see UpdateIterator.Next
+func (it *SupersedeIterator) Next() bool {
+	for it.i >= len(it.page) {
+		records, ok := it.p.next()
+		if !ok {
+			it.page, it.i = nil, 0
+			return false
+		}
+		it.page, it.i = records.([]UpdateSupersede), 0
+	}
+	it.i++
+	return true
+}
+
+// Supersede returns the current record
+func (it *SupersedeIterator) Supersede() UpdateSupersede {
+	return it.page[it.i-1]
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *SupersedeIterator) Err() error {
+	return it.p.err
+}
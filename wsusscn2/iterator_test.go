@@ -0,0 +1,145 @@
+/**************************************************************************************************/
+// File: iterator_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the paged iterators
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// testPages returns a pageFunc over total updates, answering the first pages last
+func testPages(total int) pageFunc {
+	return func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		time.Sleep(time.Duration(total-offset) * time.Millisecond)
+
+		var page []Update
+		for i := offset; i < offset+limit && i < total; i++ {
+			page = append(page, Update{UpdateUid: fmt.Sprint(i)})
+		}
+		return page, len(page), nil
+	}
+}
+
+func TestUpdateIterator(t *testing.T) {
+	for _, parallel := range []int{1, 4} {
+		for _, tc := range []struct {
+			total, recordLimit, expected int
+		}{
+			{25, 100, 25}, // short last page
+			{21, 100, 21}, // full last page, one more empty page
+			{25, 10, 10},  // record limit inside a page
+			{0, 100, 0},
+		} {
+			fetch := testPages(tc.total)
+			it := &UpdateIterator{p: newPager(context.Background(), Page{Limit: 7, RecordLimit: tc.recordLimit, Parallel: parallel}, fetch)}
+			n := 0
+			for it.Next() {
+				if uid := it.Update().UpdateUid; uid != fmt.Sprint(n) {
+					t.Fatalf("parallel %d, %d records: record %d is %s", parallel, tc.total, n, uid)
+				}
+				n++
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n != tc.expected {
+				t.Errorf("parallel %d, %d records, limit %d: got %d records, expected %d", parallel, tc.total, tc.recordLimit, n, tc.expected)
+			}
+		}
+	}
+}
+
+func TestUpdateIteratorError(t *testing.T) {
+	failure := errors.New("Page failed")
+	for _, parallel := range []int{1, 4} {
+		fetch := testPages(100)
+		it := &UpdateIterator{p: newPager(context.Background(), Page{Limit: 10, RecordLimit: 100, Parallel: parallel}, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+			if offset == 20 {
+				return nil, 0, failure
+			}
+			return fetch(ctx, limit, offset)
+		})}
+		n := 0
+		for it.Next() {
+			n++
+		}
+		if it.Err() != failure || n != 20 {
+			t.Errorf("parallel %d: got %d records and %v, expected 20 records and %v", parallel, n, it.Err(), failure)
+		}
+	}
+}
+
+// TestUpdateIteratorCancel cancels the context while later pages are in flight. The iteration
+// must stop with context.Canceled and leave no fetch goroutine behind.
+func TestUpdateIteratorCancel(t *testing.T) {
+	for _, parallel := range []int{1, 4} {
+		baseline := runtime.NumGoroutine()
+
+		// the first page is answered, the others only end when their request is cancelled
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			if offset, _ := strconv.Atoi(r.URL.Query().Get("offset")); offset > 0 {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+				return
+			}
+			page := make([]Update, limit)
+			for i := range page {
+				page[i].UpdateUid = fmt.Sprint(i)
+			}
+			json.NewEncoder(w).Encode(page)
+		}))
+		transport := &http.Transport{}
+		c := NewClient(srv.URL, "test")
+		c.HttpClient = &http.Client{Transport: transport}
+		c.Logger = log.New(ioutil.Discard, "", 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		it := c.Updates(ctx, UpdateFilter{Page: Page{Limit: 7, RecordLimit: 100, Parallel: parallel}})
+		n := 0
+		for it.Next() {
+			if n++; n == 7 {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+		}
+		cancel()
+		if it.Err() != context.Canceled || n != 7 {
+			t.Errorf("parallel %d: got %d records and %v, expected 7 records and %v", parallel, n, it.Err(), context.Canceled)
+		}
+		if it.Next() {
+			t.Errorf("parallel %d: Next returned a record after the cancellation", parallel)
+		}
+
+		srv.Close()
+		transport.CloseIdleConnections()
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > baseline {
+			buf := make([]byte, 1<<16)
+			t.Errorf("parallel %d: %d goroutines left, expected %d\n%s", parallel, n, baseline, buf[:runtime.Stack(buf, true)])
+		}
+	}
+}
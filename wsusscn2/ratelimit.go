@@ -0,0 +1,175 @@
+/**************************************************************************************************/
+// File: ratelimit.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Client-side rate limiting and API quota tracking
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// rateLimiter: Token bucket refilled at rate tokens per second, holding at most burst tokens
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time                                 // clock, time.Now
+	sleep func(ctx context.Context, d time.Duration) error // wait on the clock, sleep
+}
+
+// Quota: Rate limit and usage reported by the service in its response headers. Limit and
+// Remaining are -1 when the service did not report them.
+type Quota struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time // zero if not reported
+	Updated   time.Time // time of the response the values were read from
+}
+
+// quotaTracker: Latest Quota seen, shared by concurrent requests
+type quotaTracker struct {
+	mu    sync.Mutex
+	quota Quota
+	seen  bool
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func newRateLimiter(rps float64) *rateLimiter {
+	burst := math.Max(1, math.Ceil(rps))
+	return &rateLimiter{rate: rps, burst: burst, tokens: burst, last: time.Now(), now: time.Now, sleep: sleep}
+}
+
+// wait blocks until a token is available or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		// rounded up, a delay truncated to 0 would not add the missing fraction of a token
+		delay := time.Duration(math.Ceil((1 - l.tokens) / l.rate * float64(time.Second)))
+		l.mu.Unlock()
+
+		if err := l.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// SetRateLimit limits the client to rps requests per second. 0 removes the limit.
+func (c *Client) SetRateLimit(rps float64) {
+	if rps <= 0 {
+		c.limiter = nil
+		return
+	}
+	c.limiter = newRateLimiter(rps)
+}
+
+// Quota returns the quota reported with the most recent response. ok is false if the service
+// has not sent any rate limit headers yet.
+func (c *Client) Quota() (quota Quota, ok bool) {
+	c.quota.mu.Lock()
+	defer c.quota.mu.Unlock()
+	return c.quota.quota, c.quota.seen
+}
+
+// String formats the quota for logging
+func (q Quota) String() string {
+	s := "Quota:"
+	if q.Remaining >= 0 {
+		s += fmt.Sprintf(" %d", q.Remaining)
+		if q.Limit >= 0 {
+			s += fmt.Sprintf("/%d", q.Limit)
+		}
+		s += " requests remaining"
+	} else if q.Limit >= 0 {
+		s += fmt.Sprintf(" limit %d requests", q.Limit)
+	}
+	if !q.Reset.IsZero() {
+		s += ", resets " + q.Reset.Format(time.RFC3339)
+	}
+	return s
+}
+
+// headerInt returns the first of names present in h as an integer, or -1
+func headerInt(h http.Header, names ...string) int64 {
+	for _, name := range names {
+		v := strings.TrimSpace(h.Get(name))
+		if v == "" {
+			continue
+		}
+		// draft RateLimit headers may carry a policy: "100, 100;w=60"
+		if i := strings.IndexAny(v, ",;"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return -1
+}
+
+// parseQuota reads the common rate limit headers. Reset is accepted as unix time or as seconds
+// from now.
+func parseQuota(h http.Header, now time.Time) (Quota, bool) {
+	q := Quota{
+		Limit:     headerInt(h, "X-RateLimit-Limit", "RateLimit-Limit", "X-Quota-Limit"),
+		Remaining: headerInt(h, "X-RateLimit-Remaining", "RateLimit-Remaining", "X-Quota-Remaining"),
+		Updated:   now,
+	}
+	if reset := headerInt(h, "X-RateLimit-Reset", "RateLimit-Reset", "X-Quota-Reset"); reset >= 0 {
+		if reset > 1000000000 {
+			q.Reset = time.Unix(reset, 0)
+		} else {
+			q.Reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	if q.Limit < 0 && q.Remaining < 0 && q.Reset.IsZero() {
+		return q, false
+	}
+	return q, true
+}
+
+// updateQuota records the quota from a response. It is logged the first time it is seen, when
+// less than 10% is remaining and when debugging.
+func (c *Client) updateQuota(h http.Header) {
+	q, ok := parseQuota(h, time.Now())
+	if !ok {
+		return
+	}
+
+	c.quota.mu.Lock()
+	first := !c.quota.seen
+	c.quota.quota, c.quota.seen = q, true
+	c.quota.mu.Unlock()
+
+	if first || c.Debug || (q.Limit > 0 && q.Remaining >= 0 && q.Remaining*10 < q.Limit) {
+		c.logf("%s", q)
+	}
+}
@@ -0,0 +1,205 @@
+/**************************************************************************************************/
+// File: ratelimit_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the rate limiter on a fake clock and of the quota headers
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// fakeClock: Clock that only moves when slept on
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.t = c.t.Add(d)
+	c.slept += d
+	return nil
+}
+
+// testLimiter returns a limiter of rps on a fake clock
+func testLimiter(rps float64) (*rateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC)}
+	l := newRateLimiter(rps)
+	l.now, l.sleep, l.last = clock.now, clock.sleep, clock.t
+	return l, clock
+}
+
+// waitN takes n tokens and returns the time it took on the clock
+func waitN(t *testing.T, l *rateLimiter, clock *fakeClock, n int) time.Duration {
+	start := clock.t
+	for i := 0; i < n; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return clock.t.Sub(start)
+}
+
+// near reports if d is within a millisecond of expected, allowing for float rounding
+func near(d time.Duration, expected time.Duration) bool {
+	return d > expected-time.Millisecond && d < expected+time.Millisecond
+}
+
+func TestRateLimiter(t *testing.T) {
+	for _, tc := range []struct {
+		rps      float64
+		n        int
+		expected time.Duration
+	}{
+		{2, 2, 0},                      // burst of 2
+		{2, 3, 500 * time.Millisecond}, // then one every 500ms
+		{2, 10, 4 * time.Second},       // 2 at once and 8 more
+		{10, 30, 2 * time.Second},      // burst of 10
+		{0.5, 1, 0},                    // burst of 1
+		{0.5, 3, 4 * time.Second},      // one every 2s
+		{1.5, 5, 2 * time.Second},      // burst of 2, then one every 667ms
+		{1, 61, 60 * time.Second},      // a minute of requests
+		{100, 1000, 9 * time.Second},   // burst of 100
+	} {
+		l, clock := testLimiter(tc.rps)
+		if d := waitN(t, l, clock, tc.n); !near(d, tc.expected) {
+			t.Errorf("%v rps: %d requests took %s, expected %s", tc.rps, tc.n, d, tc.expected)
+		}
+	}
+}
+
+func TestRateLimiterIdle(t *testing.T) {
+	// tokens add up while idle, up to the burst
+	l, clock := testLimiter(2)
+	waitN(t, l, clock, 2)
+	clock.t = clock.t.Add(10 * time.Second)
+	if d := waitN(t, l, clock, 2); d != 0 {
+		t.Errorf("Burst after idling took %s", d)
+	}
+	if d := waitN(t, l, clock, 1); !near(d, 500*time.Millisecond) {
+		t.Errorf("Request after the burst took %s, expected 500ms", d)
+	}
+
+	// half a token left after 250ms
+	clock.t = clock.t.Add(250 * time.Millisecond)
+	if d := waitN(t, l, clock, 1); !near(d, 250*time.Millisecond) {
+		t.Errorf("Request took %s, expected 250ms", d)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l, clock := testLimiter(1)
+	waitN(t, l, clock, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("Wait for a token returned %v, expected %v", err, context.Canceled)
+	}
+	if clock.slept != 0 {
+		t.Errorf("Slept %s after the cancellation", clock.slept)
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	c := NewClient("http://localhost", "test")
+	c.SetRateLimit(5)
+	if c.limiter == nil || c.limiter.rate != 5 || c.limiter.burst != 5 {
+		t.Errorf("Limiter %+v, expected 5 rps", c.limiter)
+	}
+	c.SetRateLimit(0)
+	if c.limiter != nil {
+		t.Errorf("Limiter not removed")
+	}
+}
+
+func TestParseQuota(t *testing.T) {
+	now := time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		headers          map[string]string
+		limit, remaining int64
+		reset            time.Time
+		ok               bool
+	}{
+		{map[string]string{"X-RateLimit-Limit": "1000", "X-RateLimit-Remaining": "998", "X-RateLimit-Reset": "1539090000"},
+			1000, 998, time.Unix(1539090000, 0), true},
+		{map[string]string{"X-RateLimit-Limit": "1000", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "3600"},
+			1000, 0, now.Add(time.Hour), true},
+		// draft IETF headers with a policy
+		{map[string]string{"RateLimit-Limit": "100, 100;w=60", "RateLimit-Remaining": "42", "RateLimit-Reset": "30"},
+			100, 42, now.Add(30 * time.Second), true},
+		{map[string]string{"RateLimit-Limit": "100;w=60"}, 100, -1, time.Time{}, true},
+		{map[string]string{"X-Quota-Limit": "50000", "X-Quota-Remaining": "12345"}, 50000, 12345, time.Time{}, true},
+		{map[string]string{"X-RateLimit-Remaining": " 7 "}, -1, 7, time.Time{}, true},
+		// X-RateLimit wins over RateLimit
+		{map[string]string{"X-RateLimit-Limit": "10", "RateLimit-Limit": "20"}, 10, -1, time.Time{}, true},
+		// invalid values fall through to the next header
+		{map[string]string{"X-RateLimit-Limit": "unlimited", "RateLimit-Limit": "20"}, 20, -1, time.Time{}, true},
+		{map[string]string{"X-RateLimit-Limit": "unlimited"}, -1, -1, time.Time{}, false},
+		{map[string]string{"Content-Type": "application/json"}, -1, -1, time.Time{}, false},
+	} {
+		h := make(http.Header)
+		for k, v := range tc.headers {
+			h.Set(k, v)
+		}
+		q, ok := parseQuota(h, now)
+		if ok != tc.ok || q.Limit != tc.limit || q.Remaining != tc.remaining || !q.Reset.Equal(tc.reset) {
+			t.Errorf("%v: got %d/%d reset %s ok %v, expected %d/%d reset %s ok %v", tc.headers,
+				q.Remaining, q.Limit, q.Reset, ok, tc.remaining, tc.limit, tc.reset, tc.ok)
+		}
+	}
+}
+
+func TestQuotaString(t *testing.T) {
+	reset := time.Date(2018, 10, 9, 13, 0, 0, 0, time.UTC)
+	for q, expected := range map[Quota]string{
+		{Limit: 1000, Remaining: 998, Reset: reset}: "Quota: 998/1000 requests remaining, resets 2018-10-09T13:00:00Z",
+		{Limit: -1, Remaining: 7}:                   "Quota: 7 requests remaining",
+		{Limit: 100, Remaining: -1}:                 "Quota: limit 100 requests",
+	} {
+		if s := q.String(); s != expected {
+			t.Errorf("Got %q, expected %q", s, expected)
+		}
+	}
+}
+
+func TestClientQuota(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", "999")
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	c := testClient(srv, 0)
+	if _, ok := c.Quota(); ok {
+		t.Errorf("Quota known before any request")
+	}
+	if _, err := c.ListProducts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if q, ok := c.Quota(); !ok || q.Limit != 1000 || q.Remaining != 999 {
+		t.Errorf("Quota %+v, %v after a request", q, ok)
+	}
+}
@@ -0,0 +1,226 @@
+/**************************************************************************************************/
+// File: remediate.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Minimal set of current updates remediating a list of CVEs
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const remediateDepth = 10 // supersedence steps Remediate follows past the latest supersession
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Remediation: Current update to install on a product, architecture and version, with the CVEs
+// it remediates
+type Remediation struct {
+	ProductTitle       string `json:"product_title"`
+	Arch               string `json:"arch"`
+	Version            string `json:"version"` // from the update title, empty if it has none
+	Kb                 string `json:"kb"`
+	UpdateUid          string `json:"update_uid"`
+	UpdateTitle        string `json:"update_title"`
+	UpdateCreationDate string `json:"update_creation_date"`
+	MsrcSeverity       string `json:"msrc_severity"`
+	CveCount           int    `json:"cve_count"`
+	Cves               string `json:"cves"`     // comma separated
+	Replaces           string `json:"replaces"` // KBs of the CVE records superseded by this update, comma separated
+	Note               string `json:"note"`
+}
+
+// remediationGroup: CVEs of one product, architecture and version, with the current updates
+// remediating each of them
+type remediationGroup struct {
+	product, arch, version string
+	cves                   []string
+	fixes                  map[string][]string // current uids by CVE
+	replaces               map[string][]string // KBs of the CVE records by current uid
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// Remediate returns the fewest current updates remediating the CVEs matching f, per product,
+// architecture and version. Each CVE record is followed from its update, or the latest update
+// superseding it, to the updates nothing supersedes. CVEs fixed by the same cumulative update
+// collapse into one row.
+func Remediate(ctx context.Context, src Source, f CveFilter) ([]Remediation, error) {
+	var records []Cve
+	var targets []string
+	it := src.Cves(ctx, f)
+	for it.Next() {
+		c := it.Cve()
+		records = append(records, c)
+		targets = append(targets, remediationTarget(c))
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	// the latest supersession may be superseded since, follow it a few steps
+	g := NewGraph()
+	fetched := make(map[string]bool)
+	pending := targets
+	for depth := 0; len(pending) > 0 && depth < remediateDepth; depth++ {
+		var uids []string
+		for _, uid := range pending {
+			if uid != "" && !fetched[uid] {
+				fetched[uid] = true
+				uids = append(uids, uid)
+			}
+		}
+		if _, err := g.Fetch(ctx, src, uids); err != nil {
+			return nil, err
+		}
+		pending = nil
+		for _, uid := range uids {
+			pending = append(pending, g.superseders(uid)...)
+		}
+	}
+
+	groups := make(map[string]*remediationGroup)
+	var keys []string
+	for i, c := range records {
+		if targets[i] == "" {
+			continue
+		}
+		version := TitleVersion(c.UpdateTitle)
+		key := strings.ToLower(c.ProductTitle + "\x00" + c.Arch + "\x00" + version)
+		grp, ok := groups[key]
+		if !ok {
+			grp = &remediationGroup{product: c.ProductTitle, arch: c.Arch, version: version, fixes: make(map[string][]string), replaces: make(map[string][]string)}
+			groups[key] = grp
+			keys = append(keys, key)
+		}
+		cve := strings.ToUpper(c.Cve)
+		if _, ok := grp.fixes[cve]; !ok {
+			grp.cves = append(grp.cves, cve)
+			grp.fixes[cve] = nil
+		}
+		for _, uid := range g.remediations(targets[i], c.ProductTitle) {
+			if !contains(grp.fixes[cve], uid) {
+				grp.fixes[cve] = append(grp.fixes[cve], uid)
+			}
+			if u := g.updates[uid]; c.Kb != "" && (u == nil || u.Kb != c.Kb) && !contains(grp.replaces[uid], c.Kb) {
+				grp.replaces[uid] = append(grp.replaces[uid], c.Kb)
+			}
+		}
+	}
+
+	var remediations []Remediation
+	sort.Strings(keys)
+	for _, key := range keys {
+		remediations = append(remediations, g.cover(groups[key])...)
+	}
+	return remediations, nil
+}
+
+// remediationTarget returns the uid of the latest update superseding the update of CVE record c,
+// or of the update itself if it is not superseded
+func remediationTarget(c Cve) string {
+	if superseded, err := strconv.ParseBool(c.IsSuperseded); err == nil && superseded && c.LatestSupersessionUid != "" {
+		return strings.ToLower(c.LatestSupersessionUid)
+	}
+	return strings.ToLower(c.UpdateUid)
+}
+
+// remediations returns the current updates superseding uid, preferring those of product, or uid
+// itself if nothing supersedes it
+func (g *Graph) remediations(uid string, product string) []string {
+	current := g.current(uid)
+	if len(current) == 0 {
+		return []string{uid}
+	}
+	var same []string
+	for _, c := range current {
+		if contains(g.products[c], product) {
+			same = append(same, c)
+		}
+	}
+	if len(same) > 0 {
+		return same
+	}
+	return current
+}
+
+// cover picks the updates of grp covering every CVE: repeatedly the update remediating the most
+// CVEs not yet covered, the newest on ties
+func (g *Graph) cover(grp *remediationGroup) []Remediation {
+	covers := make(map[string][]string) // CVEs by uid
+	var uids []string
+	for _, cve := range grp.cves {
+		for _, uid := range grp.fixes[cve] {
+			if _, ok := covers[uid]; !ok {
+				uids = append(uids, uid)
+			}
+			covers[uid] = append(covers[uid], cve)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		if ci, cj := g.created(uids[i]), g.created(uids[j]); ci != cj {
+			return ci > cj
+		}
+		return uids[i] < uids[j]
+	})
+
+	covered := make(map[string]bool)
+	var remediations []Remediation
+	for len(covered) < len(grp.cves) {
+		best, bestCount := "", 0
+		for _, uid := range uids {
+			count := 0
+			for _, cve := range covers[uid] {
+				if !covered[cve] {
+					count++
+				}
+			}
+			if count > bestCount {
+				best, bestCount = uid, count
+			}
+		}
+		if best == "" {
+			break
+		}
+		for _, cve := range covers[best] {
+			covered[cve] = true
+		}
+		sort.Strings(covers[best])
+		sort.Strings(grp.replaces[best])
+
+		r := Remediation{
+			ProductTitle: grp.product,
+			Arch:         grp.arch,
+			Version:      grp.version,
+			UpdateUid:    best,
+			CveCount:     len(covers[best]),
+			Cves:         strings.Join(covers[best], ","),
+			Replaces:     strings.Join(grp.replaces[best], ","),
+		}
+		if u := g.updates[best]; u != nil {
+			r.Kb, r.UpdateTitle, r.UpdateCreationDate, r.MsrcSeverity = u.Kb, u.UpdateTitle, u.UpdateCreationDate, u.MsrcSeverity
+			if superseded, err := strconv.ParseBool(u.IsSuperseded); err == nil && superseded {
+				r.Note = "is_superseded, but no superseding update found"
+			}
+		} else {
+			r.Note = "update not found"
+		}
+		remediations = append(remediations, r)
+	}
+	return remediations
+}
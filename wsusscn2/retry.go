@@ -0,0 +1,113 @@
+/**************************************************************************************************/
+// File: retry.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Retry policy for failed API requests
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const (
+	DefaultMaxRetries = 3               // retries after the first attempt
+	DefaultRetryWait  = 1 * time.Second // wait before the first retry, doubled for every retry
+	maxRetryWait      = 2 * time.Minute // upper bound for backoff and Retry-After
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// statusError: Non-200 response from the service
+type statusError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func (e *statusError) Error() string {
+	return e.Message
+}
+
+// retryable reports if a request that failed with err should be tried again
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *statusError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case net.Error:
+		// dns failures, refused or reset connections and timeouts. *url.Error is a net.Error.
+		return true
+	}
+	// response body cut off
+	return err == io.ErrUnexpectedEOF
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// backoff returns the wait before retry number attempt (0 based): RetryWait doubled per
+// attempt, jittered between half and all of it. A Retry-After from the service wins.
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	if se, ok := err.(*statusError); ok && se.RetryAfter > 0 {
+		if se.RetryAfter > maxRetryWait {
+			return maxRetryWait
+		}
+		return se.RetryAfter
+	}
+
+	wait := c.RetryWait
+	if wait <= 0 {
+		wait = DefaultRetryWait
+	}
+	for i := 0; i < attempt && wait < maxRetryWait; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryWait {
+		wait = maxRetryWait
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
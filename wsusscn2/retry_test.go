@@ -0,0 +1,254 @@
+/**************************************************************************************************/
+// File: retry_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the retry policy against a fake API failing a number of times
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// flakyApi: Fake /update endpoint over total updates. The page at each offset of failures fails
+// that many times with status before it is answered.
+type flakyApi struct {
+	total      int
+	status     int
+	retryAfter string // Retry-After header of the failures
+	failures   map[int]int
+
+	mu       sync.Mutex
+	requests map[int]int // requests by offset
+}
+
+// writerFunc: io.Writer calling a function
+type writerFunc func(p []byte) (int, error)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (a *flakyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	a.mu.Lock()
+	if a.requests == nil {
+		a.requests = make(map[int]int)
+	}
+	a.requests[offset]++
+	fail := a.requests[offset] <= a.failures[offset]
+	a.mu.Unlock()
+
+	if fail {
+		if a.retryAfter != "" {
+			w.Header().Set("Retry-After", a.retryAfter)
+		}
+		w.WriteHeader(a.status)
+		return
+	}
+	page := []Update{}
+	for i := offset; i < offset+limit && i < a.total; i++ {
+		page = append(page, Update{UpdateUid: fmt.Sprint(i)})
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// testClient returns a client of srv retrying maxRetries times without a noticeable wait
+func testClient(srv *httptest.Server, maxRetries int) *Client {
+	c := NewClient(srv.URL, "test")
+	c.MaxRetries = maxRetries
+	c.RetryWait = time.Millisecond
+	c.Logger = log.New(ioutil.Discard, "", 0)
+	return c
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		expected bool
+	}{
+		{&statusError{StatusCode: 429}, true},
+		{&statusError{StatusCode: 500}, true},
+		{&statusError{StatusCode: 502}, true},
+		{&statusError{StatusCode: 503}, true},
+		{&statusError{StatusCode: 400}, false},
+		{&statusError{StatusCode: 401}, false},
+		{&statusError{StatusCode: 403}, false},
+		{&statusError{StatusCode: 404}, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("invalid character '<' looking for beginning of value"), false},
+	} {
+		if retryable(tc.err) != tc.expected {
+			t.Errorf("retryable(%#v) = %v, expected %v", tc.err, !tc.expected, tc.expected)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 10, 9, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"":                                0,
+		"120":                             2 * time.Minute,
+		" 5 ":                             5 * time.Second,
+		"0":                               0,
+		"-3":                              0,
+		"soon":                            0,
+		"Tue, 09 Oct 2018 12:00:30 GMT":   30 * time.Second,
+		"Tuesday, 09-Oct-18 12:01:00 GMT": time.Minute,
+		"Tue Oct  9 12:00:10 2018":        10 * time.Second,
+		"Tue, 09 Oct 2018 11:59:00 GMT":   0,
+	} {
+		if d := parseRetryAfter(value, now); d != expected {
+			t.Errorf("parseRetryAfter(%q) = %s, expected %s", value, d, expected)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{RetryWait: 100 * time.Millisecond}
+	failure := &statusError{StatusCode: 503}
+	for attempt, wait := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if d := c.backoff(attempt, failure); d < wait/2 || d > wait {
+				t.Fatalf("Attempt %d waits %s, expected %s to %s", attempt, d, wait/2, wait)
+			}
+		}
+	}
+	if d := c.backoff(30, failure); d < maxRetryWait/2 || d > maxRetryWait {
+		t.Errorf("Attempt 30 waits %s, expected at most %s", d, maxRetryWait)
+	}
+
+	// Retry-After wins over the backoff, up to maxRetryWait
+	if d := c.backoff(0, &statusError{StatusCode: 429, RetryAfter: 7 * time.Second}); d != 7*time.Second {
+		t.Errorf("Retry-After of 7s waits %s", d)
+	}
+	if d := c.backoff(0, &statusError{StatusCode: 429, RetryAfter: time.Hour}); d != maxRetryWait {
+		t.Errorf("Retry-After of 1h waits %s, expected %s", d, maxRetryWait)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	for _, tc := range []struct {
+		status     int
+		failures   int
+		maxRetries int
+		requests   int  // requests of the failing page
+		ok         bool // all records returned
+	}{
+		{503, 2, 3, 3, true},
+		{500, 3, 3, 4, true},
+		{429, 1, 3, 2, true},
+		{502, 4, 3, 4, false}, // --max_retries exhausted
+		{503, 1, 0, 1, false},
+		{404, 1, 3, 1, false}, // not retried
+		{401, 1, 3, 1, false},
+	} {
+		api := &flakyApi{total: 20, status: tc.status, failures: map[int]int{7: tc.failures}}
+		srv := httptest.NewServer(api)
+		c := testClient(srv, tc.maxRetries)
+		updates, err := c.ListUpdates(context.Background(), UpdateFilter{Page: Page{Limit: 7}})
+		srv.Close()
+
+		if tc.ok && (err != nil || len(updates) != 20) {
+			t.Errorf("%d failing %d times, %d retries: got %d updates and %v, expected 20", tc.status, tc.failures, tc.maxRetries, len(updates), err)
+		}
+		if !tc.ok {
+			se, isStatus := err.(*statusError)
+			if !isStatus || se.StatusCode != tc.status {
+				t.Errorf("%d failing %d times, %d retries: got %v, expected status %d", tc.status, tc.failures, tc.maxRetries, err, tc.status)
+			}
+			// the page fetched before the failure is kept
+			if len(updates) != 7 || updates[6].UpdateUid != "6" {
+				t.Errorf("%d failing %d times, %d retries: kept %d updates, expected 7", tc.status, tc.failures, tc.maxRetries, len(updates))
+			}
+		}
+		if api.requests[7] != tc.requests {
+			t.Errorf("%d failing %d times, %d retries: page requested %d times, expected %d", tc.status, tc.failures, tc.maxRetries, api.requests[7], tc.requests)
+		}
+		// a retry only repeats the failed page
+		if api.requests[0] != 1 {
+			t.Errorf("%d failing %d times, %d retries: first page requested %d times", tc.status, tc.failures, tc.maxRetries, api.requests[0])
+		}
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	// a server that closes every connection before answering
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	c := testClient(srv, 2)
+	attempts := 0
+	c.Logger = log.New(writerFunc(func(p []byte) (int, error) {
+		attempts++
+		return len(p), nil
+	}), "", 0)
+	_, err := c.ListUpdates(context.Background(), UpdateFilter{})
+	if err == nil || !retryable(err) {
+		t.Fatalf("Got %v, expected a network error", err)
+	}
+	// GET and Retrying lines for each retry, GET for the last attempt
+	if attempts != 5 {
+		t.Errorf("Logged %d lines, expected 3 attempts", attempts)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	// the service asks for a 1 second wait, more than the backoff of 1ms
+	api := &flakyApi{total: 3, status: 429, retryAfter: "1", failures: map[int]int{0: 1}}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	start := time.Now()
+	updates, err := testClient(srv, 3).ListUpdates(context.Background(), UpdateFilter{})
+	if err != nil || len(updates) != 3 {
+		t.Fatalf("Got %d updates and %v", len(updates), err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("Retried after %s, expected 1s", d)
+	}
+
+	// a cancelled context ends the wait
+	api = &flakyApi{total: 3, status: 429, retryAfter: "60", failures: map[int]int{0: 1}}
+	srv2 := httptest.NewServer(api)
+	defer srv2.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := testClient(srv2, 3).ListUpdates(ctx, UpdateFilter{}); err != context.DeadlineExceeded {
+		t.Errorf("Got %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Waited %s despite the deadline", d)
+	}
+}
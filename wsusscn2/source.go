@@ -0,0 +1,75 @@
+/**************************************************************************************************/
+// File: source.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Record source interface shared by the API client and offline copies
+/**************************************************************************************************/
+package wsusscn2
+
+import (
+	"context"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Source: Provider of wsusscn2 records. Client reads them from the API, other implementations
+// from local copies of the catalog. Filters have the same meaning for every source.
+type Source interface {
+	ListClassifications(ctx context.Context) ([]Classification, error)
+	ListProducts(ctx context.Context) ([]Product, error)
+	ListProductFamilies(ctx context.Context) ([]ProductFamily, error)
+	Updates(ctx context.Context, f UpdateFilter) *UpdateIterator
+	Supersedes(ctx context.Context, f UpdateFilter) *SupersedeIterator
+	Cves(ctx context.Context, f CveFilter) *CveIterator
+}
+
+var _ Source = (*Client)(nil)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// NewUpdateIterator returns an iterator over the pages returned by fetch, for use by other
+// Sources. fetch is called with the limit and offset of each page as described by page.
+func NewUpdateIterator(ctx context.Context, page Page, fetch func(ctx context.Context, limit int, offset int) ([]Update, error)) *UpdateIterator {
+	return &UpdateIterator{p: newPager(ctx, page, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		records, err := fetch(ctx, limit, offset)
+		return records, len(records), err
+	})}
+}
+
+// NewSupersedeIterator returns an iterator over the pages returned by fetch, see
+// NewUpdateIterator
+func NewSupersedeIterator(ctx context.Context, page Page, fetch func(ctx context.Context, limit int, offset int) ([]UpdateSupersede, error)) *SupersedeIterator {
+	return &SupersedeIterator{p: newPager(ctx, page, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		records, err := fetch(ctx, limit, offset)
+		return records, len(records), err
+	})}
+}
+
+// NewCveIterator returns an iterator over the pages returned by fetch, see NewUpdateIterator
+func NewCveIterator(ctx context.Context, page Page, fetch func(ctx context.Context, limit int, offset int) ([]Cve, error)) *CveIterator {
+	return &CveIterator{p: newPager(ctx, page, func(ctx context.Context, limit int, offset int) (interface{}, int, error) {
+		records, err := fetch(ctx, limit, offset)
+		return records, len(records), err
+	})}
+}
+
+// ErrUpdateIterator returns an iterator that yields no records and reports err
+func ErrUpdateIterator(err error) *UpdateIterator {
+	return &UpdateIterator{p: failedPager(err)}
+}
+
+// ErrSupersedeIterator returns an iterator that yields no records and reports err
+func ErrSupersedeIterator(err error) *SupersedeIterator {
+	return &SupersedeIterator{p: failedPager(err)}
+}
+
+// ErrCveIterator returns an iterator that yields no records and reports err
+func ErrCveIterator(err error) *CveIterator {
+	return &CveIterator{p: failedPager(err)}
+}
@@ -0,0 +1,139 @@
+/**************************************************************************************************/
+// File: wsusscn2.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Record types returned by the wsusscn2.cab API
+/**************************************************************************************************/
+
+// Package wsusscn2 is a client for the wsusscn2.cab REST API.
+//
+// A Client is created with NewClient and exposes one method per API endpoint:
+//
+//	c := wsusscn2.NewClient(wsusscn2.DefaultApiUrl, apiKey)
+//	updates, err := c.ListUpdates(ctx, wsusscn2.UpdateFilter{Kb: []string{"4025339"}})
+package wsusscn2
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hashauthority/wsusscn2cli/cvss"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// titleVersion finds the version in titles like "Cumulative Update for Windows 10 Version 1703"
+var titleVersion = regexp.MustCompile(`(?i)\bversion (\d{4}|\d{2}H\d)\b`)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// Update: Structure for update records
+type Update struct {
+	Bundles             string `json:"bundles"`
+	ClassificationTitle string `json:"classification_title"`
+	CompanyTitle        string `json:"company_title"`
+	Description         string `json:"description"`
+	InstallBehavior     string `json:"install_behavior"`
+	IsBeta              string `json:"is_beta"`
+	IsBundled           string `json:"is_bundled"`
+	IsPublic            string `json:"is_public"`
+	IsSuperseded        string `json:"is_superseded"`
+	Kb                  string `json:"kb"`
+	Language            string `json:"language"`
+	Arch                string `json:"arch"`
+	MoreInfoUrl         string `json:"more_info_url"`
+	MsrcSeverity        string `json:"msrc_severity"`
+	ProductFamilyTitle  string `json:"product_family_title"`
+	ProductTitle        string `json:"product_title"`
+	PublicationState    string `json:"publication_state"`
+	Readiness           string `json:"readiness"`
+	Supersedes          string `json:"supersedes"`
+	SupportUrl          string `json:"support_url"`
+	UninstallBehavior   string `json:"uninstall_behavior"`
+	UninstallNotes      string `json:"uninstall_notes"`
+	UpdateCreationDate  string `json:"update_creation_date"`
+	UpdateRevision      string `json:"update_revision"`
+	UpdateTitle         string `json:"update_title"`
+	UpdateType          string `json:"update_type"`
+	UpdateUid           string `json:"update_uid"`
+}
+
+// Classification: Structure for classification records
+type Classification struct {
+	ClassificationUid      string `json:"classification_uid"`
+	ClassificationRevision string `json:"classification_revision"`
+	ClassificationTitle    string `json:"classification_title"`
+}
+
+// Product: Structure for product records
+type Product struct {
+	ProductUid      string `json:"product_uid"`
+	ProductRevision string `json:"product_revision"`
+	ProductTitle    string `json:"product_title"`
+}
+
+// ProductFamily: Structure for product family records
+type ProductFamily struct {
+	ProductFamilyUid      string `json:"product_family_uid"`
+	ProductFamilyRevision string `json:"product_family_revision"`
+	ProductFamilyTitle    string `json:"product_family_title"`
+}
+
+// UpdateSupersede: Structure for superseded update records
+type UpdateSupersede struct {
+	UpdateUid          string `json:"update_uid"`
+	UpdateTitle        string `json:"update_title"`
+	UpdateCreationDate string `json:"update_creation_date"`
+	ProductTitle       string `json:"product_title"`
+	IsSuperseded       string `json:"is_superseded"`
+	SuperUpdateUid     string `json:"super_uid"`
+	SuperTitle         string `json:"super_title"`
+	SuperCreationDate  string `json:"super_creation_date"`
+	SuperProductTitle  string `json:"super_product_title"`
+	SuperIsSuperseded  string `json:"super_is_superseded"`
+}
+
+// Cve: Structure for cve records
+type Cve struct {
+	Cve                   string `json:"cve"`
+	CveTitle              string `json:"cve_title"`
+	UpdateUid             string `json:"update_uid"`
+	Cvssv3BaseScore       string `json:"cvssv3_base_score"`
+	Cvssv3TemporalScore   string `json:"cvssv3_temporal_score"`
+	Cvssv3Vector          string `json:"cvssv3_vector"`
+	UpdateTitle           string `json:"update_title"`
+	Kb                    string `json:"kb"`
+	ProductTitle          string `json:"product_title"`
+	ProductFamilyTitle    string `json:"product_family_title"`
+	ClassificationTitle   string `json:"classification_title"`
+	MsrcSeverity          string `json:"msrc_severity"`
+	Arch                  string `json:"arch"`
+	IsInFile              string `json:"is_in_file"`
+	IsSuperseded          string `json:"is_superseded"`
+	LatestSupersessionUid string `json:"latest_supersession_uid"`
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+// TitleVersion returns the Windows version of an update title in upper case (Ex., "1703" or
+// "20H2"), empty if the title has none
+func TitleVersion(title string) string {
+	if m := titleVersion.FindStringSubmatch(title); m != nil {
+		return strings.ToUpper(m[1])
+	}
+	return ""
+}
+
+// Vector parses the CVSS v3 vector of the CVE
+func (c Cve) Vector() (cvss.Vector, error) {
+	return cvss.Parse(c.Cvssv3Vector)
+}
@@ -12,27 +12,49 @@
 // 0.1.5: Added listsupersede
 // 0.2.0: Use new API endpoint
 // 0.3.0: New /cve endpoint. Added "arch" and "is_in_file" column to /update. Added -k option.
+// 0.4.0: Moved API client into the wsusscn2 package. Fixed --cve filter of listcve. Added
+//        --parallel to listupdate. Retry failed requests. Added --rps
+//        and quota. Escape CSV output, added --delimiter, --no_header, --quote and --crlf.
+//        Added --output json and ndjson. Added --columns to every list command. Print the
+//        header once per result instead of once per page. Added sync and --db for an offline
+//        SQLite mirror. Added query. Added --cab to read a local wsusscn2.cab. Added extract and verifycab. Added showupdate. Added assess
+//        with systeminfo, Get-HotFix, wmic qfe and osquery inventories. Added fleetreport. Added chain
+//        and graph. Added auditsupersede.
+//        Added CVSS v3 vector filters, --verify_scores and --env_profile to listcve.
+//        Added --kev and --epss to listcve and listupdate. Added remediate.
 /**************************************************************************************************/
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/json"     //api
-	"errors"            //new error
-	"fmt"               //printing
-	"io"                //multiwriter for logging
-	"io/ioutil"         //writing to file
-	"log"               //logging
-	"net/http"          //http client
-	"net/http/httputil" //http debug
-	"os"                //testing existence of a file
-	"path/filepath"     //splitting paths
-	"regexp"            //include/exclude pattern matching
-	"strconv"           //parsing boolean
+	"crypto/x509"   //trust bundle of verifycab
+	"encoding/json" //config file
+	"encoding/pem"
+	"fmt"           //printing
+	"io"            //multiwriter for logging
+	"io/ioutil"     //writing to file
+	"log"           //logging
+	"math"          //unlimited record limit
+	"net/http"      //http client
+	"os"            //testing existence of a file
+	"os/signal"     //cancel on interrupt
+	"path/filepath" //splitting paths
+	"regexp"        //include/exclude pattern matching
+	"sort"          //sorting by environmental score
+	"strconv"       //parsing boolean
 	"strings"
-	"time" //saving current time to marks.json
-
-	"github.com/urfave/cli" //cli structure
+	"time"
+
+	"github.com/hashauthority/wsusscn2cli/cab"       //wsusscn2.cab reader
+	"github.com/hashauthority/wsusscn2cli/cvss"      //cvss vectors and scores
+	"github.com/hashauthority/wsusscn2cli/exploit"   //kev and epss data
+	"github.com/hashauthority/wsusscn2cli/inventory" //host inventory and assessment
+	"github.com/hashauthority/wsusscn2cli/mirror"    //offline sqlite copy
+	"github.com/hashauthority/wsusscn2cli/output"    //csv and json output
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"  //api client
+	"github.com/urfave/cli"                          //cli structure
 )
 
 /**************************************************************************************************/
@@ -40,105 +62,105 @@ import (
 /*                                           CONSTANTS                                            */
 /*                                                                                                */
 /**************************************************************************************************/
+// default --columns of listupdate and listcve. Other commands print every field by default.
+const defaultUpdateColumns = "update_uid, kb, update_title, update_creation_date, product_title, product_family_title, update_type, is_superseded, classification_title, company_title, description, install_behavior, is_beta, is_bundled, is_public, language, more_info_url, msrc_severity, publication_state, readiness, support_url, uninstall_behavior, uninstall_notes, update_revision, arch"
+const defaultCveColumns = "cve, cve_title, cvssv3_base_score, cvssv3_temporal_score, cvssv3_vector, update_uid, update_title, kb, product_title, product_family_title, classification_title, msrc_severity, arch, is_in_file, is_superseded, latest_supersession_uid"
+
+// default --columns of listcve --env_profile
+const defaultEnvCveColumns = "cve, cve_title, cvssv3_base_score, cvssv3_temporal_score, environmental_score, cvssv3_vector, environmental_vector, update_uid, update_title, kb, product_title, product_family_title, classification_title, msrc_severity, arch, is_in_file, is_superseded, latest_supersession_uid"
+
+// columns added to the defaults of listcve and listupdate by --kev and --epss
+const exploitColumns = "in_kev, kev_due_date, epss, epss_percentile"
 
 /**************************************************************************************************/
 /*                                                                                                */
 /*                                             TYPES                                              */
 /*                                                                                                */
 /**************************************************************************************************/
-// Update: Structure for update records
-type Update struct {
-	Bundles             string `json:"bundles"`
-	ClassificationTitle string `json:"classification_title"`
-	CompanyTitle        string `json:"company_title"`
-	Description         string `json:"description"`
-	InstallBehavior     string `json:"install_behavior"`
-	IsBeta              string `json:"is_beta"`
-	IsBundled           string `json:"is_bundled"`
-	IsPublic            string `json:"is_public"`
-	IsSuperseded        string `json:"is_superseded"`
-	Kb                  string `json:"kb"`
-	Language            string `json:"language"`
-	Arch                string `json:"arch"`
-	MoreInfoUrl         string `json:"more_info_url"`
-	MsrcSeverity        string `json:"msrc_severity"`
-	ProductFamilyTitle  string `json:"product_family_title"`
-	ProductTitle        string `json:"product_title"`
-	PublicationState    string `json:"publication_state"`
-	Readiness           string `json:"readiness"`
-	Supersedes          string `json:"supersedes"`
-	SupportUrl          string `json:"support_url"`
-	UninstallBehavior   string `json:"uninstall_behavior"`
-	UninstallNotes      string `json:"uninstall_notes"`
-	UpdateCreationDate  string `json:"update_creation_date"`
-	UpdateRevision      string `json:"update_revision"`
-	UpdateTitle         string `json:"update_title"`
-	UpdateType          string `json:"update_type"`
-	UpdateUid           string `json:"update_uid"`
+// wConfig: wsusscn2cli config file
+type wConfig struct {
+	ApiServer string `json:"api_server"`
+	ApiPort   string `json:"api_port"`
+	ApiKey    string `json:"api_key"`
 }
 
-// Classification: Structure for classification records
-type Classification struct {
-	ClassificationUid      string `json:"classification_uid"`
-	ClassificationRevision string `json:"classification_revision"`
-	ClassificationTitle    string `json:"classification_title"`
+// quotaRecord: Output row of the quota command
+type quotaRecord struct {
+	Limit     string `json:"limit"`
+	Remaining string `json:"remaining"`
+	Reset     string `json:"reset"`
 }
 
-// Product: Structure for product records
-type Product struct {
-	ProductUid      string `json:"product_uid"`
-	ProductRevision string `json:"product_revision"`
-	ProductTitle    string `json:"product_title"`
+// cabFileRecord: Output row of extract --list
+type cabFileRecord struct {
+	Name        string `json:"name"`
+	Size        uint32 `json:"size"`
+	Modified    string `json:"modified"`
+	Folder      int    `json:"folder"`
+	Compression string `json:"compression"`
 }
 
-// ProductFamily: Structure for product family records
-type ProductFamily struct {
-	ProductFamilyUid      string `json:"product_family_uid"`
-	ProductFamilyRevision string `json:"product_family_revision"`
-	ProductFamilyTitle    string `json:"product_family_title"`
+// signatureRecord: Output row of verifycab
+type signatureRecord struct {
+	File               string `json:"file"`
+	Valid              bool   `json:"valid"`
+	Signer             string `json:"signer"`
+	Issuer             string `json:"issuer"`
+	DigestAlgorithm    string `json:"digest_algorithm"`
+	SigningTime        string `json:"signing_time"`
+	TimestampAuthority string `json:"timestamp_authority"`
+	Error              string `json:"error"`
 }
 
-//UpdateSupersede: Structure for superseded update records
-type UpdateSupersede struct {
+// chainRecord: Output row of chain, one per update of the supersedence chain
+type chainRecord struct {
+	Depth              int    `json:"depth"`
+	Kb                 string `json:"kb"`
 	UpdateUid          string `json:"update_uid"`
 	UpdateTitle        string `json:"update_title"`
 	UpdateCreationDate string `json:"update_creation_date"`
 	ProductTitle       string `json:"product_title"`
-	IsSuperseded       string `json:"is_superseded"`
-	SuperUpdateUid     string `json:"super_uid"`
-	SuperTitle         string `json:"super_title"`
-	SuperCreationDate  string `json:"super_creation_date"`
-	SuperProductTitle  string `json:"super_product_title"`
-	SuperIsSuperseded  string `json:"super_is_superseded"`
+	Supersedes         string `json:"supersedes"`    // KBs of the chain superseded directly
+	SupersededBy       string `json:"superseded_by"` // KBs of the chain superseding directly
+	Bundles            string `json:"bundles"`       // KBs bundled into the update
+	IsCurrent          bool   `json:"is_current"`
+	Note               string `json:"note"`
 }
 
-// Cve: Structure for cve records
-type Cve struct {
-	Cve                   string `json:"cve"`
-	CveTitle              string `json:"cve_title"`
-	UpdateUid             string `json:"update_uid"`
-	Cvssv3BaseScore       string `json:"cvssv3_base_score"`
-	Cvssv3TemporalScore   string `json:"cvssv3_temporal_score"`
-    Cvssv3Vector          string `json:"cvssv3_vector"`
-	UpdateTitle           string `json:"update_title"`
-	Kb                    string `json:"kb"`
-	ProductTitle          string `json:"product_title"`
-	ProductFamilyTitle    string `json:"product_family_title"`
-	ClassificationTitle   string `json:"classification_title"`
-	MsrcSeverity          string `json:"msrc_severity"`
-	Arch                  string `json:"arch"`
-	IsInFile              string `json:"is_in_file"`
-	IsSuperseded          string `json:"is_superseded"`
-	LatestSupersessionUid string `json:"latest_supersession_uid"`
+// cveRecord: Output row of listcve with --env_profile, --kev or --epss, a CVE scored for the
+// environment of its product and joined with its exploitation
+type cveRecord struct {
+	wsusscn2.Cve
+	EnvironmentalScore  string `json:"environmental_score"`
+	EnvironmentalVector string `json:"environmental_vector"` // vector with the metrics of the profile
+	exploit.Exploitation
 }
 
-// wConfig: wsusscn2cli config file
-type wConfig struct {
-	ApiServer string `json:"api_server"`
-	ApiPort   string `json:"api_port"`
-	ApiKey    string `json:"api_key"`
+// updateRecord: Output row of listupdate with --kev or --epss, an update joined with the
+// exploitation of its CVEs
+type updateRecord struct {
+	wsusscn2.Update
+	Cves string `json:"cves"` // comma separated
+	exploit.Exploitation
 }
 
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                            GLOBALS                                             */
+/*                                                                                                */
+/**************************************************************************************************/
+var apiKey string           //userinput, required
+var apiUrl string           //url to wsusscn2 api
+var execPath string         //path to the go executable
+var debug bool              //debug logging
+var quiet bool              //do not log to screen
+var insecure bool           //do not verify server's ssl cert
+var config wConfig          //contents of wsusscn2cli.json
+var logFile *os.File        //wsusscn2cli.log
+var maxRetries int          //retries for failed requests
+var retryWait time.Duration //wait before the first retry
+var rps float64             //max requests per second
+
 /**************************************************************************************************/
 /*                                                                                                */
 /*                                           FUNCTIONS                                            */
@@ -151,6 +173,15 @@ func check(e error) {
 	}
 }
 
+// checkStream stops like check, after writing out the records already streamed to out. The
+// output is left without its end, such as the closing bracket of a JSON array.
+func checkStream(out *output.Stream, e error) {
+	if e != nil {
+		out.Abort()
+		log.Fatalf("%s", e)
+	}
+}
+
 func strToBool(str string) bool {
 	b, err := strconv.ParseBool(str)
 	check(err)
@@ -185,145 +216,748 @@ func pathMatch(matches []string, path string) bool {
 	return false
 }
 
-func createNewHttpReq(url string, key string) *http.Request {
-	req, err := http.NewRequest("GET", url, nil)
+// readConfig reads in configuration items
+func readConfig(file string) wConfig {
+	var c = wConfig{}
+	_, err := os.Stat(file)
+	if err == nil {
+		f, _ := os.Open(file)
+		defer f.Close()
+		decoder := json.NewDecoder(f)
+		err := decoder.Decode(&c)
+		check(err)
+	} else {
+		if !os.IsNotExist(err) {
+			log.Fatalf("Unable to read %s: %s\n", file, err)
+		}
+	}
+	return c
+}
+
+// setupLogging sends log output to the log file and, unless quiet, stderr. stdout only carries
+// the output of the command.
+func setupLogging(command string) {
+	if quiet {
+		log.SetOutput(logFile)
+	} else {
+		mw := io.MultiWriter(os.Stderr, logFile)
+		log.SetOutput(mw)
+	}
+
+	log.Println(command + " called")
+}
+
+// newClient returns an API client using the api key from the command line or config file
+func newClient() *wsusscn2.Client {
+	//Authentication setup
+	if apiKey == "" && config.ApiKey == "" {
+		log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
+	}
+
+	if apiKey == "" {
+		apiKey = config.ApiKey
+	}
+
+	api := wsusscn2.NewClient(apiUrl, apiKey)
+	api.Debug = debug
+	api.MaxRetries = maxRetries
+	api.RetryWait = retryWait
+	api.SetRateLimit(rps)
+
+	if insecure {
+		api.HttpClient.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	return api
+}
+
+// newSource returns the wsusscn2.cab named by --cab or the mirror named by --db, or the API client
+// if neither is set
+func newSource(c *cli.Context) wsusscn2.Source {
+	if c.String("cab") != "" {
+		catalog, err := cab.OpenCatalog(c.String("cab"))
+		check(err)
+		return catalogSource(catalog)
+	}
+
+	if c.String("db") == "" {
+		return newClient()
+	}
+	db, err := mirror.Open(c.String("db"))
+	check(err)
+	return db
+}
+
+// catalogSource loads a catalog read from wsusscn2.cab into an in-memory mirror
+func catalogSource(catalog *cab.Catalog) wsusscn2.Source {
+	db, err := mirror.NewMemory()
 	check(err)
-	req.SetBasicAuth("u", key)
-	return req
+	for _, records := range []interface{}{catalog.Updates, catalog.Supersedes, catalog.Products, catalog.ProductFamilies, catalog.Classifications} {
+		check(db.Load(context.Background(), records))
+	}
+	return db
 }
 
-func getJson(c *http.Client, req *http.Request, debug bool, insecure bool, target interface{}) error {
-	log.Println("GET " + req.URL.String())
+// readTrustBundle reads the root certificates in a PEM or DER file, or in every file of a
+// directory. An empty name returns nil, the system roots.
+func readTrustBundle(name string) *x509.CertPool {
+	if name == "" {
+		return nil
+	}
+	files := []string{name}
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(name, "*"))
+		check(err)
+	}
 
+	roots := x509.NewCertPool()
+	count := 0
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		check(err)
+		if bytes.Contains(b, []byte("-----BEGIN")) {
+			for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+				if block.Type != "CERTIFICATE" {
+					continue
+				}
+				cert, err := x509.ParseCertificate(block.Bytes)
+				check(err)
+				roots.AddCert(cert)
+				count++
+			}
+		} else if certs, err := x509.ParseCertificates(b); err == nil {
+			for _, cert := range certs {
+				roots.AddCert(cert)
+				count++
+			}
+		} else if len(files) == 1 {
+			log.Fatalf("%s: Not a PEM or DER certificate: %s", file, err)
+		}
+	}
+	if count == 0 {
+		log.Fatalf("No certificates found in %s", name)
+	}
 	if debug {
-		requestDump, err := httputil.DumpRequest(req, true)
+		log.Printf("Read %d trusted certificates from %s", count, name)
+	}
+	return roots
+}
+
+// matchSigner reports if the organization or common name of cert is name, ignoring case
+func matchSigner(cert *x509.Certificate, name string) bool {
+	if strings.EqualFold(cert.Subject.CommonName, name) {
+		return true
+	}
+	for _, o := range cert.Subject.Organization {
+		if strings.EqualFold(o, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readInventory reads the hosts of the inventory files names, - for stdin, in format. Hosts in
+// several files are merged by hostname and every host must end up with a product, or with
+// skipNoProduct is left out with a warning.
+func readInventory(names []string, format string, skipNoProduct bool) []inventory.Host {
+	if len(names) == 0 {
+		log.Fatalf("--inventory argument is blank. Ex., wsusscn2cli assess --inventory host.json")
+	}
+	var hosts []inventory.Host
+	for _, name := range names {
+		in := os.Stdin
+		if name != "-" {
+			f, err := os.Open(name)
+			check(err)
+			defer f.Close()
+			in = f
+		}
+		h, err := inventory.Import(in, format)
 		if err != nil {
-			fmt.Println(err)
+			log.Fatalf("%s: %s", name, err)
 		}
-		fmt.Println(string(requestDump))
+		hosts = append(hosts, h...)
 	}
 
-	if insecure {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	var complete []inventory.Host
+	for _, h := range inventory.Merge(hosts) {
+		if h.Product != "" {
+			complete = append(complete, h)
+		} else if skipNoProduct {
+			log.Printf("Skipping host %s, it has no product. Add its systeminfo or osquery os_version to the inventory.", h.Hostname)
+		} else {
+			log.Fatalf("Host %s has no product. Add its systeminfo or osquery os_version to the inventory.", h.Hostname)
+		}
 	}
+	if len(complete) == 0 {
+		log.Fatalf("No host with a product in the inventory")
+	}
+	return complete
+}
 
-	r, err := c.Do(req)
+// inventoryDir returns the inventory files in directory dir, leaving out hidden files
+func inventoryDir(dir string) []string {
+	if dir == "" {
+		log.Fatalf("--dir argument is blank. Ex., wsusscn2cli fleetreport --dir inventory")
+	}
+	files, err := ioutil.ReadDir(dir)
+	check(err)
+	var names []string
+	for _, f := range files {
+		if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, filepath.Join(dir, f.Name()))
+		}
+	}
+	if len(names) == 0 {
+		log.Fatalf("No inventory files in %s", dir)
+	}
+	return names
+}
 
-	if err != nil {
-		return err
+// loadGraph reads the supersedence graph of the products of --product_title, or if not set of
+// the products of the updates of kbs
+func loadGraph(ctx context.Context, c *cli.Context, source wsusscn2.Source, kbs []string) *wsusscn2.Graph {
+	products := c.StringSlice("product_title")
+	if len(products) == 0 && len(kbs) > 0 {
+		it := source.Updates(ctx, wsusscn2.UpdateFilter{Kb: kbs, Page: wsusscn2.Page{RecordLimit: math.MaxInt32}})
+		for it.Next() {
+			products = append(products, it.Update().ProductTitle)
+		}
+		check(it.Err())
+		if len(products) == 0 {
+			log.Fatalf("KB %s not found", strings.Join(kbs, ", "))
+		}
+		products = uniqueStrings(products)
 	}
 
+	graph, err := wsusscn2.LoadGraph(ctx, source, wsusscn2.UpdateFilter{
+		ProductTitle: products,
+		Page:         wsusscn2.Page{RecordLimit: math.MaxInt32, Parallel: c.Int("parallel")},
+	})
+	check(err)
 	if debug {
-		responseDump, err := httputil.DumpResponse(r, true)
-		if err != nil {
-			fmt.Println(err)
+		log.Printf("Loaded %d updates of %s", graph.Len(), strings.Join(products, ", "))
+	}
+	return graph
+}
+
+// followChain returns the supersedence chain of kb in graph. Links into products that were not
+// loaded are fetched from source until only broken ones are left.
+func followChain(ctx context.Context, source wsusscn2.Source, graph *wsusscn2.Graph, kb string) wsusscn2.Chain {
+	var uids []string
+	for _, uid := range graph.Kb(kb) {
+		// the payload bundled into an update of the KB has the same KB
+		if len(graph.BundledBy(uid)) == 0 {
+			uids = append(uids, uid)
 		}
-		fmt.Println(string(responseDump))
+	}
+	if len(uids) == 0 {
+		log.Fatalf("KB %s not found", kb)
 	}
 
-	if r.StatusCode == http.StatusUnauthorized {
-		return errors.New("Unauthorized request to service")
-	} else if r.StatusCode == http.StatusForbidden {
-		return errors.New("Forbidden request to service")
-	} else if r.StatusCode == http.StatusNotFound {
-		return errors.New("Resource not found (404) received from service")
-	} else if r.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("Unknown HTTP error (%d) received from service", r.StatusCode))
+	chain := graph.Chain(uids...)
+	for {
+		var missing []string
+		for _, step := range chain.Steps {
+			if step.Missing {
+				missing = append(missing, step.UpdateUid)
+			}
+		}
+		added, err := graph.Fetch(ctx, source, missing)
+		check(err)
+		if added == 0 {
+			return chain
+		}
+		chain = graph.Chain(uids...)
 	}
+}
 
-	defer r.Body.Close()
+// graphKbs returns the KBs of uids, or the uid itself for updates not in g
+func graphKbs(g *wsusscn2.Graph, uids []string) string {
+	var kbs []string
+	for _, uid := range uids {
+		if u, ok := g.Update(uid); ok && u.Kb != "" {
+			kbs = append(kbs, u.Kb)
+		} else {
+			kbs = append(kbs, uid)
+		}
+	}
+	return strings.Join(uniqueStrings(kbs), ",")
+}
 
-	return json.NewDecoder(r.Body).Decode(target)
+// indexOf returns the position of v in values, -1 if absent
+func indexOf(values []string, v string) int {
+	for i, x := range values {
+		if x == v {
+			return i
+		}
+	}
+	return -1
 }
 
-// readConfig reads in configuration items
-func readConfig(file string) wConfig {
-	var c = wConfig{}
-	_, err := os.Stat(file)
-	if err == nil {
-		f, _ := os.Open(file)
-		defer f.Close()
-		decoder := json.NewDecoder(f)
-		err := decoder.Decode(&c)
-		check(err)
-	} else {
-		if !os.IsNotExist(err) {
-			log.Fatalf("Unable to read %s: %s\n", file, err)
+// uniqueStrings returns values without duplicates, in order of first appearance
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
 		}
 	}
-	return c
+	return unique
 }
 
-/**************************************************************************************************/
-/*                                                                                                */
-/*                                             MAIN                                               */
-/*                                                                                                */
-/**************************************************************************************************/
-func main() {
-	var apiKey string //userinput, required
-	var apiUrl string //url to wsusscn2 api
-	//var execPath string //path to the go executable
-	var debug bool     //debug logging
-	var quiet bool     //do not log to screen
-	var insecure bool  //do not verify server's ssl cert
-	var countOnly bool //only print number of records returned
-
-	var cve []string
-	var productTitle []string
-	var updateUid []string
-	var updateTitle []string
-	var updateKb []string
-	var updateType []string
-	var productFamilyTitle []string
-	var classificationTitle []string
-	var msrcSeverity []string
-	var arch []string
-	var columns string
-
-	var limit int
-	var offset int
-	var recordLimit int
-
-	defaultLimit := 1000
-	defaultOffset := 0
-	defaultRecordLimit := 20000
-
-	var defaultUpdateColumns = "update_uid, kb, update_title, update_creation_date, product_title, product_family_title, update_type, is_superseded, classification_title, company_title, description, install_behavior, is_beta, is_bundled, is_public, language, more_info_url, msrc_severity, publication_state, readiness, support_url, uninstall_behavior, uninstall_notes, update_revision, arch"
-	var defaultUpdateColumnsTitle = make(map[string]string)
-
-	for _, v := range strings.Split(defaultUpdateColumns, ",") {
-		c := strings.ToLower(strings.TrimSpace(v))
-		words := strings.Split(c, "_")
-		newTitle := ""
-		for _, w := range words {
-			newTitle += strings.Title(w)
+// quotaValue formats a quota count, -1 meaning not reported
+func quotaValue(n int64) string {
+	if n < 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// apiFlags returns the flags shared by every command that calls the API
+func apiFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "api_key, a",
+			Usage:       "API key (required if not using config file)",
+			Destination: &apiKey,
+		},
+		cli.BoolFlag{
+			Name:        "debug, d",
+			Usage:       "Output debug level logging",
+			Destination: &debug,
+		},
+		cli.BoolFlag{
+			Name:        "insecure, k",
+			Usage:       "Do not verify server's SSL cert",
+			Destination: &insecure,
+		},
+		cli.BoolFlag{
+			Name:        "quiet, q",
+			Usage:       "Do not log to screen",
+			Destination: &quiet,
+		},
+		cli.IntFlag{
+			Name:        "max_retries",
+			Usage:       "Number of retries for rate limited (429), server (5xx) and network errors.",
+			Value:       wsusscn2.DefaultMaxRetries,
+			Destination: &maxRetries,
+		},
+		cli.DurationFlag{
+			Name:        "retry_wait",
+			Usage:       "Wait before the first retry, doubled for every further retry.",
+			Value:       wsusscn2.DefaultRetryWait,
+			Destination: &retryWait,
+		},
+		cli.Float64Flag{
+			Name:        "rps",
+			Usage:       "Max number of API requests per second (0 for no limit).",
+			Destination: &rps,
+		},
+	}
+}
+
+// sourceFlags returns the flags of every list command, see newSource
+func sourceFlags() []cli.Flag {
+	return append(apiFlags(),
+		cli.StringFlag{
+			Name:  "db",
+			Usage: "Read from the SQLite mirror created by sync instead of the API (Ex., wsusscn2cli.db)",
+		},
+		cli.StringFlag{
+			Name:  "cab",
+			Usage: "Read from a local wsusscn2.cab instead of the API. It has no CVE data.",
+		},
+	)
+}
+
+// updateFilterFlags returns the flags of wsusscn2.UpdateFilter
+func updateFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "product_title",
+			Usage: "Name of product.",
+		},
+		cli.StringSliceFlag{
+			Name:  "update_uid",
+			Usage: "Update Uid.",
+		},
+		cli.StringSliceFlag{
+			Name:  "update_title",
+			Usage: "Update Title.",
+		},
+		cli.StringSliceFlag{
+			Name:  "kb",
+			Usage: "Update KB.",
+		},
+		cli.StringSliceFlag{
+			Name:  "update_type",
+			Usage: "Update Type.",
+		},
+		cli.StringSliceFlag{
+			Name:  "product_family_title",
+			Usage: "Product Family Title.",
+		},
+		cli.StringSliceFlag{
+			Name:  "classification_title",
+			Usage: "Classification Title.",
+		},
+		cli.StringSliceFlag{
+			Name:  "msrc_severity",
+			Usage: "MSRC Severity.",
+		},
+		cli.StringFlag{
+			Name:  "is_superseded",
+			Usage: "Is Superseded.",
+		},
+		cli.StringFlag{
+			Name:  "is_bundled",
+			Usage: "Is Bundled.",
+		},
+		cli.StringFlag{
+			Name:  "is_public",
+			Usage: "Is Public.",
+		},
+		cli.StringFlag{
+			Name:  "is_beta",
+			Usage: "Is Beta.",
+		},
+		cli.StringFlag{
+			Name:  "update_creation_date_after",
+			Usage: "Updates created after this date [YYYY-MM-DD] (exclusive).",
+		},
+		cli.StringFlag{
+			Name:  "update_creation_date_before",
+			Usage: "Updates created before this date [YYYY-MM-DD] (exclusive).",
+		},
+		cli.StringFlag{
+			Name:  "update_creation_date_on",
+			Usage: "Updates created on this date [YYYY-MM-DD].",
+		},
+	}
+}
+
+// pageFlags returns the flags of wsusscn2.Page
+func pageFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:  "limit",
+			Usage: "Number of records per page.",
+			Value: wsusscn2.DefaultLimit,
+		},
+		cli.IntFlag{
+			Name:  "offset",
+			Usage: "Number of records to skip.",
+			Value: wsusscn2.DefaultOffset,
+		},
+		cli.IntFlag{
+			Name:  "record_limit",
+			Usage: "Max number of records to return.",
+			Value: wsusscn2.DefaultRecordLimit,
+		},
+	}
+}
+
+// outputFlags returns the flags of newStream
+func outputFlags() []cli.Flag {
+	format := formatFlags()
+	return append([]cli.Flag{
+		format[0],
+		cli.StringFlag{
+			Name:  "columns",
+			Usage: "Restrict output to listed columns (Ex., \"kb, update_title\").",
+		},
+	}, format[1:]...)
+}
+
+// fleetOutputFlags returns outputFlags with html as another --output of fleetreport
+func fleetOutputFlags() []cli.Flag {
+	flags := outputFlags()
+	flags[0] = cli.StringFlag{
+		Name:  "output, o",
+		Usage: "Output format: csv, json, ndjson or html (self-contained report of hosts and KBs).",
+		Value: "csv",
+	}
+	return flags
+}
+
+// formatFlags returns the flags of newWriter
+func formatFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Output format: csv, json or ndjson (one JSON object per line).",
+			Value: "csv",
+		},
+		cli.StringFlag{
+			Name:  "delimiter",
+			Usage: "CSV field delimiter. Use \"tab\" for tab separated output.",
+			Value: ",",
+		},
+		cli.BoolFlag{
+			Name:  "no_header",
+			Usage: "Do not print the CSV header row",
+		},
+		cli.StringFlag{
+			Name:  "quote",
+			Usage: "CSV quoting: \"all\" fields or only where \"minimal\"ly required.",
+			Value: "all",
+		},
+		cli.BoolFlag{
+			Name:  "crlf",
+			Usage: "End CSV lines with CRLF instead of LF",
+		},
+	}
+}
+
+// newWriter returns the stdout writer configured by formatFlags
+func newWriter(c *cli.Context) output.Writer {
+	switch strings.ToLower(c.String("output")) {
+	case "", "csv":
+	case "json":
+		return output.NewJSONWriter(os.Stdout)
+	case "ndjson":
+		return output.NewNDJSONWriter(os.Stdout)
+	default:
+		log.Fatalf("Unknown output format %s. Expected: csv, json, ndjson", c.String("output"))
+	}
+
+	delimiter, err := output.ParseDelimiter(c.String("delimiter"))
+	check(err)
+	quote, err := output.ParseQuotePolicy(c.String("quote"))
+	check(err)
+
+	return output.NewCSVWriter(os.Stdout, output.CSVOptions{
+		Delimiter: delimiter,
+		NoHeader:  c.Bool("no_header"),
+		Quote:     quote,
+		CRLF:      c.Bool("crlf"),
+	})
+}
+
+// newSelector returns the columns of record picked with --columns, or defaults if not set
+func newSelector(c *cli.Context, record interface{}, defaults string) *output.Selector {
+	columns := c.String("columns")
+	if columns == "" {
+		columns = defaults
+	}
+	selector, err := output.NewSelector(record, columns)
+	check(err)
+	return selector
+}
+
+// newStream returns the output stream for records like record, see newSelector and newWriter
+func newStream(c *cli.Context, record interface{}, defaults string) *output.Stream {
+	return output.NewStream(newWriter(c), newSelector(c, record, defaults))
+}
+
+// cvssFlags returns a flag per CVSS v3 base and temporal metric, for filters on the vector
+func cvssFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, m := range cvss.Metrics {
+		values := make([]string, 0, len(m.Values))
+		for _, v := range m.Values {
+			values = append(values, v.Name)
+		}
+		flags = append(flags, cli.StringSliceFlag{
+			Name:  m.Name,
+			Usage: fmt.Sprintf("CVSS v3 %s (%s) of the vector, checked locally: %s.", strings.Replace(m.Name, "_", " ", -1), m.Abbr, strings.Join(values, ", ")),
+		})
+	}
+	return flags
+}
+
+// cvssFilterFromContext reads the flags of cvssFlags
+func cvssFilterFromContext(c *cli.Context) cvss.Filter {
+	filter := cvss.NewFilter()
+	for _, m := range cvss.Metrics {
+		for _, value := range c.StringSlice(m.Name) {
+			check(filter.Add(m.Name, strings.Split(value, ",")...))
+		}
+	}
+	return filter
+}
+
+// exploitFlags returns the flags joining the CVEs of listcve and listupdate with KEV and EPSS
+func exploitFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "kev",
+			Usage: "CISA Known Exploited Vulnerabilities catalog (Ex., known_exploited_vulnerabilities.json). Adds the in_kev and kev_due_date columns.",
+		},
+		cli.StringFlag{
+			Name:  "epss",
+			Usage: "FIRST EPSS scores (Ex., epss_scores-2018-10-12.csv.gz). Adds the epss and epss_percentile columns.",
+		},
+		cli.BoolFlag{
+			Name:  "kev_only",
+			Usage: "Only CVEs, or updates fixing CVEs, in the KEV catalog. Needs --kev.",
+		},
+		cli.Float64Flag{
+			Name:  "min_epss",
+			Usage: "Only CVEs, or updates fixing CVEs, with an EPSS score of at least this (Range 0-1). Needs --epss.",
+		},
+	}
+}
+
+// exploitDataFromContext reads the files of exploitFlags, nil if there are none
+func exploitDataFromContext(c *cli.Context) *exploit.Data {
+	if c.Bool("kev_only") && c.String("kev") == "" {
+		log.Fatalf("--kev_only needs --kev")
+	}
+	if c.Float64("min_epss") > 0 && c.String("epss") == "" {
+		log.Fatalf("--min_epss needs --epss")
+	}
+	if c.String("kev") == "" && c.String("epss") == "" {
+		return nil
+	}
+
+	var data exploit.Data
+	if name := c.String("kev"); name != "" {
+		f, err := os.Open(name)
+		check(err)
+		data.KEV, err = exploit.ReadKEV(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		if debug {
+			log.Printf("Read %d CVEs of KEV catalog %s", data.KEV.Len(), data.KEV.CatalogVersion)
 		}
-		defaultUpdateColumnsTitle[c] = newTitle
 	}
+	if name := c.String("epss"); name != "" {
+		f, err := os.Open(name)
+		check(err)
+		data.EPSS, err = exploit.ReadEPSS(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		if debug {
+			log.Printf("Read EPSS scores of %d CVEs, score date %s", data.EPSS.Len(), data.EPSS.ScoreDate)
+		}
+	}
+	return &data
+}
+
+// updateCves returns the distinct CVEs of updates, by lower case update uid, UidBatch updates
+// per request
+func updateCves(ctx context.Context, source wsusscn2.Source, updates []wsusscn2.Update) map[string][]string {
+	cves := make(map[string][]string)
+	if len(updates) == 0 {
+		return cves
+	}
+	var uids []string
+	for _, u := range updates {
+		uids = append(uids, u.UpdateUid)
+	}
+	seen := make(map[string]bool)
+	check(wsusscn2.Batches(uniqueStrings(uids), func(batch []string) error {
+		it := source.Cves(ctx, wsusscn2.CveFilter{UpdateUid: batch, Page: wsusscn2.Page{RecordLimit: math.MaxInt32}})
+		for it.Next() {
+			cve := it.Cve()
+			uid := strings.ToLower(cve.UpdateUid)
+			if !seen[uid+" "+cve.Cve] {
+				seen[uid+" "+cve.Cve] = true
+				cves[uid] = append(cves[uid], cve.Cve)
+			}
+		}
+		return it.Err()
+	}))
+	return cves
+}
 
-	var cvssv3BaseScore string
-	var cvssv3TemporalScore string
+// readProfile reads the CVSS v3 environmental profile of file name
+func readProfile(name string) cvss.Profile {
+	f, err := os.Open(name)
+	check(err)
+	defer f.Close()
+	profile, err := cvss.ReadProfile(f)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+	return profile
+}
 
-	var isInFile string
-	var isBeta string
-	var isBundled string
-	var isPublic string
-	var isSuperseded string
+// verifyScores logs the CVSS v3 scores of cve that differ from those computed from vector
+func verifyScores(cve wsusscn2.Cve, vector cvss.Vector) {
+	for _, score := range []struct {
+		name     string
+		api      string
+		computed float64
+	}{
+		{"base", cve.Cvssv3BaseScore, vector.BaseScore()},
+		{"temporal", cve.Cvssv3TemporalScore, vector.TemporalScore()},
+	} {
+		api, err := strconv.ParseFloat(score.api, 64)
+		if err != nil || math.Abs(api-score.computed) > 0.05 {
+			log.Printf("%s %s: %s score is %q, %s gives %s", cve.Cve, cve.UpdateUid, score.name, score.api, vector, cvss.FormatScore(score.computed))
+		}
+	}
+}
 
-	var updateCreationDateAfter string
-	var updateCreationDateBefore string
-	var updateCreationDateOn string
+// pageFromContext reads the flags of pageFlags
+func pageFromContext(c *cli.Context) wsusscn2.Page {
+	return wsusscn2.Page{
+		Limit:       c.Int("limit"),
+		Offset:      c.Int("offset"),
+		RecordLimit: c.Int("record_limit"),
+		Parallel:    c.Int("parallel"),
+	}
+}
 
-	apiUrl = "https://api.wsusscn2.cab:443"
+// updateFilterFromContext reads the flags of updateFilterFlags and pageFlags
+func updateFilterFromContext(c *cli.Context) wsusscn2.UpdateFilter {
+	return wsusscn2.UpdateFilter{
+		ProductTitle:             c.StringSlice("product_title"),
+		UpdateUid:                c.StringSlice("update_uid"),
+		UpdateTitle:              c.StringSlice("update_title"),
+		Kb:                       c.StringSlice("kb"),
+		UpdateType:               c.StringSlice("update_type"),
+		ProductFamilyTitle:       c.StringSlice("product_family_title"),
+		ClassificationTitle:      c.StringSlice("classification_title"),
+		MsrcSeverity:             c.StringSlice("msrc_severity"),
+		Arch:                     c.StringSlice("arch"),
+		IsSuperseded:             c.String("is_superseded"),
+		IsBundled:                c.String("is_bundled"),
+		IsPublic:                 c.String("is_public"),
+		IsBeta:                   c.String("is_beta"),
+		UpdateCreationDateAfter:  c.String("update_creation_date_after"),
+		UpdateCreationDateBefore: c.String("update_creation_date_before"),
+		UpdateCreationDateOn:     c.String("update_creation_date_on"),
+		Page:                     pageFromContext(c),
+	}
+}
 
-	api := &http.Client{Timeout: 30 * time.Second}
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             MAIN                                               */
+/*                                                                                                */
+/**************************************************************************************************/
+func main() {
+	//stop paging cleanly on ctrl-c
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	apiUrl = wsusscn2.DefaultApiUrl
 
 	//setup
 	ex, err := os.Executable()
 	check(err)
-	execPath := filepath.Dir(ex)
+	execPath = filepath.Dir(ex)
 
-	config := readConfig(execPath + "/wsusscn2cli.json")
+	config = readConfig(execPath + "/wsusscn2cli.json")
 
 	if config.ApiPort != "" {
 		apiUrl = strings.Replace(apiUrl, "443", config.ApiPort, -1)
@@ -333,68 +967,36 @@ func main() {
 		apiUrl = strings.Replace(apiUrl, "api.wsusscn2.cab", config.ApiServer, -1)
 	}
 
-	logFile, err := os.OpenFile("wsusscn2cli.log", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	logFile, err = os.OpenFile("wsusscn2cli.log", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	check(err)
+
+	newApp(ctx).Run(os.Args)
+}
 
+// newApp returns the command line application. Commands stop paging when ctx is cancelled.
+func newApp(ctx context.Context) *cli.App {
 	app := cli.NewApp()
 	app.Name = "wsusscn2cli"
-	app.Version = "0.3.0"
+	app.Version = "0.4.0"
 	app.Usage = "wsusscn2.cab integration"
 	app.Copyright = "(c) 2018 Hash Authority, LLC"
 	app.Commands = []cli.Command{
 		{
 			Name:  "listclassification",
 			Usage: "List all classifications",
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:        "api_key, a",
-					Usage:       "API key (required if not using config file)",
-					Destination: &apiKey,
-				},
-				cli.BoolFlag{
-					Name:        "debug, d",
-					Usage:       "Output debug level logging",
-					Destination: &debug,
-				},
-				cli.BoolFlag{
-					Name:        "insecure, k",
-					Usage:       "Do not verify server's SSL cert",
-					Destination: &insecure,
-				},
-				cli.BoolFlag{
-					Name:        "quiet, q",
-					Usage:       "Do not log to screen",
-					Destination: &quiet,
-				},
-			},
+			Flags: append(sourceFlags(), outputFlags()...),
 			Action: func(c *cli.Context) error {
-				if quiet {
-					log.SetOutput(logFile)
-				} else {
-					mw := io.MultiWriter(os.Stdout, logFile)
-					log.SetOutput(mw)
-				}
-
-				log.Println("List classification called")
-
-				//Authentication setup
-				if apiKey == "" && config.ApiKey == "" {
-					log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
-				}
-
-				if apiKey == "" {
-					apiKey = config.ApiKey
-				}
-
-				var classification []Classification
+				setupLogging("List classification")
 
-				req := createNewHttpReq(apiUrl+"/classification", apiKey)
+				out := newStream(c, wsusscn2.Classification{}, "")
 
-				err := getJson(api, req, debug, insecure, &classification)
+				classification, err := newSource(c).ListClassifications(ctx)
 				check(err)
-				fmt.Println(`"ClassificationUid","ClassificationRevision","ClassificationTitle"`)
+
 				for _, v := range classification {
-					fmt.Printf("\"%s\",\"%s\",\"%s\"\n", v.ClassificationUid, v.ClassificationRevision, v.ClassificationTitle)
+					check(out.Write(v))
 				}
+				check(out.Close())
 
 				return nil
 			},
@@ -402,57 +1004,19 @@ func main() {
 		{
 			Name:  "listproduct",
 			Usage: "List all products",
-			Flags: []cli.Flag{
-				cli.BoolFlag{
-					Name:        "debug, d",
-					Usage:       "Output debug level logging",
-					Destination: &debug,
-				},
-				cli.StringFlag{
-					Name:        "api_key, a",
-					Usage:       "API key (required if not using config file)",
-					Destination: &apiKey,
-				},
-				cli.BoolFlag{
-					Name:        "insecure, k",
-					Usage:       "Do not verify server's SSL cert",
-					Destination: &insecure,
-				},
-				cli.BoolFlag{
-					Name:        "quiet, q",
-					Usage:       "Do not log to screen",
-					Destination: &quiet,
-				},
-			},
+			Flags: append(sourceFlags(), outputFlags()...),
 			Action: func(c *cli.Context) error {
-				if quiet {
-					log.SetOutput(logFile)
-				} else {
-					mw := io.MultiWriter(os.Stdout, logFile)
-					log.SetOutput(mw)
-				}
-
-				log.Println("List product called")
+				setupLogging("List product")
 
-				//Authentication setup
-				if apiKey == "" && config.ApiKey == "" {
-					log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
-				}
-
-				if apiKey == "" {
-					apiKey = config.ApiKey
-				}
+				out := newStream(c, wsusscn2.Product{}, "")
 
-				var product []Product
-
-				req := createNewHttpReq(apiUrl+"/product", apiKey)
-
-				err := getJson(api, req, debug, insecure, &product)
+				product, err := newSource(c).ListProducts(ctx)
 				check(err)
-				fmt.Println(`"ProductUid","ProductRevision","ProductTitle"`)
+
 				for _, v := range product {
-					fmt.Printf("\"%s\",\"%s\",\"%s\"\n", v.ProductUid, v.ProductRevision, v.ProductTitle)
+					check(out.Write(v))
 				}
+				check(out.Close())
 
 				return nil
 			},
@@ -460,57 +1024,19 @@ func main() {
 		{
 			Name:  "listproductfamily",
 			Usage: "List all product families",
-			Flags: []cli.Flag{
-				cli.BoolFlag{
-					Name:        "debug, d",
-					Usage:       "Output debug level logging",
-					Destination: &debug,
-				},
-				cli.StringFlag{
-					Name:        "api_key, a",
-					Usage:       "API key (required if not using config file)",
-					Destination: &apiKey,
-				},
-				cli.BoolFlag{
-					Name:        "insecure, k",
-					Usage:       "Do not verify server's SSL cert",
-					Destination: &insecure,
-				},
-				cli.BoolFlag{
-					Name:        "quiet, q",
-					Usage:       "Do not log to screen",
-					Destination: &quiet,
-				},
-			},
+			Flags: append(sourceFlags(), outputFlags()...),
 			Action: func(c *cli.Context) error {
-				if quiet {
-					log.SetOutput(logFile)
-				} else {
-					mw := io.MultiWriter(os.Stdout, logFile)
-					log.SetOutput(mw)
-				}
-
-				log.Println("List productfamily called")
-
-				//Authentication setup
-				if apiKey == "" && config.ApiKey == "" {
-					log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
-				}
-
-				if apiKey == "" {
-					apiKey = config.ApiKey
-				}
-
-				var productfamily []ProductFamily
+				setupLogging("List productfamily")
 
-				req := createNewHttpReq(apiUrl+"/productfamily", apiKey)
+				out := newStream(c, wsusscn2.ProductFamily{}, "")
 
-				err := getJson(api, req, debug, insecure, &productfamily)
+				productfamily, err := newSource(c).ListProductFamilies(ctx)
 				check(err)
-				fmt.Println(`"ProductFamilyUid","ProductFamilyRevision","ProductFamilyTitle"`)
+
 				for _, v := range productfamily {
-					fmt.Printf("\"%s\",\"%s\",\"%s\"\n", v.ProductFamilyUid, v.ProductFamilyRevision, v.ProductFamilyTitle)
+					check(out.Write(v))
 				}
+				check(out.Close())
 
 				return nil
 			},
@@ -518,27 +1044,7 @@ func main() {
 		{
 			Name:  "listcve",
 			Usage: "List all CVEs",
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:        "api_key, a",
-					Usage:       "API key (required if not using config file)",
-					Destination: &apiKey,
-				},
-				cli.BoolFlag{
-					Name:        "debug, d",
-					Usage:       "Output debug level logging",
-					Destination: &debug,
-				},
-				cli.BoolFlag{
-					Name:        "insecure, k",
-					Usage:       "Do not verify server's SSL cert",
-					Destination: &insecure,
-				},
-				cli.BoolFlag{
-					Name:        "quiet, q",
-					Usage:       "Do not log to screen",
-					Destination: &quiet,
-				},
+			Flags: append(append(append(append(append(sourceFlags(),
 				cli.StringSliceFlag{
 					Name:  "cve",
 					Usage: "CVE number (Ex., CVE-2018-0001).",
@@ -546,12 +1052,10 @@ func main() {
 				cli.StringFlag{
 					Name:  "cvssv3_base_score",
 					Usage: "CVSS v3 Base Score (Range 1-10). Range allowed (Ex., 7.1-10.0)",
-					Destination: &cvssv3BaseScore,
 				},
 				cli.StringFlag{
 					Name:  "cvssv3_temporal_score",
 					Usage: "CVSS v3 Temporal Score (Range 1-10). Range allowed (Ex., 7.1-10.0)",
-					Destination: &cvssv3TemporalScore,
 				},
 				cli.StringSliceFlag{
 					Name:  "product_title",
@@ -586,858 +1090,989 @@ func main() {
 					Usage: "Architecture.",
 				},
 				cli.StringFlag{
-					Name:        "is_superseded",
-					Usage:       "Is Superseded.",
-					Destination: &isSuperseded,
+					Name:  "is_superseded",
+					Usage: "Is Superseded.",
 				},
 				cli.StringFlag{
-					Name:        "is_in_file",
-					Usage:       "Is in file (is in the current wsusscn2.cab file).",
-					Destination: &isInFile,
+					Name:  "is_in_file",
+					Usage: "Is in file (is in the current wsusscn2.cab file).",
 				},
-				cli.IntFlag{
-					Name:        "limit",
-					Usage:       "Number of records per page.",
-					Value:       defaultLimit,
-					Destination: &limit,
+				cli.BoolFlag{
+					Name:  "verify_scores",
+					Usage: "Recompute the CVSS v3 scores from the vector and log those that differ",
 				},
-				cli.IntFlag{
-					Name:        "offset",
-					Usage:       "Number of records to skip.",
-					Value:       defaultOffset,
-					Destination: &offset,
+				cli.StringFlag{
+					Name:  "env_profile",
+					Usage: "JSON file of CVSS v3 environmental metrics by product. Adds the environmental_score column.",
 				},
-				cli.IntFlag{
-					Name:        "record_limit",
-					Usage:       "Max number of records to return.",
-					Value:       defaultRecordLimit,
-					Destination: &recordLimit,
+				cli.BoolFlag{
+					Name:  "sort_env",
+					Usage: "Sort by environmental score, highest first. Needs --env_profile.",
 				},
-			},
+			), cvssFlags()...), exploitFlags()...), pageFlags()...), outputFlags()...),
 			Action: func(c *cli.Context) error {
-				if quiet {
-					log.SetOutput(logFile)
-				} else {
-					mw := io.MultiWriter(os.Stdout, logFile)
-					log.SetOutput(mw)
-				}
-
-				log.Println("List cve called")
-
-				//Authentication setup
-				if apiKey == "" && config.ApiKey == "" {
-					log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
-				}
-
-				if apiKey == "" {
-					apiKey = config.ApiKey
-				}
-
-				cve = c.StringSlice("cve")
-				productTitle = c.StringSlice("product_title")
-				updateUid = c.StringSlice("update_uid")
-				updateTitle = c.StringSlice("update_title")
-				updateKb = c.StringSlice("kb")
-				productFamilyTitle = c.StringSlice("product_family_title")
-				classificationTitle = c.StringSlice("classification_title")
-				msrcSeverity = c.StringSlice("msrc_severity")
-				arch = c.StringSlice("arch")
-
-				recordCnt := 0
-				done := false
-
-				if limit <= 0 {
-					limit = defaultLimit
-				}
-
-				if offset <= 0 {
-					offset = defaultOffset
+				setupLogging("List cve")
+
+				vectorFilter := cvssFilterFromContext(c)
+				exploits := exploitDataFromContext(c)
+				var profile *cvss.Profile
+				columns := defaultCveColumns
+				if c.String("env_profile") != "" {
+					p := readProfile(c.String("env_profile"))
+					profile = &p
+					columns = defaultEnvCveColumns
+				} else if c.Bool("sort_env") {
+					log.Fatalf("--sort_env needs --env_profile")
 				}
-
-				if recordLimit <= 0 {
-					recordLimit = defaultRecordLimit
+				var out *output.Stream
+				switch {
+				case exploits != nil:
+					out = newStream(c, cveRecord{}, columns+", "+exploitColumns)
+				case profile != nil:
+					out = newStream(c, cveRecord{}, columns)
+				default:
+					out = newStream(c, wsusscn2.Cve{}, columns)
 				}
 
-				if recordLimit < limit {
-					limit = recordLimit
+				filter := wsusscn2.CveFilter{
+					Cve:                 c.StringSlice("cve"),
+					ProductTitle:        c.StringSlice("product_title"),
+					UpdateUid:           c.StringSlice("update_uid"),
+					UpdateTitle:         c.StringSlice("update_title"),
+					Kb:                  c.StringSlice("kb"),
+					ProductFamilyTitle:  c.StringSlice("product_family_title"),
+					ClassificationTitle: c.StringSlice("classification_title"),
+					MsrcSeverity:        c.StringSlice("msrc_severity"),
+					Arch:                c.StringSlice("arch"),
+					Cvssv3BaseScore:     c.String("cvssv3_base_score"),
+					Cvssv3TemporalScore: c.String("cvssv3_temporal_score"),
+					IsSuperseded:        c.String("is_superseded"),
+					IsInFile:            c.String("is_in_file"),
+					Page:                pageFromContext(c),
 				}
 
-				fmt.Println(`"Cve","CveTitle","Cvssv3BaseScore","Cvssv3TemporalScore","Cvssv3Vector","UpdateUid","UpdateTitle","Kb","ProductTitle","ProductFamilyTitle","ClassificationTitle","MsrcSeverity","Arch","IsInFile","IsSuperseded","LatestSupersessionUid"`)
-
-				for recordCnt < recordLimit && !done {
-					var cves []Cve
-
-					req := createNewHttpReq(apiUrl+"/cve", apiKey)
+				it := newSource(c).Cves(ctx, filter)
 
-					q := req.URL.Query()
-					q.Add("limit", strconv.Itoa(limit))
-					q.Add("offset", strconv.Itoa(offset))
-
-					if len(productTitle) > 0 {
-						for _, p := range productTitle {
-							q.Add("product_title", p)
-						}
-					}
-					if len(updateUid) > 0 {
-						for _, p := range updateUid {
-							q.Add("uid", p)
-						}
+				var records []cveRecord
+				for it.Next() {
+					cve := it.Cve()
+					if len(vectorFilter) == 0 && !c.Bool("verify_scores") && profile == nil && exploits == nil {
+						check(out.Write(cve))
+						continue
 					}
-					if len(updateTitle) > 0 {
-						for _, p := range updateTitle {
-							q.Add("title", p)
-						}
-					}
-					if len(updateKb) > 0 {
-						for _, p := range updateKb {
-							q.Add("kb", p)
+
+					r := cveRecord{Cve: cve}
+					if exploits != nil {
+						r.Exploitation = exploits.Lookup(cve.Cve)
+						if !r.Exploitation.Match(c.Bool("kev_only"), c.Float64("min_epss")) {
+							continue
 						}
 					}
-					if len(productFamilyTitle) > 0 {
-						for _, p := range productFamilyTitle {
-							q.Add("product_family_title", p)
+
+					vector, err := cve.Vector()
+					if err != nil {
+						if debug || c.Bool("verify_scores") {
+							log.Printf("%s: %s", cve.Cve, err)
 						}
-					}
-					if len(classificationTitle) > 0 {
-						for _, p := range classificationTitle {
-							q.Add("classification_title", p)
+						if len(vectorFilter) > 0 {
+							continue
 						}
-					}
-					if len(msrcSeverity) > 0 {
-						for _, p := range msrcSeverity {
-							q.Add("msrc_severity", p)
+					} else {
+						if c.Bool("verify_scores") {
+							verifyScores(cve, vector)
 						}
-					}
-					if len(arch) > 0 {
-						for _, p := range arch {
-							q.Add("arch", p)
+						if !vectorFilter.Match(vector) {
+							continue
 						}
 					}
-
-					if isInFile != "" {
-						q.Add("is_in_file", isInFile)
-					}
-					if isSuperseded != "" {
-						q.Add("is_superseded", isSuperseded)
-					}
-					if cvssv3BaseScore != "" {
-						q.Add("cvssv3_base_score", cvssv3BaseScore)
-					}
-					if cvssv3TemporalScore != "" {
-						q.Add("cvssv3_temporal_score", cvssv3TemporalScore)
-					}
-
-					req.URL.RawQuery = q.Encode()
-
-					err := getJson(api, req, debug, insecure, &cves)
-					check(err)
-
-					curRecordCnt := len(cves)
-
-					for _, v := range cves {
-						fmt.Printf("\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\"\n", v.Cve, v.CveTitle, v.Cvssv3BaseScore, v.Cvssv3TemporalScore, v.Cvssv3Vector, v.UpdateUid, v.UpdateTitle, v.Kb, v.ProductTitle, v.ProductFamilyTitle, v.ClassificationTitle, v.MsrcSeverity, v.Arch, v.IsInFile, v.IsSuperseded, v.LatestSupersessionUid)
+					if profile == nil && exploits == nil {
+						check(out.Write(cve))
+						continue
 					}
 
-					recordCnt += curRecordCnt
-					offset += curRecordCnt
-
-					if curRecordCnt == 0 {
-						if debug {
-							log.Println("No more records returned")
-						}
-						done = true
+					if profile != nil && err == nil {
+						env := vector.Environment(profile.Environment(cve.ProductTitle))
+						r.EnvironmentalScore = cvss.FormatScore(env.EnvironmentalScore())
+						r.EnvironmentalVector = env.String()
 					}
-					if curRecordCnt < limit {
-						if debug {
-							log.Println("Last page of records reached")
-						}
-						done = true
+					if c.Bool("sort_env") {
+						records = append(records, r)
+					} else {
+						check(out.Write(r))
 					}
 				}
+				checkStream(out, it.Err())
+
+				// CVEs without a valid vector have no score and sort last
+				sort.SliceStable(records, func(i, j int) bool {
+					return cvss.Higher(records[i].EnvironmentalScore, records[j].EnvironmentalScore)
+				})
+				for _, r := range records {
+					check(out.Write(r))
+				}
+				check(out.Close())
+
 				return nil
 			},
 		},
 		{
 			Name:  "listupdate",
 			Usage: "List updates",
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:        "api_key, a",
-					Usage:       "API key (required if not using config file)",
-					Destination: &apiKey,
-				},
-				cli.BoolFlag{
-					Name:        "debug, d",
-					Usage:       "Output debug level logging",
-					Destination: &debug,
-				},
-				cli.BoolFlag{
-					Name:        "insecure, k",
-					Usage:       "Do not verify server's SSL cert",
-					Destination: &insecure,
-				},
-				cli.BoolFlag{
-					Name:        "quiet, q",
-					Usage:       "Do not log to screen",
-					Destination: &quiet,
-				},
+			Flags: append(append(append(append(append(sourceFlags(),
 				cli.BoolFlag{
-					Name:        "count_only",
-					Usage:       "Only print number of records",
-					Destination: &countOnly,
-				},
-				cli.StringSliceFlag{
-					Name:  "product_title",
-					Usage: "Name of product.",
-				},
-				cli.StringSliceFlag{
-					Name:  "update_uid",
-					Usage: "Update Uid.",
-				},
-				cli.StringSliceFlag{
-					Name:  "update_title",
-					Usage: "Update Title.",
-				},
-				cli.StringSliceFlag{
-					Name:  "kb",
-					Usage: "Update KB.",
-				},
-				cli.StringSliceFlag{
-					Name:  "update_type",
-					Usage: "Update Type.",
-				},
-				cli.StringSliceFlag{
-					Name:  "product_family_title",
-					Usage: "Product Family Title.",
-				},
-				cli.StringSliceFlag{
-					Name:  "classification_title",
-					Usage: "Classification Title.",
-				},
-				cli.StringSliceFlag{
-					Name:  "msrc_severity",
-					Usage: "MSRC Severity.",
+					Name:  "count_only",
+					Usage: "Only print number of records",
 				},
 				cli.StringSliceFlag{
 					Name:  "arch",
 					Usage: "Architecture.",
 				},
-				cli.StringFlag{
-					Name:        "is_superseded",
-					Usage:       "Is Superseded.",
-					Destination: &isSuperseded,
-				},
-				cli.StringFlag{
-					Name:        "is_bundled",
-					Usage:       "Is Bundled.",
-					Destination: &isBundled,
-				},
-				cli.StringFlag{
-					Name:        "is_public",
-					Usage:       "Is Public.",
-					Destination: &isPublic,
-				},
-				cli.StringFlag{
-					Name:        "is_beta",
-					Usage:       "Is Beta.",
-					Destination: &isBeta,
-				},
-				cli.StringFlag{
-					Name:        "update_creation_date_after",
-					Usage:       "Updates created after this date [YYYY-MM-DD] (exclusive).",
-					Destination: &updateCreationDateAfter,
-				},
-				cli.StringFlag{
-					Name:        "update_creation_date_before",
-					Usage:       "Updates created before this date [YYYY-MM-DD] (exclusive).",
-					Destination: &updateCreationDateBefore,
-				},
-				cli.StringFlag{
-					Name:        "update_creation_date_on",
-					Usage:       "Updates created on this date [YYYY-MM-DD].",
-					Destination: &updateCreationDateOn,
-				},
-				cli.StringFlag{
-					Name:        "columns",
-					Usage:       "Restrict output to listed columns.",
-					Destination: &columns,
-				},
-				cli.IntFlag{
-					Name:        "limit",
-					Usage:       "Number of records per page.",
-					Value:       defaultLimit,
-					Destination: &limit,
-				},
-				cli.IntFlag{
-					Name:        "offset",
-					Usage:       "Number of records to skip.",
-					Value:       defaultOffset,
-					Destination: &offset,
-				},
 				cli.IntFlag{
-					Name:        "record_limit",
-					Usage:       "Max number of records to return.",
-					Value:       defaultRecordLimit,
-					Destination: &recordLimit,
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently. Rows are still written in order.",
+					Value: 1,
 				},
-			},
+			), updateFilterFlags()...), exploitFlags()...), pageFlags()...), outputFlags()...),
 			Action: func(c *cli.Context) error {
-				if quiet {
-					log.SetOutput(logFile)
+				setupLogging("List update")
+
+				exploits := exploitDataFromContext(c)
+				var out *output.Stream
+				if exploits != nil {
+					out = newStream(c, updateRecord{}, defaultUpdateColumns+", cves, "+exploitColumns)
 				} else {
-					mw := io.MultiWriter(os.Stdout, logFile)
-					log.SetOutput(mw)
+					out = newStream(c, wsusscn2.Update{}, defaultUpdateColumns)
 				}
 
-				log.Println("List update called")
-
-				//Authentication setup
-				if apiKey == "" && config.ApiKey == "" {
-					log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
-				}
+				source := newSource(c)
+				it := source.Updates(ctx, updateFilterFromContext(c))
 
-				if apiKey == "" {
-					apiKey = config.ApiKey
+				if c.Bool("count_only") && exploits == nil {
+					recordCnt := 0
+					for it.Next() {
+						recordCnt++
+					}
+					check(it.Err())
+					fmt.Printf("Number of records: %d\n", recordCnt)
+					return nil
 				}
 
-				productTitle = c.StringSlice("product_title")
-				updateUid = c.StringSlice("update_uid")
-				updateTitle = c.StringSlice("update_title")
-				updateKb = c.StringSlice("kb")
-				updateType = c.StringSlice("update_type")
-				productFamilyTitle = c.StringSlice("product_family_title")
-				classificationTitle = c.StringSlice("classification_title")
-				msrcSeverity = c.StringSlice("msrc_severity")
-				arch = c.StringSlice("arch")
-
-				if columns == "" {
-					columns = defaultUpdateColumns
+				if exploits == nil {
+					for it.Next() {
+						check(out.Write(it.Update()))
+					}
+					checkStream(out, it.Err())
+					check(out.Close())
+					return nil
 				}
 
-				columnFilter := strToSlice(columns)
-
+				// the updates are written a batch at a time once their CVEs are fetched, so the
+				// output still streams
 				recordCnt := 0
-				done := false
+				var batch []wsusscn2.Update
+				flush := func() {
+					cves := updateCves(ctx, source, batch)
+					for _, u := range batch {
+						r := updateRecord{Update: u, Cves: strings.Join(cves[strings.ToLower(u.UpdateUid)], ",")}
+						r.Exploitation = exploits.Lookup(cves[strings.ToLower(u.UpdateUid)]...)
+						if !r.Exploitation.Match(c.Bool("kev_only"), c.Float64("min_epss")) {
+							continue
+						}
+						recordCnt++
+						if !c.Bool("count_only") {
+							check(out.Write(r))
+						}
+					}
+					batch = batch[:0]
+				}
+				for it.Next() {
+					batch = append(batch, it.Update())
+					if len(batch) == wsusscn2.UidBatch {
+						flush()
+					}
+				}
+				checkStream(out, it.Err())
+				flush()
 
-				if limit <= 0 {
-					limit = defaultLimit
+				if c.Bool("count_only") {
+					fmt.Printf("Number of records: %d\n", recordCnt)
+					return nil
 				}
+				check(out.Close())
+				return nil
+			},
+		},
+		{
+			Name:  "showupdate",
+			Usage: "Show one update, and with --rules its prerequisites and applicability rules",
+			Flags: append(sourceFlags(),
+				cli.StringFlag{
+					Name:  "update_uid",
+					Usage: "Update to show",
+				},
+				cli.BoolFlag{
+					Name:  "rules",
+					Usage: "Show the prerequisites and IsInstalled/IsInstallable rules. Needs --cab.",
+				},
+			),
+			Action: func(c *cli.Context) error {
+				setupLogging("Show update")
 
-				if offset <= 0 {
-					offset = defaultOffset
+				uid := strings.TrimSpace(c.String("update_uid"))
+				if uid == "" {
+					log.Fatalf("--update_uid argument is blank. Ex., wsusscn2cli showupdate --cab wsusscn2.cab --rules --update_uid 8b4e84f6-595f-41ed-854f-4ca886e317a5")
 				}
 
-				if recordLimit <= 0 {
-					recordLimit = defaultRecordLimit
+				var source wsusscn2.Source
+				var rules []*cab.UpdateRules
+				if c.Bool("rules") {
+					// applicability rules are only in the cab, read it once for both
+					if c.String("cab") == "" {
+						log.Fatalf("--rules needs --cab. The API and the mirror carry no applicability rules")
+					}
+					cabinet, err := cab.Open(c.String("cab"))
+					check(err)
+					catalog, err := cab.ReadCatalogRules(cabinet, nil, []string{uid})
+					cabinet.Close()
+					check(err)
+					source, rules = catalogSource(catalog), catalog.Rules
+				} else {
+					source = newSource(c)
 				}
 
-				if recordLimit < limit {
-					limit = recordLimit
+				// one record per product, the other fields are the same
+				var update wsusscn2.Update
+				var products, families []string
+				it := source.Updates(ctx, wsusscn2.UpdateFilter{UpdateUid: []string{uid}})
+				for it.Next() {
+					update = it.Update()
+					products = append(products, update.ProductTitle)
+					families = append(families, update.ProductFamilyTitle)
+				}
+				check(it.Err())
+				if len(products) == 0 {
+					log.Fatalf("Update %s not found", uid)
 				}
+				update.ProductTitle = strings.Join(products, ", ")
+				update.ProductFamilyTitle = strings.Join(uniqueStrings(families), ", ")
 
-				for recordCnt < recordLimit && !done {
-					var update []Update
+				selector, err := output.NewSelector(update, "")
+				check(err)
+				width := 0
+				for _, column := range selector.Columns() {
+					if len(column.Title) > width {
+						width = len(column.Title)
+					}
+				}
+				values := selector.Values(update)
+				for i, column := range selector.Columns() {
+					fmt.Printf("%-*s %s\n", width+1, column.Title+":", values[i])
+				}
 
-					req := createNewHttpReq(apiUrl+"/update", apiKey)
-
-					q := req.URL.Query()
-					q.Add("limit", strconv.Itoa(limit))
-					q.Add("offset", strconv.Itoa(offset))
-
-					if len(productTitle) > 0 {
-						for _, p := range productTitle {
-							q.Add("product_title", p)
-						}
-					}
-					if len(updateUid) > 0 {
-						for _, p := range updateUid {
-							q.Add("uid", p)
-						}
-					}
-					if len(updateTitle) > 0 {
-						for _, p := range updateTitle {
-							q.Add("title", p)
-						}
-					}
-					if len(updateKb) > 0 {
-						for _, p := range updateKb {
-							q.Add("kb", p)
-						}
-					}
-					if len(updateType) > 0 {
-						for _, p := range updateType {
-							q.Add("type", p)
-						}
-					}
-					if len(productFamilyTitle) > 0 {
-						for _, p := range productFamilyTitle {
-							q.Add("product_family_title", p)
-						}
-					}
-					if len(classificationTitle) > 0 {
-						for _, p := range classificationTitle {
-							q.Add("classification_title", p)
-						}
-					}
-					if len(msrcSeverity) > 0 {
-						for _, p := range msrcSeverity {
-							q.Add("msrc_severity", p)
-						}
-					}
-					if len(arch) > 0 {
-						for _, p := range arch {
-							q.Add("arch", p)
-						}
-					}
+				for _, r := range rules {
+					fmt.Println()
+					check(r.Format(os.Stdout))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "listsupersede",
+			Usage: "List supersession updates",
+			Flags: append(append(append(sourceFlags(), updateFilterFlags()...), pageFlags()...), outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("List supersede")
 
-					if isSuperseded != "" {
-						q.Add("is_superseded", isSuperseded)
-					}
+				out := newStream(c, wsusscn2.UpdateSupersede{}, "")
 
-					if isBundled != "" {
-						q.Add("is_bundled", isBundled)
-					}
+				it := newSource(c).Supersedes(ctx, updateFilterFromContext(c))
 
-					if isPublic != "" {
-						q.Add("is_public", isPublic)
-					}
+				for it.Next() {
+					check(out.Write(it.Supersede()))
+				}
+				checkStream(out, it.Err())
+				check(out.Close())
 
-					if isBeta != "" {
-						q.Add("is_beta", isBeta)
-					}
+				return nil
+			},
+		},
+		{
+			Name:  "chain",
+			Usage: "Show the supersedence chain of a KB, from the oldest update it replaces to its current replacement",
+			Flags: append(append(sourceFlags(),
+				cli.StringFlag{
+					Name:  "kb",
+					Usage: "KB to follow (Ex., 4025339)",
+				},
+				cli.StringSliceFlag{
+					Name:  "product_title",
+					Usage: "Products to load, default the products of the KB",
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
+				},
+			), outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Chain")
 
-					layout := "2006-01-02"
-					if updateCreationDateAfter != "" {
-						_, err := time.Parse(layout, updateCreationDateAfter)
-						if err != nil {
-							log.Fatal(err)
-							log.Println("Unable to parse provided date. Expected: YYYY-MM-DD. Found %s", updateCreationDateAfter)
-							return nil
-						}
-						q.Add("update_creation_date_after", updateCreationDateAfter)
+				kb := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(c.String("kb"))), "KB")
+				if kb == "" {
+					log.Fatalf("--kb argument is blank. Ex., wsusscn2cli chain --kb 4025339")
+				}
+				out := newStream(c, chainRecord{}, "")
+
+				source := newSource(c)
+				graph := loadGraph(ctx, c, source, []string{kb})
+				chain := followChain(ctx, source, graph, kb)
+
+				for _, step := range chain.Steps {
+					r := chainRecord{
+						Depth:        step.Depth,
+						UpdateUid:    step.UpdateUid,
+						Supersedes:   graphKbs(graph, step.Supersedes),
+						SupersededBy: graphKbs(graph, step.SupersededBy),
+						Bundles:      graphKbs(graph, graph.Bundles(step.UpdateUid)),
+						IsCurrent:    step.Current && !step.Missing,
 					}
-
-					if updateCreationDateBefore != "" {
-						_, err := time.Parse(layout, updateCreationDateBefore)
-						if err != nil {
-							log.Fatal(err)
-							log.Println("Unable to parse provided date. Expected: YYYY-MM-DD. Found %s", updateCreationDateBefore)
-							return nil
-						}
-						q.Add("update_creation_date_before", updateCreationDateBefore)
+					if u, ok := graph.Update(step.UpdateUid); ok {
+						r.Kb, r.UpdateTitle, r.UpdateCreationDate = u.Kb, u.UpdateTitle, u.UpdateCreationDate
+						r.ProductTitle = strings.Join(graph.Products(step.UpdateUid), ", ")
 					}
-
-					if updateCreationDateOn != "" {
-						if strings.ToLower(updateCreationDateOn) != "today" {
-							_, err := time.Parse(layout, updateCreationDateOn)
-							if err != nil {
-								log.Fatal(err)
-								log.Println("Unable to parse provided date. Expected: YYYY-MM-DD. Found %s", updateCreationDateOn)
-								return nil
-							}
-						}
-						q.Add("update_creation_date_on", updateCreationDateOn)
+					var notes []string
+					if step.Depth == 0 {
+						notes = append(notes, "requested")
 					}
-
-					req.URL.RawQuery = q.Encode()
-
-					err := getJson(api, req, debug, insecure, &update)
-					check(err)
-
-					curRecordCnt := len(update)
-
-					if countOnly {
-						//do nothing here
-					} else {
-						firstCol := true
-						for _, c := range columnFilter {
-							if val, ok := defaultUpdateColumnsTitle[c]; ok {
-								if firstCol {
-									firstCol = false
-								} else {
-									fmt.Printf(",")
-								}
-								fmt.Printf("\"%s\"", val)
-							}
-						}
-						fmt.Printf("\n")
-
-						for _, v := range update {
-							firstCol = true
-							for _, c := range columnFilter {
-								if firstCol {
-									firstCol = false
-								} else {
-									fmt.Printf(",")
-								}
-
-								switch c {
-								case "arch":
-									fmt.Printf("\"%s\"", v.Arch)
-								case "classification_title":
-									fmt.Printf("\"%s\"", v.ClassificationTitle)
-								case "company_title":
-									fmt.Printf("\"%s\"", v.CompanyTitle)
-								case "description":
-									fmt.Printf("\"%s\"", v.Description)
-								case "install_behavior":
-									fmt.Printf("\"%s\"", v.InstallBehavior)
-								case "is_beta":
-									fmt.Printf("\"%s\"", v.IsBeta)
-								case "is_bundled":
-									fmt.Printf("\"%s\"", v.IsBundled)
-								case "is_public":
-									fmt.Printf("\"%s\"", v.IsPublic)
-								case "is_superseded":
-									fmt.Printf("\"%s\"", v.IsSuperseded)
-								case "kb":
-									fmt.Printf("\"%s\"", v.Kb)
-								case "language":
-									fmt.Printf("\"%s\"", v.Language)
-								case "more_info_url":
-									fmt.Printf("\"%s\"", v.MoreInfoUrl)
-								case "msrc_severity":
-									fmt.Printf("\"%s\"", v.MsrcSeverity)
-								case "product_family_title":
-									fmt.Printf("\"%s\"", v.ProductFamilyTitle)
-								case "product_title":
-									fmt.Printf("\"%s\"", v.ProductTitle)
-								case "publication_state":
-									fmt.Printf("\"%s\"", v.PublicationState)
-								case "readiness":
-									fmt.Printf("\"%s\"", v.Readiness)
-								case "support_url":
-									fmt.Printf("\"%s\"", v.SupportUrl)
-								case "uninstall_behavior":
-									fmt.Printf("\"%s\"", v.UninstallBehavior)
-								case "uninstall_notes":
-									fmt.Printf("\"%s\"", v.UninstallNotes)
-								case "update_creation_date":
-									fmt.Printf("\"%s\"", v.UpdateCreationDate)
-								case "update_revision":
-									fmt.Printf("\"%s\"", v.UpdateRevision)
-								case "update_title":
-									fmt.Printf("\"%s\"", v.UpdateTitle)
-								case "update_type":
-									fmt.Printf("\"%s\"", v.UpdateType)
-								case "update_uid":
-									fmt.Printf("\"%s\"", v.UpdateUid)
-								}
-							}
-							fmt.Printf("\n")
-						}
+					if step.Missing {
+						notes = append(notes, "broken link: update not found")
 					}
-
-					recordCnt += curRecordCnt
-					offset += curRecordCnt
-
-					if curRecordCnt == 0 {
-						if debug {
-							log.Println("No more records returned")
-						}
-						done = true
+					if len(step.SupersededBy) > 1 {
+						notes = append(notes, "superseded by several updates")
 					}
-					if curRecordCnt < limit {
-						if debug {
-							log.Println("Last page of records reached")
-						}
-						done = true
+					if step.Current && step.Depth > 0 {
+						notes = append(notes, "current replacement")
 					}
+					r.Note = strings.Join(notes, "; ")
+					check(out.Write(r))
 				}
+				check(out.Close())
 
-				if countOnly {
-					fmt.Printf("Number of records: %d\n", recordCnt)
+				for _, cycle := range chain.Cycles {
+					log.Printf("Supersedence cycle: %s", strings.Replace(graphKbs(graph, cycle), ",", " -> ", -1))
 				}
-
 				return nil
 			},
 		},
 		{
-			Name:  "listsupersede",
-			Usage: "List supersession updates",
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:        "api_key, a",
-					Usage:       "API key (required if not using config file)",
-					Destination: &apiKey,
+			Name:  "graph",
+			Usage: "Draw the supersedence and bundle graph of KBs or products as Graphviz DOT, GraphML or Mermaid",
+			Flags: append(sourceFlags(),
+				cli.StringSliceFlag{
+					Name:  "kb",
+					Usage: "KBs whose supersedence chains to draw. Repeat for more KBs.",
 				},
-				cli.BoolFlag{
-					Name:        "debug, d",
-					Usage:       "Output debug level logging",
-					Destination: &debug,
+				cli.StringSliceFlag{
+					Name:  "product_title",
+					Usage: "Products to load. Without --kb every update of the products is drawn.",
 				},
-				cli.BoolFlag{
-					Name:        "insecure, k",
-					Usage:       "Do not verify server's SSL cert",
-					Destination: &insecure,
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "Graph format: dot (Graphviz), graphml (yEd) or mermaid (Markdown).",
+					Value: "dot",
 				},
-				cli.BoolFlag{
-					Name:        "quiet, q",
-					Usage:       "Do not log to screen",
-					Destination: &quiet,
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
 				},
+			),
+			Action: func(c *cli.Context) error {
+				setupLogging("Graph")
+
+				var kbs []string
+				for _, kb := range c.StringSlice("kb") {
+					kbs = append(kbs, strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(kb)), "KB"))
+				}
+				if len(kbs) == 0 && len(c.StringSlice("product_title")) == 0 {
+					log.Fatalf("--kb and --product_title arguments are blank. Ex., wsusscn2cli graph --kb 4025339 --format mermaid")
+				}
+				format := strings.ToLower(c.String("format"))
+				if indexOf(wsusscn2.GraphFormats, format) < 0 {
+					log.Fatalf("Unknown graph format %s. Expected: %s", c.String("format"), strings.Join(wsusscn2.GraphFormats, ", "))
+				}
+
+				source := newSource(c)
+				graph := loadGraph(ctx, c, source, kbs)
+				var uids []string
+				if len(kbs) == 0 {
+					uids = graph.Nodes()
+				}
+				for _, kb := range kbs {
+					for _, step := range followChain(ctx, source, graph, kb).Steps {
+						uids = append(uids, step.UpdateUid)
+						uids = append(uids, graph.Bundles(step.UpdateUid)...)
+					}
+				}
+
+				sub := graph.Subgraph(uniqueStrings(uids))
+				log.Printf("Drawing %d updates and %d links", len(sub.Nodes()), len(sub.Links()))
+				check(sub.Write(os.Stdout, format))
+
+				return nil
+			},
+		},
+		{
+			Name:  "auditsupersede",
+			Usage: "Check is_superseded flags and supersede records against the supersedence chains",
+			Flags: append(append(sourceFlags(),
 				cli.StringSliceFlag{
 					Name:  "product_title",
-					Usage: "Name of product.",
+					Usage: "Products to audit, default all. Repeat for more products.",
 				},
 				cli.StringSliceFlag{
-					Name:  "update_uid",
-					Usage: "Update Uid.",
+					Name:  "check",
+					Usage: "Checks to report, default all: " + strings.Join(wsusscn2.AuditChecks, ", "),
 				},
-				cli.StringSliceFlag{
-					Name:  "update_title",
-					Usage: "Update Title.",
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
 				},
+			), outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Audit supersede")
+
+				checks := make(map[string]bool)
+				for _, name := range c.StringSlice("check") {
+					name = strings.ToLower(strings.TrimSpace(name))
+					if indexOf(wsusscn2.AuditChecks, name) < 0 {
+						log.Fatalf("Unknown check %s. Expected: %s", name, strings.Join(wsusscn2.AuditChecks, ", "))
+					}
+					checks[name] = true
+				}
+				out := newStream(c, wsusscn2.Anomaly{}, "")
+
+				// updates of other products the loaded ones link to are not dangling
+				source := newSource(c)
+				graph := loadGraph(ctx, c, source, nil)
+				var linked []string
+				for _, l := range graph.Broken() {
+					for _, uid := range []string{l.From, l.To} {
+						if _, ok := graph.Update(uid); !ok {
+							linked = append(linked, uid)
+						}
+					}
+				}
+				_, err := graph.Fetch(ctx, source, uniqueStrings(linked))
+				check(err)
+
+				counts := make(map[string]int)
+				for _, a := range graph.Audit() {
+					if len(checks) > 0 && !checks[a.Check] {
+						continue
+					}
+					counts[a.Check]++
+					check(out.Write(a))
+				}
+				check(out.Close())
+
+				for _, name := range wsusscn2.AuditChecks {
+					if counts[name] > 0 {
+						log.Printf("%s: %d", name, counts[name])
+					}
+				}
+				if len(counts) == 0 {
+					log.Printf("No anomalies in %d updates", graph.Len())
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "remediate",
+			Usage: "List the fewest current KBs per product, architecture and version that remediate a list of CVEs",
+			Flags: append(append(sourceFlags(),
 				cli.StringSliceFlag{
-					Name:  "kb",
-					Usage: "Update KB.",
+					Name:  "cve",
+					Usage: "CVE to remediate (Ex., CVE-2018-8174). Repeat for more CVEs.",
 				},
 				cli.StringSliceFlag{
-					Name:  "update_type",
-					Usage: "Update Type.",
+					Name:  "product_title",
+					Usage: "Products to remediate, default all. Repeat for more products.",
 				},
 				cli.StringSliceFlag{
-					Name:  "product_family_title",
-					Usage: "Product Family Title.",
+					Name:  "arch",
+					Usage: "Architecture.",
 				},
-				cli.StringSliceFlag{
-					Name:  "classification_title",
-					Usage: "Classification Title.",
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
 				},
+			), outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Remediate")
+
+				var cves []string
+				for _, value := range c.StringSlice("cve") {
+					for _, cve := range strings.Split(value, ",") {
+						if cve = strings.ToUpper(strings.TrimSpace(cve)); cve != "" {
+							cves = append(cves, cve)
+						}
+					}
+				}
+				if len(cves) == 0 {
+					log.Fatalf("--cve argument is blank. Ex., wsusscn2cli remediate --cve CVE-2018-8174 --product_title \"Windows 10\"")
+				}
+				out := newStream(c, wsusscn2.Remediation{}, "")
+
+				remediations, err := wsusscn2.Remediate(ctx, newSource(c), wsusscn2.CveFilter{
+					Cve:          uniqueStrings(cves),
+					ProductTitle: c.StringSlice("product_title"),
+					Arch:         c.StringSlice("arch"),
+					Page:         wsusscn2.Page{RecordLimit: math.MaxInt32, Parallel: c.Int("parallel")},
+				})
+				check(err)
+
+				remediated := make(map[string]bool)
+				for _, r := range remediations {
+					for _, cve := range strings.Split(r.Cves, ",") {
+						remediated[cve] = true
+					}
+					check(out.Write(r))
+				}
+				check(out.Close())
+
+				for _, cve := range uniqueStrings(cves) {
+					if !remediated[cve] {
+						log.Printf("No update found for %s", cve)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "assess",
+			Usage: "List the updates hosts are missing, from an inventory of their OS and installed KBs",
+			Flags: append(append(sourceFlags(),
 				cli.StringSliceFlag{
-					Name:  "msrc_severity",
-					Usage: "MSRC Severity.",
-				},
-				cli.StringFlag{
-					Name:        "is_superseded",
-					Usage:       "Is Superseded.",
-					Destination: &isSuperseded,
+					Name:  "inventory, i",
+					Usage: "Host inventory file, - reads stdin. Repeat for more files, hosts are merged by hostname.",
 				},
 				cli.StringFlag{
-					Name:        "is_bundled",
-					Usage:       "Is Bundled.",
-					Destination: &isBundled,
+					Name:  "format",
+					Usage: "Inventory format: auto, json, systeminfo, hotfix (Get-HotFix | ConvertTo-Csv), qfe (wmic qfe list /format:csv) or osquery.",
+					Value: "auto",
 				},
-				cli.StringFlag{
-					Name:        "is_public",
-					Usage:       "Is Public.",
-					Destination: &isPublic,
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
 				},
+			), outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Assess")
+
+				hosts := readInventory(c.StringSlice("inventory"), c.String("format"), false)
+				out := newStream(c, inventory.Finding{}, "")
+
+				assessor := inventory.NewAssessor(newSource(c))
+				assessor.Parallel = c.Int("parallel")
+				for _, host := range hosts {
+					findings, err := assessor.Assess(ctx, host)
+					check(err)
+					for _, f := range findings {
+						check(out.Write(f))
+					}
+					if debug || len(hosts) > 1 {
+						log.Printf("%s: %d missing updates", host.Hostname, len(findings))
+					}
+				}
+				check(out.Close())
+
+				return nil
+			},
+		},
+		{
+			Name:  "fleetreport",
+			Usage: "Report the compliance of every host of an inventory directory, per host and per KB",
+			Flags: append(append(sourceFlags(),
 				cli.StringFlag{
-					Name:        "is_beta",
-					Usage:       "Is Beta.",
-					Destination: &isBeta,
+					Name:  "dir",
+					Usage: "Directory of inventory files, one or more per host. Files in several formats are merged by hostname.",
 				},
 				cli.StringFlag{
-					Name:        "update_creation_date_after",
-					Usage:       "Updates created after this date [YYYY-MM-DD] (exclusive).",
-					Destination: &updateCreationDateAfter,
+					Name:  "format",
+					Usage: "Inventory format: auto, json, systeminfo, hotfix, qfe or osquery.",
+					Value: "auto",
 				},
 				cli.StringFlag{
-					Name:        "update_creation_date_before",
-					Usage:       "Updates created before this date [YYYY-MM-DD] (exclusive).",
-					Destination: &updateCreationDateBefore,
+					Name:  "by",
+					Usage: "Rows of csv and json output: host or kb. html shows both.",
+					Value: "host",
 				},
 				cli.StringFlag{
-					Name:        "update_creation_date_on",
-					Usage:       "Updates created on this date [YYYY-MM-DD].",
-					Destination: &updateCreationDateOn,
+					Name:  "title",
+					Usage: "Title of the html report",
+					Value: "Fleet compliance report",
 				},
 				cli.IntFlag{
-					Name:        "limit",
-					Usage:       "Number of records per page.",
-					Value:       defaultLimit,
-					Destination: &limit,
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
 				},
-				cli.IntFlag{
-					Name:        "offset",
-					Usage:       "Number of records to skip.",
-					Value:       defaultOffset,
-					Destination: &offset,
-				},
-				cli.IntFlag{
-					Name:        "record_limit",
-					Usage:       "Max number of records to return.",
-					Value:       defaultRecordLimit,
-					Destination: &recordLimit,
-				},
-			},
+			), fleetOutputFlags()...),
 			Action: func(c *cli.Context) error {
-				if quiet {
-					log.SetOutput(logFile)
-				} else {
-					mw := io.MultiWriter(os.Stdout, logFile)
-					log.SetOutput(mw)
+				setupLogging("FleetReport")
+
+				var record interface{}
+				switch strings.ToLower(c.String("by")) {
+				case "host":
+					record = inventory.HostSummary{}
+				case "kb":
+					record = inventory.KbSummary{}
+				default:
+					log.Fatalf("Unknown --by %s. Expected: host, kb", c.String("by"))
+				}
+				html := strings.EqualFold(c.String("output"), "html")
+				var out *output.Stream
+				if !html {
+					out = newStream(c, record, "")
 				}
 
-				log.Println("List supersede called")
-
-				//Authentication setup
-				if apiKey == "" && config.ApiKey == "" {
-					log.Fatalf("Unable to find api key. use api_key or set one using wsusscn2cli setapikey --api_key 1234")
+				hosts := readInventory(inventoryDir(c.String("dir")), c.String("format"), true)
+				report := inventory.NewReport(c.String("title"), time.Now())
+				assessor := inventory.NewAssessor(newSource(c))
+				assessor.Parallel = c.Int("parallel")
+				for _, host := range hosts {
+					findings, err := assessor.Assess(ctx, host)
+					check(err)
+					report.Add(host, findings)
+					if debug {
+						log.Printf("%s: %d missing updates", host.Hostname, len(findings))
+					}
 				}
+				report.Finish()
+				log.Printf("%d of %d hosts compliant, %d missing updates", report.Compliant(), len(report.Hosts), len(report.Findings))
 
-				if apiKey == "" {
-					apiKey = config.ApiKey
+				if html {
+					check(report.WriteHTML(os.Stdout))
+					return nil
 				}
+				if _, ok := record.(inventory.HostSummary); ok {
+					for _, h := range report.Hosts {
+						check(out.Write(h))
+					}
+				} else {
+					for _, k := range report.Kbs {
+						check(out.Write(k))
+					}
+				}
+				check(out.Close())
 
-				productTitle = c.StringSlice("product_title")
-				updateUid = c.StringSlice("update_uid")
-				updateTitle = c.StringSlice("update_title")
-				updateKb = c.StringSlice("kb")
-				updateType = c.StringSlice("update_type")
-				productFamilyTitle = c.StringSlice("product_family_title")
-				classificationTitle = c.StringSlice("classification_title")
-				msrcSeverity = c.StringSlice("msrc_severity")
+				return nil
+			},
+		},
+		{
+			Name:  "quota",
+			Usage: "Show the API rate limit and remaining quota",
+			Flags: append(apiFlags(), outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Quota")
 
-				recordCnt := 0
-				done := false
+				out := newStream(c, quotaRecord{}, "")
 
-				if limit <= 0 {
-					limit = defaultLimit
-				}
+				api := newClient()
+				_, err := api.ListClassifications(ctx)
+				check(err)
 
-				if offset <= 0 {
-					offset = defaultOffset
+				q, ok := api.Quota()
+				if !ok {
+					log.Println("No rate limit headers returned by service")
+					return nil
 				}
 
-				if recordLimit <= 0 {
-					recordLimit = defaultRecordLimit
+				record := quotaRecord{Limit: quotaValue(q.Limit), Remaining: quotaValue(q.Remaining)}
+				if !q.Reset.IsZero() {
+					record.Reset = q.Reset.Format(time.RFC3339)
 				}
 
-				if recordLimit < limit {
-					limit = recordLimit
-				}
+				check(out.Write(record))
+				check(out.Close())
 
-				for recordCnt < recordLimit && !done {
-					var update []UpdateSupersede
+				return nil
+			},
+		},
+		{
+			Name:  "sync",
+			Usage: "Copy updates, CVEs, supersedence and catalogs into a local SQLite mirror",
+			Flags: append(apiFlags(),
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "SQLite mirror to create or update",
+					Value: "wsusscn2cli.db",
+				},
+				cli.BoolFlag{
+					Name:  "full",
+					Usage: "Pull every update and CVE again instead of only those created since the last sync",
+				},
+				cli.IntFlag{
+					Name:  "limit",
+					Usage: "Records per request.",
+					Value: wsusscn2.DefaultLimit,
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of pages to fetch concurrently.",
+					Value: 1,
+				},
+			),
+			Action: func(c *cli.Context) error {
+				setupLogging("Sync")
 
-					req := createNewHttpReq(apiUrl+"/supersede", apiKey)
+				db, err := mirror.Create(c.String("db"))
+				check(err)
+				defer db.Close()
 
-					q := req.URL.Query()
-					q.Add("limit", strconv.Itoa(limit))
-					q.Add("offset", strconv.Itoa(offset))
+				stats, err := db.Sync(ctx, newClient(), mirror.SyncOptions{
+					Full:     c.Bool("full"),
+					Limit:    c.Int("limit"),
+					Parallel: c.Int("parallel"),
+				})
+				check(err)
 
-					if len(productTitle) > 0 {
-						for _, p := range productTitle {
-							q.Add("product_title", p)
-						}
-					}
-					if len(updateUid) > 0 {
-						for _, p := range updateUid {
-							q.Add("uid", p)
-						}
-					}
-					if len(updateTitle) > 0 {
-						for _, p := range updateTitle {
-							q.Add("title", p)
-						}
-					}
-					if len(updateKb) > 0 {
-						for _, p := range updateKb {
-							q.Add("kb", p)
-						}
-					}
-					if len(updateType) > 0 {
-						for _, p := range updateType {
-							q.Add("type", p)
-						}
-					}
-					if len(productFamilyTitle) > 0 {
-						for _, p := range productFamilyTitle {
-							q.Add("product_family_title", p)
-						}
-					}
-					if len(classificationTitle) > 0 {
-						for _, p := range classificationTitle {
-							q.Add("classification_title", p)
-						}
-					}
-					if len(msrcSeverity) > 0 {
-						for _, p := range msrcSeverity {
-							q.Add("msrc_severity", p)
-						}
-					}
+				log.Printf("Synced %d updates, %d CVEs and %d supersede records into %s", stats.Updates, stats.Cves, stats.Supersedes, c.String("db"))
+				return nil
+			},
+		},
+		{
+			Name:      "query",
+			Usage:     "Run a read-only SQL query against the local mirror",
+			ArgsUsage: "\"SQL\" (- to read it from stdin)",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "SQLite mirror created by sync",
+					Value: "wsusscn2cli.db",
+				},
+				cli.BoolFlag{
+					Name:        "debug, d",
+					Usage:       "Output debug level logging",
+					Destination: &debug,
+				},
+				cli.BoolFlag{
+					Name:        "quiet, q",
+					Usage:       "Do not log to screen",
+					Destination: &quiet,
+				},
+			}, formatFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Query")
 
-					if isSuperseded != "" {
-						q.Add("is_superseded", isSuperseded)
-					}
+				query := strings.Join(c.Args(), " ")
+				if query == "-" {
+					b, err := ioutil.ReadAll(os.Stdin)
+					check(err)
+					query = string(b)
+				}
+				if strings.TrimSpace(query) == "" {
+					log.Fatalf("No query given. Ex., wsusscn2cli query \"SELECT kb, update_title FROM updates LIMIT 10\"")
+				}
+				if debug {
+					log.Println(query)
+				}
 
-					if isBundled != "" {
-						q.Add("is_bundled", isBundled)
-					}
+				db, err := mirror.Open(c.String("db"))
+				check(err)
+				defer db.Close()
 
-					if isPublic != "" {
-						q.Add("is_public", isPublic)
-					}
+				rows, err := db.Query(ctx, query)
+				check(err)
+				defer rows.Close()
 
-					if isBeta != "" {
-						q.Add("is_beta", isBeta)
-					}
+				var columns []output.Column
+				for _, name := range rows.Columns() {
+					columns = append(columns, output.Column{Name: name, Title: name})
+				}
 
-					layout := "2006-01-02"
-					if updateCreationDateAfter != "" {
-						_, err := time.Parse(layout, updateCreationDateAfter)
-						if err != nil {
-							log.Fatal(err)
-							log.Println("Unable to parse provided date. Expected: YYYY-MM-DD. Found %s", updateCreationDateAfter)
-							return nil
-						}
-						q.Add("update_creation_date_after", updateCreationDateAfter)
-					}
+				out := newWriter(c)
+				check(out.WriteHeader(columns))
+				for rows.Next() {
+					check(out.WriteRow(rows.Values()))
+				}
+				check(rows.Err())
+				check(out.Flush())
 
-					if updateCreationDateBefore != "" {
-						_, err := time.Parse(layout, updateCreationDateBefore)
-						if err != nil {
-							log.Fatal(err)
-							log.Println("Unable to parse provided date. Expected: YYYY-MM-DD. Found %s", updateCreationDateBefore)
-							return nil
-						}
-						q.Add("update_creation_date_before", updateCreationDateBefore)
-					}
+				return nil
+			},
+		},
+		{
+			Name:  "extract",
+			Usage: "List, test or extract the files of a cabinet such as wsusscn2.cab",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "cab",
+					Usage: "Cabinet to read",
+				},
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "Directory to extract into",
+					Value: ".",
+				},
+				cli.BoolFlag{
+					Name:  "list, l",
+					Usage: "List the files instead of extracting them",
+				},
+				cli.BoolFlag{
+					Name:  "test, t",
+					Usage: "Decompress and verify checksums without writing files",
+				},
+				cli.BoolFlag{
+					Name:  "inner",
+					Usage: "Also extract (or list) the cabinets nested in the cabinet, each into a directory named after it",
+				},
+				cli.BoolFlag{
+					Name:        "debug, d",
+					Usage:       "Output debug level logging",
+					Destination: &debug,
+				},
+				cli.BoolFlag{
+					Name:        "quiet, q",
+					Usage:       "Do not log to screen",
+					Destination: &quiet,
+				},
+			}, outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Extract")
 
-					if updateCreationDateOn != "" {
-						if strings.ToLower(updateCreationDateOn) != "today" {
-							_, err := time.Parse(layout, updateCreationDateOn)
-							if err != nil {
-								log.Fatal(err)
-								log.Println("Unable to parse provided date. Expected: YYYY-MM-DD. Found %s", updateCreationDateOn)
-								return nil
-							}
+				if c.String("cab") == "" {
+					log.Fatalf("--cab argument is blank. Ex., wsusscn2cli extract --cab wsusscn2.cab --out wsusscn2")
+				}
+				cabinet, err := cab.Open(c.String("cab"))
+				check(err)
+				defer cabinet.Close()
+
+				if c.Bool("list") {
+					out := newStream(c, cabFileRecord{}, "")
+					write := func(name string, f *cab.File) error {
+						return out.Write(cabFileRecord{
+							Name:        name,
+							Size:        f.Size,
+							Modified:    f.Modified.Format("2006-01-02 15:04:05"),
+							Folder:      f.Folder,
+							Compression: f.Compression(),
+						})
+					}
+					if c.Bool("inner") {
+						cabinet.Verify = true
+						check(cabinet.WalkNested(func(name string, f *cab.File, r io.Reader) error {
+							return write(name, f)
+						}))
+					} else {
+						for _, f := range cabinet.Files {
+							check(write(f.Path(), f))
 						}
-						q.Add("update_creation_date_on", updateCreationDateOn)
 					}
+					check(out.Close())
+					return nil
+				}
 
-					req.URL.RawQuery = q.Encode()
-
-					err := getJson(api, req, debug, insecure, &update)
-					check(err)
+				files := 0
+				err = cabinet.Extract(c.String("out"), cab.ExtractOptions{
+					Inner: c.Bool("inner"),
+					Test:  c.Bool("test"),
+					Progress: func(name string, f *cab.File) {
+						files++
+						if debug {
+							log.Printf("%s (%d bytes)", name, f.Size)
+						}
+					},
+				})
+				check(err)
 
-					curRecordCnt := len(update)
+				if c.Bool("test") {
+					log.Printf("All %d files of %s are OK", files, c.String("cab"))
+				} else {
+					log.Printf("Extracted %d files to %s", files, c.String("out"))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "verifycab",
+			Usage: "Verify the Authenticode signature of a cabinet such as wsusscn2.cab",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "cab",
+					Usage: "Cabinet to verify",
+				},
+				cli.StringFlag{
+					Name:  "trust",
+					Usage: "PEM or DER file, or directory of them, with the trusted root certificates. Default is the system roots.",
+				},
+				cli.StringFlag{
+					Name:  "signer",
+					Usage: "Required organization or common name of the signer. Blank accepts any trusted signer.",
+					Value: "Microsoft Corporation",
+				},
+				cli.BoolFlag{
+					Name:        "debug, d",
+					Usage:       "Output debug level logging",
+					Destination: &debug,
+				},
+				cli.BoolFlag{
+					Name:        "quiet, q",
+					Usage:       "Do not log to screen",
+					Destination: &quiet,
+				},
+			}, outputFlags()...),
+			Action: func(c *cli.Context) error {
+				setupLogging("Verify Cab")
 
-					fmt.Println(`"UpdateUid","UpdateTitle","UpdateCreationDate","ProductTitle","IsSuperseded","SuperUpdateUid","SuperTitle","SuperCreationDate","SuperProductTitle","SuperIsSuperseded"`)
+				if c.String("cab") == "" {
+					log.Fatalf("--cab argument is blank. Ex., wsusscn2cli verifycab --cab wsusscn2.cab")
+				}
+				roots := readTrustBundle(c.String("trust"))
+				out := newStream(c, signatureRecord{}, "")
 
-					for _, v := range update {
-						fmt.Printf("\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\"\n", v.UpdateUid, v.UpdateTitle, v.UpdateCreationDate, v.ProductTitle, v.IsSuperseded, v.SuperUpdateUid, v.SuperTitle, v.SuperCreationDate, v.SuperProductTitle, v.SuperIsSuperseded)
+				cabinet, err := cab.Open(c.String("cab"))
+				check(err)
+				defer cabinet.Close()
+
+				record := signatureRecord{File: c.String("cab")}
+				sig, err := cabinet.Signature()
+				if err == nil {
+					record.Signer = sig.Signer.Subject.CommonName
+					record.Issuer = sig.Signer.Issuer.CommonName
+					record.DigestAlgorithm = sig.DigestAlgorithm
+					err = sig.Verify(roots)
+					if !sig.Timestamp.IsZero() {
+						record.SigningTime = sig.Timestamp.UTC().Format(time.RFC3339)
+						record.TimestampAuthority = sig.TimestampSigner.Subject.CommonName
 					}
-
-					recordCnt += curRecordCnt
-					offset += curRecordCnt
-
-					if curRecordCnt == 0 {
-						if debug {
-							log.Println("No more records returned")
-						}
-						done = true
+					if err == nil && c.String("signer") != "" && !matchSigner(sig.Signer, c.String("signer")) {
+						err = fmt.Errorf("Signer %s is not %s", sig.Signer.Subject, c.String("signer"))
 					}
-					if curRecordCnt < limit {
-						if debug {
-							log.Println("Last page of records reached")
+				}
+				if debug && sig != nil {
+					for _, chain := range sig.Chains {
+						for i, cert := range chain {
+							log.Printf("Chain %d: %s (%s to %s)", i, cert.Subject, cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"))
 						}
-						done = true
 					}
 				}
 
+				record.Valid = err == nil
+				if err != nil {
+					record.Error = err.Error()
+				}
+				check(out.Write(record))
+				check(out.Close())
+
+				if err != nil {
+					log.Fatalf("%s: %s", c.String("cab"), err)
+				}
+				log.Printf("%s is signed by %s", c.String("cab"), record.Signer)
 				return nil
 			},
 		},
@@ -1467,11 +2102,11 @@ func main() {
 				config.ApiKey = apiKey
 
 				configJson, _ := json.Marshal(config)
-				err = ioutil.WriteFile(execPath+"/wsusscn2cli.json", configJson, 0644)
+				err := ioutil.WriteFile(execPath+"/wsusscn2cli.json", configJson, 0644)
 				check(err)
 				return nil
 			},
 		},
 	}
-	app.Run(os.Args)
+	return app
 }
@@ -0,0 +1,430 @@
+/**************************************************************************************************/
+// File: wsusscn2cli_test.go
+// Author: Jon Smith
+// Copyright: Hash Authority, LLC 2018
+// Description: Tests of the list commands paging through a fake API and of exit codes
+/**************************************************************************************************/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashauthority/wsusscn2cli/wsusscn2"
+)
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           CONSTANTS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+const testRecords = 25 // records served by testServer
+const testLimit = 7    // page size, 4 pages with the last one short
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                             TYPES                                              */
+/*                                                                                                */
+/**************************************************************************************************/
+// testApi: Fake /update, /supersede and /cve endpoints serving testRecords records, and the
+// catalogs
+type testApi struct {
+	delay  func(offset int) time.Duration // wait before answering a page, may be nil
+	status func(offset int) int           // status of a page, may be nil for 200
+
+	mu    sync.Mutex
+	pages int
+}
+
+/**************************************************************************************************/
+/*                                                                                                */
+/*                                           FUNCTIONS                                            */
+/*                                                                                                */
+/**************************************************************************************************/
+func testUid(i int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+}
+
+func (a *testApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	a.mu.Lock()
+	a.pages++
+	a.mu.Unlock()
+	if a.delay != nil {
+		time.Sleep(a.delay(offset))
+	}
+	if a.status != nil {
+		if status := a.status(offset); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/product":
+		json.NewEncoder(w).Encode([]wsusscn2.Product{{ProductUid: "a3c2375d", ProductTitle: "Windows 10"}})
+		return
+	case "/productfamily":
+		json.NewEncoder(w).Encode([]wsusscn2.ProductFamily{{ProductFamilyUid: "6964aab4", ProductFamilyTitle: "Windows"}})
+		return
+	case "/classification":
+		json.NewEncoder(w).Encode([]wsusscn2.Classification{{ClassificationUid: "0fa1201d", ClassificationTitle: "Security Updates"}})
+		return
+	}
+
+	var page []interface{}
+	for i := offset; i < offset+limit && i < testRecords; i++ {
+		// titles with quotes and commas exercise the CSV quoting
+		title := fmt.Sprintf("2018-%02d Update, \"%d\" for Windows 10", i%12+1, i)
+		switch r.URL.Path {
+		case "/update":
+			page = append(page, wsusscn2.Update{UpdateUid: testUid(i), Kb: strconv.Itoa(4000000 + i), UpdateTitle: title, IsSuperseded: "false"})
+		case "/supersede":
+			page = append(page, wsusscn2.UpdateSupersede{UpdateUid: testUid(i), UpdateTitle: title, IsSuperseded: "true", SuperUpdateUid: testUid(i + 100)})
+		case "/cve":
+			page = append(page, wsusscn2.Cve{Cve: fmt.Sprintf("CVE-2018-%04d", i), UpdateUid: testUid(i), UpdateTitle: title, Kb: strconv.Itoa(4000000 + i)})
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if page == nil {
+		page = []interface{}{}
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// run runs the command line against api and returns what it wrote to stdout
+func run(t *testing.T, api *testApi, args ...string) string {
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	stdout, err := ioutil.TempFile("", "wsusscn2cli-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stdout.Name())
+	defer stdout.Close()
+	log, err := ioutil.TempFile("", "wsusscn2cli-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(log.Name())
+	defer log.Close()
+
+	savedStdout := os.Stdout
+	os.Stdout, logFile, apiUrl = stdout, log, srv.URL
+	defer func() { os.Stdout = savedStdout }()
+
+	args = append([]string{"wsusscn2cli"}, args...)
+	args = append(args, "--api_key", "test", "-q", "--limit", strconv.Itoa(testLimit))
+	if err := newApp(context.Background()).Run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// uidsOf returns the update_uid of every row of out, written in format, and fails if the
+// header or the JSON array is not written exactly once
+func uidsOf(t *testing.T, format string, out string) []string {
+	var uids []string
+	switch format {
+	case "csv":
+		rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("Invalid CSV: %s\n%s", err, out)
+		}
+		// CSV headers are the column titles
+		if len(rows) == 0 || rows[0][0] != "UpdateUid" {
+			t.Fatalf("Missing header:\n%s", out)
+		}
+		for _, row := range rows[1:] {
+			if row[0] == "UpdateUid" {
+				t.Fatalf("Header written more than once:\n%s", out)
+			}
+			uids = append(uids, row[0])
+		}
+	case "json":
+		if strings.Count(out, "[\n") != 1 || strings.Count(out, "\n]") != 1 {
+			t.Fatalf("Array not framed exactly once:\n%s", out)
+		}
+		var records []map[string]interface{}
+		if err := json.Unmarshal([]byte(out), &records); err != nil {
+			t.Fatalf("Invalid JSON: %s\n%s", err, out)
+		}
+		for _, r := range records {
+			uids = append(uids, r["update_uid"].(string))
+		}
+	case "ndjson":
+		scanner := bufio.NewScanner(strings.NewReader(out))
+		for scanner.Scan() {
+			var r map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("Invalid NDJSON line %q: %s", scanner.Text(), err)
+			}
+			uids = append(uids, r["update_uid"].(string))
+		}
+	}
+	return uids
+}
+
+// checkUids fails unless uids are the testRecords uids in order
+func checkUids(t *testing.T, uids []string) {
+	if len(uids) != testRecords {
+		t.Fatalf("Got %d records, expected %d", len(uids), testRecords)
+	}
+	for i, uid := range uids {
+		if uid != testUid(i) {
+			t.Fatalf("Record %d is %s, expected %s", i, uid, testUid(i))
+		}
+	}
+}
+
+func TestListPages(t *testing.T) {
+	for _, command := range []string{"listupdate", "listsupersede"} {
+		for _, format := range []string{"csv", "json", "ndjson"} {
+			t.Run(command+"/"+format, func(t *testing.T) {
+				api := &testApi{}
+				out := run(t, api, command, "-o", format)
+				checkUids(t, uidsOf(t, format, out))
+				if api.pages != 4 {
+					t.Errorf("Fetched %d pages, expected 4", api.pages)
+				}
+			})
+		}
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	for format, expected := range map[string]string{"json": "[\n]\n", "ndjson": ""} {
+		out := run(t, &testApi{}, "listupdate", "-o", format, "--offset", "100")
+		if out != expected {
+			t.Errorf("%s output of no records is %q, expected %q", format, out, expected)
+		}
+	}
+}
+
+func TestListParallelOrder(t *testing.T) {
+	// the first pages answer last, rows must still come out in offset order
+	api := &testApi{delay: func(offset int) time.Duration {
+		return time.Duration(testRecords-offset) * 2 * time.Millisecond
+	}}
+	for _, format := range []string{"csv", "json", "ndjson"} {
+		out := run(t, api, "listupdate", "--parallel", "4", "-o", format)
+		checkUids(t, uidsOf(t, format, out))
+	}
+}
+
+// runMain runs the command line in a new process, for commands stopping with log.Fatalf, and
+// returns its exit code and what it wrote to stdout and stderr. With a non-nil api the process
+// reads from it as a TLS server, so the command line needs -k.
+func runMain(t *testing.T, api http.Handler, args ...string) (int, string, string) {
+	dir, err := ioutil.TempDir("", "wsusscn2cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exe := os.Args[0]
+	if api != nil {
+		// the config file is read next to the executable
+		srv := httptest.NewTLSServer(api)
+		defer srv.Close()
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _ := json.Marshal(wConfig{ApiServer: u.Hostname(), ApiPort: u.Port(), ApiKey: "test"})
+		exe = filepath.Join(dir, "wsusscn2cli.test")
+		if err := copyFile(os.Args[0], exe); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "wsusscn2cli.json"), config, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(exe, append([]string{"-test.run=TestMainProcess", "--"}, args...)...)
+	cmd.Dir = dir // for wsusscn2cli.log
+	cmd.Env = append(os.Environ(), "WSUSSCN2CLI_MAIN=1")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), stdout.String(), stderr.String()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stdout.String(), stderr.String()
+}
+
+// copyFile copies the executable src to dst
+func copyFile(src string, dst string) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0700)
+}
+
+// TestMainProcess is the process of runMain
+func TestMainProcess(t *testing.T) {
+	if os.Getenv("WSUSSCN2CLI_MAIN") != "1" {
+		return
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"wsusscn2cli"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+	os.Exit(0)
+}
+
+func TestVerifyCabExit(t *testing.T) {
+	testdata, err := filepath.Abs("cab/testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for trust, expected := range map[string]int{"root.pem": 0, "untrusted.pem": 1} {
+		code, _, _ := runMain(t, nil, "verifycab", "-q", "--cab", filepath.Join(testdata, "signed.cab"), "--trust", filepath.Join(testdata, trust))
+		if code != expected {
+			t.Errorf("verifycab with %s exited with %d, expected %d", trust, code, expected)
+		}
+	}
+}
+
+func TestListKeepsPagesOnFailure(t *testing.T) {
+	// the third page keeps failing after the retries
+	api := &testApi{status: func(offset int) int {
+		if offset >= 2*testLimit {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	for _, format := range []string{"csv", "json", "ndjson"} {
+		code, out, _ := runMain(t, api, "listupdate", "-q", "-k", "-o", format, "--limit", strconv.Itoa(testLimit),
+			"--max_retries", "1", "--retry_wait", "1ms")
+		if code != 1 {
+			t.Errorf("%s: exited with %d, expected 1", format, code)
+		}
+
+		// the output has the records of the first two pages and is not ended
+		if format == "json" {
+			if !strings.HasPrefix(out, "[\n") || strings.Contains(out, "\n]") {
+				t.Errorf("json: array not left open:\n%s", out)
+			}
+			out += "\n]\n"
+		}
+		uids := uidsOf(t, format, out)
+		if len(uids) != 2*testLimit || uids[len(uids)-1] != testUid(2*testLimit-1) {
+			t.Errorf("%s: kept %d records, expected %d", format, len(uids), 2*testLimit)
+		}
+	}
+}
+
+func TestStdoutOnlyOutput(t *testing.T) {
+	// without -q the log goes to stderr, stdout stays parseable
+	for _, command := range []string{"listupdate", "listsupersede"} {
+		for _, format := range []string{"csv", "json", "ndjson"} {
+			code, out, log := runMain(t, &testApi{}, command, "-k", "-o", format, "--limit", strconv.Itoa(testLimit))
+			if code != 0 {
+				t.Fatalf("%s -o %s exited with %d\n%s", command, format, code, log)
+			}
+			checkUids(t, uidsOf(t, format, out))
+			if strings.Contains(out, "GET ") || !strings.Contains(log, "GET ") {
+				t.Errorf("%s -o %s: log lines not on stderr\nstdout:\n%s\nstderr:\n%s", command, format, out, log)
+			}
+		}
+	}
+}
+
+func TestListMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsusscn2cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "wsusscn2cli.db")
+	run(t, &testApi{}, "sync", "--db", db)
+
+	// with --db the list commands read the mirror, not the API
+	api := &testApi{status: func(offset int) int { return http.StatusServiceUnavailable }}
+	for _, command := range []string{"listupdate", "listsupersede"} {
+		for _, format := range []string{"csv", "json", "ndjson"} {
+			out := run(t, api, command, "--db", db, "-o", format)
+			checkUids(t, uidsOf(t, format, out))
+		}
+	}
+	if uids := uidsOf(t, "ndjson", run(t, api, "listupdate", "--db", db, "-o", "ndjson", "--kb", "4000003", "--kb", "4000011")); len(uids) != 2 || uids[0] != testUid(3) || uids[1] != testUid(11) {
+		t.Errorf("listupdate --kb returned %v", uids)
+	}
+	if uids := uidsOf(t, "ndjson", run(t, api, "listcve", "--db", db, "-o", "ndjson", "--cve", "cve-2018-0007")); len(uids) != 1 || uids[0] != testUid(7) {
+		t.Errorf("listcve --cve returned %v", uids)
+	}
+	if api.pages != 0 {
+		t.Errorf("Requested %d pages of the API", api.pages)
+	}
+}
+
+func TestQueryOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsusscn2cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "wsusscn2cli.db")
+	run(t, &testApi{}, "sync", "--db", db)
+
+	// the result goes through the writers of the list commands
+	query := "SELECT update_uid, kb, update_title FROM updates ORDER BY update_uid"
+	for _, format := range []string{"csv", "json", "ndjson"} {
+		code, out, log := runMain(t, nil, "query", "--db", db, "-o", format, query)
+		if code != 0 {
+			t.Fatalf("query -o %s exited with %d\n%s", format, code, log)
+		}
+		if format == "csv" {
+			// the header of a query is its column names
+			out = strings.Replace(out, `"update_uid"`, `"UpdateUid"`, 1)
+		}
+		checkUids(t, uidsOf(t, format, out))
+	}
+	code, out, _ := runMain(t, nil, "query", "--db", db, "--quote", "minimal", "--delimiter", "tab", "--no_header",
+		"SELECT kb, update_title FROM updates WHERE update_uid = '"+testUid(3)+"'")
+	if expected := "4000003\t\"2018-04 Update, \"\"3\"\" for Windows 10\"\n"; code != 0 || out != expected {
+		t.Errorf("query with CSV options wrote %q, expected %q", out, expected)
+	}
+
+	for _, query := range []string{"DELETE FROM updates", "ATTACH DATABASE '" + filepath.Join(dir, "other.db") + "' AS other"} {
+		if code, _, _ := runMain(t, nil, "query", "-q", "--db", db, query); code != 1 {
+			t.Errorf("%s exited with %d, expected 1", query, code)
+		}
+	}
+	if out := run(t, &testApi{status: func(offset int) int { return http.StatusServiceUnavailable }}, "listupdate", "--db", db, "-o", "ndjson"); len(uidsOf(t, "ndjson", out)) != testRecords {
+		t.Errorf("DELETE changed the mirror")
+	}
+}